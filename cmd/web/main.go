@@ -1,20 +1,25 @@
 package main
 
 import (
+	"crypto/rand"
 	"encoding/gob"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/hd719/go-bookings/internal/config"
 	"github.com/hd719/go-bookings/internal/driver"
 	"github.com/hd719/go-bookings/internal/handlers"
 	"github.com/hd719/go-bookings/internal/helpers"
+	"github.com/hd719/go-bookings/internal/lockout"
 	"github.com/hd719/go-bookings/internal/models"
 	"github.com/hd719/go-bookings/internal/render"
+	"github.com/hd719/go-bookings/internal/repository/dbrepo"
+	"github.com/hd719/go-bookings/internal/roles"
 
 	"github.com/alexedwards/scs/v2"
 )
@@ -31,12 +36,23 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	log.Println("Connected to the DB :)")
-	defer db.SQL.Close()
+	if db != nil {
+		log.Println("Connected to the DB :)")
+		defer db.SQL.Close()
+	}
+
+	if flag.Arg(0) == "migrate" {
+		if err = runMigrate(db, flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
 	fmt.Println(fmt.Sprintf("Staring mail server..."))
 	defer close(app.MailChan)
 	listenForMail()
+	purgeDeletedReservations()
+	pruneLoginAttempts()
 
 	fmt.Println(fmt.Sprintf("Staring application on port %s", portNumber))
 
@@ -60,11 +76,24 @@ func run() (*driver.DB, error) {
 	// read flags
 	inProduction := flag.Bool("production", true, "Application is in production")
 	useCache := flag.Bool("cache", true, "Use template cache")
+	dbDriver := flag.String("dbdriver", "postgres", "Database driver (postgres, sqlite or memory)")
+	dbBackend := flag.String("db", "", "Shorthand for -dbdriver")
+	dbPath := flag.String("dbpath", "go-bookings.db", "Path to the SQLite database file")
 	dbName := flag.String("dbname", "", "Database Name")
 	dbUser := flag.String("dbuser", "", "Database Password")
 	dbPort := flag.String("dbport", "5432", "Database Port")
 	dbSSL := flag.String("dbssl", "disable", "Database ssl settings (disable, prefer, require)")
 	dbhost := flag.String("dbhost", "localhost", "Database Host")
+	migrateOnStart := flag.Bool("migrate", false, "Apply pending migrations on startup")
+	dbTimeout := flag.Duration("dbtimeout", 3*time.Second, "Maximum duration of a single database query")
+	uploadPath := flag.String("uploads", "./uploads", "Directory uploaded room photos are stored in")
+	baseURL := flag.String("baseurl", "http://localhost"+portNumber, "Scheme and host links in emails point to")
+	linkSecret := flag.String("linksecret", "", "Key the links in guest emails are signed with, a random one is used if empty")
+	retention := flag.Duration("retention", 30*24*time.Hour, "How long deleted reservations can be restored before they are purged")
+	attempts := flag.String("attempts", "db", "Where failed logins are counted, db or memory (forgotten on restart)")
+	lockoutAfter := flag.Int("lockout", lockout.DefaultAccount.MaxFailures, "Failed logins in a row that lock an account out, 0 never locks out")
+	lockoutFor := flag.Duration("lockoutfor", lockout.DefaultAccount.Lockout, "How long a lockout lasts")
+	twoFactor := flag.String("twofactor", "", "Comma separated roles that must log in with two-factor authentication, e.g. owner,manager")
 
 	flag.Parse()
 
@@ -76,6 +105,33 @@ func run() (*driver.DB, error) {
 	// change this to true when in production
 	app.InProduction = *inProduction
 	app.UseCache = *useCache
+	app.DBTimeout = *dbTimeout
+	app.UploadPath = *uploadPath
+	app.BaseURL = strings.TrimSuffix(*baseURL, "/")
+	app.LinkSecret = []byte(*linkSecret)
+	app.Retention = *retention
+
+	for _, role := range strings.Split(*twoFactor, ",") {
+		if role = strings.TrimSpace(role); role == "" {
+			continue
+		}
+
+		if !roles.Valid(role) {
+			return nil, fmt.Errorf("unknown role %q in -twofactor", role)
+		}
+
+		app.TwoFactorRoles = append(app.TwoFactorRoles, role)
+	}
+
+	if *attempts != "db" && *attempts != "memory" {
+		return nil, fmt.Errorf("unknown -attempts %q, use db or memory", *attempts)
+	}
+
+	// Failed logins are counted in memory, with -attempts=db they move to the database once it is connected
+	app.Lockout = lockout.New(lockout.NewMemoryStore())
+	app.Lockout.Account.MaxFailures = *lockoutAfter
+	app.Lockout.Account.Lockout = *lockoutFor
+	app.Lockout.IP.Lockout = *lockoutFor
 
 	// Creating Info Logger
 	// Print logs to the terminal (stdout)
@@ -87,6 +143,15 @@ func run() (*driver.DB, error) {
 	errorLog = log.New(os.Stdout, "ERROR \t", log.Ldate|log.Ltime|log.Lshortfile)
 	app.ErrorLog = errorLog
 
+	// Without a fixed secret the links already sent to guests stop working when the app restarts
+	if len(app.LinkSecret) == 0 {
+		app.LinkSecret = make([]byte, 32)
+		if _, err := rand.Read(app.LinkSecret); err != nil {
+			return nil, err
+		}
+		infoLog.Println("No -linksecret given, links in guest emails only work until the app restarts")
+	}
+
 	// Create our session
 	session = scs.New()
 	session.Lifetime = 24 * time.Hour
@@ -97,15 +162,6 @@ func run() (*driver.DB, error) {
 	// Add our session to the Application config (global state)
 	app.Session = session
 
-	// Connect to DB
-	log.Println("Connecting to DB...")
-	connectionString := fmt.Sprintf("host=%s port=%s dbname=%s user=%s password=secret sslmode=%s", *dbhost, *dbPort, *dbName, *dbUser, *dbSSL)
-	db, err := driver.ConnectSQL(connectionString)
-	// db, err := driver.ConnectMongo("host=localhost port=27107 dbname=go-bookings user=system password=secret")
-	if err != nil {
-		log.Fatal("Cannot connect to DB!")
-	}
-
 	tc, err := render.CreateTemplateCache()
 	if err != nil {
 		log.Fatal("Cannot create template cache")
@@ -114,14 +170,60 @@ func run() (*driver.DB, error) {
 
 	app.TemplateCache = tc
 
-	// This related to the the extra code in the handlers file on line 37, DO NOT DELETE
-	// repo := handlers.NewRepo(&app, db)
-	// handlers.NewHandlers(repo)
-
-	// Note: the db connection is not tied to a specific database (pointer to a driver)
-	handlers.NewRepo(&app, db)
 	render.NewRenderer(&app)
 	helpers.NewHelpers(&app)
 
+	if *dbBackend != "" {
+		*dbDriver = *dbBackend
+	}
+
+	var db *driver.DB
+
+	switch *dbDriver {
+	case "memory":
+		// The in-memory backend needs no database server, everything is lost when the app stops
+		log.Println("Using in-memory DB")
+		handlers.NewMemoryRepo(&app)
+		return nil, nil
+	case "sqlite":
+		log.Printf("Opening SQLite DB %s...", *dbPath)
+		db, err = driver.ConnectSQLite(*dbPath)
+		if err != nil {
+			return nil, err
+		}
+
+		handlers.NewSQLiteRepo(&app, db)
+	case "postgres":
+		// Connect to DB
+		log.Println("Connecting to DB...")
+		connectionString := fmt.Sprintf("host=%s port=%s dbname=%s user=%s password=secret sslmode=%s", *dbhost, *dbPort, *dbName, *dbUser, *dbSSL)
+		db, err = driver.ConnectSQL(connectionString)
+		// db, err := driver.ConnectMongo("host=localhost port=27107 dbname=go-bookings user=system password=secret")
+		if err != nil {
+			log.Fatal("Cannot connect to DB!")
+		}
+
+		// This related to the the extra code in the handlers file on line 37, DO NOT DELETE
+		// repo := handlers.NewRepo(&app, db)
+		// handlers.NewHandlers(repo)
+
+		// Note: the db connection is not tied to a specific database (pointer to a driver)
+		handlers.NewRepo(&app, db)
+	default:
+		return nil, fmt.Errorf("unknown database driver %q", *dbDriver)
+	}
+
+	// Counted in the database, failed logins survive restarts and are shared by every instance of the app
+	if *attempts == "db" {
+		app.Lockout.Store = dbrepo.NewAttemptStore(db.SQL, &app)
+	}
+
+	// The migrate command is how a database that is behind gets fixed, so it skips the check
+	if flag.Arg(0) != "migrate" {
+		if err = checkSchema(db, *migrateOnStart); err != nil {
+			return db, err
+		}
+	}
+
 	return db, nil
 }
@@ -1,8 +1,14 @@
 package main
 
-import "testing"
+import (
+	"os"
+	"testing"
+)
 
 func TestRun(t *testing.T) {
+	// Use the in-memory database so the test does not need a postgres server
+	os.Args = []string{os.Args[0], "-db=memory", "-production=false"}
+
 	_, err := run()
 
 	// Testing if error is nil
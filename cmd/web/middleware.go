@@ -1,10 +1,17 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 
+	"github.com/hd719/go-bookings/internal/audit"
+	"github.com/hd719/go-bookings/internal/handlers"
 	"github.com/hd719/go-bookings/internal/helpers"
+	"github.com/hd719/go-bookings/internal/models"
+	"github.com/hd719/go-bookings/internal/roles"
 	"github.com/justinas/nosurf"
 )
 
@@ -35,13 +42,92 @@ func SessionLoad(next http.Handler) http.Handler {
 	return session.LoadAndSave(next)
 }
 
+// Audit records who is making the request and from where in its context, for the entries of the audit log
+func Audit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actor := audit.Guest
+		if helpers.IsAuthenticated(r) {
+			actor.UserID = session.GetInt(r.Context(), "user_id")
+			actor.Name = session.GetString(r.Context(), "user_email")
+			if actor.Name == "" {
+				actor.Name = fmt.Sprintf("user #%d", actor.UserID)
+			}
+		}
+
+		ctx := audit.WithRequest(audit.WithActor(r.Context(), actor), audit.NewRequest(r))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// contextKey is the type of the keys the middleware store values in the request context under
+type contextKey int
+
+// userKey is where Auth stores the logged in user for the middleware after it
+const userKey contextKey = iota
+
+// errorResponse is what API clients get instead of a redirect when they aren't let through
+type errorResponse struct {
+	OK      bool   `json:"ok"`
+	Message string `json:"message"`
+}
+
+// wantsJSON reports whether r was made by an API client or a script rather than by a browser loading a page
+func wantsJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/json") || r.Header.Get("X-Requested-With") == "XMLHttpRequest"
+}
+
+// deny sends a browser to url with msg as a flash message of kind ("error" or "warning"), API clients get status with
+// msg as JSON instead
+func deny(w http.ResponseWriter, r *http.Request, status int, kind, msg, url string) {
+	if wantsJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(errorResponse{OK: false, Message: msg})
+		return
+	}
+
+	session.Put(r.Context(), kind, msg)
+	http.Redirect(w, r, url, http.StatusSeeOther)
+}
+
+// Auth only lets through logged in users. Sessions of users that were disabled, or that logged in before the password
+// of the user last changed, are logged out. A browser is sent back to the page it asked for once it logged in
 func Auth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if !helpers.IsAuthenticated(r) {
-			session.Put(r.Context(), "error", "Log in first!")
-			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		user, err := handlers.Repo.DB.GetUserById(r.Context(), session.GetInt(r.Context(), "user_id"))
+		if err != nil || user.Disabled() || user.SessionVersion != session.GetInt(r.Context(), "session_version") {
+			session.Remove(r.Context(), "user_id")
+
+			// only pages can be gone back to, a form that was posted would have to be filled in again anyway
+			if r.Method == http.MethodGet && !wantsJSON(r) {
+				session.Put(r.Context(), "login_redirect", r.URL.RequestURI())
+			}
+
+			deny(w, r, http.StatusUnauthorized, "error", "Log in first!", "/user/login")
+			return
 		}
 
-		next.ServeHTTP(w, r)
+		// users whose role was made to require two-factor authentication after they logged in set it up first
+		if !user.TwoFactor() && handlers.Repo.TwoFactorRequired(user.Role) && !strings.HasPrefix(r.URL.Path, "/admin/account/") {
+			deny(w, r, http.StatusForbidden, "warning", "Your role requires two-factor authentication, set it up to continue", "/admin/account/two-factor")
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userKey, user)))
 	})
 }
+
+// Permit only lets through users whose role has the permission p, it runs after Auth
+func Permit(p roles.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, ok := r.Context().Value(userKey).(models.User)
+			if !ok || !roles.Can(user.Role, p) {
+				deny(w, r, http.StatusForbidden, "error", "You don't have permission to do that", "/admin/dashboard")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"net/http"
 	"testing"
+
+	"github.com/hd719/go-bookings/internal/roles"
 )
 
 func TestNoSurf(t *testing.T) {
@@ -29,3 +31,48 @@ func TestSessionLoad(t *testing.T) {
 		t.Error(fmt.Sprintf("type is not http.Handler, but is %T", v))
 	}
 }
+
+func TestPermit(t *testing.T) {
+	var myH myHandler
+	h := Permit(roles.View)(&myH)
+
+	switch v := h.(type) {
+	case http.Handler:
+		// do nothing
+	default:
+		t.Error(fmt.Sprintf("type is not http.Handler, but is %T", v))
+	}
+}
+
+func TestAuth(t *testing.T) {
+	var myH myHandler
+	h := Auth(&myH)
+
+	switch v := h.(type) {
+	case http.Handler:
+		// do nothing
+	default:
+		t.Error(fmt.Sprintf("type is not http.Handler, but is %T", v))
+	}
+}
+
+func TestWantsJSON(t *testing.T) {
+	tests := []struct {
+		header string
+		value  string
+		json   bool
+	}{
+		{"Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", false},
+		{"Accept", "application/json", true},
+		{"X-Requested-With", "XMLHttpRequest", true},
+	}
+
+	for _, e := range tests {
+		r, _ := http.NewRequest("GET", "/admin/dashboard", nil)
+		r.Header.Set(e.header, e.value)
+
+		if wantsJSON(r) != e.json {
+			t.Errorf("%s: %s, expected wantsJSON to be %t", e.header, e.value, e.json)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"strconv"
+
+	"github.com/hd719/go-bookings/internal/driver"
+	"github.com/hd719/go-bookings/internal/migrate"
+	"github.com/hd719/go-bookings/migrations"
+	"github.com/hd719/go-bookings/seed"
+)
+
+const migrateUsage = "usage: go-bookings [flags] migrate up|down [steps]|status|seed"
+
+// checkSchema refuses to start the app on a database that is missing migrations, unless apply is set in which case they are applied
+func checkSchema(db *driver.DB, apply bool) error {
+	if db == nil {
+		return nil
+	}
+
+	m, err := migrate.New(db.SQL, db.Dialect, migrations.FS)
+	if err != nil {
+		return err
+	}
+
+	if apply {
+		applied, err := m.Up(context.Background())
+		for _, mig := range applied {
+			log.Printf("Applied migration %s_%s", mig.Version, mig.Name)
+		}
+		return err
+	}
+
+	pending, err := m.Pending(context.Background())
+	if err != nil {
+		return err
+	}
+
+	if len(pending) > 0 {
+		return fmt.Errorf("database schema is behind by %d migration(s), run go-bookings migrate up or start with -migrate", len(pending))
+	}
+
+	return nil
+}
+
+// runMigrate handles the migrate command, args are whatever follows "migrate" on the command line
+func runMigrate(db *driver.DB, args []string) error {
+	if db == nil {
+		return errors.New("the in-memory database has no schema to migrate")
+	}
+
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	var source fs.FS = migrations.FS
+	if args[0] == "seed" {
+		source = seed.FS
+	}
+
+	m, err := migrate.New(db.SQL, db.Dialect, source)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	switch args[0] {
+	case "up", "seed":
+		applied, err := m.Up(ctx)
+		for _, mig := range applied {
+			log.Printf("Applied %s_%s", mig.Version, mig.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			log.Println("Nothing to apply")
+		}
+		return err
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return errors.New(migrateUsage)
+			}
+		}
+
+		rolledBack, err := m.Down(ctx, steps)
+		for _, mig := range rolledBack {
+			log.Printf("Rolled back %s_%s", mig.Version, mig.Name)
+		}
+		return err
+
+	case "status":
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, s := range statuses {
+			state := "pending"
+			if s.Applied {
+				state = "applied"
+			}
+			fmt.Printf("%-8s %s_%s\n", state, s.Version, s.Name)
+		}
+		return nil
+	}
+
+	return errors.New(migrateUsage)
+}
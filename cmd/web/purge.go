@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/hd719/go-bookings/internal/handlers"
+)
+
+// purgeInterval is how often reservations that have been in the trash longer than the retention are removed
+const purgeInterval = 24 * time.Hour
+
+// purgeDeletedReservations removes reservations from the trash once they are older than -retention, now and then once a day
+func purgeDeletedReservations() {
+	go func() {
+		for {
+			purged, err := handlers.Repo.DB.PurgeDeletedReservations(context.Background(), time.Now().Add(-app.Retention))
+			if err != nil {
+				errorLog.Println(err)
+			} else if purged > 0 {
+				infoLog.Printf("Purged %d deleted reservations", purged)
+			}
+
+			time.Sleep(purgeInterval)
+		}
+	}()
+}
+
+// pruneInterval is how often failed logins that no longer count are forgotten
+const pruneInterval = time.Hour
+
+// pruneLoginAttempts forgets the failed logins that no longer slow down or lock out anyone, once an hour
+func pruneLoginAttempts() {
+	go func() {
+		for {
+			if err := app.Lockout.Prune(context.Background(), time.Now()); err != nil {
+				errorLog.Println(err)
+			}
+
+			time.Sleep(pruneInterval)
+		}
+	}()
+}
@@ -4,6 +4,7 @@ import (
 	"net/http"
 
 	"github.com/hd719/go-bookings/internal/handlers"
+	"github.com/hd719/go-bookings/internal/roles"
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
@@ -15,10 +16,15 @@ func routes() http.Handler {
 
 	mux.Use(NoSurf) // Ignore all requests that does not have proper Cross Site Token, which is why post requests do not work
 	mux.Use(SessionLoad)
+	mux.Use(Audit)
 	mux.Get("/", handlers.Repo.Home)
 	mux.Get("/about", handlers.Repo.About)
-	mux.Get("/generals-quarters", handlers.Repo.Generals)
-	mux.Get("/majors-suite", handlers.Repo.Majors)
+	mux.Get("/rooms", handlers.Repo.Rooms)
+	mux.Get("/rooms/{slug}", handlers.Repo.Room)
+
+	// Room pages used to be hard-coded, keep their old links working
+	mux.Handle("/generals-quarters", http.RedirectHandler("/rooms/generals-quarters", http.StatusMovedPermanently))
+	mux.Handle("/majors-suite", http.RedirectHandler("/rooms/majors-suite", http.StatusMovedPermanently))
 
 	mux.Get("/search-availability", handlers.Repo.Availability)
 	mux.Post("/search-availability", handlers.Repo.PostAvailability)
@@ -31,27 +37,114 @@ func routes() http.Handler {
 	mux.Get("/make-reservation", handlers.Repo.Reservation)
 	mux.Post("/make-reservation", handlers.Repo.PostReservation)
 	mux.Get("/reservation-summary", handlers.Repo.ReservationSummary)
+	mux.Get("/my-reservation", handlers.Repo.MyReservation)
+	mux.Post("/my-reservation", handlers.Repo.PostMyReservation)
+	mux.Get("/my-reservation/{id}/{token}", handlers.Repo.ManageReservation)
+	mux.Post("/my-reservation/{id}/{token}/change", handlers.Repo.PostChangeReservation)
+	mux.Post("/my-reservation/{id}/{token}/cancel", handlers.Repo.PostCancelReservation)
 
 	mux.Get("/user/login", handlers.Repo.ShowLogin)
 	mux.Post("/user/login", handlers.Repo.PostShowLogin)
 	mux.Get("/user/logout", handlers.Repo.Logout)
+	mux.Get("/user/invite/{id}/{token}", handlers.Repo.AcceptInvite)
+	mux.Post("/user/invite/{id}/{token}", handlers.Repo.PostAcceptInvite)
+	mux.Get("/user/login/two-factor", handlers.Repo.TwoFactorLogin)
+	mux.Post("/user/login/two-factor", handlers.Repo.PostTwoFactorLogin)
+	mux.Get("/user/forgot-password", handlers.Repo.ForgotPassword)
+	mux.Post("/user/forgot-password", handlers.Repo.PostForgotPassword)
+	mux.Get("/user/reset-password/{token}", handlers.Repo.ResetPassword)
+	mux.Post("/user/reset-password/{token}", handlers.Repo.PostResetPassword)
 
 	// . is the root level directory of the app
 	fileServer := http.FileServer(http.Dir("./static/"))
 	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
 
-	// Anything that is prefixed with /admin is a protected route
+	uploads := http.FileServer(http.Dir(app.UploadPath))
+	mux.Handle("/uploads/*", http.StripPrefix("/uploads", uploads))
+
+	// Anything that is prefixed with /admin is a protected route, only for logged in users whose role has the permission
+	// each one needs
 	mux.Route("/admin", func(mux chi.Router) {
-		// mux.Use(Auth)
-		mux.Get("/dashboard", handlers.Repo.AdminDashboard)
-		mux.Get("/reservations-new", handlers.Repo.AdminNewReservations)
-		mux.Get("/reservations-all", handlers.Repo.AdminAllReservations)
-		mux.Get("/reservations-calendar", handlers.Repo.AdminReservationsCalendar)
-		mux.Post("/reservations-calendar", handlers.Repo.AdminPostReservationsCalendar)
-		mux.Get("/process-reservation/{src}/{id}/do", handlers.Repo.AdminProcessReservation)
-		mux.Get("/reservations/{src}/{id}/show", handlers.Repo.AdminShowReservation)
-		mux.Post("/reservations/{src}/{id}", handlers.Repo.AdminPostReservation)
-		mux.Get("/delete-reservation/{src}/{id}/do", handlers.Repo.AdminDeleteReservation)
+		mux.Use(Auth)
+		mux.Group(func(mux chi.Router) {
+			mux.Use(Permit(roles.View))
+			mux.Get("/dashboard", handlers.Repo.AdminDashboard)
+			mux.Get("/reservations-new", handlers.Repo.AdminNewReservations)
+			mux.Get("/reservations-all", handlers.Repo.AdminAllReservations)
+			mux.Get("/reservations-calendar", handlers.Repo.AdminReservationsCalendar)
+			mux.Get("/reservations/{src}/{id}/show", handlers.Repo.AdminShowReservation)
+			mux.Get("/reservations-trash", handlers.Repo.AdminTrash)
+
+			mux.Get("/rooms", handlers.Repo.AdminRooms)
+			mux.Get("/rooms/{id}", handlers.Repo.AdminShowRoom)
+			mux.Get("/rooms/{id}/rates/{rate_id}", handlers.Repo.AdminShowRatePlan)
+			mux.Get("/rooms/{id}/rules/{rule_id}", handlers.Repo.AdminShowStayRule)
+			mux.Get("/restrictions", handlers.Repo.AdminRestrictions)
+			mux.Get("/restrictions/{id}", handlers.Repo.AdminShowRestriction)
+			mux.Get("/cancellation-policies", handlers.Repo.AdminCancellationPolicies)
+			mux.Get("/cancellation-policies/{id}", handlers.Repo.AdminShowCancellationPolicy)
+
+			mux.Get("/account/two-factor", handlers.Repo.AdminTwoFactor)
+			mux.Post("/account/two-factor", handlers.Repo.AdminPostTwoFactor)
+			mux.Post("/account/two-factor/recovery-codes", handlers.Repo.AdminNewRecoveryCodes)
+			mux.Post("/account/two-factor/disable", handlers.Repo.AdminDisableTwoFactor)
+		})
+
+		mux.Group(func(mux chi.Router) {
+			mux.Use(Permit(roles.EditReservations))
+			mux.Post("/reservations-calendar", handlers.Repo.AdminPostReservationsCalendar)
+			mux.Get("/reservation-status/{src}/{id}/{status}/do", handlers.Repo.AdminUpdateReservationStatus)
+			mux.Post("/reservations/{src}/{id}", handlers.Repo.AdminPostReservation)
+			mux.Get("/delete-reservation/{src}/{id}/do", handlers.Repo.AdminDeleteReservation)
+			mux.Get("/restore-reservation/{id}/do", handlers.Repo.AdminRestoreReservation)
+			mux.Get("/cancel-reservation/{src}/{id}/do", handlers.Repo.AdminCancelReservation)
+			mux.Get("/rooms/{id}/blocks/new", handlers.Repo.AdminNewBlock)
+			mux.Post("/rooms/{id}/blocks/new", handlers.Repo.AdminPostNewBlock)
+		})
+
+		mux.Group(func(mux chi.Router) {
+			mux.Use(Permit(roles.ManageRooms))
+			mux.Get("/rooms/new", handlers.Repo.AdminNewRoom)
+			mux.Post("/rooms/new", handlers.Repo.AdminPostNewRoom)
+			mux.Post("/rooms/{id}", handlers.Repo.AdminPostRoom)
+			mux.Get("/rooms/{id}/delete", handlers.Repo.AdminDeleteRoom)
+			mux.Post("/rooms/{id}/photos", handlers.Repo.AdminPostRoomPhoto)
+			mux.Get("/rooms/{id}/photos/{photo_id}/move/{dir}", handlers.Repo.AdminMoveRoomPhoto)
+			mux.Get("/rooms/{id}/photos/{photo_id}/delete", handlers.Repo.AdminDeleteRoomPhoto)
+			mux.Get("/rooms/{id}/rates/new", handlers.Repo.AdminNewRatePlan)
+			mux.Post("/rooms/{id}/rates/new", handlers.Repo.AdminPostNewRatePlan)
+			mux.Post("/rooms/{id}/rates/{rate_id}", handlers.Repo.AdminPostRatePlan)
+			mux.Get("/rooms/{id}/rates/{rate_id}/delete", handlers.Repo.AdminDeleteRatePlan)
+			mux.Get("/rooms/{id}/rules/new", handlers.Repo.AdminNewStayRule)
+			mux.Post("/rooms/{id}/rules/new", handlers.Repo.AdminPostNewStayRule)
+			mux.Post("/rooms/{id}/rules/{rule_id}", handlers.Repo.AdminPostStayRule)
+			mux.Get("/rooms/{id}/rules/{rule_id}/delete", handlers.Repo.AdminDeleteStayRule)
+
+			mux.Get("/restrictions/new", handlers.Repo.AdminNewRestriction)
+			mux.Post("/restrictions/new", handlers.Repo.AdminPostNewRestriction)
+			mux.Post("/restrictions/{id}", handlers.Repo.AdminPostRestriction)
+			mux.Get("/restrictions/{id}/delete", handlers.Repo.AdminDeleteRestriction)
+
+			mux.Get("/cancellation-policies/new", handlers.Repo.AdminNewCancellationPolicy)
+			mux.Post("/cancellation-policies/new", handlers.Repo.AdminPostNewCancellationPolicy)
+			mux.Post("/cancellation-policies/{id}", handlers.Repo.AdminPostCancellationPolicy)
+			mux.Get("/cancellation-policies/{id}/delete", handlers.Repo.AdminDeleteCancellationPolicy)
+		})
+
+		mux.With(Permit(roles.ViewAudit)).Get("/audit", handlers.Repo.AdminAudit)
+
+		mux.Group(func(mux chi.Router) {
+			mux.Use(Permit(roles.ManageUsers))
+			mux.Get("/users", handlers.Repo.AdminUsers)
+			mux.Get("/users/new", handlers.Repo.AdminNewUser)
+			mux.Post("/users/new", handlers.Repo.AdminPostNewUser)
+			mux.Get("/users/{id}", handlers.Repo.AdminShowUser)
+			mux.Post("/users/{id}", handlers.Repo.AdminPostUser)
+			mux.Post("/users/{id}/disable", handlers.Repo.AdminDisableUser)
+			mux.Post("/users/{id}/enable", handlers.Repo.AdminEnableUser)
+			mux.Post("/users/{id}/reset-two-factor", handlers.Repo.AdminResetTwoFactor)
+			mux.Post("/users/{id}/unlock", handlers.Repo.AdminUnlockUser)
+		})
 	})
 
 	return mux
@@ -1,10 +1,23 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
 	"testing"
 
 	"github.com/go-chi/chi"
+	"github.com/hd719/go-bookings/internal/handlers"
+	"github.com/hd719/go-bookings/internal/models"
+	"github.com/hd719/go-bookings/internal/roles"
 )
 
 func TestRoutes(t *testing.T) {
@@ -18,3 +31,258 @@ func TestRoutes(t *testing.T) {
 		t.Error(fmt.Sprintf("type is not *chi.mux and type is %T", v))
 	}
 }
+
+// newClient returns a client that keeps its cookies like a browser, but doesn't follow redirects so they can be checked
+func newClient() *http.Client {
+	jar, _ := cookiejar.New(nil)
+	return &http.Client{
+		Jar: jar,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+var csrfField = regexp.MustCompile(`name="csrf_token" value="([^"]+)"`)
+
+// csrfToken loads the login page with client and returns the CSRF token on it
+func csrfToken(t *testing.T, client *http.Client, ts *httptest.Server) string {
+	resp, err := client.Get(ts.URL + "/user/login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	m := csrfField.FindSubmatch(body)
+	if m == nil {
+		t.Fatal("no CSRF token on the login page")
+	}
+
+	return html.UnescapeString(string(m[1]))
+}
+
+// logIn posts the login form with client and returns where it redirects to
+func logIn(t *testing.T, client *http.Client, ts *httptest.Server, email, password string) string {
+	form := url.Values{"csrf_token": {csrfToken(t, client, ts)}, "email": {email}, "password": {password}}
+
+	resp, err := client.PostForm(ts.URL+"/user/login", form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("expected the login to redirect but got %d", resp.StatusCode)
+	}
+
+	return resp.Header.Get("Location")
+}
+
+// get requests path with client, as an API client if api is true
+func get(t *testing.T, client *http.Client, ts *httptest.Server, path string, api bool) *http.Response {
+	req, _ := http.NewRequest("GET", ts.URL+path, nil)
+	if api {
+		req.Header.Set("Accept", "application/json")
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	return resp
+}
+
+var routeParam = regexp.MustCompile(`{[^}]+}`)
+
+func TestRoutes_AdminRequiresLogin(t *testing.T) {
+	mux := routes()
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	client := newClient()
+	token := csrfToken(t, client, ts)
+
+	var checked int
+	err := chi.Walk(mux.(chi.Routes), func(method, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		if !strings.HasPrefix(route, "/admin/") {
+			return nil
+		}
+
+		checked++
+		path := routeParam.ReplaceAllString(route, "1")
+
+		for _, api := range []bool{false, true} {
+			req, _ := http.NewRequest(method, ts.URL+path, nil)
+			req.Header.Set("X-CSRF-Token", token)
+			if api {
+				req.Header.Set("Accept", "application/json")
+			}
+
+			resp, err := client.Do(req)
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+
+			switch {
+			case api && resp.StatusCode != http.StatusUnauthorized:
+				t.Errorf("%s %s returned %d to an API client, wanted %d", method, route, resp.StatusCode, http.StatusUnauthorized)
+			case !api && (resp.StatusCode != http.StatusSeeOther || resp.Header.Get("Location") != "/user/login"):
+				t.Errorf("%s %s returned %d %s, wanted %d to the login", method, route, resp.StatusCode, resp.Header.Get("Location"), http.StatusSeeOther)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if checked < 40 {
+		t.Errorf("expected every admin route to be checked but only found %d", checked)
+	}
+}
+
+func TestRoutes_AdminAPIClient(t *testing.T) {
+	ts := httptest.NewServer(routes())
+	defer ts.Close()
+
+	resp := get(t, newClient(), ts, "/admin/reservations-all", true)
+	if resp.StatusCode != http.StatusUnauthorized || resp.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("expected a 401 with JSON but got %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	var body errorResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.OK || body.Message == "" {
+		t.Errorf("expected an error message but got %+v, %v", body, err)
+	}
+}
+
+func TestRoutes_AdminRedirectBack(t *testing.T) {
+	ts := httptest.NewServer(routes())
+	defer ts.Close()
+
+	client := newClient()
+
+	if resp := get(t, client, ts, "/admin/reservations-all?page=2", false); resp.Header.Get("Location") != "/user/login" {
+		t.Fatalf("expected to be sent to the login but got %d %s", resp.StatusCode, resp.Header.Get("Location"))
+	}
+
+	// back to the page asked for, once
+	if to := logIn(t, client, ts, "admin@admin.com", "password"); to != "/admin/reservations-all?page=2" {
+		t.Errorf("expected to go back to the page asked for after logging in but went to %s", to)
+	}
+
+	if resp := get(t, client, ts, "/admin/reservations-all?page=2", false); resp.StatusCode != http.StatusOK {
+		t.Errorf("expected the page once logged in but got %d", resp.StatusCode)
+	}
+
+	if to := logIn(t, client, ts, "admin@admin.com", "password"); to != "/admin/dashboard" {
+		t.Errorf("expected to go to the dashboard after logging in again but went to %s", to)
+	}
+
+	// logging out logs out every admin page
+	get(t, client, ts, "/user/logout", false)
+	if resp := get(t, client, ts, "/admin/dashboard", false); resp.Header.Get("Location") != "/user/login" {
+		t.Errorf("expected to be sent to the login after logging out but got %d %s", resp.StatusCode, resp.Header.Get("Location"))
+	}
+}
+
+func TestRoutes_AdminPermissions(t *testing.T) {
+	ctx := context.Background()
+	id, err := handlers.Repo.DB.InsertUser(ctx, models.User{FirstName: "Fay", LastName: "Front", Email: "fay@here.com", Role: roles.FrontDesk})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = handlers.Repo.DB.UpdateUserPassword(ctx, id, "password1")
+
+	ts := httptest.NewServer(routes())
+	defer ts.Close()
+
+	client := newClient()
+	logIn(t, client, ts, "fay@here.com", "password1")
+
+	if resp := get(t, client, ts, "/admin/reservations-new", false); resp.StatusCode != http.StatusOK {
+		t.Errorf("expected front desk to see reservations but got %d", resp.StatusCode)
+	}
+
+	if resp := get(t, client, ts, "/admin/users", false); resp.StatusCode != http.StatusSeeOther || resp.Header.Get("Location") != "/admin/dashboard" {
+		t.Errorf("expected front desk to be sent away from the users but got %d %s", resp.StatusCode, resp.Header.Get("Location"))
+	}
+
+	if resp := get(t, client, ts, "/admin/users", true); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected a 403 for an API client without the permission but got %d", resp.StatusCode)
+	}
+
+	// a disabled user is logged out on their next request
+	_ = handlers.Repo.DB.SetUserDisabled(ctx, id, true)
+	if resp := get(t, client, ts, "/admin/dashboard", false); resp.Header.Get("Location") != "/user/login" {
+		t.Errorf("expected a disabled user to be sent to the login but got %d %s", resp.StatusCode, resp.Header.Get("Location"))
+	}
+}
+
+func TestRoutes_AdminUserActionsArePosted(t *testing.T) {
+	ctx := context.Background()
+	ownerId, err := handlers.Repo.DB.InsertUser(ctx, models.User{FirstName: "Olga", LastName: "Owner", Email: "olga@here.com", Role: roles.Owner})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = handlers.Repo.DB.UpdateUserPassword(ctx, ownerId, "password1")
+
+	id, err := handlers.Repo.DB.InsertUser(ctx, models.User{FirstName: "Dora", LastName: "Desk", Email: "dora@here.com", Role: roles.FrontDesk})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewServer(routes())
+	defer ts.Close()
+
+	client := newClient()
+	logIn(t, client, ts, "olga@here.com", "password1")
+
+	disable := fmt.Sprintf("/admin/users/%d/disable", id)
+
+	// a link or an image on another site can't disable a user
+	if resp := get(t, client, ts, disable, false); resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET %s returned %d, wanted %d", disable, resp.StatusCode, http.StatusMethodNotAllowed)
+	}
+
+	resp, err := client.PostForm(ts.URL+disable, url.Values{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("POST %s without a CSRF token returned %d, wanted %d", disable, resp.StatusCode, http.StatusBadRequest)
+	}
+
+	if user, _ := handlers.Repo.DB.GetUserById(ctx, id); user.Disabled() {
+		t.Fatal("expected the user not to be disabled without a CSRF token")
+	}
+
+	// the form on the user's page carries the token
+	page := get(t, client, ts, fmt.Sprintf("/admin/users/%d", id), false)
+	body, _ := io.ReadAll(page.Body)
+	m := csrfField.FindSubmatch(body)
+	if m == nil {
+		t.Fatal("no CSRF token on the user's page")
+	}
+
+	resp, err = client.PostForm(ts.URL+disable, url.Values{"csrf_token": {html.UnescapeString(string(m[1]))}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusSeeOther {
+		t.Errorf("POST %s returned %d, wanted %d", disable, resp.StatusCode, http.StatusSeeOther)
+	}
+
+	if user, _ := handlers.Repo.DB.GetUserById(ctx, id); !user.Disabled() {
+		t.Error("expected the user to be disabled")
+	}
+}
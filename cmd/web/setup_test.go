@@ -1,14 +1,50 @@
 package main
 
 import (
+	"log"
 	"net/http"
 	"os"
 	"testing"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/hd719/go-bookings/internal/handlers"
+	"github.com/hd719/go-bookings/internal/helpers"
+	"github.com/hd719/go-bookings/internal/lockout"
+	"github.com/hd719/go-bookings/internal/models"
+	"github.com/hd719/go-bookings/internal/render"
 )
 
 func TestMain(m *testing.M) {
+	// The app runs from the root of the repo, where the templates are
+	if err := os.Chdir("../.."); err != nil {
+		log.Fatal(err)
+	}
+
+	infoLog = log.New(os.Stdout, "INFO \t", log.Ldate|log.Ltime)
+	app.InfoLog = infoLog
+	errorLog = log.New(os.Stdout, "ERROR \t", log.Ldate|log.Ltime|log.Lshortfile)
+	app.ErrorLog = errorLog
+
+	session = scs.New()
+	session.Lifetime = 24 * time.Hour
+	session.Cookie.SameSite = http.SameSiteLaxMode
+	app.Session = session
+
+	app.MailChan = make(chan models.MailData)
+	go func() {
+		for range app.MailChan {
+		}
+	}()
+
+	app.UploadPath = "./uploads"
+	app.LinkSecret = []byte("test-secret")
+	app.Lockout = &lockout.Limiter{Store: lockout.NewMemoryStore()}
+
+	render.NewRenderer(&app)
+	helpers.NewHelpers(&app)
+	handlers.NewMemoryRepo(&app)
 
-	// Do something (set vars) and then before exiting THEN RUN MY TESTS
 	os.Exit(m.Run())
 }
 
@@ -0,0 +1,184 @@
+// Package audit describes the entries of the audit log: who made a change, from where, and what it changed
+package audit
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"reflect"
+	"time"
+
+	"github.com/hd719/go-bookings/internal/models"
+)
+
+// The actions recorded in the audit log
+const (
+	Create       = "create"
+	Update       = "update"
+	Delete       = "delete"
+	Restore      = "restore"
+	Purge        = "purge"
+	Cancel       = "cancel"
+	ChangeStatus = "status"
+	Block        = "block"
+)
+
+// The kinds of entities changes are recorded for
+const (
+	Reservation        = "reservation"
+	Room               = "room"
+	RoomPhoto          = "room_photo"
+	RoomRestriction    = "room_restriction"
+	RatePlan           = "rate_plan"
+	StayRule           = "stay_rule"
+	CancellationPolicy = "cancellation_policy"
+	Restriction        = "restriction"
+	User               = "user"
+)
+
+// Entities lists every kind of entity, in the order they are offered to filter the audit log by
+var Entities = []string{Reservation, Room, RoomPhoto, RoomRestriction, RatePlan, StayRule, CancellationPolicy, Restriction, User}
+
+// Actor is who made a change, a logged in user, a guest or the app itself
+type Actor struct {
+	UserID int // 0 unless a user was logged in
+	Name   string
+}
+
+// Guest is the actor for changes made by someone who isn't logged in
+var Guest = Actor{Name: "guest"}
+
+// System is the actor for changes the app makes by itself, e.g. purging the trash
+var System = Actor{Name: "system"}
+
+// Request is where a change came from
+type Request struct {
+	IP        string
+	UserAgent string
+	Method    string
+	Path      string
+}
+
+// NewRequest returns where r came from
+func NewRequest(r *http.Request) Request {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	return Request{IP: ip, UserAgent: r.UserAgent(), Method: r.Method, Path: r.URL.Path}
+}
+
+type contextKey int
+
+const (
+	actorKey contextKey = iota
+	requestKey
+)
+
+// WithActor returns a copy of ctx that changes are recorded as made by a in
+func WithActor(ctx context.Context, a Actor) context.Context {
+	return context.WithValue(ctx, actorKey, a)
+}
+
+// ActorFrom returns who is making changes in ctx, System if nobody was set
+func ActorFrom(ctx context.Context) Actor {
+	if a, ok := ctx.Value(actorKey).(Actor); ok {
+		return a
+	}
+
+	return System
+}
+
+// WithRequest returns a copy of ctx that changes are recorded as coming from r in
+func WithRequest(ctx context.Context, r Request) context.Context {
+	return context.WithValue(ctx, requestKey, r)
+}
+
+// RequestFrom returns where the changes in ctx come from, empty outside of a request
+func RequestFrom(ctx context.Context) Request {
+	r, _ := ctx.Value(requestKey).(Request)
+	return r
+}
+
+// NewEntry returns an entry for the audit log of a change made in ctx
+func NewEntry(ctx context.Context, action, entity string, entityID int, changes []models.FieldChange) models.AuditEntry {
+	actor := ActorFrom(ctx)
+	r := RequestFrom(ctx)
+
+	e := models.AuditEntry{
+		UserID:    actor.UserID,
+		Actor:     actor.Name,
+		Action:    action,
+		Entity:    entity,
+		EntityID:  entityID,
+		Changes:   changes,
+		IP:        r.IP,
+		UserAgent: r.UserAgent,
+		CreatedAt: time.Now(),
+	}
+
+	if r.Method != "" {
+		e.Request = r.Method + " " + r.Path
+	}
+
+	return e
+}
+
+// Diff returns the fields that differ between two values of the same struct type, either of which may be nil for a
+// value that was created or deleted. Fields of nested structs are named Parent.Field, fields tagged `audit:"-"` and the
+// CreatedAt and UpdatedAt timestamps are left out
+func Diff(before, after interface{}) []models.FieldChange {
+	b, a := reflect.ValueOf(before), reflect.ValueOf(after)
+	switch {
+	case !b.IsValid() && !a.IsValid():
+		return nil
+	case !b.IsValid():
+		b = reflect.Zero(a.Type())
+	case !a.IsValid():
+		a = reflect.Zero(b.Type())
+	}
+
+	var changes []models.FieldChange
+	diffStruct("", b, a, &changes)
+
+	return changes
+}
+
+func diffStruct(prefix string, before, after reflect.Value, changes *[]models.FieldChange) {
+	t := before.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() || f.Tag.Get("audit") == "-" || f.Name == "CreatedAt" || f.Name == "UpdatedAt" {
+			continue
+		}
+
+		b, a := before.Field(i), after.Field(i)
+		if f.Type.Kind() == reflect.Struct && f.Type != reflect.TypeOf(time.Time{}) {
+			diffStruct(prefix+f.Name+".", b, a, changes)
+			continue
+		}
+
+		bs, as := format(b), format(a)
+		if bs != as {
+			*changes = append(*changes, models.FieldChange{Field: prefix + f.Name, Before: bs, After: as})
+		}
+	}
+}
+
+// format returns how a field value is shown in the audit log, empty for the zero value
+func format(v reflect.Value) string {
+	if v.IsZero() || (v.Kind() == reflect.Slice && v.Len() == 0) {
+		return ""
+	}
+
+	if t, ok := v.Interface().(time.Time); ok {
+		if t.Equal(t.Truncate(24 * time.Hour)) {
+			return t.Format("2006-01-02")
+		}
+		return t.Format("2006-01-02 15:04:05")
+	}
+
+	return fmt.Sprint(v.Interface())
+}
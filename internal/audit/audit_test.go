@@ -0,0 +1,76 @@
+package audit
+
+import (
+	"context"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hd719/go-bookings/internal/models"
+)
+
+func TestDiff(t *testing.T) {
+	before := models.Reservation{
+		ID:        1,
+		FirstName: "Jane",
+		StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+		UpdatedAt: time.Now(),
+		Room:      models.Room{RoomName: "General's Quarters"},
+	}
+
+	after := before
+	after.FirstName = "Janet"
+	after.CancelledAt = time.Date(2049, 12, 1, 9, 30, 0, 0, time.UTC)
+	after.Cancellation.LateFee = 5000
+	after.UpdatedAt = time.Now().Add(time.Hour)
+	after.Room.RoomName = "Major's Suite"
+
+	want := []models.FieldChange{
+		{Field: "FirstName", Before: "Jane", After: "Janet"},
+		{Field: "CancelledAt", Before: "", After: "2049-12-01 09:30:00"},
+		{Field: "Cancellation.LateFee", Before: "", After: "5000"},
+	}
+
+	if got := Diff(before, after); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %+v but got %+v", want, got)
+	}
+
+	if got := Diff(before, before); len(got) != 0 {
+		t.Errorf("expected no changes but got %+v", got)
+	}
+}
+
+func TestDiff_CreatedAndDeleted(t *testing.T) {
+	u := models.User{ID: 2, Email: "jane@example.com", Password: "secret"}
+
+	created := Diff(nil, u)
+	want := []models.FieldChange{{Field: "ID", After: "2"}, {Field: "Email", After: "jane@example.com"}}
+	if !reflect.DeepEqual(created, want) {
+		t.Errorf("expected %+v without the password but got %+v", want, created)
+	}
+
+	deleted := Diff(models.Room{RoomName: "Attic", Amenities: []string{"wifi", "desk"}}, nil)
+	want = []models.FieldChange{{Field: "RoomName", Before: "Attic"}, {Field: "Amenities", Before: "[wifi desk]"}}
+	if !reflect.DeepEqual(deleted, want) {
+		t.Errorf("expected %+v but got %+v", want, deleted)
+	}
+}
+
+func TestNewEntry(t *testing.T) {
+	e := NewEntry(context.Background(), Purge, Reservation, 0, nil)
+	if e.Actor != "system" || e.UserID != 0 || e.Request != "" {
+		t.Errorf("expected an entry by the system but got %+v", e)
+	}
+
+	r := httptest.NewRequest("POST", "/admin/reservations/all/3", nil)
+	r.RemoteAddr = "203.0.113.7:52100"
+	r.Header.Set("User-Agent", "test-agent")
+
+	ctx := WithRequest(WithActor(context.Background(), Actor{UserID: 1, Name: "admin@admin.com"}), NewRequest(r))
+	e = NewEntry(ctx, Update, Reservation, 3, nil)
+
+	if e.UserID != 1 || e.Actor != "admin@admin.com" || e.IP != "203.0.113.7" || e.UserAgent != "test-agent" || e.Request != "POST /admin/reservations/all/3" {
+		t.Errorf("expected an entry by the admin with the request but got %+v", e)
+	}
+}
@@ -0,0 +1,100 @@
+// Package blocks expands a room block, possibly recurring, into the room restrictions that hold its dates
+package blocks
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hd719/go-bookings/internal/models"
+)
+
+// MaxOccurrences caps how many room restrictions a single block can expand into, about two years of weekly blocks
+const MaxOccurrences = 104
+
+var (
+	ErrEndBeforeStart     = errors.New("The last day can't be before the first day")
+	ErrNoRepeatUntil      = errors.New("Pick the day the block repeats until")
+	ErrRepeatUntilTooSoon = errors.New("The block has to repeat until on or after its first day")
+	ErrOverlapsItself     = errors.New("The block is longer than the time between its repeats")
+	ErrTooManyOccurrences = fmt.Errorf("A block can repeat at most %d times", MaxOccurrences)
+)
+
+// Expand returns a room restriction for each occurrence of b, in date order
+// The room restrictions end the day after the last blocked day, like every other room restriction
+func Expand(b models.RoomBlock) ([]models.RoomRestriction, error) {
+	if b.EndDate.Before(b.StartDate) {
+		return nil, ErrEndBeforeStart
+	}
+
+	occurrence := func(start time.Time) models.RoomRestriction {
+		return models.RoomRestriction{
+			RoomID:        b.RoomID,
+			RestrictionID: b.RestrictionID,
+			StartDate:     start,
+			EndDate:       start.Add(b.EndDate.Sub(b.StartDate)).AddDate(0, 0, 1),
+		}
+	}
+
+	if b.RepeatWeeks <= 0 {
+		return []models.RoomRestriction{occurrence(b.StartDate)}, nil
+	}
+
+	if b.RepeatUntil.IsZero() {
+		return nil, ErrNoRepeatUntil
+	}
+
+	if b.RepeatUntil.Before(b.StartDate) {
+		return nil, ErrRepeatUntilTooSoon
+	}
+
+	interval := 7 * b.RepeatWeeks
+	if days := int(b.EndDate.Sub(b.StartDate).Hours()/24) + 1; days > interval {
+		return nil, ErrOverlapsItself
+	}
+
+	var occurrences []models.RoomRestriction
+	for start := b.StartDate; !start.After(b.RepeatUntil); start = start.AddDate(0, 0, interval) {
+		if len(occurrences) == MaxOccurrences {
+			return nil, ErrTooManyOccurrences
+		}
+		occurrences = append(occurrences, occurrence(start))
+	}
+
+	return occurrences, nil
+}
+
+// Remove returns what is left of the room restriction r once days are taken out of it, one room restriction for
+// each run of days in a row that is kept. Nothing is left when every day of r is removed
+func Remove(r models.RoomRestriction, days []time.Time) []models.RoomRestriction {
+	removed := make(map[string]bool)
+	for _, d := range days {
+		removed[d.Format("2006-01-02")] = true
+	}
+
+	var left []models.RoomRestriction
+	keep := func(start, end time.Time) {
+		piece := r
+		piece.ID = 0
+		piece.StartDate = start
+		piece.EndDate = end
+		left = append(left, piece)
+	}
+
+	var start time.Time
+	for d := r.StartDate; d.Before(r.EndDate); d = d.AddDate(0, 0, 1) {
+		switch {
+		case removed[d.Format("2006-01-02")] && !start.IsZero():
+			keep(start, d)
+			start = time.Time{}
+		case !removed[d.Format("2006-01-02")] && start.IsZero():
+			start = d
+		}
+	}
+
+	if !start.IsZero() {
+		keep(start, r.EndDate)
+	}
+
+	return left
+}
@@ -0,0 +1,133 @@
+package blocks
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hd719/go-bookings/internal/models"
+)
+
+func date(s string) time.Time {
+	d, _ := time.Parse("2006-01-02", s)
+	return d
+}
+
+func TestExpand(t *testing.T) {
+	var tests = []struct {
+		name   string
+		block  models.RoomBlock
+		starts []string
+		nights int
+		err    error
+	}{
+		{
+			name:   "single day",
+			block:  models.RoomBlock{StartDate: date("2050-03-07"), EndDate: date("2050-03-07")},
+			starts: []string{"2050-03-07"},
+			nights: 1,
+		},
+		{
+			name:   "range",
+			block:  models.RoomBlock{StartDate: date("2050-03-07"), EndDate: date("2050-03-20")},
+			starts: []string{"2050-03-07"},
+			nights: 14,
+		},
+		{
+			name:   "every monday",
+			block:  models.RoomBlock{StartDate: date("2050-03-07"), EndDate: date("2050-03-07"), RepeatWeeks: 1, RepeatUntil: date("2050-03-28")},
+			starts: []string{"2050-03-07", "2050-03-14", "2050-03-21", "2050-03-28"},
+			nights: 1,
+		},
+		{
+			name:   "weekend every other week",
+			block:  models.RoomBlock{StartDate: date("2050-03-05"), EndDate: date("2050-03-06"), RepeatWeeks: 2, RepeatUntil: date("2050-04-01")},
+			starts: []string{"2050-03-05", "2050-03-19"},
+			nights: 2,
+		},
+		{
+			name:  "backwards",
+			block: models.RoomBlock{StartDate: date("2050-03-07"), EndDate: date("2050-03-06")},
+			err:   ErrEndBeforeStart,
+		},
+		{
+			name:  "no end to the repeats",
+			block: models.RoomBlock{StartDate: date("2050-03-07"), EndDate: date("2050-03-07"), RepeatWeeks: 1},
+			err:   ErrNoRepeatUntil,
+		},
+		{
+			name:  "repeats until before it starts",
+			block: models.RoomBlock{StartDate: date("2050-03-07"), EndDate: date("2050-03-07"), RepeatWeeks: 1, RepeatUntil: date("2050-03-01")},
+			err:   ErrRepeatUntilTooSoon,
+		},
+		{
+			name:  "longer than a week, every week",
+			block: models.RoomBlock{StartDate: date("2050-03-07"), EndDate: date("2050-03-14"), RepeatWeeks: 1, RepeatUntil: date("2050-04-01")},
+			err:   ErrOverlapsItself,
+		},
+		{
+			name:  "too many repeats",
+			block: models.RoomBlock{StartDate: date("2050-03-07"), EndDate: date("2050-03-07"), RepeatWeeks: 1, RepeatUntil: date("2060-03-01")},
+			err:   ErrTooManyOccurrences,
+		},
+	}
+
+	for _, e := range tests {
+		got, err := Expand(e.block)
+		if err != e.err {
+			t.Errorf("%s: expected error %v but got %v", e.name, e.err, err)
+			continue
+		}
+
+		if len(got) != len(e.starts) {
+			t.Errorf("%s: expected %d occurrences but got %d", e.name, len(e.starts), len(got))
+			continue
+		}
+
+		for i, r := range got {
+			if !r.StartDate.Equal(date(e.starts[i])) || !r.EndDate.Equal(r.StartDate.AddDate(0, 0, e.nights)) {
+				t.Errorf("%s: unexpected occurrence %d from %s to %s", e.name, i, r.StartDate, r.EndDate)
+			}
+		}
+	}
+}
+
+func TestRemove(t *testing.T) {
+	// blocks the 10th, 11th and 12th
+	block := models.RoomRestriction{ID: 7, RoomID: 2, RestrictionID: 3, StartDate: date("2050-03-10"), EndDate: date("2050-03-13")}
+
+	var tests = []struct {
+		name    string
+		removed []string
+		left    [][2]string // the first and last blocked day of each piece
+	}{
+		{"nothing", nil, [][2]string{{"2050-03-10", "2050-03-12"}}},
+		{"first day", []string{"2050-03-10"}, [][2]string{{"2050-03-11", "2050-03-12"}}},
+		{"middle day", []string{"2050-03-11"}, [][2]string{{"2050-03-10", "2050-03-10"}, {"2050-03-12", "2050-03-12"}}},
+		{"last day", []string{"2050-03-12"}, [][2]string{{"2050-03-10", "2050-03-11"}}},
+		{"every day", []string{"2050-03-10", "2050-03-11", "2050-03-12"}, nil},
+		{"another day", []string{"2050-03-20"}, [][2]string{{"2050-03-10", "2050-03-12"}}},
+	}
+
+	for _, e := range tests {
+		var days []time.Time
+		for _, d := range e.removed {
+			days = append(days, date(d))
+		}
+
+		got := Remove(block, days)
+		if len(got) != len(e.left) {
+			t.Errorf("%s: expected %d pieces left but got %+v", e.name, len(e.left), got)
+			continue
+		}
+
+		for i, r := range got {
+			if !r.StartDate.Equal(date(e.left[i][0])) || !r.EndDate.Equal(date(e.left[i][1]).AddDate(0, 0, 1)) {
+				t.Errorf("%s: unexpected piece %d from %s to %s", e.name, i, r.StartDate, r.EndDate)
+			}
+
+			if r.ID != 0 || r.RoomID != block.RoomID || r.RestrictionID != block.RestrictionID {
+				t.Errorf("%s: expected a new piece of the same block but got %+v", e.name, r)
+			}
+		}
+	}
+}
@@ -0,0 +1,71 @@
+// Package cancellation works out the terms of a cancellation policy for a stay and the fee for cancelling it
+package cancellation
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hd719/go-bookings/internal/models"
+	"github.com/hd719/go-bookings/internal/pricing"
+)
+
+// The ways the fee of a late cancellation is worked out
+const (
+	FeePercent    = "percent"     // a percentage of the total
+	FeeFirstNight = "first_night" // the price of the first night
+)
+
+// Terms applies policy to a stay priced by quote, a zero policy means cancelling is always free
+func Terms(policy models.CancellationPolicy, quote pricing.Quote) models.CancellationTerms {
+	if policy.ID == 0 {
+		return models.CancellationTerms{}
+	}
+
+	terms := models.CancellationTerms{Policy: policy.Name, FreeDays: policy.FreeDays}
+
+	switch policy.FeeType {
+	case FeePercent:
+		terms.LateFee = quote.Total * policy.FeePercent / 100
+	case FeeFirstNight:
+		if len(quote.Nights) > 0 {
+			terms.LateFee = quote.Nights[0].Rate
+		}
+	}
+
+	return terms
+}
+
+// Deadline returns the last moment a stay arriving on arrival can be cancelled for free
+func Deadline(terms models.CancellationTerms, arrival time.Time) time.Time {
+	return arrival.AddDate(0, 0, -terms.FreeDays)
+}
+
+// Fee returns what cancelling a stay arriving on arrival costs at the time at
+func Fee(terms models.CancellationTerms, arrival, at time.Time) int {
+	if at.Before(Deadline(terms, arrival)) {
+		return 0
+	}
+
+	return terms.LateFee
+}
+
+// Describe explains a policy to a guest, e.g. "Free cancellation until 7 days before arrival, then 50% of the total"
+func Describe(policy models.CancellationPolicy) string {
+	if policy.ID == 0 {
+		return "Free cancellation"
+	}
+
+	var fee string
+	switch policy.FeeType {
+	case FeePercent:
+		fee = fmt.Sprintf("%d%% of the total", policy.FeePercent)
+	case FeeFirstNight:
+		fee = "the price of the first night"
+	}
+
+	if policy.FreeDays == 0 {
+		return fmt.Sprintf("Cancelling before the day of arrival is free, after that %s is charged", fee)
+	}
+
+	return fmt.Sprintf("Free cancellation until %d days before arrival, after that %s is charged", policy.FreeDays, fee)
+}
@@ -0,0 +1,61 @@
+package cancellation
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hd719/go-bookings/internal/models"
+	"github.com/hd719/go-bookings/internal/pricing"
+)
+
+func date(s string) time.Time {
+	d, _ := time.Parse("2006-01-02", s)
+	return d
+}
+
+func TestTerms(t *testing.T) {
+	quote := pricing.NewQuote(models.Room{NightlyRate: 10000, WeekendRate: 15000}, nil, date("2050-01-07"), date("2050-01-10"))
+
+	var tests = []struct {
+		name    string
+		policy  models.CancellationPolicy
+		lateFee int
+	}{
+		{"no policy", models.CancellationPolicy{}, 0},
+		{"half", models.CancellationPolicy{ID: 1, FeeType: FeePercent, FeePercent: 50}, 20000},
+		{"first night", models.CancellationPolicy{ID: 2, FeeType: FeeFirstNight}, 15000},
+	}
+
+	for _, e := range tests {
+		if terms := Terms(e.policy, quote); terms.LateFee != e.lateFee {
+			t.Errorf("%s: expected a late fee of %d but got %d", e.name, e.lateFee, terms.LateFee)
+		}
+	}
+}
+
+func TestFee(t *testing.T) {
+	terms := models.CancellationTerms{Policy: "Moderate", FreeDays: 7, LateFee: 20000}
+	arrival := date("2050-03-15")
+
+	var tests = []struct {
+		name string
+		at   time.Time
+		fee  int
+	}{
+		{"weeks before", date("2050-02-01"), 0},
+		{"just before the deadline", date("2050-03-07").Add(23 * time.Hour), 0},
+		{"on the deadline", date("2050-03-08"), 20000},
+		{"on the day", date("2050-03-15"), 20000},
+	}
+
+	for _, e := range tests {
+		if fee := Fee(terms, arrival, e.at); fee != e.fee {
+			t.Errorf("%s: expected a fee of %d but got %d", e.name, e.fee, fee)
+		}
+	}
+
+	// without a policy cancelling is free up to the day of arrival
+	if fee := Fee(models.CancellationTerms{}, arrival, arrival); fee != 0 {
+		t.Errorf("expected no fee without a policy but got %d", fee)
+	}
+}
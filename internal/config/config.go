@@ -3,18 +3,27 @@ package config
 import (
 	"html/template"
 	"log"
+	"time"
 
 	"github.com/alexedwards/scs/v2"
+	"github.com/hd719/go-bookings/internal/lockout"
 	"github.com/hd719/go-bookings/internal/models"
 )
 
 // AppConfig holds the application configuration, which is initialized in main.go
 type AppConfig struct {
-	UseCache      bool
-	TemplateCache map[string]*template.Template
-	InfoLog       *log.Logger
-	ErrorLog      *log.Logger
-	InProduction  bool
-	Session       *scs.SessionManager
-	MailChan      chan models.MailData
+	UseCache       bool
+	TemplateCache  map[string]*template.Template
+	InfoLog        *log.Logger
+	ErrorLog       *log.Logger
+	InProduction   bool
+	Session        *scs.SessionManager
+	MailChan       chan models.MailData
+	DBTimeout      time.Duration    // how long a single database query may run before it is cancelled
+	UploadPath     string           // directory uploaded room photos are stored in, served at /uploads/
+	BaseURL        string           // scheme and host of the site, e.g. https://example.com, links in emails start with it
+	LinkSecret     []byte           // key the links guests manage their reservation with are signed with
+	Retention      time.Duration    // how long deleted reservations stay in the trash before they are purged
+	TwoFactorRoles []string         // roles that have to log in with two-factor authentication
+	Lockout        *lockout.Limiter // counts failed logins, too many slow down the next ones and lock the account out
 }
@@ -0,0 +1,44 @@
+// Package confirmation generates the codes guests use to look up their reservation
+package confirmation
+
+import (
+	"crypto/rand"
+	"strings"
+)
+
+// alphabet leaves out the letters and digits that are easily mistaken for each other, e.g. O and 0
+const alphabet = "ABCDEFGHJKMNPQRSTVWXYZ23456789"
+
+// Length of a code, 30^10 possible codes make them impractical to guess
+const Length = 10
+
+// NewCode returns a random confirmation code, e.g. "K7MD2QXW9P"
+func NewCode() (string, error) {
+	b := make([]byte, Length)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	// 256 is not a multiple of len(alphabet), redraw the bytes that would make some characters more likely than others
+	limit := byte(256 - 256%len(alphabet))
+	for i := range b {
+		for b[i] >= limit {
+			if _, err := rand.Read(b[i : i+1]); err != nil {
+				return "", err
+			}
+		}
+		b[i] = alphabet[int(b[i])%len(alphabet)]
+	}
+
+	return string(b), nil
+}
+
+// Normalize turns a code the way a guest might type it, e.g. "k7md-2qxw 9p", into the way it is stored
+func Normalize(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(strings.TrimSpace(code)))
+}
@@ -0,0 +1,49 @@
+package confirmation
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewCode(t *testing.T) {
+	seen := make(map[string]bool)
+
+	for i := 0; i < 1000; i++ {
+		code, err := NewCode()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(code) != Length {
+			t.Fatalf("expected a code of %d characters but got %q", Length, code)
+		}
+
+		for _, c := range code {
+			if !strings.ContainsRune(alphabet, c) {
+				t.Fatalf("unexpected character %q in %q", c, code)
+			}
+		}
+
+		if seen[code] {
+			t.Fatalf("got %q twice", code)
+		}
+		seen[code] = true
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	var tests = []struct {
+		code     string
+		expected string
+	}{
+		{"K7MD2QXW9P", "K7MD2QXW9P"},
+		{" k7md-2qxw 9p ", "K7MD2QXW9P"},
+		{"", ""},
+	}
+
+	for _, e := range tests {
+		if got := Normalize(e.code); got != e.expected {
+			t.Errorf("Normalize(%q) returned %q, expected %q", e.code, got, e.expected)
+		}
+	}
+}
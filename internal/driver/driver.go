@@ -11,7 +11,8 @@ import (
 )
 
 type DB struct {
-	SQL *sql.DB
+	SQL     *sql.DB
+	Dialect string // "postgres" or "sqlite3", used to pick the right migration files
 }
 
 var dbConn = &DB{}
@@ -32,6 +33,7 @@ func ConnectSQL(dsn string) (*DB, error) {
 	d.SetConnMaxLifetime(maxDbLifeTime)
 
 	dbConn.SQL = d
+	dbConn.Dialect = "postgres"
 
 	err = TestDb(dbConn.SQL)
 	if err != nil {
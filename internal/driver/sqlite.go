@@ -0,0 +1,28 @@
+package driver
+
+import (
+	"database/sql"
+	"fmt"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// ConnectSQLite opens the SQLite database at path, creating the file if it does not exist
+func ConnectSQLite(path string) (*DB, error) {
+	// Foreign keys are off by default in SQLite, and a busy timeout saves us from "database is locked" errors
+	dsn := fmt.Sprintf("file:%s?_foreign_keys=on&_busy_timeout=5000&_journal_mode=WAL", path)
+
+	d, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite only allows a single writer, so a single connection serializes transactions for us
+	d.SetMaxOpenConns(1)
+
+	if err = TestDb(d); err != nil {
+		return nil, err
+	}
+
+	return &DB{SQL: d, Dialect: "sqlite3"}, nil
+}
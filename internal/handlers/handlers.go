@@ -1,24 +1,46 @@
 package handlers
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"html/template"
+	"io"
 	"log"
 	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/go-chi/chi"
+	"github.com/hd719/go-bookings/internal/audit"
+	"github.com/hd719/go-bookings/internal/blocks"
+	"github.com/hd719/go-bookings/internal/cancellation"
 	"github.com/hd719/go-bookings/internal/config"
+	"github.com/hd719/go-bookings/internal/confirmation"
 	"github.com/hd719/go-bookings/internal/driver"
 	"github.com/hd719/go-bookings/internal/forms"
 	"github.com/hd719/go-bookings/internal/helpers"
 	"github.com/hd719/go-bookings/internal/models"
+	"github.com/hd719/go-bookings/internal/pricing"
 	"github.com/hd719/go-bookings/internal/render"
 	"github.com/hd719/go-bookings/internal/repository"
 	"github.com/hd719/go-bookings/internal/repository/dbrepo"
+	"github.com/hd719/go-bookings/internal/roles"
+	"github.com/hd719/go-bookings/internal/signing"
+	"github.com/hd719/go-bookings/internal/status"
+	"github.com/hd719/go-bookings/internal/stayrules"
+	"github.com/hd719/go-bookings/internal/tokens"
+	"github.com/hd719/go-bookings/internal/twofactor"
 )
 
 type Repository struct {
@@ -44,13 +66,28 @@ func NewRepo(a *config.AppConfig, db *driver.DB) {
 	}
 }
 
+// NewMemoryRepo sets up the handlers with an in-memory database, nothing is kept between restarts
+func NewMemoryRepo(a *config.AppConfig) {
+	Repo = &Repository{
+		App: a,
+		DB:  dbrepo.NewMemoryRepo(a),
+	}
+}
+
+// NewSQLiteRepo sets up the handlers with a SQLite database file
+func NewSQLiteRepo(a *config.AppConfig, db *driver.DB) {
+	Repo = &Repository{
+		App: a,
+		DB:  dbrepo.NewSQLiteRepo(db.SQL, a),
+	}
+}
+
 // This may not be needed, but DO NOT DELETE
 func NewHandlers(r *Repository) {
 	Repo = r
 }
 
 func (m *Repository) Home(w http.ResponseWriter, r *http.Request) {
-	m.DB.AllUsers()
 	render.Template(w, r, "home.page.tmpl", &models.TemplateData{})
 }
 
@@ -58,12 +95,72 @@ func (m *Repository) About(w http.ResponseWriter, r *http.Request) {
 	render.Template(w, r, "about.page.tmpl", &models.TemplateData{})
 }
 
-func (m *Repository) Generals(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "generals.page.tmpl", &models.TemplateData{})
+// Rooms lists the rooms guests can book
+func (m *Repository) Rooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	var active []models.Room
+	for _, room := range rooms {
+		if room.Active {
+			active = append(active, room)
+		}
+	}
+
+	if err := m.loadPhotos(r.Context(), active); err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = active
+
+	render.Template(w, r, "rooms.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// Room shows a single room, looked up by the slug in the url
+func (m *Repository) Room(w http.ResponseWriter, r *http.Request) {
+	room, err := m.DB.GetRoomBySlug(r.Context(), chi.URLParam(r, "slug"))
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !room.Active) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	room.Photos, err = m.DB.GetPhotosForRoom(r.Context(), room.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["room"] = room
+
+	render.Template(w, r, "room.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
 }
 
-func (m *Repository) Majors(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "majors.page.tmpl", &models.TemplateData{})
+// loadPhotos fills in the photo gallery of each room, the first photo is used as the cover
+func (m *Repository) loadPhotos(ctx context.Context, rooms []models.Room) error {
+	for i := range rooms {
+		photos, err := m.DB.GetPhotosForRoom(ctx, rooms[i].ID)
+		if err != nil {
+			return err
+		}
+		rooms[i].Photos = photos
+	}
+
+	return nil
 }
 
 func (m *Repository) Availability(w http.ResponseWriter, r *http.Request) {
@@ -87,22 +184,38 @@ func (m *Repository) PostAvailability(w http.ResponseWriter, r *http.Request) {
 		helpers.ServerError(w, err)
 	}
 
-	rooms, err := m.DB.SearchAvailabilityForAllRooms(startDate, endDate)
+	rooms, exclusions, err := m.DB.SearchAvailabilityForAllRooms(r.Context(), startDate, endDate)
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
 
-	// No available rooms
-	if len(rooms) == 0 {
+	// No available rooms, if the stay rules of a room are the only reason we show the guest why on the choose room page
+	if len(rooms) == 0 && len(exclusions) == 0 {
 		m.App.InfoLog.Println("No availability ")
 		m.App.Session.Put(r.Context(), "error", "No availability")
 		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
 		return
 	}
 
+	if err := m.loadPhotos(r.Context(), rooms); err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	quotes := make(map[int]pricing.Quote)
+	for _, room := range rooms {
+		quotes[room.ID], err = m.DB.GetRatesForStay(r.Context(), room.ID, startDate, endDate)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
 	data := make(map[string]interface{})
 	data["rooms"] = rooms
+	data["quotes"] = quotes
+	data["exclusions"] = exclusions
 
 	res := models.Reservation{
 		StartDate: startDate,
@@ -150,7 +263,7 @@ func (m *Repository) AvailabilityJSON(w http.ResponseWriter, r *http.Request) {
 
 	roomID, _ := strconv.Atoi(r.Form.Get("room_id"))
 
-	available, _ := m.DB.SearchAvailabilityByDatesForRoomId(startDate, endDate, roomID)
+	available, err := m.DB.SearchAvailabilityByDatesForRoomId(r.Context(), startDate, endDate, roomID)
 
 	if err != nil {
 		// cant parse form
@@ -172,6 +285,20 @@ func (m *Repository) AvailabilityJSON(w http.ResponseWriter, r *http.Request) {
 		RoomID:    strconv.Itoa(roomID),
 	}
 
+	// A free room can still be excluded by its stay rules, the guest gets the reason
+	if available {
+		var violation *stayrules.Violation
+		if err := m.DB.CheckStayRules(r.Context(), roomID, startDate, endDate); errors.As(err, &violation) {
+			resp.OK = false
+			resp.Message = violation.Reason
+		} else if err != nil {
+			resp = jsonResponse{
+				OK:      false,
+				Message: "error connecting to db",
+			}
+		}
+	}
+
 	indent := "     "
 
 	// Removed the error check since we handle all aspects of json
@@ -197,7 +324,7 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	room, err := m.DB.GetRoomById(res.RoomID)
+	room, err := m.DB.GetRoomById(r.Context(), res.RoomID)
 	if err != nil {
 		m.App.Session.Put(r.Context(), "error", "cant find room")
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
@@ -206,6 +333,21 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 
 	res.Room.RoomName = room.RoomName
 
+	// The price shown here is worked out again when the reservation is booked, then kept with it even if the rates change
+	quote, err := m.DB.GetRatesForStay(r.Context(), room.ID, res.StartDate, res.EndDate)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	res.TotalPrice = quote.Total
+
+	// So are the cancellation terms
+	res.Cancellation, err = m.DB.GetCancellationTermsForStay(r.Context(), room.ID, res.StartDate, res.EndDate)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
 	m.App.Session.Put(r.Context(), "reservation", res)
 
 	sd := res.StartDate.Format("2006-01-02")
@@ -217,6 +359,8 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 
 	data := make(map[string]interface{})
 	data["reservation"] = res
+	data["quote"] = quote
+	data["fee_now"] = cancellation.Fee(res.Cancellation, res.StartDate, time.Now())
 
 	render.Template(w, r, "make-reservation.page.tmpl", &models.TemplateData{
 		Form:      forms.New(nil), // Initializing an empty form when we go the reservation page
@@ -274,28 +418,41 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 	// Form is Valid after passing validation:
 	fmt.Println("The form is valid")
 
-	// Inserting the reservation from the POST request into the database and getting the id of the reservation back
-	var newReservationId int
-	newReservationId, err = m.DB.InsertReservation(reservation)
+	// The guest looks the reservation up later with this code and their email
+	reservation.ConfirmationCode, err = confirmation.NewCode()
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
 
-	// Once the reservation has been posted (the room is now reserved), inserting the room restriction into the db
-	restriction := models.RoomRestriction{
-		StartDate:     reservation.StartDate,
-		EndDate:       reservation.EndDate,
-		RoomID:        reservation.RoomID,
-		ReservationID: newReservationId,
-		RestrictionID: 1,
+	// Inserting the reservation and its room restriction in one transaction, if someone else booked the room first we send the guest back to search again
+	reservation.ID, err = m.DB.BookRoom(r.Context(), reservation)
+	if errors.Is(err, repository.ErrRoomNotAvailable) {
+		m.App.Session.Put(r.Context(), "error", "Sorry, this room is no longer available for your dates. Please search again.")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+
+	var violation *stayrules.Violation
+	if errors.As(err, &violation) {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("Sorry, this room can't be booked for your dates. %s.", violation.Reason))
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
 	}
 
-	err = m.DB.InsertRoomRestriction(restriction)
+	// The booking priced the stay again, the guest is sent the price and terms that were kept
+	booked, err := m.DB.GetReservationById(r.Context(), reservation.ID)
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
+	reservation.TotalPrice = booked.TotalPrice
+	reservation.Cancellation = booked.Cancellation
 
 	// Adding the reservation object from line 121 into our session
 	m.App.Session.Put(r.Context(), "reservation", reservation)
@@ -304,8 +461,13 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 	htmlMessage := fmt.Sprintf(`
 		<strong>Reservation Confirmation</strong>
 		Dear, %s <br>
-		This is to confirm your reservation %s to %s
-	`, reservation.FirstName, reservation.StartDate.Format("2006-01-02"), reservation.EndDate.Format("2006-01-02"))
+		This is to confirm your reservation %s to %s <br>
+		Total: %s <br>
+		Your confirmation code is <strong>%s</strong>, you can look up your reservation at
+		<a href="%s/my-reservation?code=%s">%s/my-reservation</a> <br>
+		To change your dates or cancel, follow <a href="%s%s">this link</a>
+	`, reservation.FirstName, reservation.StartDate.Format("2006-01-02"), reservation.EndDate.Format("2006-01-02"), pricing.Format(reservation.TotalPrice),
+		reservation.ConfirmationCode, m.App.BaseURL, reservation.ConfirmationCode, m.App.BaseURL, m.App.BaseURL, m.manageLink(reservation))
 
 	msg := models.MailData{
 		To:      reservation.Email,
@@ -351,6 +513,7 @@ func (m *Repository) ReservationSummary(w http.ResponseWriter, r *http.Request)
 
 	data := make(map[string]interface{})
 	data["reservation"] = reservation
+	data["fee_now"] = cancellation.Fee(reservation.Cancellation, reservation.StartDate, time.Now())
 
 	sd := reservation.StartDate.Format("2006-01-02")
 	ed := reservation.EndDate.Format("2006-01-02")
@@ -364,6 +527,263 @@ func (m *Repository) ReservationSummary(w http.ResponseWriter, r *http.Request)
 	})
 }
 
+// MyReservation shows the form guests look up their reservation with, the code is filled in when following the link in the confirmation email
+func (m *Repository) MyReservation(w http.ResponseWriter, r *http.Request) {
+	stringMap := make(map[string]string)
+	stringMap["code"] = confirmation.Normalize(r.URL.Query().Get("code"))
+
+	render.Template(w, r, "my-reservation.page.tmpl", &models.TemplateData{
+		Form:      forms.New(nil),
+		StringMap: stringMap,
+	})
+}
+
+// PostMyReservation shows a reservation to the guest who made it, given its confirmation code and the email it was made with
+func (m *Repository) PostMyReservation(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	code := confirmation.Normalize(r.Form.Get("code"))
+	email := strings.TrimSpace(r.Form.Get("email"))
+
+	stringMap := make(map[string]string)
+	stringMap["code"] = code
+	stringMap["email"] = email
+
+	form := forms.New(r.PostForm)
+	form.Required("code", "email")
+	form.IsEmail("email")
+
+	if !form.Valid() {
+		render.Template(w, r, "my-reservation.page.tmpl", &models.TemplateData{
+			Form:      form,
+			StringMap: stringMap,
+		})
+		return
+	}
+
+	reservation, err := m.DB.GetReservationByConfirmationCode(r.Context(), code, email)
+	if errors.Is(err, sql.ErrNoRows) {
+		// The same message whether the code or the email is wrong, so codes can't be checked without knowing the email
+		form.Errors.Add("code", "We couldn't find a reservation with this confirmation code and email")
+		render.Template(w, r, "my-reservation.page.tmpl", &models.TemplateData{
+			Form:      form,
+			StringMap: stringMap,
+		})
+		return
+	}
+
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["reservation"] = reservation
+	stringMap["link"] = m.manageLink(reservation)
+
+	render.Template(w, r, "my-reservation.page.tmpl", &models.TemplateData{
+		Form:      form,
+		Data:      data,
+		StringMap: stringMap,
+	})
+}
+
+// manageLink returns the signed path a guest changes or cancels their reservation at, it only works for that reservation
+func (m *Repository) manageLink(res models.Reservation) string {
+	token := signing.Sign(m.App.LinkSecret, fmt.Sprintf("reservation:%d:%s", res.ID, res.ConfirmationCode))
+	return fmt.Sprintf("/my-reservation/%d/%s", res.ID, token)
+}
+
+// guestReservation returns the reservation the signed link of the request is for, links that don't match a reservation are not found
+func (m *Repository) guestReservation(w http.ResponseWriter, r *http.Request) (models.Reservation, bool) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	res, err := m.DB.GetReservationById(r.Context(), id)
+	if err != nil || !signing.Verify(m.App.LinkSecret, fmt.Sprintf("reservation:%d:%s", res.ID, res.ConfirmationCode), chi.URLParam(r, "token")) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return res, false
+	}
+
+	return res, true
+}
+
+// guestCanChange reports whether the guest can still change or cancel res themselves, the owner has to be asked once the stay has started
+func guestCanChange(res models.Reservation) bool {
+	return status.Open(res.Status) && res.StartDate.After(time.Now())
+}
+
+// renderManageReservation shows a reservation to its guest with the forms to change its dates or cancel it
+func (m *Repository) renderManageReservation(w http.ResponseWriter, r *http.Request, res models.Reservation, form *forms.Form, stringMap map[string]string) {
+	data := make(map[string]interface{})
+	data["reservation"] = res
+	data["can_change"] = guestCanChange(res)
+	data["fee_now"] = cancellation.Fee(res.Cancellation, res.StartDate, time.Now())
+
+	stringMap["link"] = m.manageLink(res)
+
+	render.Template(w, r, "manage-reservation.page.tmpl", &models.TemplateData{
+		Form:      form,
+		Data:      data,
+		StringMap: stringMap,
+	})
+}
+
+// mailGuestAndOwner lets both the guest and the owner know about a change to a reservation
+func (m *Repository) mailGuestAndOwner(res models.Reservation, subject, guestContent, ownerContent string) {
+	m.App.MailChan <- models.MailData{
+		To:      res.Email,
+		From:    "me@here.com",
+		Subject: subject,
+		Content: guestContent,
+	}
+
+	m.App.MailChan <- models.MailData{
+		To:      "me@here.com",
+		From:    "me@here.com",
+		Subject: subject,
+		Content: ownerContent,
+	}
+}
+
+// ManageReservation shows a reservation to the guest following the signed link from their confirmation email
+func (m *Repository) ManageReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.guestReservation(w, r)
+	if !ok {
+		return
+	}
+
+	stringMap := make(map[string]string)
+	stringMap["start_date"] = res.StartDate.Format("2006-01-02")
+	stringMap["end_date"] = res.EndDate.Format("2006-01-02")
+
+	m.renderManageReservation(w, r, res, forms.New(nil), stringMap)
+}
+
+// PostChangeReservation moves a reservation to the dates the guest picked, if the room is free and the stay rules allow it
+func (m *Repository) PostChangeReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.guestReservation(w, r)
+	if !ok {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if !guestCanChange(res) {
+		m.App.Session.Put(r.Context(), "error", "This reservation can no longer be changed online, please contact us")
+		http.Redirect(w, r, m.manageLink(res), http.StatusSeeOther)
+		return
+	}
+
+	stringMap := make(map[string]string)
+	stringMap["start_date"] = r.Form.Get("start_date")
+	stringMap["end_date"] = r.Form.Get("end_date")
+
+	form := forms.New(r.PostForm)
+	form.Required("start_date", "end_date")
+
+	layout := "2006-01-02"
+	start, err := time.Parse(layout, r.Form.Get("start_date"))
+	if err != nil {
+		form.Errors.Add("start_date", "Enter your new arrival date")
+	} else if !start.After(time.Now()) {
+		form.Errors.Add("start_date", "Your new arrival has to be after today")
+	}
+
+	end, err := time.Parse(layout, r.Form.Get("end_date"))
+	if err != nil {
+		form.Errors.Add("end_date", "Enter your new departure date")
+	} else if !end.After(start) {
+		form.Errors.Add("end_date", "Departure has to be after arrival")
+	}
+
+	if !form.Valid() {
+		m.renderManageReservation(w, r, res, form, stringMap)
+		return
+	}
+
+	old := res
+	res, err = m.DB.ChangeReservationDates(r.Context(), res.ID, start, end, "guest")
+
+	var violation *stayrules.Violation
+	switch {
+	case errors.Is(err, repository.ErrRoomNotAvailable):
+		form.Errors.Add("start_date", "Sorry, the room is not available for these dates")
+	case errors.As(err, &violation):
+		form.Errors.Add("start_date", violation.Reason)
+	case err != nil:
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if !form.Valid() {
+		m.renderManageReservation(w, r, old, form, stringMap)
+		return
+	}
+
+	m.mailGuestAndOwner(res, "Reservation Changed", fmt.Sprintf(`
+		<strong>Reservation Changed</strong>
+		Dear, %s <br>
+		Your reservation %s is now from %s to %s <br>
+		Total: %s
+	`, res.FirstName, res.ConfirmationCode, res.StartDate.Format("2006-01-02"), res.EndDate.Format("2006-01-02"), pricing.Format(res.TotalPrice)), fmt.Sprintf(`
+		<strong>Reservation Changed</strong>
+		%s moved reservation %s from %s - %s to %s - %s
+	`, res.FirstName, res.ConfirmationCode, old.StartDate.Format("2006-01-02"), old.EndDate.Format("2006-01-02"), res.StartDate.Format("2006-01-02"), res.EndDate.Format("2006-01-02")))
+
+	m.App.Session.Put(r.Context(), "flash", "Your reservation has been changed")
+	http.Redirect(w, r, m.manageLink(res), http.StatusSeeOther)
+}
+
+// PostCancelReservation cancels a reservation for its guest and frees its dates
+func (m *Repository) PostCancelReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.guestReservation(w, r)
+	if !ok {
+		return
+	}
+
+	if !guestCanChange(res) {
+		m.App.Session.Put(r.Context(), "error", "This reservation can no longer be cancelled online, please contact us")
+		http.Redirect(w, r, m.manageLink(res), http.StatusSeeOther)
+		return
+	}
+
+	fee, err := m.DB.CancelReservation(r.Context(), res.ID, "guest")
+	if errors.Is(err, repository.ErrReservationCancelled) {
+		http.Redirect(w, r, m.manageLink(res), http.StatusSeeOther)
+		return
+	}
+
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.mailGuestAndOwner(res, "Reservation Cancelled", fmt.Sprintf(`
+		<strong>Reservation Cancelled</strong>
+		Dear, %s <br>
+		Your reservation %s from %s to %s has been cancelled <br>
+		Cancellation fee: %s
+	`, res.FirstName, res.ConfirmationCode, res.StartDate.Format("2006-01-02"), res.EndDate.Format("2006-01-02"), pricing.Format(fee)), fmt.Sprintf(`
+		<strong>Reservation Cancelled</strong>
+		%s cancelled reservation %s from %s to %s, the cancellation fee is %s
+	`, res.FirstName, res.ConfirmationCode, res.StartDate.Format("2006-01-02"), res.EndDate.Format("2006-01-02"), pricing.Format(fee)))
+
+	if fee > 0 {
+		m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Your reservation has been cancelled, a cancellation fee of %s applies", pricing.Format(fee)))
+	} else {
+		m.App.Session.Put(r.Context(), "flash", "Your reservation has been cancelled")
+	}
+	http.Redirect(w, r, m.manageLink(res), http.StatusSeeOther)
+}
+
 // Displays list of available room
 func (m *Repository) ChooseRoom(w http.ResponseWriter, r *http.Request) {
 	exploded := strings.Split(r.RequestURI, "/")
@@ -407,7 +827,7 @@ func (m *Repository) BookRoom(w http.ResponseWriter, r *http.Request) {
 		helpers.ServerError(w, err)
 	}
 
-	room, err := m.DB.GetRoomById(roomId)
+	room, err := m.DB.GetRoomById(r.Context(), roomId)
 	if err != nil {
 		helpers.ServerError(w, err)
 	}
@@ -455,48 +875,167 @@ func (m *Repository) PostShowLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	id, _, err := m.DB.Authenticate(email, password)
+	// the password isn't even checked while the account or the address has to wait, so guessing gets no faster
+	if m.mustWait(w, r, email) {
+		return
+	}
+
+	id, _, err := m.DB.Authenticate(r.Context(), email, password)
 	if err != nil {
 		log.Println(err)
+		if err := m.loginFailed(r, email); err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
 		m.App.Session.Put(r.Context(), "error", "invalid login credentials")
 		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
 	}
 
-	m.App.Session.Put(r.Context(), "user_id", id)
-	m.App.Session.Put(r.Context(), "flash", "Login successfully")
+	user, err := m.DB.GetUserById(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// the password is right, users with two-factor authentication are only logged in once they entered a code too
+	if user.TwoFactor() || m.TwoFactorRequired(user.Role) {
+		m.App.Session.Put(r.Context(), "twofactor_user_id", user.ID)
+		m.App.Session.Put(r.Context(), "twofactor_version", user.SessionVersion)
+		m.App.Session.Put(r.Context(), "twofactor_started", time.Now().Unix())
+		m.App.Session.Remove(r.Context(), "twofactor_attempts")
+		http.Redirect(w, r, "/user/login/two-factor", http.StatusSeeOther)
+		return
+	}
 
-	http.Redirect(w, r, "/admin/dashboard", http.StatusSeeOther)
+	m.logIn(r, user)
+	http.Redirect(w, r, m.loginRedirect(r), http.StatusSeeOther)
 }
 
-// Log a user out
-func (m *Repository) Logout(w http.ResponseWriter, r *http.Request) {
-	_ = m.App.Session.Destroy(r.Context())
+// logIn puts user in the session. The session is logged out once the password of the user changes, see Permit
+func (m *Repository) logIn(r *http.Request, user models.User) {
 	_ = m.App.Session.RenewToken(r.Context())
 
-	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+	for _, key := range []string{"twofactor_user_id", "twofactor_version", "twofactor_started", "twofactor_attempts", "twofactor_secret"} {
+		m.App.Session.Remove(r.Context(), key)
+	}
+
+	m.App.Session.Put(r.Context(), "user_id", user.ID)
+	m.App.Session.Put(r.Context(), "session_version", user.SessionVersion)
+	m.App.Session.Put(r.Context(), "user_email", user.Email)
+	m.App.Session.Put(r.Context(), "flash", "Login successfully")
+
+	// the failed logins before this one no longer count against the account
+	if err := m.App.Lockout.Unlock(r.Context(), user.Email); err != nil {
+		log.Println(err)
+	}
 }
 
-func (m *Repository) AdminDashboard(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "admin-dashboard.page.tmpl", &models.TemplateData{})
+// loginRedirect returns where a user that just logged in goes: the admin page they asked for before they had to log
+// in, or the dashboard
+func (m *Repository) loginRedirect(r *http.Request) string {
+	to := m.App.Session.PopString(r.Context(), "login_redirect")
+
+	// only ever a page of this site, the session can't be made to send the user elsewhere
+	if !strings.HasPrefix(to, "/admin/") {
+		return "/admin/dashboard"
+	}
+
+	return to
 }
 
-func (m *Repository) AdminNewReservations(w http.ResponseWriter, r *http.Request) {
-	reservations, err := m.DB.AllNewReservations()
+// mustWait sends a login to the account with email back to the login page if there were too many failed logins to
+// it, or from the address of r, to try again yet
+func (m *Repository) mustWait(w http.ResponseWriter, r *http.Request, email string) bool {
+	wait, err := m.App.Lockout.Wait(r.Context(), email, audit.NewRequest(r).IP, time.Now())
 	if err != nil {
 		helpers.ServerError(w, err)
-		return
+		return true
 	}
 
-	data := make(map[string]interface{})
-	data["reservations"] = reservations
+	if wait == 0 {
+		return false
+	}
+
+	m.App.Session.Put(r.Context(), "error", fmt.Sprintf("Too many failed logins, try again in %s", wait.Round(time.Second)))
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+	return true
+}
+
+// loginFailed counts a failed login to the account with email, its user gets an email when it locked them out
+func (m *Repository) loginFailed(r *http.Request, email string) error {
+	locked, err := m.App.Lockout.Fail(r.Context(), email, audit.NewRequest(r).IP, time.Now())
+	if err != nil || !locked {
+		return err
+	}
+
+	u, err := m.DB.GetUserByEmail(r.Context(), strings.TrimSpace(email))
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && u.Disabled()) {
+		// nobody to tell
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	until := time.Now().Add(m.App.Lockout.Account.Lockout)
+
+	m.App.MailChan <- models.MailData{
+		To:      u.Email,
+		From:    "me@here.com",
+		Subject: "Your account is locked",
+		Content: fmt.Sprintf(`
+			<strong>Your account is locked</strong>
+			Dear %s, <br>
+			After %d failed logins in a row your account at Fort Smythe Bed and Breakfast is locked until %s, an administrator can unlock it sooner. <br>
+			If that wasn't you, someone may be guessing your password. <a href="%s/user/forgot-password">Choose a new one</a>, that unlocks your account too.
+		`, u.FirstName, m.App.Lockout.Account.MaxFailures, until.Format("2006-01-02 15:04"), m.App.BaseURL),
+	}
+
+	return nil
+}
+
+// Log a user out
+func (m *Repository) Logout(w http.ResponseWriter, r *http.Request) {
+	_ = m.App.Session.Destroy(r.Context())
+	_ = m.App.Session.RenewToken(r.Context())
+
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+func (m *Repository) AdminDashboard(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, r, "admin-dashboard.page.tmpl", &models.TemplateData{})
+}
+
+func (m *Repository) AdminNewReservations(w http.ResponseWriter, r *http.Request) {
+	reservations, err := m.DB.AllNewReservations(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["reservations"] = reservations
 
 	render.Template(w, r, "admin-new-reservations.page.tmpl", &models.TemplateData{
 		Data: data,
 	})
 }
 
+// AdminAllReservations lists every reservation, or only the ones with the status given in ?status=
 func (m *Repository) AdminAllReservations(w http.ResponseWriter, r *http.Request) {
-	reservations, err := m.DB.AllReservations()
+	filter := r.URL.Query().Get("status")
+
+	var reservations []models.Reservation
+	var err error
+	if status.Valid(filter) {
+		reservations, err = m.DB.ReservationsByStatus(r.Context(), filter)
+	} else {
+		filter = ""
+		reservations, err = m.DB.AllReservations(r.Context())
+	}
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
@@ -504,9 +1043,11 @@ func (m *Repository) AdminAllReservations(w http.ResponseWriter, r *http.Request
 
 	data := make(map[string]interface{})
 	data["reservations"] = reservations
+	data["statuses"] = status.All
 
 	render.Template(w, r, "admin-all-reservations.page.tmpl", &models.TemplateData{
-		Data: data,
+		Data:      data,
+		StringMap: map[string]string{"status": filter},
 	})
 }
 
@@ -517,7 +1058,7 @@ func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request
 	id, err := strconv.Atoi(exploded[4])
 	log.Println("id", id)
 	if err != nil {
-		fmt.Sprint("error getting user id from params")
+		log.Println("error getting user id from params")
 		helpers.ServerError(w, err)
 		return
 	}
@@ -533,7 +1074,7 @@ func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request
 	stringMap["year"] = year
 
 	// Get reservation from the database
-	res, err := m.DB.GetReservationById(id)
+	res, err := m.DB.GetReservationById(r.Context(), id)
 	log.Println(res)
 	if err != nil {
 		helpers.ServerError(w, err)
@@ -542,6 +1083,9 @@ func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request
 
 	data := make(map[string]interface{})
 	data["reservation"] = res
+	data["fee_now"] = cancellation.Fee(res.Cancellation, res.StartDate, time.Now())
+	data["next_statuses"] = status.Next(res.Status)
+	data["retention_days"] = retentionDays(m.App.Retention)
 
 	render.Template(w, r, "admin-reservations-show.page.tmpl", &models.TemplateData{
 		StringMap: stringMap,
@@ -560,7 +1104,7 @@ func (m *Repository) AdminPostReservation(w http.ResponseWriter, r *http.Request
 	exploded := strings.Split(r.RequestURI, "/")
 	id, err := strconv.Atoi(exploded[4])
 	if err != nil {
-		fmt.Sprint("error getting user id from params")
+		log.Println("error getting user id from params")
 		helpers.ServerError(w, err)
 		return
 	}
@@ -570,7 +1114,7 @@ func (m *Repository) AdminPostReservation(w http.ResponseWriter, r *http.Request
 	stringMap := make(map[string]string)
 	stringMap["src"] = src
 
-	res, err := m.DB.GetReservationById(id)
+	res, err := m.DB.GetReservationById(r.Context(), id)
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
@@ -581,8 +1125,9 @@ func (m *Repository) AdminPostReservation(w http.ResponseWriter, r *http.Request
 	res.LastName = r.Form.Get("last_name")
 	res.Email = r.Form.Get("email")
 	res.Phone = r.Form.Get("phone")
+	res.ChangedBy = m.changedBy(r)
 
-	err = m.DB.UpdateReservation(res)
+	err = m.DB.UpdateReservation(r.Context(), res)
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
@@ -633,14 +1178,14 @@ func (m *Repository) AdminReservationsCalendar(w http.ResponseWriter, r *http.Re
 
 	// Get the first and last days of the month
 	currentYear, currentMonth, _ := now.Date()
-	currentLocation := now.Location()
-	firstOfMonth := time.Date(currentYear, currentMonth, 1, 0, 0, 0, 0, currentLocation)
+	// Restriction dates are stored without a time zone, so the calendar always works in UTC
+	firstOfMonth := time.Date(currentYear, currentMonth, 1, 0, 0, 0, 0, time.UTC)
 	lastOfMonth := firstOfMonth.AddDate(0, 1, -1)
 
 	intMap := make(map[string]int)
 	intMap["days_in_month"] = lastOfMonth.Day()
 
-	rooms, err := m.DB.AllRooms()
+	rooms, err := m.DB.AllRooms(r.Context())
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
@@ -648,11 +1193,31 @@ func (m *Repository) AdminReservationsCalendar(w http.ResponseWriter, r *http.Re
 
 	data["rooms"] = rooms
 
+	restrictions, err := m.DB.AllRestrictions(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// Every restriction type can be used to block a day, except the one reservations hold their dates with
+	var blockTypes []models.Restriction
+	for _, t := range restrictions {
+		if t.ID == repository.ReservationRestrictionID {
+			data["reservation_type"] = t
+			continue
+		}
+		blockTypes = append(blockTypes, t)
+	}
+
+	data["restrictions"] = restrictions
+	data["block_types"] = blockTypes
+
 	// Ranging over rooms
 	for _, x := range rooms {
 		// Create 2 maps
-		reservationMap := make(map[string]int) // this will hold info if the day has a reservation
-		blockMap := make(map[string]int)       // this will hold info if the day is blocked (maintenance or whatever)
+		reservationMap := make(map[string]int)              // this will hold info if the day has a reservation
+		blockMap := make(map[string]int)                    // this will hold info if the day is blocked (maintenance or whatever)
+		blockTypeMap := make(map[string]models.Restriction) // the type of the block on a day, for its colour
 
 		// Loop through the days starting at 1st of the month and ending on the last
 		for d := firstOfMonth; d.After(lastOfMonth) == false; d = d.AddDate(0, 0, 1) {
@@ -661,7 +1226,7 @@ func (m *Repository) AdminReservationsCalendar(w http.ResponseWriter, r *http.Re
 		}
 
 		// get all the restrictions for the current room
-		restrictions, _ := m.DB.GetRestrictionsForRoomByDate(x.ID, firstOfMonth, lastOfMonth)
+		restrictions, err := m.DB.GetRestrictionsForRoomByDate(r.Context(), x.ID, firstOfMonth, lastOfMonth)
 		if err != nil {
 			helpers.ServerError(w, err)
 			return
@@ -674,13 +1239,28 @@ func (m *Repository) AdminReservationsCalendar(w http.ResponseWriter, r *http.Re
 					reservationMap[d.Format("2006-01-2")] = y.ReservationID
 				}
 			} else {
-				// its blocked
-				blockMap[y.StartDate.Format("2006-01-2")] = y.ID
+				// its blocked, a block can span several days and start or end outside of the month
+				for d := y.StartDate; d.Before(y.EndDate); d = d.AddDate(0, 0, 1) {
+					if d.Before(firstOfMonth) || d.After(lastOfMonth) {
+						continue
+					}
+					blockMap[d.Format("2006-01-2")] = y.ID
+					blockTypeMap[d.Format("2006-01-2")] = y.Restriction
+				}
 			}
 		}
 
+		// The price of every night of the month, so the effect of the rate plans can be seen on the calendar
+		quote, err := m.DB.GetRatesForStay(r.Context(), x.ID, firstOfMonth, lastOfMonth.AddDate(0, 0, 1))
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
 		data[fmt.Sprintf("reservation_map_%d", x.ID)] = reservationMap
 		data[fmt.Sprintf("block_map_%d", x.ID)] = blockMap
+		data[fmt.Sprintf("block_types_%d", x.ID)] = blockTypeMap
+		data[fmt.Sprintf("rates_%d", x.ID)] = quote.Nights
 
 		m.App.Session.Put(r.Context(), fmt.Sprintf("block_map_%d", x.ID), blockMap)
 
@@ -693,31 +1273,66 @@ func (m *Repository) AdminReservationsCalendar(w http.ResponseWriter, r *http.Re
 	})
 }
 
-// AdminProcessReservation: marks a reservation as processed
-func (m *Repository) AdminProcessReservation(w http.ResponseWriter, r *http.Request) {
+// AdminUpdateReservationStatus: moves a reservation on to the status in the url, e.g. checks the guest in
+func (m *Repository) AdminUpdateReservationStatus(w http.ResponseWriter, r *http.Request) {
+	to := chi.URLParam(r, "status")
+	if to == status.Cancelled {
+		// cancelling charges the fee and lets the guest know
+		m.AdminCancelReservation(w, r)
+		return
+	}
+
 	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
 	src := chi.URLParam(r, "src")
-	_ = m.DB.UpdateProcessedForReservation(id, 1)
+
+	if !status.Valid(to) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	var transitionErr *status.TransitionError
+
+	err := m.DB.UpdateReservationStatus(r.Context(), id, to, m.changedBy(r))
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	case errors.As(err, &transitionErr):
+		m.App.Session.Put(r.Context(), "error", transitionErr.Error())
+	case err != nil:
+		helpers.ServerError(w, err)
+		return
+	default:
+		m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Reservation marked as %s", strings.ToLower(status.Label(to))))
+	}
 
 	year := r.URL.Query().Get("y")
 	month := r.URL.Query().Get("m")
 
-	m.App.Session.Put(r.Context(), "flash", "reservation marked as processed")
-
 	if year == "" {
-		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations/%s/%d/show", src, id), http.StatusSeeOther)
 	} else {
-		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%s&m=%s", year, month), http.StatusSeeOther)
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations/%s/%d/show?y=%s&m=%s", src, id, year, month), http.StatusSeeOther)
 	}
 }
 
-// AdminDeleteReservation: deletes a reservation
+// AdminDeleteReservation: moves a reservation to the trash with the reason given in ?reason=, freeing its dates
 func (m *Repository) AdminDeleteReservation(w http.ResponseWriter, r *http.Request) {
 	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
 	src := chi.URLParam(r, "src")
-	_ = m.DB.DeleteReservation(id)
 
-	m.App.Session.Put(r.Context(), "flash", "reservation marked as deleted")
+	err := m.DB.DeleteReservation(r.Context(), id, m.changedBy(r), strings.TrimSpace(r.URL.Query().Get("reason")))
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Reservation moved to the trash, it can be restored for %d days", retentionDays(m.App.Retention)))
 
 	year := r.URL.Query().Get("y")
 	month := r.URL.Query().Get("m")
@@ -729,6 +1344,188 @@ func (m *Repository) AdminDeleteReservation(w http.ResponseWriter, r *http.Reque
 	}
 }
 
+// AdminTrash lists the deleted reservations that can still be restored
+func (m *Repository) AdminTrash(w http.ResponseWriter, r *http.Request) {
+	deleted, err := m.DB.DeletedReservations(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	var reservations []models.Reservation
+	purgeOn := make(map[int]time.Time)
+	for _, res := range deleted {
+		if m.restorable(res) {
+			reservations = append(reservations, res)
+			purgeOn[res.ID] = res.DeletedAt.Add(m.App.Retention)
+		}
+	}
+
+	data := make(map[string]interface{})
+	data["reservations"] = reservations
+	data["purge_on"] = purgeOn
+
+	render.Template(w, r, "admin-reservations-trash.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminRestoreReservation: takes a reservation out of the trash, as long as it hasn't been there longer than the retention
+func (m *Repository) AdminRestoreReservation(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	deleted, err := m.DB.DeletedReservations(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	found := false
+	for _, res := range deleted {
+		if res.ID == id && m.restorable(res) {
+			found = true
+		}
+	}
+
+	if !found {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	err = m.DB.RestoreReservation(r.Context(), id)
+	if errors.Is(err, repository.ErrRoomNotAvailable) {
+		m.App.Session.Put(r.Context(), "error", "The room has been booked or blocked on the dates of the reservation since, it can't be restored")
+		http.Redirect(w, r, "/admin/reservations-trash", http.StatusSeeOther)
+		return
+	}
+
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Reservation restored")
+	http.Redirect(w, r, fmt.Sprintf("/admin/reservations/all/%d/show", id), http.StatusSeeOther)
+}
+
+// restorable reports whether a deleted reservation is still within the retention and so can be restored
+func (m *Repository) restorable(res models.Reservation) bool {
+	return time.Since(res.DeletedAt) < m.App.Retention
+}
+
+// retentionDays returns the retention in whole days, to tell admins how long they can restore a reservation for
+func retentionDays(retention time.Duration) int {
+	return int(retention / (24 * time.Hour))
+}
+
+// AdminAudit shows the latest changes in the audit log, filtered by the kind of entity, a single entity and who made them
+func (m *Repository) AdminAudit(w http.ResponseWriter, r *http.Request) {
+	filter := models.AuditFilter{
+		Entity: r.URL.Query().Get("entity"),
+		Actor:  r.URL.Query().Get("actor"),
+	}
+
+	valid := false
+	for _, e := range audit.Entities {
+		if filter.Entity == e {
+			valid = true
+		}
+	}
+
+	// an entity id only means something together with the kind of entity
+	if valid {
+		filter.EntityID, _ = strconv.Atoi(r.URL.Query().Get("id"))
+	} else {
+		filter.Entity = ""
+	}
+
+	entries, err := m.DB.AuditEntries(r.Context(), filter)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["entries"] = entries
+	data["entities"] = audit.Entities
+
+	stringMap := map[string]string{"entity": filter.Entity, "actor": filter.Actor}
+	if filter.EntityID > 0 {
+		stringMap["id"] = strconv.Itoa(filter.EntityID)
+	}
+
+	render.Template(w, r, "admin-audit.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+	})
+}
+
+// AdminCancelReservation: cancels a reservation for the guest, charging the fee of its cancellation terms
+func (m *Repository) AdminCancelReservation(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	src := chi.URLParam(r, "src")
+
+	res, err := m.DB.GetReservationById(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	by := m.changedBy(r)
+
+	var transitionErr *status.TransitionError
+
+	fee, err := m.DB.CancelReservation(r.Context(), id, by)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	case errors.Is(err, repository.ErrReservationCancelled):
+		m.App.Session.Put(r.Context(), "error", "The reservation was already cancelled")
+	case errors.As(err, &transitionErr):
+		m.App.Session.Put(r.Context(), "error", transitionErr.Error())
+	case err != nil:
+		helpers.ServerError(w, err)
+		return
+	default:
+		m.mailGuestAndOwner(res, "Reservation Cancelled", fmt.Sprintf(`
+			<strong>Reservation Cancelled</strong>
+			Dear, %s <br>
+			Your reservation %s from %s to %s has been cancelled <br>
+			Cancellation fee: %s
+		`, res.FirstName, res.ConfirmationCode, res.StartDate.Format("2006-01-02"), res.EndDate.Format("2006-01-02"), pricing.Format(fee)), fmt.Sprintf(`
+			<strong>Reservation Cancelled</strong>
+			Reservation %s from %s to %s was cancelled by %s, the cancellation fee is %s
+		`, res.ConfirmationCode, res.StartDate.Format("2006-01-02"), res.EndDate.Format("2006-01-02"), by, pricing.Format(fee)))
+
+		m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Reservation cancelled, cancellation fee %s", pricing.Format(fee)))
+	}
+
+	year := r.URL.Query().Get("y")
+	month := r.URL.Query().Get("m")
+
+	if year == "" {
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations/%s/%d/show", src, id), http.StatusSeeOther)
+	} else {
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations/%s/%d/show?y=%s&m=%s", src, id, year, month), http.StatusSeeOther)
+	}
+}
+
+// changedBy names the logged in admin, to record who made a change
+func (m *Repository) changedBy(r *http.Request) string {
+	user, err := m.DB.GetUserById(r.Context(), m.App.Session.GetInt(r.Context(), "user_id"))
+	if err != nil {
+		return "admin"
+	}
+
+	return user.Email
+}
+
 // Debugging
 func dump(data interface{}) {
 	b, _ := json.MarshalIndent(data, "", "  ")
@@ -747,7 +1544,7 @@ func (m *Repository) AdminPostReservationsCalendar(w http.ResponseWriter, r *htt
 	month, _ := strconv.Atoi(r.Form.Get("m"))
 
 	// Process blocks
-	rooms, err := m.DB.AllRooms()
+	rooms, err := m.DB.AllRooms(r.Context())
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
@@ -756,46 +1553,2473 @@ func (m *Repository) AdminPostReservationsCalendar(w http.ResponseWriter, r *htt
 	form := forms.New(r.PostForm)
 	dump(form)
 
-	for _, x := range rooms {
-		curMap := m.App.Session.Get(r.Context(), fmt.Sprintf("block_map_%d", x.ID)).(map[string]int)
-		// dump(x.RoomName)
-		// dump(curMap)
-
-		// Loop over everything in the block map
-		for date, restrictionId := range curMap {
-			// Checking to see if currMap[date] does not return false or undefined
-			if val, ok := curMap[date]; ok {
-				if val > 0 {
-					if !form.Has(fmt.Sprintf("remove_block_%d_%s", x.ID, date)) {
-						// delete the restriction by id
-						log.Println("would delete block with date", date, "and restriction id of", restrictionId)
-						err := m.DB.DeleteBlockForRoomById(restrictionId)
-						if err != nil {
-							log.Println(err)
-						}
+	var newBlocks []string
+	for name := range r.PostForm {
+		if strings.HasPrefix(name, "add_block") {
+			newBlocks = append(newBlocks, name)
+		}
+	}
+
+	// New blocks are all of the type picked on the calendar, it only has to be picked when blocks are added
+	var blockType models.Restriction
+	if len(newBlocks) > 0 {
+		restrictionId, _ := strconv.Atoi(r.Form.Get("restriction_id"))
+		blockType, err = m.DB.GetRestrictionById(r.Context(), restrictionId)
+		if err != nil || blockType.ID == repository.ReservationRestrictionID {
+			m.App.Session.Put(r.Context(), "error", "Pick the type of the new blocks")
+			http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%d&m=%d", year, month), http.StatusSeeOther)
+			return
+		}
+	}
+
+	// All block changes are saved together or not at all
+	err = m.DB.WithTx(r.Context(), func(repo repository.DatabaseRepo) error {
+		for _, x := range rooms {
+			curMap, _ := m.App.Session.Get(r.Context(), fmt.Sprintf("block_map_%d", x.ID)).(map[string]int)
+
+			// Loop over everything in the block map, the days no longer checked are collected by the block they are in
+			unchecked := make(map[int][]time.Time)
+			var first, last time.Time
+			for date, restrictionId := range curMap {
+				if restrictionId > 0 && !form.Has(fmt.Sprintf("remove_block_%d_%s", x.ID, date)) {
+					d, _ := time.Parse("2006-01-2", date)
+					unchecked[restrictionId] = append(unchecked[restrictionId], d)
+
+					if first.IsZero() || d.Before(first) {
+						first = d
+					}
+					if d.After(last) {
+						last = d
+					}
+				}
+			}
+
+			if len(unchecked) == 0 {
+				continue
+			}
+
+			blocked, err := repo.GetRestrictionsForRoomByDate(r.Context(), x.ID, first, last)
+			if err != nil {
+				return err
+			}
+
+			// A block can span several days, some of them in other months, only the unchecked days are taken out of it
+			for _, b := range blocked {
+				days, ok := unchecked[b.ID]
+				if !ok || b.ReservationID > 0 {
+					continue
+				}
+
+				log.Println("removing", len(days), "days from block", b.ID, "of room", x.ID)
+				if err := repo.DeleteBlockForRoomById(r.Context(), b.ID); err != nil {
+					return err
+				}
+
+				for _, left := range blocks.Remove(b, days) {
+					if err := repo.InsertBlockForRoomById(r.Context(), x.ID, b.RestrictionID, left.StartDate, left.EndDate); err != nil {
+						return err
 					}
 				}
 			}
 		}
-	}
 
-	// Handle new blocks
-	for name := range r.PostForm {
-		if strings.HasPrefix(name, "add_block") {
+		// Handle new blocks
+		for _, name := range newBlocks {
 			exploded := strings.Split(name, "_")
 			roomId, _ := strconv.Atoi(exploded[2])
 			t, _ := time.Parse("2006-01-2", exploded[3])
 
-			// insert a new block
-			log.Println("Would insert block for room id", roomId, "for date", exploded[3])
-			err := m.DB.InsertBlockForRoomById(roomId, t)
-			if err != nil {
-				log.Println("Error inserting room id")
-				log.Println(err)
+			log.Println("inserting block for room id", roomId, "for date", exploded[3])
+			if err := repo.InsertBlockForRoomById(r.Context(), roomId, blockType.ID, t, t.AddDate(0, 0, 1)); err != nil {
+				return err
 			}
 		}
+
+		return nil
+	})
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+		m.App.Session.Put(r.Context(), "error", "Changes could not be saved, please try again")
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%d&m=%d", year, month), http.StatusSeeOther)
+		return
 	}
 
 	m.App.Session.Put(r.Context(), "flash", "Changes Saved")
 	http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%d&m=%d", year, month), http.StatusSeeOther)
 }
+
+// AdminRooms lists every room, active or not
+func (m *Repository) AdminRooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+
+	render.Template(w, r, "admin-rooms.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminNewRoom shows the form for adding a room
+func (m *Repository) AdminNewRoom(w http.ResponseWriter, r *http.Request) {
+	data := make(map[string]interface{})
+	data["room"] = models.Room{Active: true, MaxOccupancy: 2}
+
+	err := m.addCancellationPolicies(r, data)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	render.Template(w, r, "admin-room.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: forms.New(nil),
+	})
+}
+
+// AdminPostNewRoom adds a room
+func (m *Repository) AdminPostNewRoom(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	room := m.roomFromForm(r, form, models.Room{})
+
+	if !form.Valid() {
+		data := make(map[string]interface{})
+		data["room"] = room
+
+		err = m.addCancellationPolicies(r, data)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		render.Template(w, r, "admin-room.page.tmpl", &models.TemplateData{
+			Data: data,
+			Form: form,
+		})
+		return
+	}
+
+	_, err = m.DB.InsertRoom(r.Context(), room)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Room added")
+	http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+}
+
+// roomFromForm copies the posted room form over room and validates it, the slug is made from the room name if none was given
+func (m *Repository) roomFromForm(r *http.Request, form *forms.Form, room models.Room) models.Room {
+	room.RoomName = r.Form.Get("room_name")
+	room.Description = r.Form.Get("description")
+	room.Beds = r.Form.Get("beds")
+	room.Active = r.Form.Get("active") == "1"
+	room.CancellationPolicyID = cancellationPolicyIDFromForm(r, form)
+
+	// One amenity per line
+	room.Amenities = nil
+	for _, a := range strings.Split(r.Form.Get("amenities"), "\n") {
+		if a = strings.TrimSpace(a); a != "" {
+			room.Amenities = append(room.Amenities, a)
+		}
+	}
+
+	form.Required("room_name", "max_occupancy", "nightly_rate")
+
+	occupancy, err := strconv.Atoi(r.Form.Get("max_occupancy"))
+	if err != nil || occupancy < 1 {
+		form.Errors.Add("max_occupancy", "Enter how many guests the room sleeps")
+	}
+	room.MaxOccupancy = occupancy
+
+	room.NightlyRate, err = pricing.ParseAmount(r.Form.Get("nightly_rate"))
+	if err != nil {
+		form.Errors.Add("nightly_rate", "Enter the nightly rate in dollars, e.g. 120 or 120.50")
+	}
+
+	// The weekend rate is optional, without one every night costs the nightly rate
+	room.WeekendRate = 0
+	if r.Form.Get("weekend_rate") != "" {
+		room.WeekendRate, err = pricing.ParseAmount(r.Form.Get("weekend_rate"))
+		if err != nil {
+			form.Errors.Add("weekend_rate", "Enter the weekend rate in dollars, or leave it empty")
+		}
+	}
+
+	room.Slug = r.Form.Get("slug")
+	if room.Slug == "" {
+		room.Slug = room.RoomName
+	}
+	room.Slug = helpers.Slugify(room.Slug)
+
+	if room.Slug == "" {
+		form.Errors.Add("slug", "Use letters or numbers in the url")
+		return room
+	}
+
+	existing, err := m.DB.GetRoomBySlug(r.Context(), room.Slug)
+	if err == nil && existing.ID != room.ID {
+		form.Errors.Add("slug", "This url is already used by "+existing.RoomName)
+	}
+
+	return room
+}
+
+// AdminShowRoom shows the form for editing a room along with its photos
+func (m *Repository) AdminShowRoom(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	room, err := m.DB.GetRoomById(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	room.Photos, err = m.DB.GetPhotosForRoom(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	ratePlans, err := m.DB.GetRatePlansForRoom(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	stayRules, err := m.DB.GetStayRulesForRoom(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["room"] = room
+	data["rate_plans"] = ratePlans
+	data["stay_rules"] = stayRules
+
+	err = m.addCancellationPolicies(r, data)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	render.Template(w, r, "admin-room.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: forms.New(nil),
+	})
+}
+
+// AdminPostRoom saves changes to a room, unchecking active deactivates it
+func (m *Repository) AdminPostRoom(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	room, err := m.DB.GetRoomById(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	room = m.roomFromForm(r, form, room)
+
+	if !form.Valid() {
+		room.Photos, _ = m.DB.GetPhotosForRoom(r.Context(), id)
+
+		data := make(map[string]interface{})
+		data["room"] = room
+
+		err = m.addCancellationPolicies(r, data)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		render.Template(w, r, "admin-room.page.tmpl", &models.TemplateData{
+			Data: data,
+			Form: form,
+		})
+		return
+	}
+
+	err = m.DB.UpdateRoom(r.Context(), room)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Changes saved")
+	http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+}
+
+// AdminDeleteRoom deletes a room, unless it has reservations
+func (m *Repository) AdminDeleteRoom(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// The photo rows go with the room, the files have to be removed by us
+	photos, err := m.DB.GetPhotosForRoom(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.DeleteRoom(r.Context(), id)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	case errors.Is(err, repository.ErrRoomHasReservations):
+		m.App.Session.Put(r.Context(), "error", "This room has reservations and can't be deleted, deactivate it instead to keep them")
+		http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d", id), http.StatusSeeOther)
+		return
+	case err != nil:
+		helpers.ServerError(w, err)
+		return
+	}
+
+	for _, p := range photos {
+		m.removePhotoFile(p)
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Room deleted")
+	http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+}
+
+// maxPhotoSize is the largest room photo that can be uploaded
+const maxPhotoSize = 10 << 20
+
+// photoExtensions maps the image types accepted for room photos to the extension they are saved with
+var photoExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+var errNotAPhoto = errors.New("only jpeg, png, gif and webp images can be uploaded")
+
+// savePhoto writes an uploaded photo to the upload directory under a random name and returns that name
+func (m *Repository) savePhoto(file io.Reader) (string, error) {
+	// Trust the content, not the file name or the content type the browser sent
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+
+	ext, ok := photoExtensions[http.DetectContentType(head[:n])]
+	if !ok {
+		return "", errNotAPhoto
+	}
+
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	name := hex.EncodeToString(random) + ext
+
+	if err := os.MkdirAll(m.App.UploadPath, 0755); err != nil {
+		return "", err
+	}
+
+	dst, err := os.Create(filepath.Join(m.App.UploadPath, name))
+	if err != nil {
+		return "", err
+	}
+	defer dst.Close()
+
+	if _, err := io.Copy(dst, io.MultiReader(bytes.NewReader(head[:n]), file)); err != nil {
+		return "", err
+	}
+
+	return name, nil
+}
+
+// removePhotoFile deletes the file of a room photo, a file that is already gone is not an error
+func (m *Repository) removePhotoFile(p models.RoomPhoto) {
+	err := os.Remove(filepath.Join(m.App.UploadPath, filepath.Base(p.FileName)))
+	if err != nil && !os.IsNotExist(err) {
+		m.App.ErrorLog.Println(err)
+	}
+}
+
+// AdminPostRoomPhoto uploads a photo and adds it to the end of the gallery of a room
+func (m *Repository) AdminPostRoomPhoto(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	redirect := fmt.Sprintf("/admin/rooms/%d", id)
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxPhotoSize+1<<20)
+	if err := r.ParseMultipartForm(maxPhotoSize); err != nil {
+		m.App.Session.Put(r.Context(), "error", "Photos can be at most 10 MB")
+		http.Redirect(w, r, redirect, http.StatusSeeOther)
+		return
+	}
+
+	file, header, err := r.FormFile("photo")
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Choose a photo to upload")
+		http.Redirect(w, r, redirect, http.StatusSeeOther)
+		return
+	}
+	defer file.Close()
+
+	if header.Size > maxPhotoSize {
+		m.App.Session.Put(r.Context(), "error", "Photos can be at most 10 MB")
+		http.Redirect(w, r, redirect, http.StatusSeeOther)
+		return
+	}
+
+	name, err := m.savePhoto(file)
+	if errors.Is(err, errNotAPhoto) {
+		m.App.Session.Put(r.Context(), "error", "Only jpeg, png, gif and webp images can be uploaded")
+		http.Redirect(w, r, redirect, http.StatusSeeOther)
+		return
+	}
+
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	photo := models.RoomPhoto{
+		RoomID:   id,
+		FileName: name,
+		Caption:  r.Form.Get("caption"),
+	}
+
+	_, err = m.DB.InsertRoomPhoto(r.Context(), photo)
+	if err != nil {
+		m.removePhotoFile(photo)
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Photo added")
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
+
+// AdminMoveRoomPhoto moves a photo one place up or down in the gallery
+func (m *Repository) AdminMoveRoomPhoto(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	photoId, _ := strconv.Atoi(chi.URLParam(r, "photo_id"))
+
+	photos, err := m.DB.GetPhotosForRoom(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	for i, p := range photos {
+		if p.ID != photoId {
+			continue
+		}
+
+		if chi.URLParam(r, "dir") == "up" && i > 0 {
+			photos[i-1], photos[i] = photos[i], photos[i-1]
+		} else if chi.URLParam(r, "dir") == "down" && i < len(photos)-1 {
+			photos[i+1], photos[i] = photos[i], photos[i+1]
+		}
+		break
+	}
+
+	// Renumber the whole gallery so gaps and ties left by deleted photos go away
+	err = m.DB.WithTx(r.Context(), func(repo repository.DatabaseRepo) error {
+		for i, p := range photos {
+			p.SortOrder = i + 1
+			if err := repo.UpdateRoomPhoto(r.Context(), p); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d", id), http.StatusSeeOther)
+}
+
+// AdminDeleteRoomPhoto removes a photo from the gallery and deletes its file
+func (m *Repository) AdminDeleteRoomPhoto(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	photoId, _ := strconv.Atoi(chi.URLParam(r, "photo_id"))
+
+	photo, err := m.DB.GetRoomPhotoById(r.Context(), photoId)
+	if err != nil || photo.RoomID != id {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	err = m.DB.DeleteRoomPhoto(r.Context(), photoId)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.removePhotoFile(photo)
+
+	m.App.Session.Put(r.Context(), "flash", "Photo deleted")
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d", id), http.StatusSeeOther)
+}
+
+// weekdays labels the day multipliers of a rate plan, in time.Weekday order
+var weekdays = []string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"}
+
+// renderRatePlan shows the form for adding or editing a rate plan of room
+func (m *Repository) renderRatePlan(w http.ResponseWriter, r *http.Request, room models.Room, plan models.RatePlan, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["room"] = room
+	data["rate_plan"] = plan
+	data["weekdays"] = weekdays
+
+	err := m.addCancellationPolicies(r, data)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	render.Template(w, r, "admin-rate-plan.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// ratePlanFromForm copies the posted rate plan form over plan and validates it
+func ratePlanFromForm(r *http.Request, form *forms.Form, plan models.RatePlan) models.RatePlan {
+	layout := "2006-01-02"
+
+	form.Required("name", "start_date", "end_date", "nightly_rate")
+	plan.Name = r.Form.Get("name")
+
+	var err error
+
+	plan.StartDate, err = time.Parse(layout, r.Form.Get("start_date"))
+	if err != nil {
+		form.Errors.Add("start_date", "Enter the first night of the plan")
+	}
+
+	plan.EndDate, err = time.Parse(layout, r.Form.Get("end_date"))
+	if err != nil {
+		form.Errors.Add("end_date", "Enter the last night of the plan")
+	} else if plan.EndDate.Before(plan.StartDate) {
+		form.Errors.Add("end_date", "The last night can't be before the first night")
+	}
+
+	plan.NightlyRate, err = pricing.ParseAmount(r.Form.Get("nightly_rate"))
+	if err != nil {
+		form.Errors.Add("nightly_rate", "Enter the nightly rate in dollars, e.g. 120 or 120.50")
+	}
+
+	plan.Priority = 0
+	if r.Form.Get("priority") != "" {
+		plan.Priority, err = strconv.Atoi(r.Form.Get("priority"))
+		if err != nil {
+			form.Errors.Add("priority", "Priority has to be a whole number")
+		}
+	}
+
+	for i := range plan.DayMultipliers {
+		percent, err := strconv.Atoi(r.Form.Get(fmt.Sprintf("multiplier_%d", i)))
+		if err != nil || percent < 0 {
+			form.Errors.Add("multipliers", "Enter a percentage of the nightly rate for every day, 100 charges the nightly rate")
+			percent = 100
+		}
+		plan.DayMultipliers[i] = percent
+	}
+
+	plan.CancellationPolicyID = cancellationPolicyIDFromForm(r, form)
+
+	return plan
+}
+
+// AdminNewRatePlan shows the form for adding a rate plan to a room
+func (m *Repository) AdminNewRatePlan(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	room, err := m.DB.GetRoomById(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	plan := models.RatePlan{RoomID: id, NightlyRate: room.NightlyRate, DayMultipliers: pricing.DefaultDayMultipliers}
+
+	m.renderRatePlan(w, r, room, plan, forms.New(nil))
+}
+
+// AdminPostNewRatePlan adds a rate plan to a room
+func (m *Repository) AdminPostNewRatePlan(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	room, err := m.DB.GetRoomById(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	plan := ratePlanFromForm(r, form, models.RatePlan{RoomID: id})
+
+	if !form.Valid() {
+		m.renderRatePlan(w, r, room, plan, form)
+		return
+	}
+
+	_, err = m.DB.InsertRatePlan(r.Context(), plan)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Rate plan added")
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d", id), http.StatusSeeOther)
+}
+
+// AdminShowRatePlan shows the form for editing a rate plan
+func (m *Repository) AdminShowRatePlan(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	planId, _ := strconv.Atoi(chi.URLParam(r, "rate_id"))
+
+	plan, err := m.DB.GetRatePlanById(r.Context(), planId)
+	if err != nil || plan.RoomID != id {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	room, err := m.DB.GetRoomById(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.renderRatePlan(w, r, room, plan, forms.New(nil))
+}
+
+// AdminPostRatePlan saves changes to a rate plan
+func (m *Repository) AdminPostRatePlan(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	planId, _ := strconv.Atoi(chi.URLParam(r, "rate_id"))
+
+	plan, err := m.DB.GetRatePlanById(r.Context(), planId)
+	if err != nil || plan.RoomID != id {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	plan = ratePlanFromForm(r, form, plan)
+
+	if !form.Valid() {
+		room, err := m.DB.GetRoomById(r.Context(), id)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		m.renderRatePlan(w, r, room, plan, form)
+		return
+	}
+
+	err = m.DB.UpdateRatePlan(r.Context(), plan)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Changes saved")
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d", id), http.StatusSeeOther)
+}
+
+// AdminDeleteRatePlan deletes a rate plan, reservations that were priced with it keep their price
+func (m *Repository) AdminDeleteRatePlan(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	planId, _ := strconv.Atoi(chi.URLParam(r, "rate_id"))
+
+	plan, err := m.DB.GetRatePlanById(r.Context(), planId)
+	if err != nil || plan.RoomID != id {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	err = m.DB.DeleteRatePlan(r.Context(), planId)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Rate plan deleted")
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d", id), http.StatusSeeOther)
+}
+
+// renderStayRule shows the form for adding or editing a stay rule of room
+func (m *Repository) renderStayRule(w http.ResponseWriter, r *http.Request, room models.Room, rule models.StayRule, form *forms.Form) {
+	var arrivalDays [7]bool
+	for _, d := range rule.ArrivalDays {
+		arrivalDays[d] = true
+	}
+
+	data := make(map[string]interface{})
+	data["room"] = room
+	data["stay_rule"] = rule
+	data["weekdays"] = weekdays
+	data["arrival_days"] = arrivalDays
+
+	render.Template(w, r, "admin-stay-rule.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// stayRuleFromForm copies the posted stay rule form over rule and validates it, leaving both dates empty makes the rule apply every day
+func stayRuleFromForm(r *http.Request, form *forms.Form, rule models.StayRule) models.StayRule {
+	layout := "2006-01-02"
+
+	var err error
+
+	rule.StartDate, rule.EndDate = time.Time{}, time.Time{}
+	if r.Form.Get("start_date") != "" || r.Form.Get("end_date") != "" {
+		rule.StartDate, err = time.Parse(layout, r.Form.Get("start_date"))
+		if err != nil {
+			form.Errors.Add("start_date", "Enter the first day of the rule, or leave both dates empty")
+		}
+
+		rule.EndDate, err = time.Parse(layout, r.Form.Get("end_date"))
+		if err != nil {
+			form.Errors.Add("end_date", "Enter the last day of the rule, or leave both dates empty")
+		} else if rule.EndDate.Before(rule.StartDate) {
+			form.Errors.Add("end_date", "The last day can't be before the first day")
+		}
+	}
+
+	for _, field := range []string{"min_nights", "max_nights"} {
+		nights := 0
+		if r.Form.Get(field) != "" {
+			nights, err = strconv.Atoi(r.Form.Get(field))
+			if err != nil || nights < 0 {
+				form.Errors.Add(field, "Enter a number of nights, 0 for no limit")
+				nights = 0
+			}
+		}
+
+		if field == "min_nights" {
+			rule.MinNights = nights
+		} else {
+			rule.MaxNights = nights
+		}
+	}
+
+	if rule.MaxNights > 0 && rule.MaxNights < rule.MinNights {
+		form.Errors.Add("max_nights", "The maximum can't be less than the minimum")
+	}
+
+	rule.ClosedToArrival = r.Form.Get("closed_to_arrival") == "1"
+	rule.ClosedToDeparture = r.Form.Get("closed_to_departure") == "1"
+
+	rule.ArrivalDays = nil
+	for i := range weekdays {
+		if r.Form.Get(fmt.Sprintf("arrival_day_%d", i)) == "1" {
+			rule.ArrivalDays = append(rule.ArrivalDays, time.Weekday(i))
+		}
+	}
+
+	if rule.MinNights == 0 && rule.MaxNights == 0 && !rule.ClosedToArrival && !rule.ClosedToDeparture && len(rule.ArrivalDays) == 0 {
+		form.Errors.Add("min_nights", "The rule doesn't restrict anything, set a number of nights, closed days or arrival days")
+	}
+
+	return rule
+}
+
+// AdminNewStayRule shows the form for adding a stay rule to a room
+func (m *Repository) AdminNewStayRule(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	room, err := m.DB.GetRoomById(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.renderStayRule(w, r, room, models.StayRule{RoomID: id}, forms.New(nil))
+}
+
+// AdminPostNewStayRule adds a stay rule to a room
+func (m *Repository) AdminPostNewStayRule(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	room, err := m.DB.GetRoomById(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	rule := stayRuleFromForm(r, form, models.StayRule{RoomID: id})
+
+	if !form.Valid() {
+		m.renderStayRule(w, r, room, rule, form)
+		return
+	}
+
+	_, err = m.DB.InsertStayRule(r.Context(), rule)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Stay rule added")
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d", id), http.StatusSeeOther)
+}
+
+// AdminShowStayRule shows the form for editing a stay rule
+func (m *Repository) AdminShowStayRule(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	ruleId, _ := strconv.Atoi(chi.URLParam(r, "rule_id"))
+
+	rule, err := m.DB.GetStayRuleById(r.Context(), ruleId)
+	if err != nil || rule.RoomID != id {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	room, err := m.DB.GetRoomById(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.renderStayRule(w, r, room, rule, forms.New(nil))
+}
+
+// AdminPostStayRule saves changes to a stay rule, reservations already made are not affected
+func (m *Repository) AdminPostStayRule(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	ruleId, _ := strconv.Atoi(chi.URLParam(r, "rule_id"))
+
+	rule, err := m.DB.GetStayRuleById(r.Context(), ruleId)
+	if err != nil || rule.RoomID != id {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	rule = stayRuleFromForm(r, form, rule)
+
+	if !form.Valid() {
+		room, err := m.DB.GetRoomById(r.Context(), id)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		m.renderStayRule(w, r, room, rule, form)
+		return
+	}
+
+	err = m.DB.UpdateStayRule(r.Context(), rule)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Changes saved")
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d", id), http.StatusSeeOther)
+}
+
+// AdminDeleteStayRule deletes a stay rule
+func (m *Repository) AdminDeleteStayRule(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	ruleId, _ := strconv.Atoi(chi.URLParam(r, "rule_id"))
+
+	rule, err := m.DB.GetStayRuleById(r.Context(), ruleId)
+	if err != nil || rule.RoomID != id {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	err = m.DB.DeleteStayRule(r.Context(), ruleId)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Stay rule deleted")
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d", id), http.StatusSeeOther)
+}
+
+// repeatOptions are the choices for how often a block repeats, in weeks
+var repeatOptions = []struct {
+	Weeks int
+	Label string
+}{
+	{0, "Doesn't repeat"},
+	{1, "Every week"},
+	{2, "Every 2 weeks"},
+	{4, "Every 4 weeks"},
+}
+
+// renderBlock shows the form for blocking a room over a range of days, once or repeating
+func (m *Repository) renderBlock(w http.ResponseWriter, r *http.Request, room models.Room, block models.RoomBlock, form *forms.Form) {
+	restrictions, err := m.DB.AllRestrictions(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	var blockTypes []models.Restriction
+	for _, t := range restrictions {
+		if t.ID != repository.ReservationRestrictionID {
+			blockTypes = append(blockTypes, t)
+		}
+	}
+
+	data := make(map[string]interface{})
+	data["room"] = room
+	data["block"] = block
+	data["block_types"] = blockTypes
+	data["repeat_options"] = repeatOptions
+
+	render.Template(w, r, "admin-block.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// blockFromForm copies the posted block form over block and validates it, including that its repeats can be expanded
+func blockFromForm(r *http.Request, form *forms.Form, block models.RoomBlock) models.RoomBlock {
+	layout := "2006-01-02"
+
+	var err error
+
+	block.RestrictionID, _ = strconv.Atoi(r.Form.Get("restriction_id"))
+	if block.RestrictionID == 0 || block.RestrictionID == repository.ReservationRestrictionID {
+		form.Errors.Add("restriction_id", "Pick the type of the block")
+	}
+
+	block.StartDate, err = time.Parse(layout, r.Form.Get("start_date"))
+	if err != nil {
+		form.Errors.Add("start_date", "Enter the first blocked day")
+	}
+
+	block.EndDate, err = time.Parse(layout, r.Form.Get("end_date"))
+	if err != nil {
+		form.Errors.Add("end_date", "Enter the last blocked day")
+	}
+
+	block.RepeatWeeks, _ = strconv.Atoi(r.Form.Get("repeat_weeks"))
+
+	block.RepeatUntil = time.Time{}
+	if block.RepeatWeeks > 0 && r.Form.Get("repeat_until") != "" {
+		block.RepeatUntil, err = time.Parse(layout, r.Form.Get("repeat_until"))
+		if err != nil {
+			form.Errors.Add("repeat_until", "Enter the day the block repeats until")
+		}
+	}
+
+	if !form.Valid() {
+		return block
+	}
+
+	_, err = blocks.Expand(block)
+	switch {
+	case errors.Is(err, blocks.ErrEndBeforeStart):
+		form.Errors.Add("end_date", err.Error())
+	case err != nil:
+		form.Errors.Add("repeat_until", err.Error())
+	}
+
+	return block
+}
+
+// AdminNewBlock shows the form for blocking a room over a range of days
+func (m *Repository) AdminNewBlock(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	room, err := m.DB.GetRoomById(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.renderBlock(w, r, room, models.RoomBlock{RoomID: id}, forms.New(nil))
+}
+
+// AdminPostNewBlock blocks a room over a range of days, every repeat of the block is added or none are
+func (m *Repository) AdminPostNewBlock(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	room, err := m.DB.GetRoomById(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	block := blockFromForm(r, form, models.RoomBlock{RoomID: id})
+
+	if !form.Valid() {
+		m.renderBlock(w, r, room, block, form)
+		return
+	}
+
+	n, err := m.DB.InsertBlocksForRoom(r.Context(), block)
+
+	var conflict *repository.BlockConflictError
+	if errors.As(err, &conflict) {
+		form.Errors.Add("start_date", fmt.Sprintf("The room is already reserved or blocked on %s", conflict.Date.Format("Monday, January 2, 2006")))
+		m.renderBlock(w, r, room, block, form)
+		return
+	}
+
+	if errors.Is(err, repository.ErrRoomNotAvailable) {
+		form.Errors.Add("start_date", "The room is already reserved or blocked on some of these days")
+		m.renderBlock(w, r, room, block, form)
+		return
+	}
+
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if n == 1 {
+		m.App.Session.Put(r.Context(), "flash", "Block added")
+	} else {
+		m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("%d blocks added", n))
+	}
+	http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%d&m=%d", block.StartDate.Year(), block.StartDate.Month()), http.StatusSeeOther)
+}
+
+// hexColor matches the colours an <input type="color"> posts, e.g. #dc3545
+var hexColor = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// AdminRestrictions lists the restriction types rooms can be blocked with
+func (m *Repository) AdminRestrictions(w http.ResponseWriter, r *http.Request) {
+	restrictions, err := m.DB.AllRestrictions(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["restrictions"] = restrictions
+
+	render.Template(w, r, "admin-restrictions.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// renderRestriction shows the form for adding or editing a restriction type
+func (m *Repository) renderRestriction(w http.ResponseWriter, r *http.Request, restriction models.Restriction, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["restriction"] = restriction
+	data["reservation_type"] = restriction.ID == repository.ReservationRestrictionID
+
+	render.Template(w, r, "admin-restriction.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// restrictionFromForm copies the posted restriction type form over restriction and validates it
+func restrictionFromForm(r *http.Request, form *forms.Form, restriction models.Restriction) models.Restriction {
+	form.Required("restriction_name", "color")
+
+	restriction.RestrictionName = r.Form.Get("restriction_name")
+	restriction.Color = r.Form.Get("color")
+	if !hexColor.MatchString(restriction.Color) {
+		form.Errors.Add("color", "Pick a colour")
+	}
+
+	// Reservations always hold their dates
+	restriction.BlocksAvailability = r.Form.Get("blocks_availability") == "1" || restriction.ID == repository.ReservationRestrictionID
+
+	return restriction
+}
+
+// AdminNewRestriction shows the form for adding a restriction type
+func (m *Repository) AdminNewRestriction(w http.ResponseWriter, r *http.Request) {
+	m.renderRestriction(w, r, models.Restriction{Color: "#6c757d", BlocksAvailability: true}, forms.New(nil))
+}
+
+// AdminPostNewRestriction adds a restriction type
+func (m *Repository) AdminPostNewRestriction(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	restriction := restrictionFromForm(r, form, models.Restriction{})
+
+	if !form.Valid() {
+		m.renderRestriction(w, r, restriction, form)
+		return
+	}
+
+	_, err = m.DB.InsertRestriction(r.Context(), restriction)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Restriction type added")
+	http.Redirect(w, r, "/admin/restrictions", http.StatusSeeOther)
+}
+
+// AdminShowRestriction shows the form for editing a restriction type
+func (m *Repository) AdminShowRestriction(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	restriction, err := m.DB.GetRestrictionById(r.Context(), id)
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	m.renderRestriction(w, r, restriction, forms.New(nil))
+}
+
+// AdminPostRestriction saves changes to a restriction type, the blocks already made with it change along with it
+func (m *Repository) AdminPostRestriction(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	restriction, err := m.DB.GetRestrictionById(r.Context(), id)
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	restriction = restrictionFromForm(r, form, restriction)
+
+	if !form.Valid() {
+		m.renderRestriction(w, r, restriction, form)
+		return
+	}
+
+	err = m.DB.UpdateRestriction(r.Context(), restriction)
+	if errors.Is(err, repository.ErrRoomNotAvailable) {
+		form.Errors.Add("blocks_availability", "Some days blocked with this type are already booked, remove those blocks first")
+		m.renderRestriction(w, r, restriction, form)
+		return
+	}
+
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Changes saved")
+	http.Redirect(w, r, "/admin/restrictions", http.StatusSeeOther)
+}
+
+// AdminDeleteRestriction deletes a restriction type that no room is restricted with
+func (m *Repository) AdminDeleteRestriction(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	if id == repository.ReservationRestrictionID {
+		m.App.Session.Put(r.Context(), "error", "Reservations need this type, it can't be deleted")
+		http.Redirect(w, r, fmt.Sprintf("/admin/restrictions/%d", id), http.StatusSeeOther)
+		return
+	}
+
+	err := m.DB.DeleteRestriction(r.Context(), id)
+	if errors.Is(err, repository.ErrRestrictionInUse) {
+		m.App.Session.Put(r.Context(), "error", "Rooms are still blocked with this type, remove those blocks on the calendar first")
+		http.Redirect(w, r, fmt.Sprintf("/admin/restrictions/%d", id), http.StatusSeeOther)
+		return
+	}
+
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Restriction type deleted")
+	http.Redirect(w, r, "/admin/restrictions", http.StatusSeeOther)
+}
+
+// AdminCancellationPolicies lists the cancellation policies rooms and rate plans can have
+func (m *Repository) AdminCancellationPolicies(w http.ResponseWriter, r *http.Request) {
+	policies, err := m.DB.AllCancellationPolicies(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	descriptions := make(map[int]string)
+	for _, p := range policies {
+		descriptions[p.ID] = cancellation.Describe(p)
+	}
+
+	data := make(map[string]interface{})
+	data["policies"] = policies
+	data["descriptions"] = descriptions
+
+	render.Template(w, r, "admin-cancellation-policies.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// addCancellationPolicies adds the cancellation policies to pick from on a room or rate plan form to data
+func (m *Repository) addCancellationPolicies(r *http.Request, data map[string]interface{}) error {
+	policies, err := m.DB.AllCancellationPolicies(r.Context())
+	if err != nil {
+		return err
+	}
+
+	data["cancellation_policies"] = policies
+
+	return nil
+}
+
+// cancellationPolicyIDFromForm returns the cancellation policy picked on a room or rate plan form, 0 for none
+func cancellationPolicyIDFromForm(r *http.Request, form *forms.Form) int {
+	if r.Form.Get("cancellation_policy_id") == "" {
+		return 0
+	}
+
+	id, err := strconv.Atoi(r.Form.Get("cancellation_policy_id"))
+	if err != nil || id < 0 {
+		form.Errors.Add("cancellation_policy_id", "Pick a cancellation policy")
+		return 0
+	}
+
+	return id
+}
+
+// feeTypes are the ways a cancellation policy can work out its fee, in the order they are offered
+var feeTypes = []struct {
+	Value string
+	Label string
+}{
+	{cancellation.FeePercent, "A percentage of the total"},
+	{cancellation.FeeFirstNight, "The price of the first night"},
+}
+
+// renderCancellationPolicy shows the form for adding or editing a cancellation policy
+func (m *Repository) renderCancellationPolicy(w http.ResponseWriter, r *http.Request, policy models.CancellationPolicy, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["policy"] = policy
+	data["fee_types"] = feeTypes
+
+	render.Template(w, r, "admin-cancellation-policy.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// cancellationPolicyFromForm copies the posted cancellation policy form over policy and validates it
+func cancellationPolicyFromForm(r *http.Request, form *forms.Form, policy models.CancellationPolicy) models.CancellationPolicy {
+	form.Required("name", "free_days", "fee_type")
+
+	policy.Name = r.Form.Get("name")
+
+	var err error
+
+	policy.FreeDays, err = strconv.Atoi(r.Form.Get("free_days"))
+	if err != nil || policy.FreeDays < 0 {
+		form.Errors.Add("free_days", "Enter a number of days, 0 for free cancellation until the day of arrival")
+		policy.FreeDays = 0
+	}
+
+	policy.FeeType = r.Form.Get("fee_type")
+	policy.FeePercent = 0
+
+	switch policy.FeeType {
+	case cancellation.FeePercent:
+		policy.FeePercent, err = strconv.Atoi(r.Form.Get("fee_percent"))
+		if err != nil || policy.FeePercent < 0 || policy.FeePercent > 100 {
+			form.Errors.Add("fee_percent", "Enter a percentage between 0 and 100")
+			policy.FeePercent = 0
+		}
+	case cancellation.FeeFirstNight:
+	default:
+		form.Errors.Add("fee_type", "Pick how the fee is worked out")
+	}
+
+	return policy
+}
+
+// AdminNewCancellationPolicy shows the form for adding a cancellation policy
+func (m *Repository) AdminNewCancellationPolicy(w http.ResponseWriter, r *http.Request) {
+	m.renderCancellationPolicy(w, r, models.CancellationPolicy{FreeDays: 7, FeeType: cancellation.FeePercent, FeePercent: 100}, forms.New(nil))
+}
+
+// AdminPostNewCancellationPolicy adds a cancellation policy
+func (m *Repository) AdminPostNewCancellationPolicy(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	policy := cancellationPolicyFromForm(r, form, models.CancellationPolicy{})
+
+	if !form.Valid() {
+		m.renderCancellationPolicy(w, r, policy, form)
+		return
+	}
+
+	_, err = m.DB.InsertCancellationPolicy(r.Context(), policy)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Cancellation policy added")
+	http.Redirect(w, r, "/admin/cancellation-policies", http.StatusSeeOther)
+}
+
+// AdminShowCancellationPolicy shows the form for editing a cancellation policy
+func (m *Repository) AdminShowCancellationPolicy(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	policy, err := m.DB.GetCancellationPolicyById(r.Context(), id)
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	m.renderCancellationPolicy(w, r, policy, forms.New(nil))
+}
+
+// AdminPostCancellationPolicy saves changes to a cancellation policy, reservations already made keep the terms they were booked with
+func (m *Repository) AdminPostCancellationPolicy(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	policy, err := m.DB.GetCancellationPolicyById(r.Context(), id)
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	policy = cancellationPolicyFromForm(r, form, policy)
+
+	if !form.Valid() {
+		m.renderCancellationPolicy(w, r, policy, form)
+		return
+	}
+
+	err = m.DB.UpdateCancellationPolicy(r.Context(), policy)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Changes saved")
+	http.Redirect(w, r, "/admin/cancellation-policies", http.StatusSeeOther)
+}
+
+// AdminDeleteCancellationPolicy deletes a cancellation policy, the rooms and rate plans using it go back to free cancellation
+func (m *Repository) AdminDeleteCancellationPolicy(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	err := m.DB.DeleteCancellationPolicy(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Cancellation policy deleted")
+	http.Redirect(w, r, "/admin/cancellation-policies", http.StatusSeeOther)
+}
+
+// AdminUsers lists the staff accounts
+func (m *Repository) AdminUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := m.DB.AllUsers(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// users locked out after too many failed logins
+	locked := make(map[int]bool)
+	for _, u := range users {
+		until, err := m.App.Lockout.LockedUntil(r.Context(), u.Email, time.Now())
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		locked[u.ID] = !until.IsZero()
+	}
+
+	data := make(map[string]interface{})
+	data["users"] = users
+	data["locked"] = locked
+
+	render.Template(w, r, "admin-users.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// renderUser shows the form for inviting or editing a user
+func (m *Repository) renderUser(w http.ResponseWriter, r *http.Request, u models.User, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["user"] = u
+	data["roles"] = roles.All
+	data["self"] = u.ID == m.App.Session.GetInt(r.Context(), "user_id")
+
+	if u.ID != 0 {
+		until, err := m.App.Lockout.LockedUntil(r.Context(), u.Email, time.Now())
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		data["locked_until"] = until
+	}
+
+	render.Template(w, r, "admin-user.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// userFromForm copies the posted user form over u and validates it
+func userFromForm(r *http.Request, form *forms.Form, u models.User) models.User {
+	form.Required("first_name", "last_name", "email", "role")
+	form.IsEmail("email")
+
+	u.FirstName = r.Form.Get("first_name")
+	u.LastName = r.Form.Get("last_name")
+	u.Email = strings.TrimSpace(r.Form.Get("email"))
+	u.Role = r.Form.Get("role")
+	if !roles.Valid(u.Role) {
+		form.Errors.Add("role", "Pick a role")
+	}
+
+	return u
+}
+
+// otherOwners counts the owners besides the user with the given id that can still log in, an owner has to be
+// left to manage the users
+func (m *Repository) otherOwners(ctx context.Context, id int) (int, error) {
+	users, err := m.DB.AllUsers(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, u := range users {
+		if u.ID != id && u.Role == roles.Owner && !u.Disabled() {
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+// inviteLink returns the signed path an invited user sets their password at, it stops working once they have one
+func (m *Repository) inviteLink(u models.User) string {
+	token := signing.Sign(m.App.LinkSecret, fmt.Sprintf("invite:%d:%s:%s", u.ID, u.Email, u.Password))
+	return fmt.Sprintf("/user/invite/%d/%s", u.ID, token)
+}
+
+// AdminNewUser shows the form for inviting a user
+func (m *Repository) AdminNewUser(w http.ResponseWriter, r *http.Request) {
+	m.renderUser(w, r, models.User{Role: roles.FrontDesk}, forms.New(nil))
+}
+
+// AdminPostNewUser adds a user without a password and emails them a link to set one
+func (m *Repository) AdminPostNewUser(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	u := userFromForm(r, form, models.User{})
+
+	if !form.Valid() {
+		m.renderUser(w, r, u, form)
+		return
+	}
+
+	u.ID, err = m.DB.InsertUser(r.Context(), u)
+	if errors.Is(err, repository.ErrEmailTaken) {
+		form.Errors.Add("email", "Another user already has this email")
+		m.renderUser(w, r, u, form)
+		return
+	}
+
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.MailChan <- models.MailData{
+		To:      u.Email,
+		From:    "me@here.com",
+		Subject: "You have been invited to Fort Smythe Bed and Breakfast",
+		Content: fmt.Sprintf(`
+			<strong>Welcome</strong>
+			Dear %s, <br>
+			You have been given a %s account for the admin of Fort Smythe Bed and Breakfast. <br>
+			To choose your password and log in, follow <a href="%s%s">this link</a>
+		`, u.FirstName, roles.Label(u.Role), m.App.BaseURL, m.inviteLink(u)),
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Invitation sent to "+u.Email)
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// AdminShowUser shows the form for editing a user
+func (m *Repository) AdminShowUser(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	u, err := m.DB.GetUserById(r.Context(), id)
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	m.renderUser(w, r, u, forms.New(nil))
+}
+
+// AdminPostUser saves changes to a user, the last owner can't be given another role
+func (m *Repository) AdminPostUser(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	u, err := m.DB.GetUserById(r.Context(), id)
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	wasOwner := u.Role == roles.Owner && !u.Disabled()
+
+	form := forms.New(r.PostForm)
+	u = userFromForm(r, form, u)
+
+	if wasOwner && u.Role != roles.Owner {
+		owners, err := m.otherOwners(r.Context(), u.ID)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		if owners == 0 {
+			form.Errors.Add("role", "This is the only owner, make someone else an owner first")
+		}
+	}
+
+	if !form.Valid() {
+		m.renderUser(w, r, u, form)
+		return
+	}
+
+	err = m.DB.UpdateUser(r.Context(), u)
+	if errors.Is(err, repository.ErrEmailTaken) {
+		form.Errors.Add("email", "Another user already has this email")
+		m.renderUser(w, r, u, form)
+		return
+	}
+
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Changes saved")
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// AdminDisableUser stops a user from logging in, users can't disable themselves and the last owner can't be disabled
+func (m *Repository) AdminDisableUser(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	u, err := m.DB.GetUserById(r.Context(), id)
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	if u.ID == m.App.Session.GetInt(r.Context(), "user_id") {
+		m.App.Session.Put(r.Context(), "error", "You can't disable your own account")
+		http.Redirect(w, r, fmt.Sprintf("/admin/users/%d", id), http.StatusSeeOther)
+		return
+	}
+
+	if u.Role == roles.Owner {
+		owners, err := m.otherOwners(r.Context(), u.ID)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		if owners == 0 {
+			m.App.Session.Put(r.Context(), "error", "This is the only owner, make someone else an owner first")
+			http.Redirect(w, r, fmt.Sprintf("/admin/users/%d", id), http.StatusSeeOther)
+			return
+		}
+	}
+
+	if err := m.DB.SetUserDisabled(r.Context(), id, true); err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", u.Email+" can no longer log in")
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// AdminEnableUser lets a disabled user log in again
+func (m *Repository) AdminEnableUser(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	u, err := m.DB.GetUserById(r.Context(), id)
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	if err := m.DB.SetUserDisabled(r.Context(), id, false); err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", u.Email+" can log in again")
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// invitedUser returns the user the signed invite link of the request is for, links that don't match a user who still
+// has to set their password are not found
+func (m *Repository) invitedUser(w http.ResponseWriter, r *http.Request) (models.User, bool) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	u, err := m.DB.GetUserById(r.Context(), id)
+	if err != nil || u.Password != "" || u.Disabled() || !signing.Verify(m.App.LinkSecret, fmt.Sprintf("invite:%d:%s:%s", u.ID, u.Email, u.Password), chi.URLParam(r, "token")) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return u, false
+	}
+
+	return u, true
+}
+
+// AcceptInvite shows an invited user the form to choose their password
+func (m *Repository) AcceptInvite(w http.ResponseWriter, r *http.Request) {
+	u, ok := m.invitedUser(w, r)
+	if !ok {
+		return
+	}
+
+	render.Template(w, r, "accept-invite.page.tmpl", &models.TemplateData{
+		Form:      forms.New(nil),
+		StringMap: map[string]string{"email": u.Email, "link": m.inviteLink(u)},
+	})
+}
+
+// PostAcceptInvite sets the password of an invited user, after which the invite link no longer works
+func (m *Repository) PostAcceptInvite(w http.ResponseWriter, r *http.Request) {
+	u, ok := m.invitedUser(w, r)
+	if !ok {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := newPasswordForm(r)
+	if !form.Valid() {
+		render.Template(w, r, "accept-invite.page.tmpl", &models.TemplateData{
+			Form:      form,
+			StringMap: map[string]string{"email": u.Email, "link": m.inviteLink(u)},
+		})
+		return
+	}
+
+	if err := m.DB.UpdateUserPassword(r.Context(), u.ID, r.Form.Get("password")); err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Password set, you can log in now")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// newPasswordForm validates a posted password and its confirmation
+func newPasswordForm(r *http.Request) *forms.Form {
+	form := forms.New(r.PostForm)
+	form.Required("password", "password_confirm")
+	form.MinLength("password", 8)
+	if r.Form.Get("password") != r.Form.Get("password_confirm") {
+		form.Errors.Add("password_confirm", "The passwords don't match")
+	}
+
+	return form
+}
+
+// passwordResetLifetime is how long the link to reset a password works
+const passwordResetLifetime = time.Hour
+
+// ForgotPassword shows the form to ask for a link to reset a password
+func (m *Repository) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, r, "forgot-password.page.tmpl", &models.TemplateData{
+		Form: forms.New(nil),
+	})
+}
+
+// PostForgotPassword emails a link to reset their password to the user with the posted email. Whether a user has
+// the email or not the answer is the same, so the form can't be used to find out who has an account
+func (m *Repository) PostForgotPassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("email")
+	form.IsEmail("email")
+
+	if !form.Valid() {
+		render.Template(w, r, "forgot-password.page.tmpl", &models.TemplateData{
+			Form: form,
+		})
+		return
+	}
+
+	u, err := m.DB.GetUserByEmail(r.Context(), strings.TrimSpace(r.Form.Get("email")))
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if err == nil && !u.Disabled() {
+		token, hash, err := tokens.New()
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		err = m.DB.InsertPasswordReset(r.Context(), models.PasswordReset{
+			UserID:    u.ID,
+			TokenHash: hash,
+			ExpiresAt: time.Now().Add(passwordResetLifetime),
+		})
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		m.App.MailChan <- models.MailData{
+			To:      u.Email,
+			From:    "me@here.com",
+			Subject: "Reset your password",
+			Content: fmt.Sprintf(`
+				<strong>Reset your password</strong>
+				Dear %s, <br>
+				To choose a new password for Fort Smythe Bed and Breakfast, follow <a href="%s/user/reset-password/%s">this link</a>. <br>
+				The link works once, for the next hour. If you didn't ask to reset your password you can ignore this email.
+			`, u.FirstName, m.App.BaseURL, token),
+		}
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "If an account uses that email, we've sent it a link to reset the password")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// resetUser returns the user the password reset token of the request is for, links that are unknown, used or expired
+// send the user back to ask for a new one
+func (m *Repository) resetUser(w http.ResponseWriter, r *http.Request) (models.User, bool) {
+	reset, err := m.DB.GetPasswordReset(r.Context(), tokens.Hash(chi.URLParam(r, "token")))
+	if err == nil && reset.Usable(time.Now()) {
+		u, err := m.DB.GetUserById(r.Context(), reset.UserID)
+		if err == nil && !u.Disabled() {
+			return u, true
+		}
+	}
+
+	m.App.Session.Put(r.Context(), "error", "This link to reset your password has expired or was already used, ask for a new one")
+	http.Redirect(w, r, "/user/forgot-password", http.StatusSeeOther)
+	return models.User{}, false
+}
+
+// ResetPassword shows the form to choose a new password
+func (m *Repository) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	u, ok := m.resetUser(w, r)
+	if !ok {
+		return
+	}
+
+	render.Template(w, r, "reset-password.page.tmpl", &models.TemplateData{
+		Form:      forms.New(nil),
+		StringMap: map[string]string{"email": u.Email, "link": r.URL.Path},
+	})
+}
+
+// PostResetPassword uses the token to set a new password, which logs the user out everywhere else
+func (m *Repository) PostResetPassword(w http.ResponseWriter, r *http.Request) {
+	u, ok := m.resetUser(w, r)
+	if !ok {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := newPasswordForm(r)
+	if !form.Valid() {
+		render.Template(w, r, "reset-password.page.tmpl", &models.TemplateData{
+			Form:      form,
+			StringMap: map[string]string{"email": u.Email, "link": r.URL.Path},
+		})
+		return
+	}
+
+	// the change is made by the user the link was sent to, not by a guest
+	ctx := audit.WithActor(r.Context(), audit.Actor{UserID: u.ID, Name: u.Email})
+
+	err = m.DB.WithTx(ctx, func(repo repository.DatabaseRepo) error {
+		id, err := repo.UsePasswordReset(ctx, tokens.Hash(chi.URLParam(r, "token")))
+		if err != nil {
+			return err
+		}
+
+		return repo.UpdateUserPassword(ctx, id, r.Form.Get("password"))
+	})
+	if errors.Is(err, repository.ErrPasswordResetInvalid) {
+		// someone else used the link in the meantime
+		m.App.Session.Put(r.Context(), "error", "This link to reset your password has expired or was already used, ask for a new one")
+		http.Redirect(w, r, "/user/forgot-password", http.StatusSeeOther)
+		return
+	}
+
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// the new password bumped the session version of the user, so their other sessions are logged out the next time
+	// they are used. This one starts over with a new token
+	_ = m.App.Session.RenewToken(r.Context())
+	m.App.Session.Remove(r.Context(), "user_id")
+	m.App.Session.Remove(r.Context(), "user_email")
+
+	// whoever locked the account out doesn't know the new password
+	if err := m.App.Lockout.Unlock(r.Context(), u.Email); err != nil {
+		log.Println(err)
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Password changed, log in with your new password")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// twoFactorIssuer is the name authenticator apps show next to the codes for this site
+const twoFactorIssuer = "Fort Smythe Bed and Breakfast"
+
+// twoFactorLoginTimeout is how long a user has to enter their code after their password
+const twoFactorLoginTimeout = 5 * time.Minute
+
+// twoFactorAttempts is how many wrong codes a user can enter before they have to start over with their password
+const twoFactorAttempts = 5
+
+// TwoFactorRequired reports whether users with role have to log in with two-factor authentication
+func (m *Repository) TwoFactorRequired(role string) bool {
+	for _, r := range m.App.TwoFactorRoles {
+		if r == role {
+			return true
+		}
+	}
+
+	return false
+}
+
+// twoFactorSecret returns the secret a user is setting up their authenticator app with. It is kept in the session
+// until they entered a code from it, so reloading the page doesn't change the QR code
+func (m *Repository) twoFactorSecret(r *http.Request) (string, error) {
+	secret := m.App.Session.GetString(r.Context(), "twofactor_secret")
+	if secret != "" {
+		return secret, nil
+	}
+
+	secret, err := twofactor.NewSecret()
+	if err != nil {
+		return "", err
+	}
+
+	m.App.Session.Put(r.Context(), "twofactor_secret", secret)
+	return secret, nil
+}
+
+// twoFactorSetup adds what a user needs to set up their authenticator app to data, the QR code and the secret to
+// type in instead
+func (m *Repository) twoFactorSetup(r *http.Request, u models.User, data map[string]interface{}) error {
+	secret, err := m.twoFactorSecret(r)
+	if err != nil {
+		return err
+	}
+
+	png, err := twofactor.QRCode(twofactor.URL(twoFactorIssuer, u.Email, secret))
+	if err != nil {
+		return err
+	}
+
+	// groups of four are easier to type
+	var groups []string
+	for i := 0; i < len(secret); i += 4 {
+		groups = append(groups, secret[i:min(i+4, len(secret))])
+	}
+
+	data["qr"] = template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png))
+	data["secret"] = strings.Join(groups, " ")
+	return nil
+}
+
+// enableTwoFactor turns on two-factor authentication for a user with the secret they set up their app with, step is
+// the code they confirmed it with so it can't be used again. It returns their new recovery codes
+func (m *Repository) enableTwoFactor(ctx context.Context, id int, secret string, step int64) ([]string, error) {
+	codes, hashes, err := twofactor.NewRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.DB.WithTx(ctx, func(repo repository.DatabaseRepo) error {
+		if err := repo.SetUserTOTPSecret(ctx, id, secret); err != nil {
+			return err
+		}
+
+		if _, err := repo.UseUserTOTPStep(ctx, id, step); err != nil {
+			return err
+		}
+
+		return repo.ReplaceRecoveryCodes(ctx, id, hashes)
+	})
+
+	return codes, err
+}
+
+// checkTwoFactorCode reports whether code is the current code of the authenticator app of u or one of their unused
+// recovery codes, and uses it up so it can't be entered again. recovery is true if a recovery code was used
+func (m *Repository) checkTwoFactorCode(ctx context.Context, u models.User, code string) (ok bool, recovery bool, err error) {
+	if step, valid := twofactor.Verify(u.TOTPSecret, strings.ReplaceAll(strings.TrimSpace(code), " ", ""), time.Now()); valid {
+		ok, err := m.DB.UseUserTOTPStep(ctx, u.ID, step)
+		return ok, false, err
+	}
+
+	// comparing with bcrypt is slow, only do it for what could be a recovery code
+	if len(confirmation.Normalize(code)) != confirmation.Length {
+		return false, false, nil
+	}
+
+	codes, err := m.DB.UnusedRecoveryCodes(ctx, u.ID)
+	if err != nil {
+		return false, false, err
+	}
+
+	for _, c := range codes {
+		if twofactor.MatchRecoveryCode(c.CodeHash, code) {
+			ok, err := m.DB.UseRecoveryCode(ctx, c.ID)
+			return ok, true, err
+		}
+	}
+
+	return false, false, nil
+}
+
+// pendingLogin returns the user that entered their password and still has to enter a code. It sends everyone else
+// back to the login page, as well as users who took too long or entered too many wrong codes
+func (m *Repository) pendingLogin(w http.ResponseWriter, r *http.Request) (models.User, bool) {
+	id := m.App.Session.GetInt(r.Context(), "twofactor_user_id")
+	started := time.Unix(m.App.Session.GetInt64(r.Context(), "twofactor_started"), 0)
+
+	if id != 0 && time.Since(started) < twoFactorLoginTimeout && m.App.Session.GetInt(r.Context(), "twofactor_attempts") < twoFactorAttempts {
+		u, err := m.DB.GetUserById(r.Context(), id)
+		if err == nil && !u.Disabled() && u.SessionVersion == m.App.Session.GetInt(r.Context(), "twofactor_version") {
+			return u, true
+		}
+	}
+
+	for _, key := range []string{"twofactor_user_id", "twofactor_version", "twofactor_started", "twofactor_attempts", "twofactor_secret"} {
+		m.App.Session.Remove(r.Context(), key)
+	}
+
+	m.App.Session.Put(r.Context(), "error", "Log in first!")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+	return models.User{}, false
+}
+
+// TwoFactorLogin asks a user that entered their password for a code, or has them set up their authenticator app
+// first if their role requires two-factor authentication and they haven't turned it on yet
+func (m *Repository) TwoFactorLogin(w http.ResponseWriter, r *http.Request) {
+	u, ok := m.pendingLogin(w, r)
+	if !ok {
+		return
+	}
+
+	m.renderTwoFactorLogin(w, r, u, forms.New(nil))
+}
+
+// renderTwoFactorLogin shows the second step of the login, the setup of the authenticator app if the user doesn't
+// have one yet
+func (m *Repository) renderTwoFactorLogin(w http.ResponseWriter, r *http.Request, u models.User, form *forms.Form) {
+	if u.TwoFactor() {
+		render.Template(w, r, "two-factor.page.tmpl", &models.TemplateData{
+			Form: form,
+		})
+		return
+	}
+
+	data := make(map[string]interface{})
+	if err := m.twoFactorSetup(r, u, data); err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	render.Template(w, r, "two-factor-setup.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// PostTwoFactorLogin checks the code of a user that entered their password and logs them in
+func (m *Repository) PostTwoFactorLogin(w http.ResponseWriter, r *http.Request) {
+	u, ok := m.pendingLogin(w, r)
+	if !ok {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// guessing codes is slowed down and locks the account out like guessing passwords
+	if m.mustWait(w, r, u.Email) {
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("code")
+	code := r.Form.Get("code")
+
+	// changes are made by the user, not by a guest, even though they aren't logged in yet
+	ctx := audit.WithActor(r.Context(), audit.Actor{UserID: u.ID, Name: u.Email})
+
+	var codes []string
+	recovery := false
+	if form.Valid() {
+		if u.TwoFactor() {
+			ok, recovery, err = m.checkTwoFactorCode(ctx, u, code)
+		} else {
+			// setting up the app, the code shows it was set up right
+			var step int64
+			secret := m.App.Session.GetString(r.Context(), "twofactor_secret")
+			if step, ok = twofactor.Verify(secret, strings.ReplaceAll(code, " ", ""), time.Now()); ok {
+				codes, err = m.enableTwoFactor(ctx, u.ID, secret, step)
+			}
+		}
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		if !ok {
+			form.Errors.Add("code", "That code isn't right, try the one your app shows now")
+		}
+	}
+
+	if !form.Valid() {
+		if err := m.loginFailed(r, u.Email); err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		m.App.Session.Put(r.Context(), "twofactor_attempts", m.App.Session.GetInt(r.Context(), "twofactor_attempts")+1)
+		m.renderTwoFactorLogin(w, r, u, form)
+		return
+	}
+
+	// the user is read again, enabling two-factor authentication changed them
+	u, err = m.DB.GetUserById(r.Context(), u.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.logIn(r, u)
+
+	if codes != nil {
+		// shown once, they are only stored hashed
+		data := make(map[string]interface{})
+		data["codes"] = codes
+
+		render.Template(w, r, "two-factor-setup.page.tmpl", &models.TemplateData{
+			Data:      data,
+			StringMap: map[string]string{"continue": m.loginRedirect(r)},
+			Form:      forms.New(nil),
+		})
+		return
+	}
+
+	if recovery {
+		left, err := m.DB.UnusedRecoveryCodes(r.Context(), u.ID)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		m.App.Session.Put(r.Context(), "warning", fmt.Sprintf("You logged in with a recovery code, %d left. You can make new ones on the Two-Factor page", len(left)))
+	}
+
+	http.Redirect(w, r, m.loginRedirect(r), http.StatusSeeOther)
+}
+
+// currentUser returns the logged in user
+func (m *Repository) currentUser(r *http.Request) (models.User, error) {
+	return m.DB.GetUserById(r.Context(), m.App.Session.GetInt(r.Context(), "user_id"))
+}
+
+// renderTwoFactor shows the two-factor authentication settings of the logged in user, codes are the recovery codes
+// they just got, shown only once
+func (m *Repository) renderTwoFactor(w http.ResponseWriter, r *http.Request, u models.User, codes []string, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["user"] = u
+	data["required"] = m.TwoFactorRequired(u.Role)
+	data["codes"] = codes
+
+	if u.TwoFactor() {
+		left, err := m.DB.UnusedRecoveryCodes(r.Context(), u.ID)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		data["left"] = len(left)
+	} else if err := m.twoFactorSetup(r, u, data); err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	render.Template(w, r, "admin-two-factor.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// AdminTwoFactor shows the two-factor authentication settings of the logged in user
+func (m *Repository) AdminTwoFactor(w http.ResponseWriter, r *http.Request) {
+	u, err := m.currentUser(r)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.renderTwoFactor(w, r, u, nil, forms.New(nil))
+}
+
+// AdminPostTwoFactor turns on two-factor authentication for the logged in user once they entered a code from the app
+// they set up, and shows them their recovery codes
+func (m *Repository) AdminPostTwoFactor(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	u, err := m.currentUser(r)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if u.TwoFactor() {
+		http.Redirect(w, r, "/admin/account/two-factor", http.StatusSeeOther)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("code")
+
+	secret := m.App.Session.GetString(r.Context(), "twofactor_secret")
+	step, ok := twofactor.Verify(secret, strings.ReplaceAll(r.Form.Get("code"), " ", ""), time.Now())
+	if form.Valid() && !ok {
+		form.Errors.Add("code", "That code isn't right, try the one your app shows now")
+	}
+
+	if !form.Valid() {
+		m.renderTwoFactor(w, r, u, nil, form)
+		return
+	}
+
+	codes, err := m.enableTwoFactor(r.Context(), u.ID, secret, step)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Remove(r.Context(), "twofactor_secret")
+
+	u, err = m.currentUser(r)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Two-factor authentication is on")
+	m.renderTwoFactor(w, r, u, codes, forms.New(nil))
+}
+
+// AdminNewRecoveryCodes replaces the recovery codes of the logged in user, the old ones stop working
+func (m *Repository) AdminNewRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	m.changeTwoFactor(w, r, func(u models.User) ([]string, error) {
+		codes, hashes, err := twofactor.NewRecoveryCodes()
+		if err != nil {
+			return nil, err
+		}
+
+		return codes, m.DB.ReplaceRecoveryCodes(r.Context(), u.ID, hashes)
+	})
+}
+
+// AdminDisableTwoFactor turns off two-factor authentication for the logged in user, unless their role requires it
+func (m *Repository) AdminDisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if u, err := m.currentUser(r); err == nil && m.TwoFactorRequired(u.Role) {
+		m.App.Session.Put(r.Context(), "error", "Your role requires two-factor authentication, it can't be turned off")
+		http.Redirect(w, r, "/admin/account/two-factor", http.StatusSeeOther)
+		return
+	}
+
+	m.changeTwoFactor(w, r, func(u models.User) ([]string, error) {
+		return nil, m.disableTwoFactor(r.Context(), u.ID)
+	})
+}
+
+// changeTwoFactor runs change for the logged in user once they confirmed it with a code, they could have left their
+// computer unlocked. The recovery codes change returns are shown to the user
+func (m *Repository) changeTwoFactor(w http.ResponseWriter, r *http.Request, change func(u models.User) ([]string, error)) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	u, err := m.currentUser(r)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if !u.TwoFactor() {
+		http.Redirect(w, r, "/admin/account/two-factor", http.StatusSeeOther)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("code")
+
+	if form.Valid() {
+		ok, _, err := m.checkTwoFactorCode(r.Context(), u, r.Form.Get("code"))
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		if !ok {
+			form.Errors.Add("code", "That code isn't right, try the one your app shows now")
+		}
+	}
+
+	if !form.Valid() {
+		m.renderTwoFactor(w, r, u, nil, form)
+		return
+	}
+
+	codes, err := change(u)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if codes == nil {
+		m.App.Session.Put(r.Context(), "flash", "Two-factor authentication is off")
+		http.Redirect(w, r, "/admin/account/two-factor", http.StatusSeeOther)
+		return
+	}
+
+	m.renderTwoFactor(w, r, u, codes, forms.New(nil))
+}
+
+// disableTwoFactor turns off two-factor authentication for a user and removes their recovery codes
+func (m *Repository) disableTwoFactor(ctx context.Context, id int) error {
+	return m.DB.WithTx(ctx, func(repo repository.DatabaseRepo) error {
+		if err := repo.SetUserTOTPSecret(ctx, id, ""); err != nil {
+			return err
+		}
+
+		return repo.ReplaceRecoveryCodes(ctx, id, nil)
+	})
+}
+
+// AdminResetTwoFactor turns off two-factor authentication for a user that lost their phone and their recovery codes,
+// if their role requires it they set it up again the next time they log in
+func (m *Repository) AdminResetTwoFactor(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	u, err := m.DB.GetUserById(r.Context(), id)
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	if err := m.disableTwoFactor(r.Context(), u.ID); err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", u.Email+" can log in without a code from their app now")
+	http.Redirect(w, r, fmt.Sprintf("/admin/users/%d", u.ID), http.StatusSeeOther)
+}
+
+// AdminUnlockUser lifts the lockout of a user that failed to log in too many times
+func (m *Repository) AdminUnlockUser(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	u, err := m.DB.GetUserById(r.Context(), id)
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	until, err := m.App.Lockout.LockedUntil(r.Context(), u.Email, time.Now())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if err := m.App.Lockout.Unlock(r.Context(), u.Email); err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// failed logins aren't counted through the repo, so the unlock is recorded in the audit log here
+	if !until.IsZero() {
+		change := models.FieldChange{Field: "Lockout", Before: "locked until " + until.Format("2006-01-02 15:04"), After: "unlocked"}
+		if err := m.DB.InsertAuditEntry(r.Context(), audit.NewEntry(r.Context(), audit.Update, audit.User, u.ID, []models.FieldChange{change})); err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	m.App.Session.Put(r.Context(), "flash", u.Email+" can log in again")
+	http.Redirect(w, r, fmt.Sprintf("/admin/users/%d", u.ID), http.StatusSeeOther)
+}
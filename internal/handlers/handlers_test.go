@@ -1,16 +1,33 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/go-chi/chi"
+	"github.com/hd719/go-bookings/internal/audit"
+	"github.com/hd719/go-bookings/internal/cancellation"
+	"github.com/hd719/go-bookings/internal/lockout"
 	"github.com/hd719/go-bookings/internal/models"
+	"github.com/hd719/go-bookings/internal/repository"
+	"github.com/hd719/go-bookings/internal/roles"
+	"github.com/hd719/go-bookings/internal/status"
+	"github.com/hd719/go-bookings/internal/twofactor"
 )
 
 var theTests = []struct {
@@ -24,8 +41,12 @@ var theTests = []struct {
 	{"about", "/about", "GET", http.StatusOK},
 	{"gq", "/generals-quarters", "GET", http.StatusOK},
 	{"ms", "/majors-suite", "GET", http.StatusOK},
+	{"rooms", "/rooms", "GET", http.StatusOK},
+	{"room", "/rooms/generals-quarters", "GET", http.StatusOK},
+	{"missing room", "/rooms/no-such-room", "GET", http.StatusNotFound},
 	{"sa", "/search-availability", "GET", http.StatusOK},
 	{"c", "/contact", "GET", http.StatusOK},
+	{"my reservation", "/my-reservation?code=abc", "GET", http.StatusOK},
 }
 
 func TestHandlers(t *testing.T) {
@@ -311,6 +332,855 @@ func TestRepository_PostReservation(t *testing.T) {
 	// }
 }
 
+func TestRepository_PostReservation_RoomNotAvailable(t *testing.T) {
+	layout := "2006-01-02"
+	sd, _ := time.Parse(layout, "2050-01-01")
+	ed, _ := time.Parse(layout, "2050-01-03")
+
+	postedData := url.Values{}
+	postedData.Add("first_name", "John")
+	postedData.Add("last_name", "Smith")
+	postedData.Add("email", "john@smith.com")
+	postedData.Add("phone", "555-555-5555")
+
+	// successful booking
+	req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
+	ctx := GetCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	session.Put(ctx, "reservation", models.Reservation{RoomID: 1, StartDate: sd, EndDate: ed})
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(Repo.PostReservation)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/reservation-summary" {
+		t.Errorf("PostReservation handler returned %d %s, wanted %d /reservation-summary", rr.Code, rr.Header().Get("Location"), http.StatusSeeOther)
+	}
+
+	available, _ := Repo.DB.SearchAvailabilityByDatesForRoomId(context.Background(), sd, ed, 1)
+	if available {
+		t.Error("room is still available after it was booked")
+	}
+
+	// another guest was looking at the same dates and books second
+	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
+	ctx = GetCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	session.Put(ctx, "reservation", models.Reservation{RoomID: 1, StartDate: sd.AddDate(0, 0, 1), EndDate: ed.AddDate(0, 0, 1)})
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/search-availability" {
+		t.Errorf("PostReservation handler returned %d %s for unavailable room, wanted %d /search-availability", rr.Code, rr.Header().Get("Location"), http.StatusSeeOther)
+	}
+
+	if session.GetString(ctx, "error") == "" {
+		t.Error("PostReservation handler did not set an error message for unavailable room")
+	}
+}
+
+func TestRepository_PostReservation_PricedOnBooking(t *testing.T) {
+	ctx := context.Background()
+
+	policyId, _ := Repo.DB.InsertCancellationPolicy(ctx, models.CancellationPolicy{Name: "Strict", FreeDays: 14, FeeType: cancellation.FeeFirstNight})
+	// the policy tests start from none
+	defer Repo.DB.DeleteCancellationPolicy(ctx, policyId)
+	roomId, _ := Repo.DB.InsertRoom(ctx, models.Room{RoomName: "Baron's Berth", Slug: "barons-berth", Active: true, NightlyRate: 10000, WeekendRate: 10000, CancellationPolicyID: policyId})
+
+	sd := time.Date(2050, 2, 1, 0, 0, 0, 0, time.UTC)
+	ed := sd.AddDate(0, 0, 3)
+
+	postedData := url.Values{}
+	postedData.Add("first_name", "John")
+	postedData.Add("last_name", "Smith")
+	postedData.Add("email", "john@smith.com")
+
+	// straight from choosing the room, the reservation in the session was never priced
+	req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
+	reqCtx := GetCtx(req)
+	req = req.WithContext(reqCtx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	session.Put(reqCtx, "reservation", models.Reservation{RoomID: roomId, StartDate: sd, EndDate: ed})
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(Repo.PostReservation)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("PostReservation handler returned %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+
+	summary, _ := session.Get(reqCtx, "reservation").(models.Reservation)
+	res, err := Repo.DB.GetReservationById(ctx, summary.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.TotalPrice != 30000 || summary.TotalPrice != 30000 {
+		t.Errorf("expected 3 nights to be charged 30000 but got %d, %d in the summary", res.TotalPrice, summary.TotalPrice)
+	}
+
+	if res.Cancellation.Policy != "Strict" || res.Cancellation.FreeDays != 14 {
+		t.Errorf("expected the room's cancellation terms to be kept but got %+v", res.Cancellation)
+	}
+}
+
+func TestRepository_PostReservation_RequestCancelled(t *testing.T) {
+	layout := "2006-01-02"
+	sd, _ := time.Parse(layout, "2050-01-01")
+	ed, _ := time.Parse(layout, "2050-01-02")
+
+	postedData := url.Values{}
+	postedData.Add("first_name", "John")
+	postedData.Add("last_name", "Smith")
+	postedData.Add("email", "john@smith.com")
+
+	req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
+	ctx := GetCtx(req)
+	session.Put(ctx, "reservation", models.Reservation{RoomID: 1, StartDate: sd, EndDate: ed})
+
+	// the client went away before the reservation could be saved
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(Repo.PostReservation)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("PostReservation handler returned wrong response code for cancelled request: got %d, wanted %d", rr.Code, http.StatusInternalServerError)
+	}
+}
+
+func TestRepository_AdminDeleteReservation(t *testing.T) {
+	layout := "2006-01-02"
+	sd, _ := time.Parse(layout, "2051-01-01")
+	ed, _ := time.Parse(layout, "2051-01-02")
+
+	id, err := Repo.DB.BookRoom(context.Background(), models.Reservation{FirstName: "John", LastName: "Smith", Email: "john@smith.com", RoomID: 1, StartDate: sd, EndDate: ed})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/admin/delete-reservation/new/%d/do?reason=Booked+twice", id), nil)
+	ctx := GetCtx(req)
+
+	// set the url params chi would normally extract from the route
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("src", "new")
+	rctx.URLParams.Add("id", strconv.Itoa(id))
+	req = req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx))
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(Repo.AdminDeleteReservation)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/admin/reservations-new" {
+		t.Errorf("AdminDeleteReservation handler returned %d %s, wanted %d /admin/reservations-new", rr.Code, rr.Header().Get("Location"), http.StatusSeeOther)
+	}
+
+	if _, err := Repo.DB.GetReservationById(context.Background(), id); err == nil {
+		t.Error("reservation still exists after it was deleted")
+	}
+
+	available, _ := Repo.DB.SearchAvailabilityByDatesForRoomId(context.Background(), sd, ed, 1)
+	if !available {
+		t.Error("room is still blocked after its reservation was deleted")
+	}
+
+	// the reservation is in the trash with the reason it was deleted for
+	req, _ = http.NewRequest("GET", "/admin/reservations-trash", nil)
+	req = req.WithContext(GetCtx(req))
+
+	rr = httptest.NewRecorder()
+	handler = http.HandlerFunc(Repo.AdminTrash)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "Booked twice") {
+		t.Errorf("AdminTrash handler returned %d without the deleted reservation", rr.Code)
+	}
+
+	restore := func(id int) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", fmt.Sprintf("/admin/restore-reservation/%d/do", id), nil)
+		ctx := GetCtx(req)
+
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", strconv.Itoa(id))
+		req = req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx))
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminRestoreReservation)
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := restore(id); rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != fmt.Sprintf("/admin/reservations/all/%d/show", id) {
+		t.Errorf("AdminRestoreReservation handler returned %d %s, wanted %d to the reservation", rr.Code, rr.Header().Get("Location"), http.StatusSeeOther)
+	}
+
+	if _, err := Repo.DB.GetReservationById(context.Background(), id); err != nil {
+		t.Errorf("reservation was not restored: %v", err)
+	}
+
+	if available, _ := Repo.DB.SearchAvailabilityByDatesForRoomId(context.Background(), sd, ed, 1); available {
+		t.Error("restored reservation does not hold its dates")
+	}
+
+	// a reservation that isn't in the trash can't be restored
+	if rr := restore(id); rr.Code != http.StatusNotFound {
+		t.Errorf("AdminRestoreReservation handler returned %d for a reservation not in the trash, wanted %d", rr.Code, http.StatusNotFound)
+	}
+
+	// free the dates again for the other tests
+	_ = Repo.DB.DeleteReservation(context.Background(), id, "admin@admin.com", "")
+}
+
+func TestRepository_AdminAudit(t *testing.T) {
+	ctx := audit.WithActor(context.Background(), audit.Actor{UserID: 1, Name: "auditor@admin.com"})
+	roomId, err := Repo.DB.InsertRoom(ctx, models.Room{RoomName: "Marquis Manor", Slug: "marquis-manor", Active: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		shown bool
+	}{
+		{"by the admin", "?actor=auditor@admin.com", true},
+		{"of the room", fmt.Sprintf("?entity=room&id=%d", roomId), true},
+		{"by guests", "?actor=guest", false},
+		{"of reservations", "?entity=reservation", false},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/admin/audit"+e.query, nil)
+		req = req.WithContext(GetCtx(req))
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminAudit)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("%s: AdminAudit handler returned %d, wanted %d", e.name, rr.Code, http.StatusOK)
+		}
+
+		if shown := strings.Contains(rr.Body.String(), "Marquis Manor"); shown != e.shown {
+			t.Errorf("%s: expected the new room to be shown %v but it was %v", e.name, e.shown, shown)
+		}
+	}
+}
+
+func TestRepository_AdminDeleteRoom(t *testing.T) {
+	roomId, err := Repo.DB.InsertRoom(context.Background(), models.Room{RoomName: "Colonel's Cabin", Slug: "colonels-cabin", Active: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sd := time.Now().AddDate(0, 1, 0).UTC().Truncate(24 * time.Hour)
+	resId, err := Repo.DB.BookRoom(context.Background(), models.Reservation{RoomID: roomId, StartDate: sd, EndDate: sd.AddDate(0, 0, 2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deleteRoom := func(id int) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", fmt.Sprintf("/admin/rooms/%d/delete", id), nil)
+		ctx := GetCtx(req)
+
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", strconv.Itoa(id))
+		req = req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx))
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminDeleteRoom)
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// the reservation keeps the room from being deleted, even once it is in the trash
+	_ = Repo.DB.DeleteReservation(context.Background(), resId, "admin@admin.com", "")
+
+	rr := deleteRoom(roomId)
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != fmt.Sprintf("/admin/rooms/%d", roomId) {
+		t.Errorf("AdminDeleteRoom handler returned %d %s, wanted %d back to the room", rr.Code, rr.Header().Get("Location"), http.StatusSeeOther)
+	}
+
+	if _, err := Repo.DB.GetRoomById(context.Background(), roomId); err != nil {
+		t.Error("room with a reservation was deleted")
+	}
+
+	// a room without reservations can be deleted
+	emptyId, _ := Repo.DB.InsertRoom(context.Background(), models.Room{RoomName: "Captain's Corner", Slug: "captains-corner", Active: true})
+
+	rr = deleteRoom(emptyId)
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/admin/rooms" {
+		t.Errorf("AdminDeleteRoom handler returned %d %s, wanted %d /admin/rooms", rr.Code, rr.Header().Get("Location"), http.StatusSeeOther)
+	}
+
+	if _, err := Repo.DB.GetRoomById(context.Background(), emptyId); err == nil {
+		t.Error("room still exists after it was deleted")
+	}
+
+	if rr = deleteRoom(emptyId); rr.Code != http.StatusNotFound {
+		t.Errorf("AdminDeleteRoom handler returned %d for a room that doesn't exist, wanted %d", rr.Code, http.StatusNotFound)
+	}
+}
+
+func TestRepository_AdminPostRoom_Deactivate(t *testing.T) {
+	roomId, _ := Repo.DB.InsertRoom(context.Background(), models.Room{RoomName: "Lieutenant's Loft", Slug: "lieutenants-loft", Active: true})
+
+	// the active checkbox is left unchecked
+	postedData := url.Values{}
+	postedData.Add("room_name", "Lieutenant's Loft")
+	postedData.Add("max_occupancy", "2")
+	postedData.Add("nightly_rate", "100")
+
+	req, _ := http.NewRequest("POST", fmt.Sprintf("/admin/rooms/%d", roomId), strings.NewReader(postedData.Encode()))
+	ctx := GetCtx(req)
+
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", strconv.Itoa(roomId))
+	req = req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(Repo.AdminPostRoom)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("AdminPostRoom handler returned %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+
+	sd := time.Now().AddDate(0, 2, 0).UTC().Truncate(24 * time.Hour)
+	_, err := Repo.DB.BookRoom(context.Background(), models.Reservation{RoomID: roomId, StartDate: sd, EndDate: sd.AddDate(0, 0, 1)})
+	if !errors.Is(err, repository.ErrRoomNotAvailable) {
+		t.Errorf("expected an inactive room to be unavailable but got %v", err)
+	}
+}
+
+func TestRepository_AdminPostNewRoom(t *testing.T) {
+	post := func(roomName, slug string) *httptest.ResponseRecorder {
+		postedData := url.Values{}
+		postedData.Add("room_name", roomName)
+		postedData.Add("slug", slug)
+		postedData.Add("max_occupancy", "2")
+		postedData.Add("nightly_rate", "100")
+		postedData.Add("active", "1")
+
+		req, _ := http.NewRequest("POST", "/admin/rooms/new", strings.NewReader(postedData.Encode()))
+		req = req.WithContext(GetCtx(req))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostNewRoom)
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// the slug is made from the room name when left empty
+	rr := post("Sergeant's Studio", "")
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("AdminPostNewRoom handler returned %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+
+	if _, err := Repo.DB.GetRoomBySlug(context.Background(), "sergeants-studio"); err != nil {
+		t.Errorf("expected a room with the slug sergeants-studio but got %v", err)
+	}
+
+	// slugs have to be unique, the form is shown again
+	rr = post("Another Studio", "Sergeants Studio")
+	if rr.Code != http.StatusOK {
+		t.Errorf("AdminPostNewRoom handler returned %d for a taken slug, wanted %d", rr.Code, http.StatusOK)
+	}
+}
+
+func TestRepository_AdminPostRoomPhoto(t *testing.T) {
+	app.UploadPath = t.TempDir()
+
+	roomId, _ := Repo.DB.InsertRoom(context.Background(), models.Room{RoomName: "Major's Attic", Slug: "majors-attic", Active: true})
+
+	upload := func(fileName string, content []byte) *httptest.ResponseRecorder {
+		body := &bytes.Buffer{}
+		mw := multipart.NewWriter(body)
+		_ = mw.WriteField("caption", "The attic")
+		fw, _ := mw.CreateFormFile("photo", fileName)
+		_, _ = fw.Write(content)
+		_ = mw.Close()
+
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/admin/rooms/%d/photos", roomId), body)
+		ctx := GetCtx(req)
+
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", strconv.Itoa(roomId))
+		req = req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx))
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostRoomPhoto)
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// the smallest valid gif
+	gif := []byte("GIF89a\x01\x00\x01\x00\x00\x00\x00;")
+
+	rr := upload("attic.gif", gif)
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("AdminPostRoomPhoto handler returned %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+
+	// a file that is not an image is turned away, whatever its name says
+	upload("attic.jpg", []byte("#!/bin/sh\necho not a photo\n"))
+
+	photos, _ := Repo.DB.GetPhotosForRoom(context.Background(), roomId)
+	if len(photos) != 1 {
+		t.Fatalf("expected 1 photo but got %d", len(photos))
+	}
+
+	if photos[0].Caption != "The attic" || filepath.Ext(photos[0].FileName) != ".gif" {
+		t.Errorf("unexpected photo %+v", photos[0])
+	}
+
+	if _, err := os.Stat(filepath.Join(app.UploadPath, photos[0].FileName)); err != nil {
+		t.Errorf("expected the photo to be saved to disk but got %v", err)
+	}
+}
+
+func TestRepository_AdminPostNewRatePlan(t *testing.T) {
+	roomId, _ := Repo.DB.InsertRoom(context.Background(), models.Room{RoomName: "Admiral's Annex", Slug: "admirals-annex", Active: true, NightlyRate: 10000})
+
+	post := func(start, end string) *httptest.ResponseRecorder {
+		postedData := url.Values{}
+		postedData.Add("name", "Christmas")
+		postedData.Add("start_date", start)
+		postedData.Add("end_date", end)
+		postedData.Add("nightly_rate", "250")
+		for i := 0; i < 7; i++ {
+			postedData.Add(fmt.Sprintf("multiplier_%d", i), "100")
+		}
+
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/admin/rooms/%d/rates/new", roomId), strings.NewReader(postedData.Encode()))
+		ctx := GetCtx(req)
+
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", strconv.Itoa(roomId))
+		req = req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostNewRatePlan)
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// the last night can't come before the first, the form is shown again
+	rr := post("2050-12-26", "2050-12-24")
+	if rr.Code != http.StatusOK {
+		t.Errorf("AdminPostNewRatePlan handler returned %d for an invalid plan, wanted %d", rr.Code, http.StatusOK)
+	}
+
+	rr = post("2050-12-24", "2050-12-26")
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("AdminPostNewRatePlan handler returned %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+
+	quote, _ := Repo.DB.GetRatesForStay(context.Background(), roomId, time.Date(2050, 12, 23, 0, 0, 0, 0, time.UTC), time.Date(2050, 12, 25, 0, 0, 0, 0, time.UTC))
+	if quote.Total != 10000+25000 {
+		t.Errorf("expected the plan to price Christmas Eve but got a total of %d", quote.Total)
+	}
+}
+
+func TestRepository_AdminPostNewStayRule(t *testing.T) {
+	roomId, _ := Repo.DB.InsertRoom(context.Background(), models.Room{RoomName: "Captain's Cove", Slug: "captains-cove", Active: true, NightlyRate: 10000})
+
+	post := func(minNights string) *httptest.ResponseRecorder {
+		postedData := url.Values{}
+		postedData.Add("min_nights", minNights)
+		postedData.Add("arrival_day_6", "1")
+
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/admin/rooms/%d/rules/new", roomId), strings.NewReader(postedData.Encode()))
+		ctx := GetCtx(req)
+
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", strconv.Itoa(roomId))
+		req = req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostNewStayRule)
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// a negative number of nights is not valid, the form is shown again
+	rr := post("-1")
+	if rr.Code != http.StatusOK {
+		t.Errorf("AdminPostNewStayRule handler returned %d for an invalid rule, wanted %d", rr.Code, http.StatusOK)
+	}
+
+	rr = post("3")
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("AdminPostNewStayRule handler returned %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+
+	rules, _ := Repo.DB.GetStayRulesForRoom(context.Background(), roomId)
+	if len(rules) != 1 || rules[0].MinNights != 3 || len(rules[0].ArrivalDays) != 1 || rules[0].ArrivalDays[0] != time.Saturday {
+		t.Errorf("expected a rule for 3 nights arriving on Saturdays but got %+v", rules)
+	}
+}
+
+func TestRepository_AvailabilityJSON_StayRules(t *testing.T) {
+	roomId, _ := Repo.DB.InsertRoom(context.Background(), models.Room{RoomName: "Ensign's Nook", Slug: "ensigns-nook", Active: true, NightlyRate: 10000})
+	Repo.DB.InsertStayRule(context.Background(), models.StayRule{RoomID: roomId, MinNights: 4})
+
+	postedData := url.Values{}
+	postedData.Add("start", "2050-03-01")
+	postedData.Add("end", "2050-03-03")
+	postedData.Add("room_id", strconv.Itoa(roomId))
+
+	req, _ := http.NewRequest("POST", "/search-availability-json", strings.NewReader(postedData.Encode()))
+	req = req.WithContext(GetCtx(req))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(Repo.AvailabilityJSON)
+	handler.ServeHTTP(rr, req)
+
+	var j jsonResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &j); err != nil {
+		t.Fatal("failed to parse json", err)
+	}
+
+	if j.OK || !strings.Contains(j.Message, "at least 4 nights") {
+		t.Errorf("expected the room to be excluded by its stay rule but got %+v", j)
+	}
+
+	// the search shows the room with the reason it can't be booked
+	req, _ = http.NewRequest("POST", "/search-availability", strings.NewReader(postedData.Encode()))
+	req = req.WithContext(GetCtx(req))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.ParseForm()
+
+	rr = httptest.NewRecorder()
+	handler = http.HandlerFunc(Repo.PostAvailability)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("PostAvailability handler returned %d, wanted %d", rr.Code, http.StatusOK)
+	}
+
+	if !strings.Contains(rr.Body.String(), "at least 4 nights") {
+		t.Error("expected the reason the room is excluded to be shown")
+	}
+}
+
+func TestRepository_AdminPostReservationsCalendar_BlockType(t *testing.T) {
+	post := func(restrictionId string) *httptest.ResponseRecorder {
+		postedData := url.Values{}
+		postedData.Add("y", "2050")
+		postedData.Add("m", "04")
+		postedData.Add("restriction_id", restrictionId)
+		postedData.Add("add_block_2_2050-04-10", "1")
+
+		req, _ := http.NewRequest("POST", "/admin/reservations-calendar", strings.NewReader(postedData.Encode()))
+		req = req.WithContext(GetCtx(req))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostReservationsCalendar)
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// reservations hold their own dates, they can't be used to block a day
+	post(strconv.Itoa(repository.ReservationRestrictionID))
+
+	restrictions, _ := Repo.DB.GetRestrictionsForRoomByDate(context.Background(), 2, time.Date(2050, 4, 10, 0, 0, 0, 0, time.UTC), time.Date(2050, 4, 10, 0, 0, 0, 0, time.UTC))
+	if len(restrictions) != 0 {
+		t.Fatalf("expected no block with the reservation type but got %+v", restrictions)
+	}
+
+	rr := post("3")
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("AdminPostReservationsCalendar handler returned %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+
+	restrictions, _ = Repo.DB.GetRestrictionsForRoomByDate(context.Background(), 2, time.Date(2050, 4, 10, 0, 0, 0, 0, time.UTC), time.Date(2050, 4, 10, 0, 0, 0, 0, time.UTC))
+	if len(restrictions) != 1 || restrictions[0].RestrictionID != 3 || restrictions[0].Restriction.RestrictionName != "Maintenance" {
+		t.Errorf("expected a maintenance block but got %+v", restrictions)
+	}
+}
+
+func TestRepository_AdminPostReservationsCalendar_RemoveOnly(t *testing.T) {
+	day := time.Date(2050, 4, 20, 0, 0, 0, 0, time.UTC)
+	if err := Repo.DB.InsertBlockForRoomById(context.Background(), 2, 3, day, day.AddDate(0, 0, 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	restrictions, _ := Repo.DB.GetRestrictionsForRoomByDate(context.Background(), 2, day, day)
+	if len(restrictions) != 1 {
+		t.Fatalf("expected the block to be added but got %+v", restrictions)
+	}
+
+	// the block is unticked and no type is picked, there are no new blocks that need one
+	postedData := url.Values{}
+	postedData.Add("y", "2050")
+	postedData.Add("m", "04")
+
+	req, _ := http.NewRequest("POST", "/admin/reservations-calendar", strings.NewReader(postedData.Encode()))
+	ctx := GetCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	session.Put(ctx, "block_map_2", map[string]int{"2050-04-20": restrictions[0].ID})
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(Repo.AdminPostReservationsCalendar)
+	handler.ServeHTTP(rr, req)
+
+	if msg := session.GetString(ctx, "error"); msg != "" {
+		t.Errorf("expected the changes to be saved but got the error %q", msg)
+	}
+
+	restrictions, _ = Repo.DB.GetRestrictionsForRoomByDate(context.Background(), 2, day, day)
+	if len(restrictions) != 0 {
+		t.Errorf("expected the unticked block to be removed but got %+v", restrictions)
+	}
+}
+
+func TestRepository_AdminPostReservationsCalendar_RemoveDay(t *testing.T) {
+	ctx := context.Background()
+	day := func(d int) time.Time {
+		return time.Date(2050, 6, d, 0, 0, 0, 0, time.UTC)
+	}
+
+	// a block of 3 days, and one that goes on into July
+	_ = Repo.DB.InsertBlockForRoomById(ctx, 2, 3, day(10), day(13))
+	_ = Repo.DB.InsertBlockForRoomById(ctx, 2, 4, day(29), day(33))
+
+	blockMap := make(map[string]int)
+	restrictions, _ := Repo.DB.GetRestrictionsForRoomByDate(ctx, 2, day(1), day(30))
+	for _, b := range restrictions {
+		for d := b.StartDate; d.Before(b.EndDate) && d.Month() == time.June; d = d.AddDate(0, 0, 1) {
+			blockMap[d.Format("2006-01-2")] = b.ID
+		}
+	}
+
+	if len(blockMap) != 5 {
+		t.Fatalf("expected 5 blocked days in June but got %v", blockMap)
+	}
+
+	// the 11th and the 30th are unchecked
+	postedData := url.Values{}
+	postedData.Add("y", "2050")
+	postedData.Add("m", "06")
+	for _, d := range []string{"2050-06-10", "2050-06-12", "2050-06-29"} {
+		postedData.Add(fmt.Sprintf("remove_block_2_%s", d), strconv.Itoa(blockMap[d]))
+	}
+
+	req, _ := http.NewRequest("POST", "/admin/reservations-calendar", strings.NewReader(postedData.Encode()))
+	reqCtx := GetCtx(req)
+	req = req.WithContext(reqCtx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	session.Put(reqCtx, "block_map_2", blockMap)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(Repo.AdminPostReservationsCalendar)
+	handler.ServeHTTP(rr, req)
+
+	if msg := session.GetString(reqCtx, "error"); msg != "" {
+		t.Fatalf("expected the changes to be saved but got the error %q", msg)
+	}
+
+	tests := []struct {
+		day         int
+		blocked     bool
+		restriction int
+	}{
+		{10, true, 3},
+		{11, false, 0},
+		{12, true, 3},
+		{29, true, 4},
+		{30, false, 0},
+		{31, true, 4}, // July 1st
+		{32, true, 4},
+	}
+
+	for _, e := range tests {
+		restrictions, _ := Repo.DB.GetRestrictionsForRoomByDate(ctx, 2, day(e.day), day(e.day))
+		if blocked := len(restrictions) > 0; blocked != e.blocked {
+			t.Errorf("expected %s to be blocked %v but it was %v", day(e.day).Format("2006-01-02"), e.blocked, blocked)
+			continue
+		}
+
+		if e.blocked && (len(restrictions) != 1 || restrictions[0].RestrictionID != e.restriction) {
+			t.Errorf("expected %s to stay blocked as type %d but got %+v", day(e.day).Format("2006-01-02"), e.restriction, restrictions)
+		}
+	}
+}
+
+func TestRepository_AdminPostNewBlock(t *testing.T) {
+	roomId, _ := Repo.DB.InsertRoom(context.Background(), models.Room{RoomName: "Commodore's Corner", Slug: "commodores-corner", Active: true, NightlyRate: 10000})
+
+	post := func(repeatUntil string) *httptest.ResponseRecorder {
+		postedData := url.Values{}
+		postedData.Add("restriction_id", "3")
+		postedData.Add("start_date", "2050-05-02")
+		postedData.Add("end_date", "2050-05-02")
+		postedData.Add("repeat_weeks", "1")
+		postedData.Add("repeat_until", repeatUntil)
+
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/admin/rooms/%d/blocks/new", roomId), strings.NewReader(postedData.Encode()))
+		ctx := GetCtx(req)
+
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", strconv.Itoa(roomId))
+		req = req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostNewBlock)
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// a block repeating forever is not valid, the form is shown again
+	rr := post("")
+	if rr.Code != http.StatusOK {
+		t.Errorf("AdminPostNewBlock handler returned %d for an invalid block, wanted %d", rr.Code, http.StatusOK)
+	}
+
+	// every Monday in May
+	rr = post("2050-05-31")
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("AdminPostNewBlock handler returned %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+
+	restrictions, _ := Repo.DB.GetRestrictionsForRoomByDate(context.Background(), roomId, time.Date(2050, 5, 1, 0, 0, 0, 0, time.UTC), time.Date(2050, 5, 31, 0, 0, 0, 0, time.UTC))
+	if len(restrictions) != 5 {
+		t.Errorf("expected a block on each of the 5 Mondays in May but got %+v", restrictions)
+	}
+
+	// the same block again clashes with the first one
+	rr = post("2050-05-31")
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "already reserved or blocked") {
+		t.Errorf("AdminPostNewBlock handler returned %d for a clashing block, wanted the form with an error", rr.Code)
+	}
+}
+
+func TestRepository_PostMyReservation(t *testing.T) {
+	id, err := Repo.DB.BookRoom(context.Background(), models.Reservation{
+		RoomID: 1, FirstName: "Jane", Email: "jane@example.com", StartDate: time.Date(2051, 2, 1, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2051, 2, 3, 0, 0, 0, 0, time.UTC),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, _ := Repo.DB.GetReservationById(context.Background(), id)
+
+	post := func(code, email string) *httptest.ResponseRecorder {
+		postedData := url.Values{}
+		postedData.Add("code", code)
+		postedData.Add("email", email)
+
+		req, _ := http.NewRequest("POST", "/my-reservation", strings.NewReader(postedData.Encode()))
+		req = req.WithContext(GetCtx(req))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.PostMyReservation)
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// the code is found however the guest types it
+	typed := strings.ToLower(res.ConfirmationCode[:5] + "-" + res.ConfirmationCode[5:])
+	rr := post(typed, "Jane@Example.com")
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "Departure:") {
+		t.Errorf("PostMyReservation handler returned %d without the reservation, wanted it shown", rr.Code)
+	}
+
+	// the email has to match too
+	rr = post(res.ConfirmationCode, "someone@example.com")
+	if rr.Code != http.StatusOK || strings.Contains(rr.Body.String(), "Departure:") || !strings.Contains(rr.Body.String(), "find a reservation with this confirmation code") {
+		t.Errorf("PostMyReservation handler returned %d for the wrong email, wanted the form with an error", rr.Code)
+	}
+}
+
+func TestRepository_ManageReservation(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2051, 3, d, 0, 0, 0, 0, time.UTC) }
+
+	id, err := Repo.DB.BookRoom(context.Background(), models.Reservation{RoomID: 1, FirstName: "Jane", Email: "jane@example.com", StartDate: day(1), EndDate: day(3)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Repo.DB.BookRoom(context.Background(), models.Reservation{RoomID: 1, FirstName: "John", Email: "john@example.com", StartDate: day(10), EndDate: day(12)}); err != nil {
+		t.Fatal(err)
+	}
+
+	res, _ := Repo.DB.GetReservationById(context.Background(), id)
+	token := strings.TrimPrefix(Repo.manageLink(res), fmt.Sprintf("/my-reservation/%d/", id))
+
+	serve := func(handler http.HandlerFunc, method, token string, postedData url.Values) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, "/my-reservation", strings.NewReader(postedData.Encode()))
+		ctx := GetCtx(req)
+
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", strconv.Itoa(id))
+		rctx.URLParams.Add("token", token)
+		req = req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// a link signed for another reservation is not found
+	other := strings.TrimPrefix(Repo.manageLink(models.Reservation{ID: id + 1, ConfirmationCode: res.ConfirmationCode}), fmt.Sprintf("/my-reservation/%d/", id+1))
+	if rr := serve(Repo.ManageReservation, "GET", other, nil); rr.Code != http.StatusNotFound {
+		t.Errorf("ManageReservation handler returned %d for a forged link, wanted %d", rr.Code, http.StatusNotFound)
+	}
+
+	if rr := serve(Repo.ManageReservation, "GET", token, nil); rr.Code != http.StatusOK {
+		t.Errorf("ManageReservation handler returned %d, wanted %d", rr.Code, http.StatusOK)
+	}
+
+	// the other reservation is in the way
+	rr := serve(Repo.PostChangeReservation, "POST", token, url.Values{"start_date": {"2051-03-09"}, "end_date": {"2051-03-11"}})
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "not available for these dates") {
+		t.Errorf("PostChangeReservation handler returned %d for taken dates, wanted the form with an error", rr.Code)
+	}
+
+	rr = serve(Repo.PostChangeReservation, "POST", token, url.Values{"start_date": {"2051-03-05"}, "end_date": {"2051-03-08"}})
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("PostChangeReservation handler returned %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+
+	res, _ = Repo.DB.GetReservationById(context.Background(), id)
+	if !res.StartDate.Equal(day(5)) || !res.EndDate.Equal(day(8)) || res.ChangedBy != "guest" {
+		t.Errorf("expected the reservation to be moved by the guest but got %+v", res)
+	}
+
+	rr = serve(Repo.PostCancelReservation, "POST", token, nil)
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("PostCancelReservation handler returned %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+
+	if res, _ = Repo.DB.GetReservationById(context.Background(), id); !res.Cancelled() {
+		t.Errorf("expected the reservation to be cancelled but got %+v", res)
+	}
+
+	if available, _ := Repo.DB.SearchAvailabilityByDatesForRoomId(context.Background(), day(5), day(8), 1); !available {
+		t.Error("expected the dates of the cancelled reservation to be free")
+	}
+}
+
 // func TestRepository_PostAvailability(t *testing.T) {
 // 	/*****************************************
 // 	// first case -- rooms are not available
@@ -648,17 +1518,48 @@ func TestRepository_PostReservation(t *testing.T) {
 // 	// make the request to our handler
 // 	handler.ServeHTTP(rr, req)
 
-// 	// this time we want to parse JSON and get the expected response
-// 	err = json.Unmarshal([]byte(rr.Body.String()), &j)
-// 	if err != nil {
-// 		t.Error("failed to parse json!")
-// 	}
+// 	// this time we want to parse JSON and get the expected response
+// 	err = json.Unmarshal([]byte(rr.Body.String()), &j)
+// 	if err != nil {
+// 		t.Error("failed to parse json!")
+// 	}
+
+// 	// since we specified a start date < 2049-12-31, we expect availability
+// 	if j.OK || j.Message != "Error querying database" {
+// 		t.Error("Got availability when simulating database error")
+// 	}
+// }
+
+func TestRepository_Reservation_Quote(t *testing.T) {
+	// Thursday to Sunday in the General's Quarters, $120 on Thursday and $150 on Friday and Saturday
+	reservation := models.Reservation{
+		RoomID:    1,
+		StartDate: time.Date(2050, 1, 6, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, 1, 9, 0, 0, 0, 0, time.UTC),
+	}
+
+	req, _ := http.NewRequest("GET", "/make-reservation", nil)
+	ctx := GetCtx(req)
+	req = req.WithContext(ctx)
+	session.Put(ctx, "reservation", reservation)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(Repo.Reservation)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Reservation handler returned wrong response code got %d wanted %d", rr.Code, http.StatusOK)
+	}
 
-// 	// since we specified a start date < 2049-12-31, we expect availability
-// 	if j.OK || j.Message != "Error querying database" {
-// 		t.Error("Got availability when simulating database error")
-// 	}
-// }
+	if !strings.Contains(rr.Body.String(), "$420.00") {
+		t.Error("expected the total of $420.00 to be shown")
+	}
+
+	res, _ := session.Get(ctx, "reservation").(models.Reservation)
+	if res.TotalPrice != 42000 {
+		t.Errorf("expected the quote of 42000 to be kept with the reservation but got %d", res.TotalPrice)
+	}
+}
 
 // func TestRepository_ReservationSummary(t *testing.T) {
 // 	/*****************************************
@@ -826,3 +1727,616 @@ func GetCtx(req *http.Request) context.Context {
 
 	return ctx
 }
+
+func TestRepository_AdminCancelReservation(t *testing.T) {
+	post := func(feePercent string) *httptest.ResponseRecorder {
+		postedData := url.Values{}
+		postedData.Add("name", "Strict")
+		postedData.Add("free_days", "7")
+		postedData.Add("fee_type", "percent")
+		postedData.Add("fee_percent", feePercent)
+
+		req, _ := http.NewRequest("POST", "/admin/cancellation-policies/new", strings.NewReader(postedData.Encode()))
+		ctx := GetCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostNewCancellationPolicy)
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// more than the whole stay is not a valid fee, the form is shown again
+	if rr := post("150"); rr.Code != http.StatusOK {
+		t.Errorf("AdminPostNewCancellationPolicy handler returned %d for an invalid policy, wanted %d", rr.Code, http.StatusOK)
+	}
+
+	if rr := post("50"); rr.Code != http.StatusSeeOther {
+		t.Errorf("AdminPostNewCancellationPolicy handler returned %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+
+	policies, _ := Repo.DB.AllCancellationPolicies(context.Background())
+	if len(policies) != 1 || policies[0].FeePercent != 50 {
+		t.Fatalf("expected the policy to be added but got %+v", policies)
+	}
+
+	roomId, _ := Repo.DB.InsertRoom(context.Background(), models.Room{RoomName: "Brigadier's Bunk", Slug: "brigadiers-bunk", Active: true, NightlyRate: 10000, CancellationPolicyID: policies[0].ID})
+
+	// arriving in three days is past the deadline of the policy
+	start := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 3)
+	end := start.AddDate(0, 0, 2)
+
+	terms, err := Repo.DB.GetCancellationTermsForStay(context.Background(), roomId, start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := Repo.DB.BookRoom(context.Background(), models.Reservation{RoomID: roomId, FirstName: "Jane", Email: "jane@example.com", StartDate: start, EndDate: end, Cancellation: terms})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/admin/cancel-reservation/all/%d/do", id), nil)
+	ctx := GetCtx(req)
+
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("src", "all")
+	rctx.URLParams.Add("id", strconv.Itoa(id))
+	req = req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx))
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(Repo.AdminCancelReservation)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != fmt.Sprintf("/admin/reservations/all/%d/show", id) {
+		t.Errorf("AdminCancelReservation handler returned %d %s, wanted %d back to the reservation", rr.Code, rr.Header().Get("Location"), http.StatusSeeOther)
+	}
+
+	res, _ := Repo.DB.GetReservationById(context.Background(), id)
+	if !res.Cancelled() || res.CancellationFee != 10000 {
+		t.Errorf("expected the reservation to be cancelled with half of the total charged but got %+v", res)
+	}
+}
+
+func TestRepository_AdminUpdateReservationStatus(t *testing.T) {
+	roomId, _ := Repo.DB.InsertRoom(context.Background(), models.Room{RoomName: "General's Garrison", Slug: "generals-garrison", Active: true, NightlyRate: 10000})
+
+	start := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 10)
+	id, err := Repo.DB.BookRoom(context.Background(), models.Reservation{RoomID: roomId, FirstName: "Jane", Email: "jane@example.com", StartDate: start, EndDate: start.AddDate(0, 0, 2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	update := func(to string) (*httptest.ResponseRecorder, context.Context) {
+		req, _ := http.NewRequest("GET", fmt.Sprintf("/admin/reservation-status/all/%d/%s/do", id, to), nil)
+		ctx := GetCtx(req)
+
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("src", "all")
+		rctx.URLParams.Add("id", strconv.Itoa(id))
+		rctx.URLParams.Add("status", to)
+		req = req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx))
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminUpdateReservationStatus)
+		handler.ServeHTTP(rr, req)
+		return rr, req.Context()
+	}
+
+	if rr, _ := update("archived"); rr.Code != http.StatusNotFound {
+		t.Errorf("AdminUpdateReservationStatus handler returned %d for an unknown status, wanted %d", rr.Code, http.StatusNotFound)
+	}
+
+	// a pending reservation has to be confirmed before the guest can check in
+	rr, ctx := update(status.CheckedIn)
+	if rr.Code != http.StatusSeeOther || Repo.App.Session.GetString(ctx, "error") == "" {
+		t.Errorf("AdminUpdateReservationStatus handler returned %d without an error for checking in a pending reservation", rr.Code)
+	}
+
+	rr, _ = update(status.Confirmed)
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != fmt.Sprintf("/admin/reservations/all/%d/show", id) {
+		t.Errorf("AdminUpdateReservationStatus handler returned %d %s, wanted %d back to the reservation", rr.Code, rr.Header().Get("Location"), http.StatusSeeOther)
+	}
+
+	if res, _ := Repo.DB.GetReservationById(context.Background(), id); res.Status != status.Confirmed || res.ConfirmedAt.IsZero() {
+		t.Errorf("expected the reservation to be confirmed but got %+v", res)
+	}
+
+	// the list can be filtered by status
+	req, _ := http.NewRequest("GET", "/admin/reservations-all?status=confirmed", nil)
+	req = req.WithContext(GetCtx(req))
+
+	rr = httptest.NewRecorder()
+	handler := http.HandlerFunc(Repo.AdminAllReservations)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), fmt.Sprintf("/admin/reservations/all/%d/show", id)) {
+		t.Errorf("AdminAllReservations handler returned %d without the confirmed reservation", rr.Code)
+	}
+
+	// a reservation in the trash is not found until it is restored
+	if err := Repo.DB.DeleteReservation(context.Background(), id, "admin@admin.com", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, to := range []string{status.CheckedIn, status.Cancelled} {
+		if rr, _ := update(to); rr.Code != http.StatusNotFound {
+			t.Errorf("AdminUpdateReservationStatus handler returned %d for a deleted reservation moving to %s, wanted %d", rr.Code, to, http.StatusNotFound)
+		}
+	}
+
+	deleted, _ := Repo.DB.DeletedReservations(context.Background())
+	for _, res := range deleted {
+		if res.ID == id && res.Status != status.Confirmed {
+			t.Errorf("expected the deleted reservation to stay confirmed but got %s", res.Status)
+		}
+	}
+}
+
+func TestRepository_AdminPostNewUser(t *testing.T) {
+	post := func(email, role string) *httptest.ResponseRecorder {
+		postedData := url.Values{}
+		postedData.Add("first_name", "Frank")
+		postedData.Add("last_name", "Front")
+		postedData.Add("email", email)
+		postedData.Add("role", role)
+
+		req, _ := http.NewRequest("POST", "/admin/users/new", strings.NewReader(postedData.Encode()))
+		ctx := GetCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostNewUser)
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// an unknown role is not valid, the form is shown again
+	if rr := post("frank@here.com", "janitor"); rr.Code != http.StatusOK {
+		t.Errorf("AdminPostNewUser handler returned %d for an unknown role, wanted %d", rr.Code, http.StatusOK)
+	}
+
+	if rr := post("frank@here.com", roles.FrontDesk); rr.Code != http.StatusSeeOther {
+		t.Errorf("AdminPostNewUser handler returned %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+
+	// the email is taken now, the form is shown again
+	if rr := post("FRANK@here.com", roles.Manager); rr.Code != http.StatusOK {
+		t.Errorf("AdminPostNewUser handler returned %d for a taken email, wanted %d", rr.Code, http.StatusOK)
+	}
+
+	var frank models.User
+	users, _ := Repo.DB.AllUsers(context.Background())
+	for _, u := range users {
+		if u.Email == "frank@here.com" {
+			frank = u
+		}
+	}
+
+	if frank.ID == 0 || frank.Role != roles.FrontDesk || frank.Password != "" {
+		t.Fatalf("expected an invited front desk user but got %+v", frank)
+	}
+
+	link := Repo.inviteLink(frank)
+	accept := func(password, confirm string) *httptest.ResponseRecorder {
+		postedData := url.Values{}
+		postedData.Add("password", password)
+		postedData.Add("password_confirm", confirm)
+
+		req, _ := http.NewRequest("POST", link, strings.NewReader(postedData.Encode()))
+		ctx := GetCtx(req)
+
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", strconv.Itoa(frank.ID))
+		rctx.URLParams.Add("token", strings.TrimPrefix(link, fmt.Sprintf("/user/invite/%d/", frank.ID)))
+		req = req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.PostAcceptInvite)
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := accept("hunter2hunter2", "hunter3hunter3"); rr.Code != http.StatusOK {
+		t.Errorf("PostAcceptInvite handler returned %d for passwords that don't match, wanted %d", rr.Code, http.StatusOK)
+	}
+
+	if rr := accept("hunter2hunter2", "hunter2hunter2"); rr.Code != http.StatusSeeOther {
+		t.Errorf("PostAcceptInvite handler returned %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+
+	if _, _, err := Repo.DB.Authenticate(context.Background(), "frank@here.com", "hunter2hunter2"); err != nil {
+		t.Errorf("expected the invited user to log in with the chosen password but got %v", err)
+	}
+
+	// the link stops working once the password is set
+	if rr := accept("takeover1234", "takeover1234"); rr.Code != http.StatusNotFound {
+		t.Errorf("PostAcceptInvite handler returned %d for a used invite, wanted %d", rr.Code, http.StatusNotFound)
+	}
+}
+
+func TestRepository_AdminPostUser_LastOwner(t *testing.T) {
+	postedData := url.Values{}
+	postedData.Add("first_name", "Admin")
+	postedData.Add("last_name", "User")
+	postedData.Add("email", "admin@admin.com")
+	postedData.Add("role", roles.Manager)
+
+	req, _ := http.NewRequest("POST", "/admin/users/1", strings.NewReader(postedData.Encode()))
+	ctx := GetCtx(req)
+
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", "1")
+	req = req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(Repo.AdminPostUser)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("AdminPostUser handler returned %d when demoting the only owner, wanted %d", rr.Code, http.StatusOK)
+	}
+
+	u, _ := Repo.DB.GetUserById(context.Background(), 1)
+	if u.Role != roles.Owner {
+		t.Errorf("expected the only owner to stay an owner but got %s", u.Role)
+	}
+}
+
+func TestRepository_AdminDisableUser(t *testing.T) {
+	disable := func(id, self int) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/admin/users/%d/disable", id), nil)
+		ctx := GetCtx(req)
+		session.Put(ctx, "user_id", self)
+
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", strconv.Itoa(id))
+		req = req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx))
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminDisableUser)
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// users can't lock themselves out, and the only owner can't be disabled by anyone
+	for _, self := range []int{1, 0} {
+		if rr := disable(1, self); rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/admin/users/1" {
+			t.Errorf("AdminDisableUser handler returned %d %s, wanted %d back to the user", rr.Code, rr.Header().Get("Location"), http.StatusSeeOther)
+		}
+	}
+
+	if u, _ := Repo.DB.GetUserById(context.Background(), 1); u.Disabled() {
+		t.Fatal("expected the only owner to stay enabled")
+	}
+
+	id, err := Repo.DB.InsertUser(context.Background(), models.User{FirstName: "Rita", LastName: "Reader", Email: "rita@here.com", Role: roles.ReadOnly})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rr := disable(id, 1); rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/admin/users" {
+		t.Errorf("AdminDisableUser handler returned %d %s, wanted %d to the users", rr.Code, rr.Header().Get("Location"), http.StatusSeeOther)
+	}
+
+	if u, _ := Repo.DB.GetUserById(context.Background(), id); !u.Disabled() {
+		t.Error("expected the user to be disabled")
+	}
+}
+
+func TestRepository_PasswordReset(t *testing.T) {
+	ctx := context.Background()
+	id, err := Repo.DB.InsertUser(ctx, models.User{FirstName: "Paula", LastName: "Reset", Email: "paula@here.com", Role: roles.Manager})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Repo.DB.UpdateUserPassword(ctx, id, "forgotten1"); err != nil {
+		t.Fatal(err)
+	}
+
+	before, _ := Repo.DB.GetUserById(ctx, id)
+
+	// catch the emails of this test instead of letting listenForMail drop them
+	mail := make(chan models.MailData, 1)
+	defer func(c chan models.MailData) { app.MailChan = c }(app.MailChan)
+	app.MailChan = mail
+
+	forgot := func(email string) *httptest.ResponseRecorder {
+		postedData := url.Values{}
+		postedData.Add("email", email)
+
+		req, _ := http.NewRequest("POST", "/user/forgot-password", strings.NewReader(postedData.Encode()))
+		ctx := GetCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.PostForgotPassword)
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// unknown emails get the same answer but no email
+	for _, email := range []string{"nobody@here.com", "PAULA@here.com"} {
+		if rr := forgot(email); rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/user/login" {
+			t.Errorf("PostForgotPassword handler returned %d %s for %s, wanted %d to the login", rr.Code, rr.Header().Get("Location"), email, http.StatusSeeOther)
+		}
+	}
+
+	if len(mail) != 1 {
+		t.Fatalf("expected one email but got %d", len(mail))
+	}
+
+	sent := <-mail
+	link := regexp.MustCompile(`/user/reset-password/([\w-]+)`).FindStringSubmatch(sent.Content)
+	if sent.To != "paula@here.com" || link == nil {
+		t.Fatalf("expected a link to reset the password sent to the user but got %+v", sent)
+	}
+
+	reset := func(method, token, password, confirm string) *httptest.ResponseRecorder {
+		postedData := url.Values{}
+		postedData.Add("password", password)
+		postedData.Add("password_confirm", confirm)
+
+		req, _ := http.NewRequest(method, "/user/reset-password/"+token, strings.NewReader(postedData.Encode()))
+		ctx := GetCtx(req)
+
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("token", token)
+		req = req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.ResetPassword)
+		if method == "POST" {
+			handler = Repo.PostResetPassword
+		}
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := reset("GET", "not-a-token", "", ""); rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/user/forgot-password" {
+		t.Errorf("ResetPassword handler returned %d %s for an unknown token, wanted %d to ask again", rr.Code, rr.Header().Get("Location"), http.StatusSeeOther)
+	}
+
+	if rr := reset("GET", link[1], "", ""); rr.Code != http.StatusOK {
+		t.Errorf("ResetPassword handler returned %d, wanted %d", rr.Code, http.StatusOK)
+	}
+
+	if rr := reset("POST", link[1], "remembered1", "remembered2"); rr.Code != http.StatusOK {
+		t.Errorf("PostResetPassword handler returned %d for passwords that don't match, wanted %d", rr.Code, http.StatusOK)
+	}
+
+	if rr := reset("POST", link[1], "remembered1", "remembered1"); rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/user/login" {
+		t.Errorf("PostResetPassword handler returned %d %s, wanted %d to the login", rr.Code, rr.Header().Get("Location"), http.StatusSeeOther)
+	}
+
+	if _, _, err := Repo.DB.Authenticate(ctx, "paula@here.com", "remembered1"); err != nil {
+		t.Errorf("expected the user to log in with the new password but got %v", err)
+	}
+
+	if after, _ := Repo.DB.GetUserById(ctx, id); after.SessionVersion == before.SessionVersion {
+		t.Error("expected the other sessions of the user to be logged out")
+	}
+
+	// the link works only once
+	if rr := reset("POST", link[1], "takeover12", "takeover12"); rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/user/forgot-password" {
+		t.Errorf("PostResetPassword handler returned %d %s for a used token, wanted %d to ask again", rr.Code, rr.Header().Get("Location"), http.StatusSeeOther)
+	}
+}
+
+func TestRepository_TwoFactorLogin(t *testing.T) {
+	ctx := context.Background()
+	id, err := Repo.DB.InsertUser(ctx, models.User{FirstName: "Tess", LastName: "Factor", Email: "tess@here.com", Role: roles.Manager})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = Repo.DB.UpdateUserPassword(ctx, id, "password1")
+	secret, _ := twofactor.NewSecret()
+	_ = Repo.DB.SetUserTOTPSecret(ctx, id, secret)
+
+	// post sends the form to handler with the session of ctx, so the steps of a login share it
+	post := func(ctx context.Context, handler http.HandlerFunc, path string, postedData url.Values) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", path, strings.NewReader(postedData.Encode()))
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	login := func(email string) context.Context {
+		req, _ := http.NewRequest("POST", "/user/login", nil)
+		ctx := GetCtx(req)
+
+		rr := post(ctx, Repo.PostShowLogin, "/user/login", url.Values{"email": {email}, "password": {"password1"}})
+		if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/user/login/two-factor" {
+			t.Fatalf("PostShowLogin handler returned %d %s, wanted %d to the second step", rr.Code, rr.Header().Get("Location"), http.StatusSeeOther)
+		}
+
+		if session.Exists(ctx, "user_id") {
+			t.Fatal("expected the user not to be logged in before entering a code")
+		}
+
+		return ctx
+	}
+
+	enter := func(ctx context.Context, code string) *httptest.ResponseRecorder {
+		return post(ctx, Repo.PostTwoFactorLogin, "/user/login/two-factor", url.Values{"code": {code}})
+	}
+
+	code, _ := twofactor.Code(secret, twofactor.Step(time.Now()))
+
+	ctx = login("tess@here.com")
+	if rr := enter(ctx, "000000"); rr.Code != http.StatusOK || session.Exists(ctx, "user_id") {
+		t.Errorf("PostTwoFactorLogin handler returned %d for a wrong code, wanted %d without logging in", rr.Code, http.StatusOK)
+	}
+
+	if rr := enter(ctx, code); rr.Code != http.StatusSeeOther || session.GetInt(ctx, "user_id") != id {
+		t.Errorf("PostTwoFactorLogin handler returned %d and user %d, wanted %d and the user logged in", rr.Code, session.GetInt(ctx, "user_id"), http.StatusSeeOther)
+	}
+
+	// a code works once, and five wrong ones send the user back to their password
+	ctx = login("tess@here.com")
+	for i := 0; i < 5; i++ {
+		if rr := enter(ctx, code); rr.Code != http.StatusOK {
+			t.Errorf("PostTwoFactorLogin handler returned %d for a used code, wanted %d", rr.Code, http.StatusOK)
+		}
+	}
+
+	if rr := enter(ctx, code); rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/user/login" {
+		t.Errorf("PostTwoFactorLogin handler returned %d %s after too many codes, wanted %d to the login", rr.Code, rr.Header().Get("Location"), http.StatusSeeOther)
+	}
+
+	codes, hashes, _ := twofactor.NewRecoveryCodes()
+	_ = Repo.DB.ReplaceRecoveryCodes(ctx, id, hashes)
+
+	ctx = login("tess@here.com")
+	if rr := enter(ctx, strings.ToLower(codes[0])); rr.Code != http.StatusSeeOther || session.GetInt(ctx, "user_id") != id {
+		t.Errorf("PostTwoFactorLogin handler returned %d for a recovery code, wanted %d and the user logged in", rr.Code, http.StatusSeeOther)
+	}
+
+	if left, _ := Repo.DB.UnusedRecoveryCodes(ctx, id); len(left) != len(codes)-1 {
+		t.Errorf("expected the recovery code to be used up but %d are left", len(left))
+	}
+}
+
+func TestRepository_TwoFactorLogin_Required(t *testing.T) {
+	ctx := context.Background()
+	id, err := Repo.DB.InsertUser(ctx, models.User{FirstName: "Finn", LastName: "Front", Email: "finn@here.com", Role: roles.FrontDesk})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = Repo.DB.UpdateUserPassword(ctx, id, "password1")
+
+	defer func() { app.TwoFactorRoles = nil }()
+	app.TwoFactorRoles = []string{roles.FrontDesk}
+
+	req, _ := http.NewRequest("POST", "/user/login", strings.NewReader(url.Values{"email": {"finn@here.com"}, "password": {"password1"}}.Encode()))
+	ctx = GetCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.PostShowLogin).ServeHTTP(rr, req)
+
+	if rr.Header().Get("Location") != "/user/login/two-factor" || session.Exists(ctx, "user_id") {
+		t.Fatalf("expected a user whose role requires two-factor authentication to set it up before logging in, got %s", rr.Header().Get("Location"))
+	}
+
+	req, _ = http.NewRequest("GET", "/user/login/two-factor", nil)
+	req = req.WithContext(ctx)
+
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(Repo.TwoFactorLogin).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "data:image/png;base64,") {
+		t.Fatalf("TwoFactorLogin handler returned %d, wanted %d with the QR code", rr.Code, http.StatusOK)
+	}
+
+	code, _ := twofactor.Code(session.GetString(ctx, "twofactor_secret"), twofactor.Step(time.Now()))
+
+	req, _ = http.NewRequest("POST", "/user/login/two-factor", strings.NewReader(url.Values{"code": {code}}.Encode()))
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(Repo.PostTwoFactorLogin).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "Save your recovery codes") || session.GetInt(ctx, "user_id") != id {
+		t.Errorf("PostTwoFactorLogin handler returned %d, wanted %d with the recovery codes and the user logged in", rr.Code, http.StatusOK)
+	}
+
+	if u, _ := Repo.DB.GetUserById(ctx, id); !u.TwoFactor() {
+		t.Error("expected two-factor authentication to be on")
+	}
+
+	if codes, _ := Repo.DB.UnusedRecoveryCodes(ctx, id); len(codes) != twofactor.RecoveryCodes {
+		t.Errorf("expected %d recovery codes but got %d", twofactor.RecoveryCodes, len(codes))
+	}
+}
+
+func TestRepository_LoginLockout(t *testing.T) {
+	ctx := context.Background()
+	id, err := Repo.DB.InsertUser(ctx, models.User{FirstName: "Lou", LastName: "Locke", Email: "lou@here.com", Role: roles.FrontDesk})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = Repo.DB.UpdateUserPassword(ctx, id, "password1")
+
+	// two free failures, the third locks the account out
+	defer func(l *lockout.Limiter) { app.Lockout = l }(app.Lockout)
+	app.Lockout = lockout.New(lockout.NewMemoryStore())
+	app.Lockout.Account = lockout.Policy{Free: 2, Delay: time.Minute, MaxDelay: time.Minute, MaxFailures: 3, Lockout: time.Hour}
+
+	// catch the emails of this test instead of letting listenForMail drop them
+	mail := make(chan models.MailData, 1)
+	defer func(c chan models.MailData) { app.MailChan = c }(app.MailChan)
+	app.MailChan = mail
+
+	login := func(email, password string) (*httptest.ResponseRecorder, context.Context) {
+		postedData := url.Values{}
+		postedData.Add("email", email)
+		postedData.Add("password", password)
+
+		req, _ := http.NewRequest("POST", "/user/login", strings.NewReader(postedData.Encode()))
+		ctx := GetCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.PostShowLogin)
+		handler.ServeHTTP(rr, req)
+		return rr, ctx
+	}
+
+	for i := 0; i < 3; i++ {
+		if rr, ctx := login("lou@here.com", "guess"); rr.Code != http.StatusSeeOther || session.GetString(ctx, "error") != "invalid login credentials" {
+			t.Errorf("PostShowLogin handler returned %d for a wrong password, wanted %d with an error", rr.Code, http.StatusSeeOther)
+		}
+	}
+
+	if len(mail) != 1 {
+		t.Fatalf("expected an email about the lockout but got %d", len(mail))
+	}
+
+	if sent := <-mail; sent.To != "lou@here.com" || !strings.Contains(sent.Content, "/user/forgot-password") {
+		t.Errorf("expected the user to be told about the lockout but got %+v", sent)
+	}
+
+	// the right password doesn't help while the account is locked out, however the email is written
+	rr, lockedCtx := login("LOU@here.com", "password1")
+	if rr.Code != http.StatusSeeOther || session.Exists(lockedCtx, "user_id") || !strings.HasPrefix(session.GetString(lockedCtx, "error"), "Too many failed logins") {
+		t.Errorf("PostShowLogin handler returned %d for a locked out account, wanted %d without logging in", rr.Code, http.StatusSeeOther)
+	}
+
+	// an administrator unlocks the account
+	req, _ := http.NewRequest("POST", fmt.Sprintf("/admin/users/%d/unlock", id), nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", strconv.Itoa(id))
+	req = req.WithContext(context.WithValue(GetCtx(req), chi.RouteCtxKey, rctx))
+
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(Repo.AdminUnlockUser).ServeHTTP(rr, req)
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("AdminUnlockUser handler returned %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+
+	entries, _ := Repo.DB.AuditEntries(ctx, models.AuditFilter{Entity: audit.User, EntityID: id})
+	if len(entries) == 0 || entries[0].Changes[0].Field != "Lockout" {
+		t.Errorf("expected the unlock in the audit log but got %+v", entries)
+	}
+
+	rr, ctx = login("lou@here.com", "password1")
+	if rr.Code != http.StatusSeeOther || session.GetInt(ctx, "user_id") != id {
+		t.Errorf("PostShowLogin handler returned %d after the unlock, wanted %d and the user logged in", rr.Code, http.StatusSeeOther)
+	}
+}
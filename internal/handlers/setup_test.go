@@ -14,10 +14,16 @@ import (
 	"github.com/alexedwards/scs/v2"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
+	"github.com/hd719/go-bookings/internal/cancellation"
 	"github.com/hd719/go-bookings/internal/config"
+	"github.com/hd719/go-bookings/internal/helpers"
+	"github.com/hd719/go-bookings/internal/lockout"
 	"github.com/hd719/go-bookings/internal/models"
+	"github.com/hd719/go-bookings/internal/pricing"
 	"github.com/hd719/go-bookings/internal/render"
 	"github.com/hd719/go-bookings/internal/repository/dbrepo"
+	"github.com/hd719/go-bookings/internal/roles"
+	"github.com/hd719/go-bookings/internal/status"
 	"github.com/justinas/nosurf"
 )
 
@@ -27,10 +33,14 @@ var pathToTemplates = "../../templates"
 var infoLog *log.Logger
 var errorLog *log.Logger
 var functions = template.FuncMap{
-	"humanDate":  render.HumanDate,
-	"formatDate": render.FormatDate,
-	"iterate":    render.Iterate,
-	"add":        render.Add,
+	"humanDate":   render.HumanDate,
+	"formatDate":  render.FormatDate,
+	"iterate":     render.Iterate,
+	"add":         render.Add,
+	"formatMoney": pricing.Format,
+	"deadline":    cancellation.Deadline,
+	"statusLabel": status.Label,
+	"roleLabel":   roles.Label,
 }
 
 func TestMain(m *testing.M) {
@@ -62,6 +72,11 @@ func TestMain(m *testing.M) {
 
 	mailChan := make(chan models.MailData)
 	app.MailChan = mailChan
+	app.BaseURL = "http://localhost:8081"
+	app.LinkSecret = []byte("test-secret")
+	app.Retention = 30 * 24 * time.Hour
+	// failed logins are counted but never make a test wait, TestRepository_LoginLockout turns the limits on
+	app.Lockout = &lockout.Limiter{Store: lockout.NewMemoryStore()}
 	defer close(mailChan)
 
 	listenForMail()
@@ -81,14 +96,17 @@ func TestMain(m *testing.M) {
 	NewTestingRepo(&app)
 
 	render.NewRenderer(&app)
+	helpers.NewHelpers(&app)
 
 	os.Exit(m.Run())
 }
 
+// listenForMail drops the emails sent on the channel the app starts with, a test can swap in its own to read them
 func listenForMail() {
+	mailChan := app.MailChan
 	go func() {
 		for {
-			_ = <-app.MailChan
+			_ = <-mailChan
 		}
 	}()
 }
@@ -102,8 +120,12 @@ func GetRoutes() http.Handler {
 
 	mux.Get("/", Repo.Home)
 	mux.Get("/about", Repo.About)
-	mux.Get("/generals-quarters", Repo.Generals)
-	mux.Get("/majors-suite", Repo.Majors)
+	mux.Get("/rooms", Repo.Rooms)
+	mux.Get("/rooms/{slug}", Repo.Room)
+
+	// Room pages used to be hard-coded, keep their old links working
+	mux.Handle("/generals-quarters", http.RedirectHandler("/rooms/generals-quarters", http.StatusMovedPermanently))
+	mux.Handle("/majors-suite", http.RedirectHandler("/rooms/majors-suite", http.StatusMovedPermanently))
 
 	mux.Get("/search-availability", Repo.Availability)
 	mux.Post("/search-availability", Repo.PostAvailability)
@@ -114,6 +136,11 @@ func GetRoutes() http.Handler {
 	mux.Get("/make-reservation", Repo.Reservation)
 	mux.Post("/make-reservation", Repo.PostReservation)
 	mux.Get("/reservation-summary", Repo.ReservationSummary)
+	mux.Get("/my-reservation", Repo.MyReservation)
+	mux.Post("/my-reservation", Repo.PostMyReservation)
+	mux.Get("/my-reservation/{id}/{token}", Repo.ManageReservation)
+	mux.Post("/my-reservation/{id}/{token}/change", Repo.PostChangeReservation)
+	mux.Post("/my-reservation/{id}/{token}/cancel", Repo.PostCancelReservation)
 
 	fileServer := http.FileServer(http.Dir("./static/"))
 	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
@@ -149,7 +176,7 @@ func CreateTestTemplateCache() (map[string]*template.Template, error) {
 	for _, page := range pages {
 		name := filepath.Base(page)
 
-		ts, err := template.New(name).ParseFiles(page)
+		ts, err := template.New(name).Funcs(functions).ParseFiles(page)
 		if err != nil {
 			return myCache, err
 		}
@@ -175,7 +202,7 @@ func CreateTestTemplateCache() (map[string]*template.Template, error) {
 func NewTestingRepo(a *config.AppConfig) {
 	config := &Repository{
 		App: a,
-		DB:  dbrepo.NewTestRepo(a),
+		DB:  dbrepo.NewMemoryRepo(a),
 	}
 
 	Repo = config
@@ -3,7 +3,9 @@ package helpers
 import (
 	"fmt"
 	"net/http"
+	"regexp"
 	"runtime/debug"
+	"strings"
 
 	"github.com/hd719/go-bookings/internal/config"
 )
@@ -30,3 +32,12 @@ func IsAuthenticated(r *http.Request) bool {
 	exists := app.Session.Exists(r.Context(), "user_id")
 	return exists
 }
+
+var nonSlugChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// Slugify turns a name into something that can be used in a url, "General's Quarters" becomes "generals-quarters"
+func Slugify(s string) string {
+	s = strings.ToLower(strings.ReplaceAll(s, "'", ""))
+	s = nonSlugChars.ReplaceAllString(s, "-")
+	return strings.Trim(s, "-")
+}
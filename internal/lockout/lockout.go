@@ -0,0 +1,195 @@
+// Package lockout slows down guessing passwords. Failed logins are counted per account and per IP address: past a few
+// mistakes every failure makes the next attempt wait twice as long, and after too many in a row logins are refused
+// for a while
+package lockout
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Attempts are the failed logins in a row counted for a key
+type Attempts struct {
+	Failures int
+	Last     time.Time // when the last failure was
+}
+
+// Store keeps the failed logins, in memory or in the database so they survive restarts and are shared between
+// instances of the app
+type Store interface {
+	// Get returns the attempts counted for key, none if there are none
+	Get(ctx context.Context, key string) (Attempts, error)
+	// Fail counts a failure at now for key and returns its attempts, a count last added to before since starts over
+	Fail(ctx context.Context, key string, now, since time.Time) (Attempts, error)
+	// Reset forgets the attempts counted for key
+	Reset(ctx context.Context, key string) error
+	// Prune forgets the keys without a failure since, so the store doesn't keep growing
+	Prune(ctx context.Context, since time.Time) error
+}
+
+// Policy is how failed logins for one kind of key are slowed down
+type Policy struct {
+	Free        int           // failures that don't slow down the next attempt, everyone mistypes a password now and then
+	Delay       time.Duration // wait after the first failure past the free ones, doubled with every further one
+	MaxDelay    time.Duration // longest wait before the key is locked out
+	MaxFailures int           // failures in a row after which logins are refused for Lockout, 0 never locks out
+	Lockout     time.Duration // how long a lockout lasts, failures older than this are forgotten
+}
+
+// DefaultAccount is the policy for the failed logins to one account
+var DefaultAccount = Policy{Free: 3, Delay: time.Second, MaxDelay: time.Minute, MaxFailures: 10, Lockout: 15 * time.Minute}
+
+// DefaultIP is the policy for the failed logins from one IP address. It is more lenient than the one for accounts,
+// an office or a hotel puts a lot of people behind one address
+var DefaultIP = Policy{Free: 10, Delay: time.Second, MaxDelay: time.Minute, MaxFailures: 100, Lockout: 15 * time.Minute}
+
+// Locked reports whether a is enough failures to be locked out at now
+func (p Policy) Locked(a Attempts, now time.Time) bool {
+	return p.MaxFailures > 0 && a.Failures >= p.MaxFailures && now.Sub(a.Last) < p.Lockout
+}
+
+// Wait returns how long after now the next attempt has to wait, 0 if it can be made right away
+func (p Policy) Wait(a Attempts, now time.Time) time.Duration {
+	if p.Locked(a, now) {
+		return p.Lockout - now.Sub(a.Last)
+	}
+
+	if a.Failures <= p.Free || now.Sub(a.Last) >= p.Lockout {
+		return 0
+	}
+
+	wait := p.Delay
+	for i := p.Free + 1; i < a.Failures && wait < p.MaxDelay; i++ {
+		wait *= 2
+	}
+
+	return max(min(wait, p.MaxDelay)-now.Sub(a.Last), 0)
+}
+
+// Limiter counts the failed logins to accounts and from IP addresses
+type Limiter struct {
+	Store   Store
+	Account Policy
+	IP      Policy
+}
+
+// New returns a limiter that keeps its counts in store, with the default policies
+func New(store Store) *Limiter {
+	return &Limiter{Store: store, Account: DefaultAccount, IP: DefaultIP}
+}
+
+// accountKey is the key failed logins to the account with email are counted under
+func accountKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// ipKey is the key failed logins from ip are counted under
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Wait returns how long a login to the account with email from ip has to wait, 0 if it can be tried now
+func (l *Limiter) Wait(ctx context.Context, email, ip string, now time.Time) (time.Duration, error) {
+	account, err := l.Store.Get(ctx, accountKey(email))
+	if err != nil {
+		return 0, err
+	}
+
+	addr, err := l.Store.Get(ctx, ipKey(ip))
+	if err != nil {
+		return 0, err
+	}
+
+	return max(l.Account.Wait(account, now), l.IP.Wait(addr, now)), nil
+}
+
+// Fail counts a failed login to the account with email from ip, locked is true if this failure locked the account out
+func (l *Limiter) Fail(ctx context.Context, email, ip string, now time.Time) (locked bool, err error) {
+	account, err := l.Store.Fail(ctx, accountKey(email), now, now.Add(-l.Account.Lockout))
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := l.Store.Fail(ctx, ipKey(ip), now, now.Add(-l.IP.Lockout)); err != nil {
+		return false, err
+	}
+
+	return l.Account.MaxFailures > 0 && account.Failures == l.Account.MaxFailures, nil
+}
+
+// LockedUntil returns when the lockout of the account with email ends, the zero time if it isn't locked out
+func (l *Limiter) LockedUntil(ctx context.Context, email string, now time.Time) (time.Time, error) {
+	a, err := l.Store.Get(ctx, accountKey(email))
+	if err != nil || !l.Account.Locked(a, now) {
+		return time.Time{}, err
+	}
+
+	return a.Last.Add(l.Account.Lockout), nil
+}
+
+// Unlock forgets the failed logins to the account with email, after it logged in or an administrator unlocked it
+func (l *Limiter) Unlock(ctx context.Context, email string) error {
+	return l.Store.Reset(ctx, accountKey(email))
+}
+
+// Prune forgets the failed logins that no longer count at now
+func (l *Limiter) Prune(ctx context.Context, now time.Time) error {
+	return l.Store.Prune(ctx, now.Add(-max(l.Account.Lockout, l.IP.Lockout)))
+}
+
+// memoryStore keeps the failed logins in memory, they are forgotten when the app restarts
+type memoryStore struct {
+	mu       sync.Mutex
+	attempts map[string]Attempts
+}
+
+// NewMemoryStore returns a store that keeps the failed logins in memory
+func NewMemoryStore() Store {
+	return &memoryStore{attempts: map[string]Attempts{}}
+}
+
+func (s *memoryStore) Get(ctx context.Context, key string) (Attempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.attempts[key], nil
+}
+
+func (s *memoryStore) Fail(ctx context.Context, key string, now, since time.Time) (Attempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a := s.attempts[key]
+	if a.Last.Before(since) {
+		a.Failures = 0
+	}
+
+	a.Failures++
+	a.Last = now
+	s.attempts[key] = a
+
+	return a, nil
+}
+
+func (s *memoryStore) Reset(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.attempts, key)
+	return nil
+}
+
+func (s *memoryStore) Prune(ctx context.Context, since time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, a := range s.attempts {
+		if a.Last.Before(since) {
+			delete(s.attempts, key)
+		}
+	}
+
+	return nil
+}
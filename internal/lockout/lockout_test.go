@@ -0,0 +1,150 @@
+package lockout
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPolicy_Wait(t *testing.T) {
+	p := Policy{Free: 3, Delay: time.Second, MaxDelay: time.Minute, MaxFailures: 10, Lockout: 15 * time.Minute}
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		failures int
+		ago      time.Duration
+		expected time.Duration
+	}{
+		{"none", 0, 0, 0},
+		{"free", 3, 0, 0},
+		{"first delay", 4, 0, time.Second},
+		{"doubled", 6, 0, 4 * time.Second},
+		{"partly waited", 6, time.Second, 3 * time.Second},
+		{"waited", 6, 5 * time.Second, 0},
+		{"last before the lockout", 9, 0, 32 * time.Second},
+		{"locked", 10, 0, 15 * time.Minute},
+		{"locked a while", 12, 5 * time.Minute, 10 * time.Minute},
+		{"lockout over", 10, 15 * time.Minute, 0},
+	}
+
+	for _, e := range tests {
+		got := p.Wait(Attempts{Failures: e.failures, Last: now.Add(-e.ago)}, now)
+		if got != e.expected {
+			t.Errorf("%s: expected to wait %s but got %s", e.name, e.expected, got)
+		}
+	}
+
+	p.MaxDelay = 10 * time.Second
+	if got := p.Wait(Attempts{Failures: 9, Last: now}, now); got != 10*time.Second {
+		t.Errorf("expected the wait to be capped but got %s", got)
+	}
+
+	// more free failures than it takes to be locked out still locks out
+	p.Free = 20
+	if got := p.Wait(Attempts{Failures: 10, Last: now}, now); got != 15*time.Minute {
+		t.Errorf("expected a lockout past the free failures but got %s", got)
+	}
+
+	p.MaxFailures = 0
+	if p.Locked(Attempts{Failures: 50, Last: now}, now) {
+		t.Error("expected no lockout without a maximum")
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	now := time.Now()
+
+	a, err := s.Get(ctx, "k")
+	if err != nil || a.Failures != 0 {
+		t.Fatalf("expected no attempts for an unknown key but got %v, %v", a, err)
+	}
+
+	s.Fail(ctx, "k", now.Add(-time.Minute), now.Add(-time.Hour))
+	a, _ = s.Fail(ctx, "k", now, now.Add(-time.Hour))
+	if a.Failures != 2 || !a.Last.Equal(now) {
+		t.Errorf("expected 2 failures, the last now, but got %v", a)
+	}
+
+	a, _ = s.Fail(ctx, "k", now.Add(time.Hour), now.Add(time.Second))
+	if a.Failures != 1 {
+		t.Errorf("expected the count to start over after a while but got %d failures", a.Failures)
+	}
+
+	s.Fail(ctx, "old", now.Add(-time.Hour), now.Add(-2*time.Hour))
+	if err := s.Prune(ctx, now); err != nil {
+		t.Fatal(err)
+	}
+
+	if a, _ = s.Get(ctx, "old"); a.Failures != 0 {
+		t.Error("expected old attempts to be pruned")
+	}
+
+	if a, _ = s.Get(ctx, "k"); a.Failures != 1 {
+		t.Error("expected recent attempts to be kept")
+	}
+
+	s.Reset(ctx, "k")
+	if a, _ = s.Get(ctx, "k"); a.Failures != 0 {
+		t.Error("expected no attempts after a reset")
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	ctx := context.Background()
+	l := New(NewMemoryStore())
+	l.Account = Policy{Free: 1, Delay: time.Second, MaxDelay: time.Minute, MaxFailures: 3, Lockout: time.Hour}
+	l.IP = Policy{Free: 10, Delay: time.Second, MaxDelay: time.Minute, MaxFailures: 0, Lockout: time.Hour}
+	now := time.Now()
+
+	for i := 1; i <= 3; i++ {
+		locked, err := l.Fail(ctx, "Admin@Admin.com ", "10.0.0.1", now)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if locked != (i == 3) {
+			t.Errorf("failure %d: expected locked to be %t", i, i == 3)
+		}
+	}
+
+	// the account is locked out, from anywhere and however the email is written
+	wait, _ := l.Wait(ctx, "admin@admin.com", "10.0.0.2", now)
+	if wait != time.Hour {
+		t.Errorf("expected to wait for the lockout but got %s", wait)
+	}
+
+	until, _ := l.LockedUntil(ctx, "admin@admin.com", now)
+	if !until.Equal(now.Add(time.Hour)) {
+		t.Errorf("expected the lockout to end in an hour but got %s", until)
+	}
+
+	// another failure doesn't email the user again
+	if locked, _ := l.Fail(ctx, "admin@admin.com", "10.0.0.1", now); locked {
+		t.Error("expected only the failure that locks the account out to report it")
+	}
+
+	// the address is slowed down for other accounts too once it is past its free failures
+	for i := 0; i < 7; i++ {
+		l.Fail(ctx, "other@here.com", "10.0.0.1", now)
+	}
+
+	wait, _ = l.Wait(ctx, "someone@here.com", "10.0.0.1", now)
+	if wait != time.Second {
+		t.Errorf("expected the address to wait a second but got %s", wait)
+	}
+
+	if err := l.Unlock(ctx, "ADMIN@admin.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	if wait, _ = l.Wait(ctx, "admin@admin.com", "10.0.0.2", now); wait != 0 {
+		t.Errorf("expected no wait after unlocking but got %s", wait)
+	}
+
+	if until, _ = l.LockedUntil(ctx, "admin@admin.com", now); !until.IsZero() {
+		t.Errorf("expected the account to be unlocked but it is locked until %s", until)
+	}
+}
@@ -0,0 +1,241 @@
+// Package migrate applies the embedded schema migrations, so we no longer need soda to set up a database
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Migration files are named <version>_<name>[.<dialect>].<up|down>.sql, files without a dialect run on every database
+var fileName = regexp.MustCompile(`^(\d{14})_(\w+?)(?:\.(postgres|sqlite3))?\.(up|down)\.sql$`)
+
+// Migration is a single versioned change to the schema
+type Migration struct {
+	Version string
+	Name    string
+	Up      string
+	Down    string
+
+	hasUp   bool
+	hasDown bool
+}
+
+// Status tells whether a migration has been applied to the database
+type Status struct {
+	Migration
+	Applied bool
+}
+
+// Migrator applies migrations and records them in the schema_migration table, the same table soda used
+type Migrator struct {
+	DB         *sql.DB
+	Migrations []Migration
+}
+
+// New loads the migrations in fsys for the given dialect ("postgres" or "sqlite3")
+func New(db *sql.DB, dialect string, fsys fs.FS) (*Migrator, error) {
+	migrations, err := Load(fsys, dialect)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{DB: db, Migrations: migrations}, nil
+}
+
+// Load reads the migrations for dialect from fsys, sorted by version
+// A file written for the dialect wins over a file without one, migrations with no up file for the dialect are left out
+func Load(fsys fs.FS, dialect string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[string]*Migration)
+	specific := make(map[string]bool) // keyed by version and direction
+
+	for _, e := range entries {
+		match := fileName.FindStringSubmatch(e.Name())
+		if e.IsDir() || match == nil {
+			continue
+		}
+
+		version, name, fileDialect, direction := match[1], match[2], match[3], match[4]
+		if fileDialect != "" && fileDialect != dialect {
+			continue
+		}
+
+		key := version + "." + direction
+		if specific[key] {
+			continue
+		}
+		specific[key] = fileDialect != ""
+
+		b, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		}
+
+		if direction == "up" {
+			m.Up = string(b)
+			m.hasUp = true
+		} else {
+			m.Down = string(b)
+			m.hasDown = true
+		}
+	}
+
+	var migrations []Migration
+	for _, m := range byVersion {
+		// a down file on its own has nothing to apply
+		if m.hasUp {
+			migrations = append(migrations, *m)
+		}
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// ensureTable creates the schema_migration table if this is a brand new database
+func (m *Migrator) ensureTable(ctx context.Context) error {
+	_, err := m.DB.ExecContext(ctx, `create table if not exists schema_migration (version varchar(14) not null primary key)`)
+	return err
+}
+
+// applied returns the versions recorded in the schema_migration table
+func (m *Migrator) applied(ctx context.Context) (map[string]bool, error) {
+	if err := m.ensureTable(ctx); err != nil {
+		return nil, err
+	}
+
+	rows, err := m.DB.QueryContext(ctx, `select version from schema_migration`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := make(map[string]bool)
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		versions[v] = true
+	}
+
+	return versions, rows.Err()
+}
+
+// Status lists every migration and whether it has been applied
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []Status
+	for _, mig := range m.Migrations {
+		statuses = append(statuses, Status{Migration: mig, Applied: applied[mig.Version]})
+	}
+
+	return statuses, nil
+}
+
+// Pending returns the migrations that have not been applied yet, oldest first
+func (m *Migrator) Pending(ctx context.Context) ([]Migration, error) {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, s := range statuses {
+		if !s.Applied {
+			pending = append(pending, s.Migration)
+		}
+	}
+
+	return pending, nil
+}
+
+// Up applies every pending migration, each in its own transaction, and returns the ones that were applied
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	pending, err := m.Pending(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, mig := range pending {
+		err := m.run(ctx, mig.Up, `insert into schema_migration (version) values ($1)`, mig.Version)
+		if err != nil {
+			return done, fmt.Errorf("migration %s_%s: %w", mig.Version, mig.Name, err)
+		}
+		done = append(done, mig)
+	}
+
+	return done, nil
+}
+
+// Down rolls back the last steps applied migrations, newest first, and returns the ones that were rolled back
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for i := len(statuses) - 1; i >= 0 && len(done) < steps; i-- {
+		mig := statuses[i].Migration
+		if !statuses[i].Applied {
+			continue
+		}
+
+		if !mig.hasDown {
+			return done, fmt.Errorf("migration %s_%s has no down file for this database", mig.Version, mig.Name)
+		}
+
+		err := m.run(ctx, mig.Down, `delete from schema_migration where version = $1`, mig.Version)
+		if err != nil {
+			return done, fmt.Errorf("rolling back %s_%s: %w", mig.Version, mig.Name, err)
+		}
+		done = append(done, mig)
+	}
+
+	return done, nil
+}
+
+// run executes the migration sql and updates schema_migration in one transaction
+func (m *Migrator) run(ctx context.Context, migration, record, version string) error {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if strings.TrimSpace(migration) != "" {
+		if _, err := tx.ExecContext(ctx, migration); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, record, version); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,126 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var testMigrations = fstest.MapFS{
+	"20240101000000_create_rooms.up.sql":               {Data: []byte("create table rooms (id integer primary key, name text);")},
+	"20240101000000_create_rooms.down.sql":             {Data: []byte("drop table rooms;")},
+	"20240102000000_add_slug.postgres.up.sql":          {Data: []byte("alter table rooms add column slug varchar(255);")},
+	"20240102000000_add_slug.sqlite3.up.sql":           {Data: []byte("alter table rooms add column slug text;")},
+	"20240102000000_add_slug.down.sql":                 {Data: []byte("alter table rooms drop column slug;")},
+	"20240103000000_postgres_only.postgres.up.sql":     {Data: []byte("create extension btree_gist;")},
+	"20240104000000_seed_rooms.up.sql":                 {Data: []byte("insert into rooms (id, name) values (1, 'one');")},
+	"schema.sql":                                       {Data: []byte("-- not a migration")},
+	"20240105000000_no_up_for_sqlite.sqlite3.down.sql": {Data: []byte("")},
+}
+
+func newTestMigrator(t *testing.T) *Migrator {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	m, err := New(db, "sqlite3", testMigrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+func TestLoad(t *testing.T) {
+	migrations, err := Load(testMigrations, "sqlite3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var versions []string
+	for _, m := range migrations {
+		versions = append(versions, m.Version)
+	}
+
+	expected := []string{"20240101000000", "20240102000000", "20240104000000"}
+	if len(versions) != len(expected) {
+		t.Fatalf("expected versions %v but got %v", expected, versions)
+	}
+
+	for i := range expected {
+		if versions[i] != expected[i] {
+			t.Errorf("expected versions %v but got %v", expected, versions)
+		}
+	}
+
+	if migrations[1].Up != "alter table rooms add column slug text;" {
+		t.Errorf("expected the sqlite3 file to be picked but got %q", migrations[1].Up)
+	}
+}
+
+func TestMigrator_UpDown(t *testing.T) {
+	m := newTestMigrator(t)
+	ctx := context.Background()
+
+	applied, err := m.Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(applied) != 3 {
+		t.Errorf("expected 3 migrations to be applied but got %d", len(applied))
+	}
+
+	pending, _ := m.Pending(ctx)
+	if len(pending) != 0 {
+		t.Errorf("expected nothing pending but got %d", len(pending))
+	}
+
+	// running up again is a no-op
+	applied, _ = m.Up(ctx)
+	if len(applied) != 0 {
+		t.Errorf("expected nothing to apply but got %d", len(applied))
+	}
+
+	// the seed migration has no down file, so rolling back stops there
+	if _, err := m.Down(ctx, 1); err == nil {
+		t.Error("expected an error rolling back a migration without a down file")
+	}
+
+	var count int
+	_ = m.DB.QueryRow("select count(*) from rooms").Scan(&count)
+	if count != 1 {
+		t.Errorf("expected the failed rollback to leave the data alone but found %d rooms", count)
+	}
+}
+
+func TestMigrator_Down(t *testing.T) {
+	m := newTestMigrator(t)
+	ctx := context.Background()
+
+	// only apply the first two
+	m.Migrations = m.Migrations[:2]
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	rolledBack, err := m.Down(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rolledBack) != 1 || rolledBack[0].Version != "20240102000000" {
+		t.Errorf("expected the newest migration to be rolled back but got %v", rolledBack)
+	}
+
+	statuses, _ := m.Status(ctx)
+	if !statuses[0].Applied || statuses[1].Applied {
+		t.Errorf("expected only the first migration to be applied but got %v", statuses)
+	}
+}
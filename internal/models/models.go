@@ -6,46 +6,194 @@ import (
 
 // User is the user model
 type User struct {
-	ID          int
-	FirstName   string
-	LastName    string
-	Email       string
-	Password    string
-	AccessLevel int
-	CreatedAt   time.Time
-	UpdatedAt   time.Time
+	ID             int
+	FirstName      string
+	LastName       string
+	Email          string
+	Password       string    `audit:"-"` // bcrypt hash, empty until an invited user sets their password
+	Role           string    // one of the roles in package roles
+	DisabledAt     time.Time // zero unless an owner disabled the account, disabled users can't log in
+	SessionVersion int       `audit:"-"` // bumped when the password changes, logging out the sessions started before
+	TOTPSecret     string    `audit:"-"` // empty unless two-factor authentication is turned on
+	TOTPLastStep   int64     `audit:"-"` // step of the last code used to log in, a code can't be used twice
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+// Disabled reports whether the user can no longer log in
+func (u User) Disabled() bool {
+	return !u.DisabledAt.IsZero()
+}
+
+// TwoFactor reports whether the user logs in with a code from their authenticator app after their password
+func (u User) TwoFactor() bool {
+	return u.TOTPSecret != ""
+}
+
+// RecoveryCode can be used once instead of a code from the authenticator app, e.g. when the phone is lost
+type RecoveryCode struct {
+	ID        int
+	UserID    int
+	CodeHash  string    // bcrypt hash of the code, see package twofactor
+	UsedAt    time.Time // zero until the code is used
+	CreatedAt time.Time
+}
+
+// PasswordReset is a token emailed to a user to choose a new password, it works once and only until it expires
+type PasswordReset struct {
+	ID        int
+	UserID    int
+	TokenHash string // hash of the token in the emailed link, see package tokens
+	ExpiresAt time.Time
+	UsedAt    time.Time // zero until the token is used
+	CreatedAt time.Time
+}
+
+// Usable reports whether the token can still be used at now
+func (p PasswordReset) Usable(now time.Time) bool {
+	return p.UsedAt.IsZero() && now.Before(p.ExpiresAt)
 }
 
 // Room is the room model
 type Room struct {
+	ID           int
+	RoomName     string
+	Slug         string // used in the public url, /rooms/{slug}
+	Description  string
+	Active       bool // inactive rooms are kept for their history but can no longer be booked
+	MaxOccupancy int
+	Beds         string // bed configuration, e.g. "1 king, 1 sofa bed"
+	Amenities    []string
+	NightlyRate  int         // in cents
+	WeekendRate  int         // in cents, charged on Friday and Saturday nights, 0 means the nightly rate applies every night
+	Photos       []RoomPhoto `audit:"-"` // in gallery order, only filled in where the photos are shown
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+
+	CancellationPolicyID int // 0 if cancelling is always free
+}
+
+// RoomPhoto is a photo in the gallery of a room, the file lives in the upload directory
+type RoomPhoto struct {
 	ID        int
-	RoomName  string
+	RoomID    int
+	FileName  string
+	Caption   string
+	SortOrder int
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
 
-// Restriction is the restriction model
+// RatePlan replaces the base rates of a room for the nights between two dates, e.g. for peak season or a holiday
+type RatePlan struct {
+	ID             int
+	RoomID         int
+	Name           string
+	StartDate      time.Time // first night the plan applies to
+	EndDate        time.Time // last night the plan applies to
+	NightlyRate    int       // in cents
+	DayMultipliers [7]int    // percent of the nightly rate charged on each day of the week, indexed by time.Weekday
+	Priority       int       // where plans overlap the one with the highest priority wins
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+
+	// CancellationPolicyID replaces the policy of the room for stays arriving on a night of the plan, 0 to keep the room's
+	CancellationPolicyID int
+}
+
+// StayRule limits how a room can be booked, for the stays arriving between StartDate and EndDate or always if they are zero
+type StayRule struct {
+	ID                int
+	RoomID            int
+	StartDate         time.Time
+	EndDate           time.Time
+	MinNights         int // 0 for no minimum
+	MaxNights         int // 0 for no maximum
+	ClosedToArrival   bool
+	ClosedToDeparture bool
+	ArrivalDays       []time.Weekday // the days guests can arrive on, empty for every day
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
+}
+
+// RoomExclusion is a room that is free for a stay but can't be booked for it because of a stay rule
+type RoomExclusion struct {
+	Room   Room
+	Reason string
+}
+
+// CancellationPolicy decides what a guest is charged for cancelling, free until FreeDays before arrival and a fee after that
+type CancellationPolicy struct {
+	ID         int
+	Name       string
+	FreeDays   int    // cancelling at least this many days before arrival is free
+	FeeType    string // how the fee is worked out, one of the cancellation.Fee* constants
+	FeePercent int    // percent of the total charged, for cancellation.FeePercent
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// CancellationTerms are the cancellation policy of a reservation as agreed when booking,
+// later changes to the policy don't affect reservations already made
+type CancellationTerms struct {
+	Policy   string // name of the policy, empty if cancelling is always free
+	FreeDays int
+	LateFee  int // in cents, charged for cancelling less than FreeDays before arrival
+}
+
+// Restriction is the restriction model, the type of a room restriction e.g. a reservation or maintenance
 type Restriction struct {
-	ID              int
-	RestrictionName string
-	CreatedAt       time.Time
-	UpdatedAt       time.Time
+	ID                 int
+	RestrictionName    string
+	Color              string // hex colour the restriction is shown in on the calendar, e.g. #dc3545
+	BlocksAvailability bool   // false for restrictions that are only a note, the room can still be booked
+	CreatedAt          time.Time
+	UpdatedAt          time.Time
 }
 
 // Reservation is the reservation model
 type Reservation struct {
-	ID        int
-	FirstName string
-	LastName  string
-	Email     string
-	Phone     string
-	StartDate time.Time
-	EndDate   time.Time
-	RoomID    int
-	CreatedAt time.Time
-	UpdatedAt time.Time
-	Room      Room
-	Processed int
+	ID         int
+	FirstName  string
+	LastName   string
+	Email      string
+	Phone      string
+	StartDate  time.Time
+	EndDate    time.Time
+	RoomID     int
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	Room       Room `audit:"-"`
+	TotalPrice int  // in cents, the quote the guest agreed to when booking
+
+	Status       string // one of the statuses in package status, pending until an admin confirms it
+	ConfirmedAt  time.Time
+	CheckedInAt  time.Time
+	CheckedOutAt time.Time
+	NoShowAt     time.Time
+
+	// ConfirmationCode is given to the guest to look the reservation up with, together with their email
+	ConfirmationCode string
+
+	CancelledAt time.Time // zero while the reservation stands
+	ChangedBy   string    // who made the last change, "guest" or the email of the admin
+
+	Cancellation    CancellationTerms
+	CancellationFee int // in cents, charged when the reservation was cancelled
+
+	DeletedAt    time.Time // zero unless an admin deleted it, it can be restored until it is purged
+	DeletedBy    string
+	DeleteReason string
+}
+
+// Cancelled reports whether the reservation has been cancelled
+func (r Reservation) Cancelled() bool {
+	return !r.CancelledAt.IsZero()
+}
+
+// Deleted reports whether the reservation is in the trash
+func (r Reservation) Deleted() bool {
+	return !r.DeletedAt.IsZero()
 }
 
 // RoomRestriction is the room restriction model
@@ -58,9 +206,52 @@ type RoomRestriction struct {
 	RestrictionID int
 	CreatedAt     time.Time
 	UpdatedAt     time.Time
-	Room          Room
-	Reservation   Reservation
-	Restriction   Restriction
+	Room          Room        `audit:"-"`
+	Reservation   Reservation `audit:"-"`
+	Restriction   Restriction `audit:"-"`
+
+	// BlocksAvailability is copied from the restriction type when the room restriction is inserted
+	BlocksAvailability bool
+}
+
+// RoomBlock is a request to block a room from StartDate to EndDate (both inclusive), repeated every RepeatWeeks weeks
+// until RepeatUntil when RepeatWeeks is set. Each occurrence is stored as a room restriction of the given type
+type RoomBlock struct {
+	RoomID        int
+	RestrictionID int
+	StartDate     time.Time // first blocked day
+	EndDate       time.Time // last blocked day
+	RepeatWeeks   int       // 0 for a single block, 1 for every week, 2 for every other week...
+	RepeatUntil   time.Time // last day an occurrence may start on
+}
+
+// AuditEntry is a change recorded in the audit log, entries are never changed or removed
+type AuditEntry struct {
+	ID        int
+	UserID    int    // the logged in user that made the change, 0 for guests and the app itself
+	Actor     string // email of the user, "guest" or "system"
+	Action    string // one of the actions in package audit, e.g. "update"
+	Entity    string // kind of entity that changed, one of the entities in package audit
+	EntityID  int
+	Changes   []FieldChange
+	IP        string
+	UserAgent string
+	Request   string // method and path of the request that made the change, empty for changes the app made by itself
+	CreatedAt time.Time
+}
+
+// FieldChange is a field of an entity that a change set from Before to After, empty for a field that was not set
+type FieldChange struct {
+	Field  string
+	Before string
+	After  string
+}
+
+// AuditFilter picks the entries of the audit log to show, empty fields match every entry
+type AuditFilter struct {
+	Entity   string
+	EntityID int
+	Actor    string
 }
 
 // MailData holds an email message
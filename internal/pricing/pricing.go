@@ -0,0 +1,164 @@
+// Package pricing works out what a stay costs, amounts are kept in cents so they always add up exactly
+package pricing
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hd719/go-bookings/internal/models"
+)
+
+// Night is the price of a single night of a stay
+type Night struct {
+	Date     time.Time // the day the night starts on
+	Rate     int
+	Weekend  bool
+	RatePlan string // name of the rate plan the rate comes from, empty for the base rate of the room
+}
+
+// Quote is the price of a stay, night by night
+type Quote struct {
+	Nights []Night
+	Total  int
+}
+
+// NumNights returns the number of nights in the quote
+func (q Quote) NumNights() int {
+	return len(q.Nights)
+}
+
+// IsWeekend reports whether the night starting on date is a weekend night, those are Friday and Saturday nights
+func IsWeekend(date time.Time) bool {
+	return date.Weekday() == time.Friday || date.Weekday() == time.Saturday
+}
+
+// NightlyRate returns the base rate of room for the night starting on date, the weekend rate is used on weekend nights if the room has one
+func NightlyRate(room models.Room, date time.Time) int {
+	if IsWeekend(date) && room.WeekendRate > 0 {
+		return room.WeekendRate
+	}
+
+	return room.NightlyRate
+}
+
+// EffectivePlan returns the rate plan that sets the price of the night starting on date, if any of plans covers it
+// Where plans overlap the highest priority wins, then the plan covering the fewest nights, then the newest plan
+func EffectivePlan(plans []models.RatePlan, date time.Time) (models.RatePlan, bool) {
+	var best models.RatePlan
+	found := false
+
+	for _, p := range plans {
+		if date.Before(p.StartDate) || date.After(p.EndDate) {
+			continue
+		}
+
+		if !found || outranks(p, best) {
+			best = p
+			found = true
+		}
+	}
+
+	return best, found
+}
+
+// outranks reports whether rate plan a takes precedence over b
+func outranks(a, b models.RatePlan) bool {
+	if a.Priority != b.Priority {
+		return a.Priority > b.Priority
+	}
+
+	aNights, bNights := a.EndDate.Sub(a.StartDate), b.EndDate.Sub(b.StartDate)
+	if aNights != bNights {
+		return aNights < bNights
+	}
+
+	return a.ID > b.ID
+}
+
+// PlanRate returns what plan charges for the night starting on date, rounded to the nearest cent
+func PlanRate(plan models.RatePlan, date time.Time) int {
+	return (plan.NightlyRate*plan.DayMultipliers[date.Weekday()] + 50) / 100
+}
+
+// PriceNight prices the night starting on date, using the effective rate plan or else the base rates of room
+func PriceNight(room models.Room, plans []models.RatePlan, date time.Time) Night {
+	night := Night{Date: date, Weekend: IsWeekend(date)}
+
+	if plan, ok := EffectivePlan(plans, date); ok {
+		night.Rate = PlanRate(plan, date)
+		night.RatePlan = plan.Name
+	} else {
+		night.Rate = NightlyRate(room, date)
+	}
+
+	return night
+}
+
+// NewQuote prices a stay in room arriving on start and leaving on end, the night of the departure day is not charged
+// plans are the rate plans of the room, plans that don't cover any night of the stay are ignored
+func NewQuote(room models.Room, plans []models.RatePlan, start, end time.Time) Quote {
+	var q Quote
+
+	for d := start; d.Before(end); d = d.AddDate(0, 0, 1) {
+		night := PriceNight(room, plans, d)
+		q.Nights = append(q.Nights, night)
+		q.Total += night.Rate
+	}
+
+	return q
+}
+
+// DefaultDayMultipliers charges the nightly rate of a rate plan on every day of the week
+var DefaultDayMultipliers = [7]int{100, 100, 100, 100, 100, 100, 100}
+
+// Format formats cents as dollars, e.g. 125050 becomes $1,250.50
+func Format(cents int) string {
+	sign := ""
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+
+	dollars := strconv.Itoa(cents / 100)
+	for i := len(dollars) - 3; i > 0; i -= 3 {
+		dollars = dollars[:i] + "," + dollars[i:]
+	}
+
+	return fmt.Sprintf("%s$%s.%02d", sign, dollars, cents%100)
+}
+
+var errInvalidAmount = errors.New("enter an amount in dollars, e.g. 120 or 120.50")
+
+// ParseAmount parses an amount in dollars, as typed into a form, into cents
+func ParseAmount(s string) (int, error) {
+	s = strings.TrimPrefix(strings.ReplaceAll(strings.TrimSpace(s), ",", ""), "$")
+	if s == "" {
+		return 0, errInvalidAmount
+	}
+
+	whole, fraction, _ := strings.Cut(s, ".")
+	if len(fraction) > 2 {
+		return 0, errInvalidAmount
+	}
+
+	dollars, err := strconv.Atoi(whole)
+	if err != nil || dollars < 0 {
+		return 0, errInvalidAmount
+	}
+
+	cents := 0
+	if fraction != "" {
+		cents, err = strconv.Atoi(fraction)
+		if err != nil || cents < 0 {
+			return 0, errInvalidAmount
+		}
+		if len(fraction) == 1 {
+			cents *= 10
+		}
+	}
+
+	return dollars*100 + cents, nil
+}
@@ -0,0 +1,149 @@
+package pricing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hd719/go-bookings/internal/models"
+)
+
+func date(s string) time.Time {
+	d, _ := time.Parse("2006-01-02", s)
+	return d
+}
+
+func TestNewQuote(t *testing.T) {
+	room := models.Room{NightlyRate: 10000, WeekendRate: 15000}
+
+	// Thursday to Sunday is a Thursday night and two weekend nights
+	q := NewQuote(room, nil, date("2050-01-06"), date("2050-01-09"))
+
+	if q.NumNights() != 3 {
+		t.Fatalf("expected 3 nights but got %d", q.NumNights())
+	}
+
+	if q.Total != 40000 {
+		t.Errorf("expected a total of 40000 but got %d", q.Total)
+	}
+
+	if q.Nights[0].Weekend || !q.Nights[1].Weekend || !q.Nights[2].Weekend {
+		t.Errorf("expected Friday and Saturday to be weekend nights but got %+v", q.Nights)
+	}
+
+	// without a weekend rate every night costs the same
+	q = NewQuote(models.Room{NightlyRate: 10000}, nil, date("2050-01-06"), date("2050-01-09"))
+	if q.Total != 30000 {
+		t.Errorf("expected a total of 30000 but got %d", q.Total)
+	}
+
+	// leaving on the day of arrival costs nothing
+	q = NewQuote(room, nil, date("2050-01-06"), date("2050-01-06"))
+	if q.NumNights() != 0 || q.Total != 0 {
+		t.Errorf("expected an empty quote but got %+v", q)
+	}
+}
+
+func TestNewQuote_RatePlans(t *testing.T) {
+	room := models.Room{NightlyRate: 10000, WeekendRate: 15000}
+
+	summer := models.RatePlan{
+		ID:             1,
+		Name:           "Summer",
+		StartDate:      date("2050-06-01"),
+		EndDate:        date("2050-08-31"),
+		NightlyRate:    20000,
+		DayMultipliers: [7]int{100, 100, 100, 100, 100, 125, 125},
+	}
+
+	holiday := models.RatePlan{
+		ID:             2,
+		Name:           "Independence Day",
+		StartDate:      date("2050-07-03"),
+		EndDate:        date("2050-07-04"),
+		NightlyRate:    30000,
+		DayMultipliers: DefaultDayMultipliers,
+		Priority:       1,
+	}
+
+	plans := []models.RatePlan{summer, holiday}
+
+	var tests = []struct {
+		name     string
+		night    string
+		rate     int
+		ratePlan string
+	}{
+		{"before the season", "2050-05-31", 10000, ""},
+		{"summer weekday", "2050-06-01", 20000, "Summer"},
+		{"summer friday", "2050-06-03", 25000, "Summer"},
+		{"holiday wins on priority", "2050-07-03", 30000, "Independence Day"},
+		{"last night of the holiday", "2050-07-04", 30000, "Independence Day"},
+		{"back to summer", "2050-07-05", 20000, "Summer"},
+		{"after the season", "2050-09-02", 15000, ""},
+	}
+
+	for _, e := range tests {
+		night := PriceNight(room, plans, date(e.night))
+		if night.Rate != e.rate || night.RatePlan != e.ratePlan {
+			t.Errorf("%s: expected %d from %q but got %d from %q", e.name, e.rate, e.ratePlan, night.Rate, night.RatePlan)
+		}
+	}
+
+	// with equal priority the shorter plan is the more specific one
+	holiday.Priority = 0
+	if plan, _ := EffectivePlan([]models.RatePlan{summer, holiday}, date("2050-07-03")); plan.ID != holiday.ID {
+		t.Errorf("expected the shorter plan to win but got %s", plan.Name)
+	}
+
+	q := NewQuote(room, plans, date("2050-07-02"), date("2050-07-06"))
+	if q.Total != 25000+30000+30000+20000 {
+		t.Errorf("expected a total of 105000 but got %d", q.Total)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	var tests = []struct {
+		cents    int
+		expected string
+	}{
+		{0, "$0.00"},
+		{5, "$0.05"},
+		{12050, "$120.50"},
+		{125050, "$1,250.50"},
+		{123456789, "$1,234,567.89"},
+		{-2500, "-$25.00"},
+	}
+
+	for _, e := range tests {
+		if got := Format(e.cents); got != e.expected {
+			t.Errorf("Format(%d) returned %q, expected %q", e.cents, got, e.expected)
+		}
+	}
+}
+
+func TestParseAmount(t *testing.T) {
+	var tests = []struct {
+		amount   string
+		expected int
+		valid    bool
+	}{
+		{"120", 12000, true},
+		{"120.5", 12050, true},
+		{"$1,250.50", 125050, true},
+		{" 0.05 ", 5, true},
+		{"", 0, false},
+		{"abc", 0, false},
+		{"-10", 0, false},
+		{"1.234", 0, false},
+	}
+
+	for _, e := range tests {
+		got, err := ParseAmount(e.amount)
+		if e.valid && (err != nil || got != e.expected) {
+			t.Errorf("ParseAmount(%q) returned %d, %v, expected %d", e.amount, got, err, e.expected)
+		}
+		if !e.valid && err == nil {
+			t.Errorf("ParseAmount(%q) returned %d, expected an error", e.amount, got)
+		}
+	}
+}
@@ -10,18 +10,26 @@ import (
 	"path/filepath"
 	"time"
 
+	"github.com/hd719/go-bookings/internal/cancellation"
 	"github.com/hd719/go-bookings/internal/config"
 	"github.com/hd719/go-bookings/internal/models"
+	"github.com/hd719/go-bookings/internal/pricing"
+	"github.com/hd719/go-bookings/internal/roles"
+	"github.com/hd719/go-bookings/internal/status"
 	"github.com/justinas/nosurf"
 )
 
 var app *config.AppConfig
 var pathToTemplates = "./templates"
 var functions = template.FuncMap{
-	"humanDate":  HumanDate,
-	"formatDate": FormatDate,
-	"iterate":    Iterate,
-	"add":        Add,
+	"humanDate":   HumanDate,
+	"formatDate":  FormatDate,
+	"iterate":     Iterate,
+	"add":         Add,
+	"formatMoney": pricing.Format,
+	"deadline":    cancellation.Deadline,
+	"statusLabel": status.Label,
+	"roleLabel":   roles.Label,
 }
 
 func Add(a, b int) int {
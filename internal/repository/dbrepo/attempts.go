@@ -0,0 +1,73 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/hd719/go-bookings/internal/config"
+	"github.com/hd719/go-bookings/internal/lockout"
+)
+
+// attemptStore keeps the failed logins in the login_attempts table, the queries work on postgres and SQLite
+type attemptStore struct {
+	App *config.AppConfig
+	DB  *sql.DB
+}
+
+// NewAttemptStore returns a store that keeps the failed logins in the database, so every instance of the app counts
+// them together and a restart doesn't forget them
+func NewAttemptStore(conn *sql.DB, a *config.AppConfig) lockout.Store {
+	return &attemptStore{App: a, DB: conn}
+}
+
+func (s *attemptStore) Get(ctx context.Context, key string) (lockout.Attempts, error) {
+	ctx, cancel := queryContext(ctx, s.App)
+	defer cancel()
+
+	var a lockout.Attempts
+
+	query := `select failures, last_failure from login_attempts where lockout_key = $1`
+
+	err := s.DB.QueryRowContext(ctx, query, key).Scan(&a.Failures, &a.Last)
+	if errors.Is(err, sql.ErrNoRows) {
+		return lockout.Attempts{}, nil
+	}
+
+	return a, err
+}
+
+// Fail counts the failure in a single statement, so logins at the same time can't lose each other's count
+func (s *attemptStore) Fail(ctx context.Context, key string, now, since time.Time) (lockout.Attempts, error) {
+	ctx, cancel := queryContext(ctx, s.App)
+	defer cancel()
+
+	var a lockout.Attempts
+
+	stmt := `
+		insert into login_attempts (lockout_key, failures, last_failure) values ($1, 1, $2)
+		on conflict (lockout_key) do update
+		set failures = case when login_attempts.last_failure < $3 then 1 else login_attempts.failures + 1 end,
+			last_failure = excluded.last_failure
+		returning failures, last_failure`
+
+	err := s.DB.QueryRowContext(ctx, stmt, key, now, since).Scan(&a.Failures, &a.Last)
+	return a, err
+}
+
+func (s *attemptStore) Reset(ctx context.Context, key string) error {
+	ctx, cancel := queryContext(ctx, s.App)
+	defer cancel()
+
+	_, err := s.DB.ExecContext(ctx, `delete from login_attempts where lockout_key = $1`, key)
+	return err
+}
+
+func (s *attemptStore) Prune(ctx context.Context, since time.Time) error {
+	ctx, cancel := queryContext(ctx, s.App)
+	defer cancel()
+
+	_, err := s.DB.ExecContext(ctx, `delete from login_attempts where last_failure < $1`, since)
+	return err
+}
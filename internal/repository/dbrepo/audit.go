@@ -0,0 +1,536 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/hd719/go-bookings/internal/audit"
+	"github.com/hd719/go-bookings/internal/models"
+	"github.com/hd719/go-bookings/internal/repository"
+)
+
+// auditRepo records every change made through a repo in its audit log, each change is made in a transaction together
+// with its entry so a change is never saved without one. Who made the change and from where is taken from the context,
+// see audit.WithActor and audit.WithRequest
+type auditRepo struct {
+	repository.DatabaseRepo
+}
+
+// audited returns repo with every change it makes recorded in the audit log
+func audited(repo repository.DatabaseRepo) repository.DatabaseRepo {
+	return &auditRepo{repo}
+}
+
+// WithTx runs fn inside a transaction, the changes fn makes are recorded in the audit log as part of it
+func (a *auditRepo) WithTx(ctx context.Context, fn func(repo repository.DatabaseRepo) error) error {
+	return a.DatabaseRepo.WithTx(ctx, func(tx repository.DatabaseRepo) error {
+		return fn(&auditRepo{tx})
+	})
+}
+
+// load returns the entity a getter loaded, or nil if there is no such entity
+func load[T any](v T, err error) (interface{}, error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+// record appends an entry for a change to the audit log
+func record(ctx context.Context, repo repository.DatabaseRepo, action, entity string, id int, changes []models.FieldChange) error {
+	return repo.InsertAuditEntry(ctx, audit.NewEntry(ctx, action, entity, id, changes))
+}
+
+// recordDiff appends an entry with the fields that differ between the entity before and after a change to the audit
+// log, a change that didn't change anything is not recorded
+func recordDiff(ctx context.Context, repo repository.DatabaseRepo, action, entity string, id int, before, after interface{}) error {
+	changes := audit.Diff(before, after)
+	if len(changes) == 0 {
+		return nil
+	}
+
+	return record(ctx, repo, action, entity, id, changes)
+}
+
+// getter loads the entity with the given id, nil if there is none
+type getter func(ctx context.Context, repo repository.DatabaseRepo, id int) (interface{}, error)
+
+// change runs fn in a transaction and records how it changed the entity with the given id in the audit log
+func (a *auditRepo) change(ctx context.Context, action, entity string, id int, get getter, fn func(repo repository.DatabaseRepo) error) error {
+	return a.DatabaseRepo.WithTx(ctx, func(tx repository.DatabaseRepo) error {
+		before, err := get(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		if err := fn(tx); err != nil {
+			return err
+		}
+
+		after, err := get(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		return recordDiff(ctx, tx, action, entity, id, before, after)
+	})
+}
+
+// insert runs fn in a transaction and records the entity it inserted in the audit log
+func (a *auditRepo) insert(ctx context.Context, entity string, get getter, fn func(repo repository.DatabaseRepo) (int, error)) (int, error) {
+	var newId int
+
+	err := a.DatabaseRepo.WithTx(ctx, func(tx repository.DatabaseRepo) error {
+		var err error
+		newId, err = fn(tx)
+		if err != nil {
+			return err
+		}
+
+		after, err := get(ctx, tx, newId)
+		if err != nil {
+			return err
+		}
+
+		return recordDiff(ctx, tx, audit.Create, entity, newId, nil, after)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return newId, nil
+}
+
+func getReservation(ctx context.Context, repo repository.DatabaseRepo, id int) (interface{}, error) {
+	return load(repo.GetReservationById(ctx, id))
+}
+
+func getUser(ctx context.Context, repo repository.DatabaseRepo, id int) (interface{}, error) {
+	return load(repo.GetUserById(ctx, id))
+}
+
+func getRoom(ctx context.Context, repo repository.DatabaseRepo, id int) (interface{}, error) {
+	return load(repo.GetRoomById(ctx, id))
+}
+
+func getRoomPhoto(ctx context.Context, repo repository.DatabaseRepo, id int) (interface{}, error) {
+	return load(repo.GetRoomPhotoById(ctx, id))
+}
+
+func getRatePlan(ctx context.Context, repo repository.DatabaseRepo, id int) (interface{}, error) {
+	return load(repo.GetRatePlanById(ctx, id))
+}
+
+func getStayRule(ctx context.Context, repo repository.DatabaseRepo, id int) (interface{}, error) {
+	return load(repo.GetStayRuleById(ctx, id))
+}
+
+func getCancellationPolicy(ctx context.Context, repo repository.DatabaseRepo, id int) (interface{}, error) {
+	return load(repo.GetCancellationPolicyById(ctx, id))
+}
+
+func getRestriction(ctx context.Context, repo repository.DatabaseRepo, id int) (interface{}, error) {
+	return load(repo.GetRestrictionById(ctx, id))
+}
+
+func (a *auditRepo) InsertReservation(ctx context.Context, res models.Reservation) (int, error) {
+	return a.insert(ctx, audit.Reservation, getReservation, func(repo repository.DatabaseRepo) (int, error) {
+		return repo.InsertReservation(ctx, res)
+	})
+}
+
+func (a *auditRepo) BookRoom(ctx context.Context, res models.Reservation) (int, error) {
+	return a.insert(ctx, audit.Reservation, getReservation, func(repo repository.DatabaseRepo) (int, error) {
+		return repo.BookRoom(ctx, res)
+	})
+}
+
+func (a *auditRepo) UpdateReservation(ctx context.Context, u models.Reservation) error {
+	return a.change(ctx, audit.Update, audit.Reservation, u.ID, getReservation, func(repo repository.DatabaseRepo) error {
+		return repo.UpdateReservation(ctx, u)
+	})
+}
+
+func (a *auditRepo) UpdateReservationDates(ctx context.Context, res models.Reservation) error {
+	return a.change(ctx, audit.Update, audit.Reservation, res.ID, getReservation, func(repo repository.DatabaseRepo) error {
+		return repo.UpdateReservationDates(ctx, res)
+	})
+}
+
+func (a *auditRepo) ChangeReservationDates(ctx context.Context, id int, start, end time.Time, changedBy string) (models.Reservation, error) {
+	var res models.Reservation
+
+	err := a.change(ctx, audit.Update, audit.Reservation, id, getReservation, func(repo repository.DatabaseRepo) error {
+		var err error
+		res, err = repo.ChangeReservationDates(ctx, id, start, end, changedBy)
+		return err
+	})
+	if err != nil {
+		return models.Reservation{}, err
+	}
+
+	return res, nil
+}
+
+func (a *auditRepo) CancelReservation(ctx context.Context, id int, changedBy string) (int, error) {
+	var fee int
+
+	err := a.change(ctx, audit.Cancel, audit.Reservation, id, getReservation, func(repo repository.DatabaseRepo) error {
+		var err error
+		fee, err = repo.CancelReservation(ctx, id, changedBy)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return fee, nil
+}
+
+func (a *auditRepo) UpdateReservationStatus(ctx context.Context, id int, to, changedBy string) error {
+	return a.change(ctx, audit.ChangeStatus, audit.Reservation, id, getReservation, func(repo repository.DatabaseRepo) error {
+		return repo.UpdateReservationStatus(ctx, id, to, changedBy)
+	})
+}
+
+// DeleteReservation records who deleted the reservation and why, once in the trash it can no longer be loaded
+func (a *auditRepo) DeleteReservation(ctx context.Context, id int, deletedBy, reason string) error {
+	return a.DatabaseRepo.WithTx(ctx, func(tx repository.DatabaseRepo) error {
+		if err := tx.DeleteReservation(ctx, id, deletedBy, reason); err != nil {
+			return err
+		}
+
+		return recordDiff(ctx, tx, audit.Delete, audit.Reservation, id,
+			nil, models.Reservation{DeletedAt: time.Now(), DeletedBy: deletedBy, DeleteReason: reason})
+	})
+}
+
+// RestoreReservation records who had deleted the reservation and why, which is cleared by restoring it
+func (a *auditRepo) RestoreReservation(ctx context.Context, id int) error {
+	return a.DatabaseRepo.WithTx(ctx, func(tx repository.DatabaseRepo) error {
+		deleted, err := deletedReservation(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.RestoreReservation(ctx, id); err != nil {
+			return err
+		}
+
+		return recordDiff(ctx, tx, audit.Restore, audit.Reservation, id,
+			models.Reservation{DeletedAt: deleted.DeletedAt, DeletedBy: deleted.DeletedBy, DeleteReason: deleted.DeleteReason}, nil)
+	})
+}
+
+// deletedReservation returns the reservation with the given id from the trash, the zero reservation if it isn't there
+func deletedReservation(ctx context.Context, repo repository.DatabaseRepo, id int) (models.Reservation, error) {
+	deleted, err := repo.DeletedReservations(ctx)
+	if err != nil {
+		return models.Reservation{}, err
+	}
+
+	for _, res := range deleted {
+		if res.ID == id {
+			return res, nil
+		}
+	}
+
+	return models.Reservation{}, nil
+}
+
+// PurgeDeletedReservations records an entry for each reservation removed for good, with the fields it had in the trash
+func (a *auditRepo) PurgeDeletedReservations(ctx context.Context, before time.Time) (int, error) {
+	var n int
+
+	err := a.DatabaseRepo.WithTx(ctx, func(tx repository.DatabaseRepo) error {
+		deleted, err := tx.DeletedReservations(ctx)
+		if err != nil {
+			return err
+		}
+
+		n, err = tx.PurgeDeletedReservations(ctx, before)
+		if err != nil {
+			return err
+		}
+
+		for _, res := range deleted {
+			if res.DeletedAt.Before(before) {
+				if err := record(ctx, tx, audit.Purge, audit.Reservation, res.ID, audit.Diff(res, nil)); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// InsertRoomRestriction records the room restriction as it was given, there is no getter to load it back with
+func (a *auditRepo) InsertRoomRestriction(ctx context.Context, r models.RoomRestriction) (int, error) {
+	var newId int
+
+	err := a.DatabaseRepo.WithTx(ctx, func(tx repository.DatabaseRepo) error {
+		var err error
+		newId, err = tx.InsertRoomRestriction(ctx, r)
+		if err != nil {
+			return err
+		}
+
+		r.ID = newId
+		return recordDiff(ctx, tx, audit.Create, audit.RoomRestriction, newId, nil, r)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return newId, nil
+}
+
+// DeleteRestrictionsForReservation records the change under the reservation, the room restrictions are removed by it
+func (a *auditRepo) DeleteRestrictionsForReservation(ctx context.Context, reservationId int) error {
+	return a.DatabaseRepo.WithTx(ctx, func(tx repository.DatabaseRepo) error {
+		if err := tx.DeleteRestrictionsForReservation(ctx, reservationId); err != nil {
+			return err
+		}
+
+		return record(ctx, tx, audit.Update, audit.Reservation, reservationId, []models.FieldChange{{Field: "RoomRestrictions", Before: "held", After: "removed"}})
+	})
+}
+
+func (a *auditRepo) InsertUser(ctx context.Context, u models.User) (int, error) {
+	return a.insert(ctx, audit.User, getUser, func(repo repository.DatabaseRepo) (int, error) {
+		return repo.InsertUser(ctx, u)
+	})
+}
+
+func (a *auditRepo) UpdateUser(ctx context.Context, u models.User) error {
+	return a.change(ctx, audit.Update, audit.User, u.ID, getUser, func(repo repository.DatabaseRepo) error {
+		return repo.UpdateUser(ctx, u)
+	})
+}
+
+// UpdateUserPassword records that the password changed, never the password or its hash
+func (a *auditRepo) UpdateUserPassword(ctx context.Context, id int, password string) error {
+	return a.DatabaseRepo.WithTx(ctx, func(tx repository.DatabaseRepo) error {
+		if err := tx.UpdateUserPassword(ctx, id, password); err != nil {
+			return err
+		}
+
+		return record(ctx, tx, audit.Update, audit.User, id, []models.FieldChange{{Field: "Password", After: "changed"}})
+	})
+}
+
+// SetUserTOTPSecret records that two-factor authentication was turned on or off, never the secret
+func (a *auditRepo) SetUserTOTPSecret(ctx context.Context, id int, secret string) error {
+	return a.DatabaseRepo.WithTx(ctx, func(tx repository.DatabaseRepo) error {
+		before, err := tx.GetUserById(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.SetUserTOTPSecret(ctx, id, secret); err != nil {
+			return err
+		}
+
+		change := models.FieldChange{Field: "TwoFactor", Before: onOff(before.TwoFactor()), After: onOff(secret != "")}
+		if change.Before == change.After {
+			// a new secret replaces the old one
+			change.After = "new secret"
+		}
+
+		return record(ctx, tx, audit.Update, audit.User, id, []models.FieldChange{change})
+	})
+}
+
+// ReplaceRecoveryCodes records that the recovery codes were replaced, never the codes
+func (a *auditRepo) ReplaceRecoveryCodes(ctx context.Context, userId int, hashes []string) error {
+	return a.DatabaseRepo.WithTx(ctx, func(tx repository.DatabaseRepo) error {
+		if err := tx.ReplaceRecoveryCodes(ctx, userId, hashes); err != nil {
+			return err
+		}
+
+		after := "replaced"
+		if len(hashes) == 0 {
+			after = "removed"
+		}
+
+		return record(ctx, tx, audit.Update, audit.User, userId, []models.FieldChange{{Field: "RecoveryCodes", After: after}})
+	})
+}
+
+// onOff describes a setting that is either on or off in the audit log
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+
+	return "off"
+}
+
+func (a *auditRepo) SetUserDisabled(ctx context.Context, id int, disabled bool) error {
+	return a.change(ctx, audit.Update, audit.User, id, getUser, func(repo repository.DatabaseRepo) error {
+		return repo.SetUserDisabled(ctx, id, disabled)
+	})
+}
+
+func (a *auditRepo) InsertRoom(ctx context.Context, room models.Room) (int, error) {
+	return a.insert(ctx, audit.Room, getRoom, func(repo repository.DatabaseRepo) (int, error) {
+		return repo.InsertRoom(ctx, room)
+	})
+}
+
+func (a *auditRepo) UpdateRoom(ctx context.Context, room models.Room) error {
+	return a.change(ctx, audit.Update, audit.Room, room.ID, getRoom, func(repo repository.DatabaseRepo) error {
+		return repo.UpdateRoom(ctx, room)
+	})
+}
+
+func (a *auditRepo) DeleteRoom(ctx context.Context, id int) error {
+	return a.change(ctx, audit.Delete, audit.Room, id, getRoom, func(repo repository.DatabaseRepo) error {
+		return repo.DeleteRoom(ctx, id)
+	})
+}
+
+func (a *auditRepo) InsertRoomPhoto(ctx context.Context, p models.RoomPhoto) (int, error) {
+	return a.insert(ctx, audit.RoomPhoto, getRoomPhoto, func(repo repository.DatabaseRepo) (int, error) {
+		return repo.InsertRoomPhoto(ctx, p)
+	})
+}
+
+func (a *auditRepo) UpdateRoomPhoto(ctx context.Context, p models.RoomPhoto) error {
+	return a.change(ctx, audit.Update, audit.RoomPhoto, p.ID, getRoomPhoto, func(repo repository.DatabaseRepo) error {
+		return repo.UpdateRoomPhoto(ctx, p)
+	})
+}
+
+func (a *auditRepo) DeleteRoomPhoto(ctx context.Context, id int) error {
+	return a.change(ctx, audit.Delete, audit.RoomPhoto, id, getRoomPhoto, func(repo repository.DatabaseRepo) error {
+		return repo.DeleteRoomPhoto(ctx, id)
+	})
+}
+
+func (a *auditRepo) InsertRatePlan(ctx context.Context, p models.RatePlan) (int, error) {
+	return a.insert(ctx, audit.RatePlan, getRatePlan, func(repo repository.DatabaseRepo) (int, error) {
+		return repo.InsertRatePlan(ctx, p)
+	})
+}
+
+func (a *auditRepo) UpdateRatePlan(ctx context.Context, p models.RatePlan) error {
+	return a.change(ctx, audit.Update, audit.RatePlan, p.ID, getRatePlan, func(repo repository.DatabaseRepo) error {
+		return repo.UpdateRatePlan(ctx, p)
+	})
+}
+
+func (a *auditRepo) DeleteRatePlan(ctx context.Context, id int) error {
+	return a.change(ctx, audit.Delete, audit.RatePlan, id, getRatePlan, func(repo repository.DatabaseRepo) error {
+		return repo.DeleteRatePlan(ctx, id)
+	})
+}
+
+func (a *auditRepo) InsertStayRule(ctx context.Context, r models.StayRule) (int, error) {
+	return a.insert(ctx, audit.StayRule, getStayRule, func(repo repository.DatabaseRepo) (int, error) {
+		return repo.InsertStayRule(ctx, r)
+	})
+}
+
+func (a *auditRepo) UpdateStayRule(ctx context.Context, r models.StayRule) error {
+	return a.change(ctx, audit.Update, audit.StayRule, r.ID, getStayRule, func(repo repository.DatabaseRepo) error {
+		return repo.UpdateStayRule(ctx, r)
+	})
+}
+
+func (a *auditRepo) DeleteStayRule(ctx context.Context, id int) error {
+	return a.change(ctx, audit.Delete, audit.StayRule, id, getStayRule, func(repo repository.DatabaseRepo) error {
+		return repo.DeleteStayRule(ctx, id)
+	})
+}
+
+func (a *auditRepo) InsertCancellationPolicy(ctx context.Context, p models.CancellationPolicy) (int, error) {
+	return a.insert(ctx, audit.CancellationPolicy, getCancellationPolicy, func(repo repository.DatabaseRepo) (int, error) {
+		return repo.InsertCancellationPolicy(ctx, p)
+	})
+}
+
+func (a *auditRepo) UpdateCancellationPolicy(ctx context.Context, p models.CancellationPolicy) error {
+	return a.change(ctx, audit.Update, audit.CancellationPolicy, p.ID, getCancellationPolicy, func(repo repository.DatabaseRepo) error {
+		return repo.UpdateCancellationPolicy(ctx, p)
+	})
+}
+
+func (a *auditRepo) DeleteCancellationPolicy(ctx context.Context, id int) error {
+	return a.change(ctx, audit.Delete, audit.CancellationPolicy, id, getCancellationPolicy, func(repo repository.DatabaseRepo) error {
+		return repo.DeleteCancellationPolicy(ctx, id)
+	})
+}
+
+func (a *auditRepo) InsertRestriction(ctx context.Context, r models.Restriction) (int, error) {
+	return a.insert(ctx, audit.Restriction, getRestriction, func(repo repository.DatabaseRepo) (int, error) {
+		return repo.InsertRestriction(ctx, r)
+	})
+}
+
+func (a *auditRepo) UpdateRestriction(ctx context.Context, r models.Restriction) error {
+	return a.change(ctx, audit.Update, audit.Restriction, r.ID, getRestriction, func(repo repository.DatabaseRepo) error {
+		return repo.UpdateRestriction(ctx, r)
+	})
+}
+
+func (a *auditRepo) DeleteRestriction(ctx context.Context, id int) error {
+	return a.change(ctx, audit.Delete, audit.Restriction, id, getRestriction, func(repo repository.DatabaseRepo) error {
+		return repo.DeleteRestriction(ctx, id)
+	})
+}
+
+// InsertBlockForRoomById records the block against the room it blocks
+func (a *auditRepo) InsertBlockForRoomById(ctx context.Context, id, restrictionId int, startDate, endDate time.Time) error {
+	return a.DatabaseRepo.WithTx(ctx, func(tx repository.DatabaseRepo) error {
+		if err := tx.InsertBlockForRoomById(ctx, id, restrictionId, startDate, endDate); err != nil {
+			return err
+		}
+
+		block := models.RoomBlock{RoomID: id, RestrictionID: restrictionId, StartDate: startDate, EndDate: endDate}
+		return recordDiff(ctx, tx, audit.Block, audit.Room, id, nil, block)
+	})
+}
+
+// InsertBlocksForRoom records the block, with how it repeats, against the room it blocks
+func (a *auditRepo) InsertBlocksForRoom(ctx context.Context, b models.RoomBlock) (int, error) {
+	var n int
+
+	err := a.DatabaseRepo.WithTx(ctx, func(tx repository.DatabaseRepo) error {
+		var err error
+		n, err = tx.InsertBlocksForRoom(ctx, b)
+		if err != nil {
+			return err
+		}
+
+		return recordDiff(ctx, tx, audit.Block, audit.Room, b.RoomID, nil, b)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+func (a *auditRepo) DeleteBlockForRoomById(ctx context.Context, id int) error {
+	return a.DatabaseRepo.WithTx(ctx, func(tx repository.DatabaseRepo) error {
+		if err := tx.DeleteBlockForRoomById(ctx, id); err != nil {
+			return err
+		}
+
+		return record(ctx, tx, audit.Delete, audit.RoomRestriction, id, nil)
+	})
+}
@@ -3,20 +3,36 @@
 package dbrepo
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"time"
 
+	"github.com/hd719/go-bookings/internal/blocks"
+	"github.com/hd719/go-bookings/internal/cancellation"
 	"github.com/hd719/go-bookings/internal/config"
+	"github.com/hd719/go-bookings/internal/confirmation"
+	"github.com/hd719/go-bookings/internal/models"
+	"github.com/hd719/go-bookings/internal/pricing"
 	"github.com/hd719/go-bookings/internal/repository"
+	"github.com/hd719/go-bookings/internal/status"
+	"github.com/hd719/go-bookings/internal/stayrules"
 )
 
-type postgresDBRepo struct {
-	App *config.AppConfig
-	DB  *sql.DB
+// defaultQueryTimeout is used when the app config does not set a query timeout
+const defaultQueryTimeout = 3 * time.Second
+
+// dbtx is satisfied by both *sql.DB and *sql.Tx, so the same queries can run inside or outside of a transaction
+type dbtx interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
 }
 
-type testDBRepo struct {
-	App *config.AppConfig
-	DB  *sql.DB
+type postgresDBRepo struct {
+	App  *config.AppConfig
+	DB   dbtx
+	conn *sql.DB // the connection pool, nil when the repo is bound to a transaction
 }
 
 // type mongoDBRepo struct {
@@ -25,16 +41,11 @@ type testDBRepo struct {
 // }
 
 func NewPostgresRepo(conn *sql.DB, a *config.AppConfig) repository.DatabaseRepo {
-	return &postgresDBRepo{
-		App: a,
-		DB:  conn,
-	}
-}
-
-func NewTestRepo(a *config.AppConfig) repository.DatabaseRepo {
-	return &testDBRepo{
-		App: a,
-	}
+	return audited(&postgresDBRepo{
+		App:  a,
+		DB:   conn,
+		conn: conn,
+	})
 }
 
 // func NewMongoRepo(conn *nosql.DB, a *config.AppConfig) repository.DatabaseRepo {
@@ -43,3 +54,272 @@ func NewTestRepo(a *config.AppConfig) repository.DatabaseRepo {
 // 		DB:  conn,
 // 	}
 // }
+
+// auditEntriesLimit is the most entries of the audit log returned at once
+const auditEntriesLimit = 500
+
+// queryContext derives the context for a single query from the request context, using the query timeout from the app config
+func queryContext(ctx context.Context, a *config.AppConfig) (context.Context, context.CancelFunc) {
+	timeout := defaultQueryTimeout
+	if a != nil && a.DBTimeout > 0 {
+		timeout = a.DBTimeout
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+// today returns the current date at midnight UTC, the way dates are stored in the database
+func today() time.Time {
+	return time.Now().UTC().Truncate(24 * time.Hour)
+}
+
+// statusColumns maps each status a reservation can move on to with UpdateReservationStatus to the column recording when it did
+var statusColumns = map[string]string{
+	status.Confirmed:  "confirmed_at",
+	status.CheckedIn:  "checked_in_at",
+	status.CheckedOut: "checked_out_at",
+	status.NoShow:     "no_show_at",
+}
+
+// cancelTransition returns repository.ErrReservationCancelled if a reservation with status from was already cancelled,
+// or a *status.TransitionError if it can't be cancelled from it
+func cancelTransition(from string) error {
+	if from == status.Cancelled {
+		return repository.ErrReservationCancelled
+	}
+
+	return status.Transition(from, status.Cancelled)
+}
+
+// confirmationCodeFor returns the confirmation code of res, or a new one if it doesn't have one yet
+func confirmationCodeFor(res models.Reservation) (string, error) {
+	if res.ConfirmationCode != "" {
+		return res.ConfirmationCode, nil
+	}
+
+	return confirmation.NewCode()
+}
+
+// ratesForStay prices a stay from the room and its rate plans, the pricing rules themselves live in the pricing package
+func ratesForStay(ctx context.Context, repo repository.DatabaseRepo, roomId int, start, end time.Time) (pricing.Quote, error) {
+	room, err := repo.GetRoomById(ctx, roomId)
+	if err != nil {
+		return pricing.Quote{}, err
+	}
+
+	plans, err := repo.GetRatePlansForRoomByDate(ctx, roomId, start, end)
+	if err != nil {
+		return pricing.Quote{}, err
+	}
+
+	return pricing.NewQuote(room, plans, start, end), nil
+}
+
+// cancellationTermsForStay works out the cancellation terms of a stay, the policy of the rate plan in effect on the night
+// of arrival wins over the policy of the room
+func cancellationTermsForStay(ctx context.Context, repo repository.DatabaseRepo, roomId int, start, end time.Time) (models.CancellationTerms, error) {
+	room, err := repo.GetRoomById(ctx, roomId)
+	if err != nil {
+		return models.CancellationTerms{}, err
+	}
+
+	plans, err := repo.GetRatePlansForRoomByDate(ctx, roomId, start, end)
+	if err != nil {
+		return models.CancellationTerms{}, err
+	}
+
+	policyId := room.CancellationPolicyID
+	if plan, ok := pricing.EffectivePlan(plans, start); ok && plan.CancellationPolicyID != 0 {
+		policyId = plan.CancellationPolicyID
+	}
+
+	if policyId == 0 {
+		return models.CancellationTerms{}, nil
+	}
+
+	policy, err := repo.GetCancellationPolicyById(ctx, policyId)
+	if err != nil {
+		return models.CancellationTerms{}, err
+	}
+
+	return cancellation.Terms(policy, pricing.NewQuote(room, plans, start, end)), nil
+}
+
+// checkStayRules returns a *stayrules.Violation if the stay breaks one of the stay rules of the room
+func checkStayRules(ctx context.Context, repo repository.DatabaseRepo, roomId int, start, end time.Time) error {
+	rules, err := repo.GetStayRulesForRoomByDate(ctx, roomId, start, end)
+	if err != nil {
+		return err
+	}
+
+	return stayrules.Check(rules, start, end)
+}
+
+// excludeByStayRules splits rooms that are free for a stay into the ones that can be booked and the ones a stay rule excludes
+func excludeByStayRules(ctx context.Context, repo repository.DatabaseRepo, rooms []models.Room, start, end time.Time) ([]models.Room, []models.RoomExclusion, error) {
+	var available []models.Room
+	var excluded []models.RoomExclusion
+
+	for _, room := range rooms {
+		err := checkStayRules(ctx, repo, room.ID, start, end)
+
+		var v *stayrules.Violation
+		if errors.As(err, &v) {
+			excluded = append(excluded, models.RoomExclusion{Room: room, Reason: v.Reason})
+			continue
+		}
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		available = append(available, room)
+	}
+
+	return available, excluded, nil
+}
+
+// changeReservationDates moves a reservation to new dates in one transaction, checking the stay rules and availability
+// of the room as if it was booked again and repricing it at today's rates and cancellation terms
+func changeReservationDates(ctx context.Context, repo repository.DatabaseRepo, id int, start, end time.Time, changedBy string) (models.Reservation, error) {
+	var res models.Reservation
+
+	err := repo.WithTx(ctx, func(repo repository.DatabaseRepo) error {
+		var err error
+		res, err = repo.GetReservationById(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		if res.Cancelled() {
+			return repository.ErrReservationCancelled
+		}
+
+		room, err := repo.GetRoomById(ctx, res.RoomID)
+		if err != nil {
+			return err
+		}
+
+		if !room.Active {
+			return repository.ErrRoomNotAvailable
+		}
+
+		if err := repo.CheckStayRules(ctx, res.RoomID, start, end); err != nil {
+			return err
+		}
+
+		// Free the current dates first, so the reservation doesn't stand in its own way
+		if err := repo.DeleteRestrictionsForReservation(ctx, id); err != nil {
+			return err
+		}
+
+		available, err := repo.SearchAvailabilityByDatesForRoomId(ctx, start, end, res.RoomID)
+		if err != nil {
+			return err
+		}
+
+		if !available {
+			return repository.ErrRoomNotAvailable
+		}
+
+		quote, err := repo.GetRatesForStay(ctx, res.RoomID, start, end)
+		if err != nil {
+			return err
+		}
+
+		terms, err := repo.GetCancellationTermsForStay(ctx, res.RoomID, start, end)
+		if err != nil {
+			return err
+		}
+
+		res.StartDate = start
+		res.EndDate = end
+		res.TotalPrice = quote.Total
+		res.Cancellation = terms
+		res.ChangedBy = changedBy
+
+		if err := repo.UpdateReservationDates(ctx, res); err != nil {
+			return err
+		}
+
+		_, err = repo.InsertRoomRestriction(ctx, models.RoomRestriction{
+			StartDate:     start,
+			EndDate:       end,
+			RoomID:        res.RoomID,
+			ReservationID: id,
+			RestrictionID: repository.ReservationRestrictionID,
+		})
+
+		return err
+	})
+	if err != nil {
+		return models.Reservation{}, err
+	}
+
+	return res, nil
+}
+
+// insertBlocks expands a block into its occurrences and inserts them all in one transaction, returning how many were inserted
+// Occurrences that would clash with a reservation or another block fail with a *repository.BlockConflictError
+func insertBlocks(ctx context.Context, repo repository.DatabaseRepo, b models.RoomBlock) (int, error) {
+	occurrences, err := blocks.Expand(b)
+	if err != nil {
+		return 0, err
+	}
+
+	err = repo.WithTx(ctx, func(repo repository.DatabaseRepo) error {
+		restriction, err := repo.GetRestrictionById(ctx, b.RestrictionID)
+		if err != nil {
+			return err
+		}
+
+		for _, r := range occurrences {
+			// Find the clashing occurrence up front so the admin is told which date is taken
+			if restriction.BlocksAvailability {
+				available, err := repo.SearchAvailabilityByDatesForRoomId(ctx, r.StartDate, r.EndDate, r.RoomID)
+				if err != nil {
+					return err
+				}
+
+				if !available {
+					return &repository.BlockConflictError{Date: r.StartDate}
+				}
+			}
+
+			if err := repo.InsertBlockForRoomById(ctx, r.RoomID, r.RestrictionID, r.StartDate, r.EndDate); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(occurrences), nil
+}
+
+// runInTx begins a transaction on conn and hands it to fn, the transaction is rolled back if fn returns an error or panics
+func runInTx(ctx context.Context, conn *sql.DB, fn func(tx *sql.Tx) error) (err error) {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			panic(p)
+		}
+
+		if err != nil {
+			_ = tx.Rollback()
+			return
+		}
+
+		err = tx.Commit()
+	}()
+
+	return fn(tx)
+}
@@ -0,0 +1,1875 @@
+// In-memory implementation of the DatabaseRepo, used by the handler tests and when running with -db=memory
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hd719/go-bookings/internal/cancellation"
+	"github.com/hd719/go-bookings/internal/config"
+	"github.com/hd719/go-bookings/internal/models"
+	"github.com/hd719/go-bookings/internal/pricing"
+	"github.com/hd719/go-bookings/internal/repository"
+	"github.com/hd719/go-bookings/internal/roles"
+	"github.com/hd719/go-bookings/internal/status"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type memoryDBRepo struct {
+	App *config.AppConfig
+	DB  *memoryDB
+	tx  bool // true for the repo handed to a WithTx callback, the store is already locked by WithTx
+}
+
+// memoryDB guards the tables, every method holds the lock for its whole duration
+type memoryDB struct {
+	mu     sync.Mutex
+	tables memoryTables
+}
+
+// memoryTables holds one map per database table, keyed by id
+type memoryTables struct {
+	ids              map[string]int // last id handed out per table, like a postgres sequence
+	users            map[int]models.User
+	rooms            map[int]models.Room
+	restrictions     map[int]models.Restriction
+	reservations     map[int]models.Reservation
+	roomRestrictions map[int]models.RoomRestriction
+	roomPhotos       map[int]models.RoomPhoto
+	ratePlans        map[int]models.RatePlan
+	stayRules        map[int]models.StayRule
+	policies         map[int]models.CancellationPolicy
+	auditLog         map[int]models.AuditEntry
+	passwordResets   map[int]models.PasswordReset
+	recoveryCodes    map[int]models.RecoveryCode
+}
+
+// NewMemoryRepo returns a DatabaseRepo that keeps everything in memory, seeded with the same rooms and restrictions
+// as the seed migrations and an admin@admin.com user with the password "password"
+func NewMemoryRepo(a *config.AppConfig) repository.DatabaseRepo {
+	now := time.Now()
+
+	t := memoryTables{
+		ids:              map[string]int{},
+		users:            map[int]models.User{},
+		rooms:            map[int]models.Room{},
+		restrictions:     map[int]models.Restriction{},
+		reservations:     map[int]models.Reservation{},
+		roomRestrictions: map[int]models.RoomRestriction{},
+		roomPhotos:       map[int]models.RoomPhoto{},
+		ratePlans:        map[int]models.RatePlan{},
+		stayRules:        map[int]models.StayRule{},
+		policies:         map[int]models.CancellationPolicy{},
+		auditLog:         map[int]models.AuditEntry{},
+		passwordResets:   map[int]models.PasswordReset{},
+		recoveryCodes:    map[int]models.RecoveryCode{},
+	}
+
+	for _, room := range []models.Room{
+		{RoomName: "General's Quarters", Slug: "generals-quarters", NightlyRate: 12000, WeekendRate: 15000},
+		{RoomName: "Major's Suite", Slug: "majors-suite", NightlyRate: 15000, WeekendRate: 18000},
+	} {
+		room.ID = t.nextID("rooms")
+		room.Description = "Your home away from home, set on the majestic waters of the Atlantic Ocean, this will be a vacation to remember."
+		room.Active = true
+		room.MaxOccupancy = 2
+		room.CreatedAt = now
+		room.UpdatedAt = now
+		t.rooms[room.ID] = room
+	}
+
+	for _, r := range []models.Restriction{
+		{RestrictionName: "Reservation", Color: "#dc3545"},
+		{RestrictionName: "Owner Block", Color: "#ffc107"},
+		{RestrictionName: "Maintenance", Color: "#17a2b8"},
+		{RestrictionName: "Out of Order", Color: "#343a40"},
+		{RestrictionName: "Hold", Color: "#6f42c1"},
+	} {
+		r.ID = t.nextID("restrictions")
+		r.BlocksAvailability = true
+		r.CreatedAt = now
+		r.UpdatedAt = now
+		t.restrictions[r.ID] = r
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.DefaultCost)
+	if err != nil {
+		log.Println(err)
+	}
+
+	id := t.nextID("users")
+	t.users[id] = models.User{
+		ID:        id,
+		FirstName: "admin",
+		LastName:  "admin",
+		Email:     "admin@admin.com",
+		Password:  string(hashedPassword),
+		Role:      roles.Owner,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	return audited(&memoryDBRepo{
+		App: a,
+		DB:  &memoryDB{tables: t},
+	})
+}
+
+// nextID returns the next id for a table
+func (t *memoryTables) nextID(table string) int {
+	t.ids[table]++
+	return t.ids[table]
+}
+
+// clone copies every table so a transaction can be rolled back
+func (t memoryTables) clone() memoryTables {
+	c := memoryTables{
+		ids:              make(map[string]int, len(t.ids)),
+		users:            make(map[int]models.User, len(t.users)),
+		rooms:            make(map[int]models.Room, len(t.rooms)),
+		restrictions:     make(map[int]models.Restriction, len(t.restrictions)),
+		reservations:     make(map[int]models.Reservation, len(t.reservations)),
+		roomRestrictions: make(map[int]models.RoomRestriction, len(t.roomRestrictions)),
+		roomPhotos:       make(map[int]models.RoomPhoto, len(t.roomPhotos)),
+		ratePlans:        make(map[int]models.RatePlan, len(t.ratePlans)),
+		stayRules:        make(map[int]models.StayRule, len(t.stayRules)),
+		policies:         make(map[int]models.CancellationPolicy, len(t.policies)),
+		auditLog:         make(map[int]models.AuditEntry, len(t.auditLog)),
+		passwordResets:   make(map[int]models.PasswordReset, len(t.passwordResets)),
+		recoveryCodes:    make(map[int]models.RecoveryCode, len(t.recoveryCodes)),
+	}
+
+	for k, v := range t.ids {
+		c.ids[k] = v
+	}
+	for k, v := range t.users {
+		c.users[k] = v
+	}
+	for k, v := range t.rooms {
+		c.rooms[k] = v
+	}
+	for k, v := range t.restrictions {
+		c.restrictions[k] = v
+	}
+	for k, v := range t.reservations {
+		c.reservations[k] = v
+	}
+	for k, v := range t.roomRestrictions {
+		c.roomRestrictions[k] = v
+	}
+	for k, v := range t.roomPhotos {
+		c.roomPhotos[k] = v
+	}
+	for k, v := range t.ratePlans {
+		c.ratePlans[k] = v
+	}
+	for k, v := range t.stayRules {
+		c.stayRules[k] = v
+	}
+	for k, v := range t.policies {
+		c.policies[k] = v
+	}
+	for k, v := range t.auditLog {
+		c.auditLog[k] = v
+	}
+	for k, v := range t.passwordResets {
+		c.passwordResets[k] = v
+	}
+	for k, v := range t.recoveryCodes {
+		c.recoveryCodes[k] = v
+	}
+
+	return c
+}
+
+// lock locks the store unless the repo is part of a transaction, which already holds the lock
+// Usage: defer m.lock()()
+func (m *memoryDBRepo) lock() func() {
+	if m.tx {
+		return func() {}
+	}
+
+	m.DB.mu.Lock()
+	return m.DB.mu.Unlock
+}
+
+// overlaps reports whether the range start-end overlaps a restriction that blocks availability,
+// the end date is the departure day so it is free
+func overlaps(start, end time.Time, r models.RoomRestriction) bool {
+	return r.BlocksAvailability && start.Before(r.EndDate) && end.After(r.StartDate)
+}
+
+// withRoom fills in the room of a reservation like the left join in the postgres queries
+func (m *memoryDBRepo) withRoom(res models.Reservation) models.Reservation {
+	if room, ok := m.DB.tables.rooms[res.RoomID]; ok {
+		res.Room.ID = room.ID
+		res.Room.RoomName = room.RoomName
+	}
+
+	return res
+}
+
+// Inserts a reservation
+func (m *memoryDBRepo) InsertReservation(ctx context.Context, res models.Reservation) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	defer m.lock()()
+
+	if _, ok := m.DB.tables.rooms[res.RoomID]; !ok {
+		return 0, errors.New("room doesnt exist")
+	}
+
+	code, err := confirmationCodeFor(res)
+	if err != nil {
+		return 0, err
+	}
+
+	res.ID = m.DB.tables.nextID("reservations")
+	res.ConfirmationCode = code
+	res.Status = status.Pending
+	res.CreatedAt = time.Now()
+	res.UpdatedAt = time.Now()
+	res.Room = models.Room{}
+	m.DB.tables.reservations[res.ID] = res
+
+	return res.ID, nil
+}
+
+// InsertRoomRestriction inserts a room restriction and returns its id
+func (m *memoryDBRepo) InsertRoomRestriction(ctx context.Context, r models.RoomRestriction) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	defer m.lock()()
+
+	if _, ok := m.DB.tables.rooms[r.RoomID]; !ok {
+		return 0, errors.New("room doesnt exist")
+	}
+
+	restriction, ok := m.DB.tables.restrictions[r.RestrictionID]
+	if !ok {
+		return 0, errors.New("restriction doesnt exist")
+	}
+	r.BlocksAvailability = restriction.BlocksAvailability
+
+	// Same guarantee as the exclusion constraint in postgres
+	for _, x := range m.DB.tables.roomRestrictions {
+		if r.BlocksAvailability && x.RoomID == r.RoomID && overlaps(r.StartDate, r.EndDate, x) {
+			return 0, repository.ErrRoomNotAvailable
+		}
+	}
+
+	r.ID = m.DB.tables.nextID("room_restrictions")
+	r.CreatedAt = time.Now()
+	r.UpdatedAt = time.Now()
+	m.DB.tables.roomRestrictions[r.ID] = r
+
+	return r.ID, nil
+}
+
+// BookRoom prices a reservation and inserts it and its room restriction in a single transaction
+func (m *memoryDBRepo) BookRoom(ctx context.Context, res models.Reservation) (int, error) {
+	var newId int
+
+	err := m.WithTx(ctx, func(repo repository.DatabaseRepo) error {
+		room, err := repo.GetRoomById(ctx, res.RoomID)
+		if err != nil {
+			return err
+		}
+
+		if !room.Active {
+			return repository.ErrRoomNotAvailable
+		}
+
+		if err := repo.CheckStayRules(ctx, res.RoomID, res.StartDate, res.EndDate); err != nil {
+			return err
+		}
+
+		// The price and cancellation terms come from the rates and policies at the time of booking, not from
+		// whatever the guest was shown earlier
+		quote, err := repo.GetRatesForStay(ctx, res.RoomID, res.StartDate, res.EndDate)
+		if err != nil {
+			return err
+		}
+		res.TotalPrice = quote.Total
+
+		res.Cancellation, err = repo.GetCancellationTermsForStay(ctx, res.RoomID, res.StartDate, res.EndDate)
+		if err != nil {
+			return err
+		}
+
+		newId, err = repo.InsertReservation(ctx, res)
+		if err != nil {
+			return err
+		}
+
+		_, err = repo.InsertRoomRestriction(ctx, models.RoomRestriction{
+			StartDate:     res.StartDate,
+			EndDate:       res.EndDate,
+			RoomID:        res.RoomID,
+			ReservationID: newId,
+			RestrictionID: repository.ReservationRestrictionID,
+		})
+
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return newId, nil
+}
+
+// SearchAvailabilityByDates returns true if availability exists for a specific room and false if no availability exists
+func (m *memoryDBRepo) SearchAvailabilityByDatesForRoomId(ctx context.Context, start, end time.Time, roomId int) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	defer m.lock()()
+
+	for _, r := range m.DB.tables.roomRestrictions {
+		if r.RoomID == roomId && overlaps(start, end, r) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// SearchAvailabilityForAllRooms returns the rooms that can be booked for a given date range,
+// and the rooms that are free but excluded by one of their stay rules
+func (m *memoryDBRepo) SearchAvailabilityForAllRooms(ctx context.Context, start, end time.Time) ([]models.Room, []models.RoomExclusion, error) {
+	rooms, err := m.freeRooms(ctx, start, end)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return excludeByStayRules(ctx, m, rooms, start, end)
+}
+
+// freeRooms returns the active rooms without restrictions between start and end
+func (m *memoryDBRepo) freeRooms(ctx context.Context, start, end time.Time) ([]models.Room, error) {
+	var rooms []models.Room
+	if err := ctx.Err(); err != nil {
+		return rooms, err
+	}
+	defer m.lock()()
+
+	taken := make(map[int]bool)
+	for _, r := range m.DB.tables.roomRestrictions {
+		if overlaps(start, end, r) {
+			taken[r.RoomID] = true
+		}
+	}
+
+	for _, room := range m.DB.tables.rooms {
+		if room.Active && !taken[room.ID] {
+			rooms = append(rooms, room)
+		}
+	}
+
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].ID < rooms[j].ID })
+
+	return rooms, nil
+}
+
+// GetRoomByID gets room by ID
+func (m *memoryDBRepo) GetRoomById(ctx context.Context, id int) (models.Room, error) {
+	var room models.Room
+	if err := ctx.Err(); err != nil {
+		return room, err
+	}
+	defer m.lock()()
+
+	room, ok := m.DB.tables.rooms[id]
+	if !ok {
+		return room, sql.ErrNoRows
+	}
+
+	return room, nil
+}
+
+// GetRoomBySlug gets room by the slug used in its url
+func (m *memoryDBRepo) GetRoomBySlug(ctx context.Context, slug string) (models.Room, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Room{}, err
+	}
+	defer m.lock()()
+
+	for _, room := range m.DB.tables.rooms {
+		if room.Slug == slug {
+			return room, nil
+		}
+	}
+
+	return models.Room{}, sql.ErrNoRows
+}
+
+// AllUsers returns every user, disabled ones included, ordered by name
+func (m *memoryDBRepo) AllUsers(ctx context.Context) ([]models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	defer m.lock()()
+
+	var users []models.User
+	for _, u := range m.DB.tables.users {
+		users = append(users, u)
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		if users[i].LastName != users[j].LastName {
+			return users[i].LastName < users[j].LastName
+		}
+		if users[i].FirstName != users[j].FirstName {
+			return users[i].FirstName < users[j].FirstName
+		}
+		return users[i].ID < users[j].ID
+	})
+
+	return users, nil
+}
+
+// Returns a user by Id
+func (m *memoryDBRepo) GetUserById(ctx context.Context, id int) (models.User, error) {
+	var u models.User
+	if err := ctx.Err(); err != nil {
+		return u, err
+	}
+	defer m.lock()()
+
+	u, ok := m.DB.tables.users[id]
+	if !ok {
+		return u, sql.ErrNoRows
+	}
+
+	return u, nil
+}
+
+// GetUserByEmail returns the user with email, ignoring case
+func (m *memoryDBRepo) GetUserByEmail(ctx context.Context, email string) (models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return models.User{}, err
+	}
+	defer m.lock()()
+
+	for _, u := range m.DB.tables.users {
+		if strings.EqualFold(u.Email, email) {
+			return u, nil
+		}
+	}
+
+	return models.User{}, sql.ErrNoRows
+}
+
+// emailTaken reports whether a user other than the one with the given id has email
+func (m *memoryDBRepo) emailTaken(email string, id int) bool {
+	for _, u := range m.DB.tables.users {
+		if u.ID != id && strings.EqualFold(u.Email, email) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// InsertUser adds a user and returns its id, the password is the hash to store, empty for a user that still has to set one
+func (m *memoryDBRepo) InsertUser(ctx context.Context, u models.User) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	defer m.lock()()
+
+	if m.emailTaken(u.Email, 0) {
+		return 0, repository.ErrEmailTaken
+	}
+
+	u.ID = m.DB.tables.nextID("users")
+	u.DisabledAt = time.Time{}
+	u.CreatedAt = time.Now()
+	u.UpdatedAt = u.CreatedAt
+	m.DB.tables.users[u.ID] = u
+
+	return u.ID, nil
+}
+
+// Updates the name, email and role of a user
+func (m *memoryDBRepo) UpdateUser(ctx context.Context, u models.User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	defer m.lock()()
+
+	existing, ok := m.DB.tables.users[u.ID]
+	if !ok {
+		return nil
+	}
+
+	if m.emailTaken(u.Email, u.ID) {
+		return repository.ErrEmailTaken
+	}
+
+	existing.FirstName = u.FirstName
+	existing.LastName = u.LastName
+	existing.Email = u.Email
+	existing.Role = u.Role
+	existing.UpdatedAt = time.Now()
+	m.DB.tables.users[u.ID] = existing
+
+	return nil
+}
+
+// UpdateUserPassword hashes password and stores it as the password of a user, the sessions of the user are logged out
+func (m *memoryDBRepo) UpdateUserPassword(ctx context.Context, id int, password string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	defer m.lock()()
+
+	u, ok := m.DB.tables.users[id]
+	if !ok {
+		return nil
+	}
+
+	u.Password = string(hashedPassword)
+	u.SessionVersion++
+	u.UpdatedAt = time.Now()
+	m.DB.tables.users[id] = u
+
+	return nil
+}
+
+// SetUserDisabled disables a user, so they can no longer log in, or enables them again
+func (m *memoryDBRepo) SetUserDisabled(ctx context.Context, id int, disabled bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	defer m.lock()()
+
+	u, ok := m.DB.tables.users[id]
+	if !ok {
+		return nil
+	}
+
+	u.DisabledAt = time.Time{}
+	if disabled {
+		u.DisabledAt = time.Now()
+	}
+	u.UpdatedAt = time.Now()
+	m.DB.tables.users[id] = u
+
+	return nil
+}
+
+// Authenticates a user
+func (m *memoryDBRepo) Authenticate(ctx context.Context, email, testPassword string) (int, string, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, "", err
+	}
+	defer m.lock()()
+
+	for _, u := range m.DB.tables.users {
+		if u.Email != email || u.Disabled() {
+			continue
+		}
+
+		err := bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(testPassword))
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return 0, "", errors.New("incorrect password")
+		} else if err != nil {
+			return 0, "", err
+		}
+
+		return u.ID, u.Password, nil
+	}
+
+	return 0, "", sql.ErrNoRows
+}
+
+// InsertPasswordReset stores the hash of a password reset token
+func (m *memoryDBRepo) InsertPasswordReset(ctx context.Context, p models.PasswordReset) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	defer m.lock()()
+
+	p.ID = m.DB.tables.nextID("password_resets")
+	p.UsedAt = time.Time{}
+	p.CreatedAt = time.Now()
+	m.DB.tables.passwordResets[p.ID] = p
+
+	return nil
+}
+
+// GetPasswordReset returns the password reset with the hash of a token, used and expired ones included
+func (m *memoryDBRepo) GetPasswordReset(ctx context.Context, tokenHash string) (models.PasswordReset, error) {
+	if err := ctx.Err(); err != nil {
+		return models.PasswordReset{}, err
+	}
+	defer m.lock()()
+
+	for _, p := range m.DB.tables.passwordResets {
+		if p.TokenHash == tokenHash {
+			return p, nil
+		}
+	}
+
+	return models.PasswordReset{}, sql.ErrNoRows
+}
+
+// UsePasswordReset marks the token with the hash as used and returns the id of its user, the other tokens of the user
+// stop working too. Returns repository.ErrPasswordResetInvalid if the token is unknown, expired or already used
+func (m *memoryDBRepo) UsePasswordReset(ctx context.Context, tokenHash string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	defer m.lock()()
+
+	now := time.Now()
+
+	userId := 0
+	for _, p := range m.DB.tables.passwordResets {
+		if p.TokenHash == tokenHash && p.Usable(now) {
+			userId = p.UserID
+		}
+	}
+
+	if userId == 0 {
+		return 0, repository.ErrPasswordResetInvalid
+	}
+
+	for id, p := range m.DB.tables.passwordResets {
+		if p.UserID == userId && p.UsedAt.IsZero() {
+			p.UsedAt = now
+			m.DB.tables.passwordResets[id] = p
+		}
+	}
+
+	return userId, nil
+}
+
+// SetUserTOTPSecret turns on two-factor authentication for a user with secret, or turns it off with an empty one
+func (m *memoryDBRepo) SetUserTOTPSecret(ctx context.Context, id int, secret string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	defer m.lock()()
+
+	u, ok := m.DB.tables.users[id]
+	if !ok {
+		return nil
+	}
+
+	u.TOTPSecret = secret
+	u.TOTPLastStep = 0
+	u.UpdatedAt = time.Now()
+	m.DB.tables.users[id] = u
+
+	return nil
+}
+
+// UseUserTOTPStep records that a user logged in with the code of step, it returns false if a code of that step or a
+// later one was used already
+func (m *memoryDBRepo) UseUserTOTPStep(ctx context.Context, id int, step int64) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	defer m.lock()()
+
+	u, ok := m.DB.tables.users[id]
+	if !ok || u.TOTPLastStep >= step {
+		return false, nil
+	}
+
+	u.TOTPLastStep = step
+	m.DB.tables.users[id] = u
+
+	return true, nil
+}
+
+// ReplaceRecoveryCodes stores the hashes as the recovery codes of a user, the codes they had before stop working
+func (m *memoryDBRepo) ReplaceRecoveryCodes(ctx context.Context, userId int, hashes []string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	defer m.lock()()
+
+	for id, c := range m.DB.tables.recoveryCodes {
+		if c.UserID == userId {
+			delete(m.DB.tables.recoveryCodes, id)
+		}
+	}
+
+	for _, hash := range hashes {
+		id := m.DB.tables.nextID("recovery_codes")
+		m.DB.tables.recoveryCodes[id] = models.RecoveryCode{ID: id, UserID: userId, CodeHash: hash, CreatedAt: time.Now()}
+	}
+
+	return nil
+}
+
+// UnusedRecoveryCodes returns the recovery codes of a user that can still be used
+func (m *memoryDBRepo) UnusedRecoveryCodes(ctx context.Context, userId int) ([]models.RecoveryCode, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	defer m.lock()()
+
+	var codes []models.RecoveryCode
+	for _, c := range m.DB.tables.recoveryCodes {
+		if c.UserID == userId && c.UsedAt.IsZero() {
+			codes = append(codes, c)
+		}
+	}
+
+	sort.Slice(codes, func(i, j int) bool { return codes[i].ID < codes[j].ID })
+
+	return codes, nil
+}
+
+// UseRecoveryCode marks a recovery code as used, it returns false if it was used already
+func (m *memoryDBRepo) UseRecoveryCode(ctx context.Context, id int) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	defer m.lock()()
+
+	c, ok := m.DB.tables.recoveryCodes[id]
+	if !ok || !c.UsedAt.IsZero() {
+		return false, nil
+	}
+
+	c.UsedAt = time.Now()
+	m.DB.tables.recoveryCodes[id] = c
+
+	return true, nil
+}
+
+// reservationsWhere returns the reservations matching keep, ordered by start date like the postgres queries
+func (m *memoryDBRepo) reservationsWhere(keep func(models.Reservation) bool) []models.Reservation {
+	var reservations []models.Reservation
+
+	for _, res := range m.DB.tables.reservations {
+		if keep(res) {
+			reservations = append(reservations, m.withRoom(res))
+		}
+	}
+
+	sort.Slice(reservations, func(i, j int) bool {
+		if reservations[i].StartDate.Equal(reservations[j].StartDate) {
+			return reservations[i].ID < reservations[j].ID
+		}
+		return reservations[i].StartDate.Before(reservations[j].StartDate)
+	})
+
+	return reservations
+}
+
+// Returns a slice of all ressys
+func (m *memoryDBRepo) AllReservations(ctx context.Context) ([]models.Reservation, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	defer m.lock()()
+
+	return m.reservationsWhere(func(res models.Reservation) bool { return !res.Deleted() }), nil
+}
+
+// Returns new reservations, the ones still pending
+func (m *memoryDBRepo) AllNewReservations(ctx context.Context) ([]models.Reservation, error) {
+	return m.ReservationsByStatus(ctx, status.Pending)
+}
+
+// ReservationsByStatus returns the reservations with the given status
+func (m *memoryDBRepo) ReservationsByStatus(ctx context.Context, s string) ([]models.Reservation, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	defer m.lock()()
+
+	return m.reservationsWhere(func(res models.Reservation) bool { return !res.Deleted() && res.Status == s }), nil
+}
+
+// DeletedReservations returns the reservations in the trash
+func (m *memoryDBRepo) DeletedReservations(ctx context.Context) ([]models.Reservation, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	defer m.lock()()
+
+	return m.reservationsWhere(func(res models.Reservation) bool { return res.Deleted() }), nil
+}
+
+// Returns 1 reservation by id
+func (m *memoryDBRepo) GetReservationById(ctx context.Context, id int) (models.Reservation, error) {
+	var res models.Reservation
+	if err := ctx.Err(); err != nil {
+		return res, err
+	}
+	defer m.lock()()
+
+	res, ok := m.DB.tables.reservations[id]
+	if !ok || res.Deleted() {
+		return models.Reservation{}, sql.ErrNoRows
+	}
+
+	return m.withRoom(res), nil
+}
+
+// GetReservationByConfirmationCode returns the reservation with the given code, if it was made with the given email
+func (m *memoryDBRepo) GetReservationByConfirmationCode(ctx context.Context, code, email string) (models.Reservation, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Reservation{}, err
+	}
+	defer m.lock()()
+
+	for _, res := range m.DB.tables.reservations {
+		if !res.Deleted() && res.ConfirmationCode == code && strings.EqualFold(res.Email, email) {
+			return m.withRoom(res), nil
+		}
+	}
+
+	return models.Reservation{}, sql.ErrNoRows
+}
+
+// Update Reservation
+func (m *memoryDBRepo) UpdateReservation(ctx context.Context, u models.Reservation) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	defer m.lock()()
+
+	res, ok := m.DB.tables.reservations[u.ID]
+	if !ok {
+		return nil
+	}
+
+	res.FirstName = u.FirstName
+	res.LastName = u.LastName
+	res.Email = u.Email
+	res.Phone = u.Phone
+	res.ChangedBy = u.ChangedBy
+	res.UpdatedAt = time.Now()
+	m.DB.tables.reservations[u.ID] = res
+
+	return nil
+}
+
+// UpdateReservationDates saves the dates, price and cancellation terms of a reservation, the room restriction holding its dates is left as is
+func (m *memoryDBRepo) UpdateReservationDates(ctx context.Context, u models.Reservation) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	defer m.lock()()
+
+	res, ok := m.DB.tables.reservations[u.ID]
+	if !ok {
+		return nil
+	}
+
+	res.StartDate = u.StartDate
+	res.EndDate = u.EndDate
+	res.TotalPrice = u.TotalPrice
+	res.Cancellation = u.Cancellation
+	res.ChangedBy = u.ChangedBy
+	res.UpdatedAt = time.Now()
+	m.DB.tables.reservations[u.ID] = res
+
+	return nil
+}
+
+// ChangeReservationDates moves a reservation to new dates and reprices it, together with the room restriction holding its dates
+func (m *memoryDBRepo) ChangeReservationDates(ctx context.Context, id int, start, end time.Time, changedBy string) (models.Reservation, error) {
+	return changeReservationDates(ctx, m, id, start, end, changedBy)
+}
+
+// CancelReservation marks a reservation as cancelled, frees its dates and returns the fee charged under its cancellation terms
+func (m *memoryDBRepo) CancelReservation(ctx context.Context, id int, changedBy string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	defer m.lock()()
+
+	res, ok := m.DB.tables.reservations[id]
+	if !ok || res.Deleted() {
+		return 0, sql.ErrNoRows
+	}
+
+	if err := cancelTransition(res.Status); err != nil {
+		return 0, err
+	}
+
+	res.Status = status.Cancelled
+	res.CancelledAt = time.Now()
+	res.CancellationFee = cancellation.Fee(res.Cancellation, res.StartDate, res.CancelledAt)
+	res.ChangedBy = changedBy
+	res.UpdatedAt = res.CancelledAt
+	m.DB.tables.reservations[id] = res
+
+	for rid, r := range m.DB.tables.roomRestrictions {
+		if r.ReservationID == id {
+			delete(m.DB.tables.roomRestrictions, rid)
+		}
+	}
+
+	return res.CancellationFee, nil
+}
+
+// DeleteReservation moves a reservation to the trash and frees its dates, it is removed for good by PurgeDeletedReservations
+func (m *memoryDBRepo) DeleteReservation(ctx context.Context, id int, deletedBy, reason string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	defer m.lock()()
+
+	res, ok := m.DB.tables.reservations[id]
+	if !ok || res.Deleted() {
+		return sql.ErrNoRows
+	}
+
+	res.DeletedAt = time.Now()
+	res.DeletedBy = deletedBy
+	res.DeleteReason = reason
+	res.UpdatedAt = res.DeletedAt
+	m.DB.tables.reservations[id] = res
+
+	for rrId, r := range m.DB.tables.roomRestrictions {
+		if r.ReservationID == id {
+			delete(m.DB.tables.roomRestrictions, rrId)
+		}
+	}
+
+	return nil
+}
+
+// RestoreReservation takes a reservation out of the trash and holds its dates again, unless it was cancelled
+func (m *memoryDBRepo) RestoreReservation(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	defer m.lock()()
+
+	res, ok := m.DB.tables.reservations[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+
+	if !res.Deleted() {
+		return nil
+	}
+
+	if res.Status != status.Cancelled {
+		r := models.RoomRestriction{
+			StartDate:          res.StartDate,
+			EndDate:            res.EndDate,
+			RoomID:             res.RoomID,
+			ReservationID:      id,
+			RestrictionID:      repository.ReservationRestrictionID,
+			BlocksAvailability: m.DB.tables.restrictions[repository.ReservationRestrictionID].BlocksAvailability,
+		}
+
+		for _, x := range m.DB.tables.roomRestrictions {
+			if r.BlocksAvailability && x.RoomID == r.RoomID && overlaps(r.StartDate, r.EndDate, x) {
+				return repository.ErrRoomNotAvailable
+			}
+		}
+
+		r.ID = m.DB.tables.nextID("room_restrictions")
+		r.CreatedAt = time.Now()
+		r.UpdatedAt = r.CreatedAt
+		m.DB.tables.roomRestrictions[r.ID] = r
+	}
+
+	res.DeletedAt = time.Time{}
+	res.DeletedBy = ""
+	res.DeleteReason = ""
+	res.UpdatedAt = time.Now()
+	m.DB.tables.reservations[id] = res
+
+	return nil
+}
+
+// PurgeDeletedReservations removes the reservations that were moved to the trash before the given time for good and returns how many
+func (m *memoryDBRepo) PurgeDeletedReservations(ctx context.Context, before time.Time) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	defer m.lock()()
+
+	purged := 0
+	for id, res := range m.DB.tables.reservations {
+		if res.Deleted() && res.DeletedAt.Before(before) {
+			delete(m.DB.tables.reservations, id)
+			purged++
+		}
+	}
+
+	return purged, nil
+}
+
+// Deletes the room restrictions that belong to a reservation
+func (m *memoryDBRepo) DeleteRestrictionsForReservation(ctx context.Context, reservationId int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	defer m.lock()()
+
+	for id, r := range m.DB.tables.roomRestrictions {
+		if r.ReservationID == reservationId {
+			delete(m.DB.tables.roomRestrictions, id)
+		}
+	}
+
+	return nil
+}
+
+// UpdateReservationStatus moves a reservation on to another status and records when it did, cancelling goes through CancelReservation
+func (m *memoryDBRepo) UpdateReservationStatus(ctx context.Context, id int, to, changedBy string) error {
+	if to == status.Cancelled {
+		_, err := m.CancelReservation(ctx, id, changedBy)
+		return err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	defer m.lock()()
+
+	res, ok := m.DB.tables.reservations[id]
+	if !ok || res.Deleted() {
+		return sql.ErrNoRows
+	}
+
+	if err := status.Transition(res.Status, to); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	switch to {
+	case status.Confirmed:
+		res.ConfirmedAt = now
+	case status.CheckedIn:
+		res.CheckedInAt = now
+	case status.CheckedOut:
+		res.CheckedOutAt = now
+	case status.NoShow:
+		res.NoShowAt = now
+	}
+
+	res.Status = to
+	res.ChangedBy = changedBy
+	res.UpdatedAt = now
+	m.DB.tables.reservations[id] = res
+
+	return nil
+}
+
+func (m *memoryDBRepo) AllRooms(ctx context.Context) ([]models.Room, error) {
+	var rooms []models.Room
+	if err := ctx.Err(); err != nil {
+		return rooms, err
+	}
+	defer m.lock()()
+
+	for _, room := range m.DB.tables.rooms {
+		rooms = append(rooms, room)
+	}
+
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].RoomName < rooms[j].RoomName })
+
+	return rooms, nil
+}
+
+// checkSlug does the job of the unique index on rooms.slug
+func (m *memoryDBRepo) checkSlug(room models.Room) error {
+	for _, x := range m.DB.tables.rooms {
+		if x.Slug == room.Slug && x.ID != room.ID {
+			return errors.New("duplicate key value violates unique constraint rooms_slug_idx")
+		}
+	}
+
+	return nil
+}
+
+// Inserts a room and returns its id
+func (m *memoryDBRepo) InsertRoom(ctx context.Context, room models.Room) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	defer m.lock()()
+
+	if err := m.checkSlug(room); err != nil {
+		return 0, err
+	}
+
+	room.ID = m.DB.tables.nextID("rooms")
+	room.CreatedAt = time.Now()
+	room.UpdatedAt = time.Now()
+	m.DB.tables.rooms[room.ID] = room
+
+	return room.ID, nil
+}
+
+// Updates a room
+func (m *memoryDBRepo) UpdateRoom(ctx context.Context, room models.Room) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	defer m.lock()()
+
+	existing, ok := m.DB.tables.rooms[room.ID]
+	if !ok {
+		return nil
+	}
+
+	if err := m.checkSlug(room); err != nil {
+		return err
+	}
+
+	existing.RoomName = room.RoomName
+	existing.Slug = room.Slug
+	existing.Description = room.Description
+	existing.Active = room.Active
+	existing.MaxOccupancy = room.MaxOccupancy
+	existing.Beds = room.Beds
+	existing.Amenities = room.Amenities
+	existing.NightlyRate = room.NightlyRate
+	existing.WeekendRate = room.WeekendRate
+	existing.CancellationPolicyID = room.CancellationPolicyID
+	existing.UpdatedAt = time.Now()
+	m.DB.tables.rooms[room.ID] = existing
+
+	return nil
+}
+
+// Deletes a room along with its restrictions, photos, rate plans and stay rules, like the cascading foreign keys in
+// postgres. A room with reservations can't be deleted
+func (m *memoryDBRepo) DeleteRoom(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	defer m.lock()()
+
+	for _, res := range m.DB.tables.reservations {
+		if res.RoomID == id {
+			return repository.ErrRoomHasReservations
+		}
+	}
+
+	if _, ok := m.DB.tables.rooms[id]; !ok {
+		return sql.ErrNoRows
+	}
+
+	delete(m.DB.tables.rooms, id)
+	for rrId, r := range m.DB.tables.roomRestrictions {
+		if r.RoomID == id {
+			delete(m.DB.tables.roomRestrictions, rrId)
+		}
+	}
+	for photoId, p := range m.DB.tables.roomPhotos {
+		if p.RoomID == id {
+			delete(m.DB.tables.roomPhotos, photoId)
+		}
+	}
+	for planId, p := range m.DB.tables.ratePlans {
+		if p.RoomID == id {
+			delete(m.DB.tables.ratePlans, planId)
+		}
+	}
+	for ruleId, r := range m.DB.tables.stayRules {
+		if r.RoomID == id {
+			delete(m.DB.tables.stayRules, ruleId)
+		}
+	}
+
+	return nil
+}
+
+// Returns the photos of a room in gallery order
+func (m *memoryDBRepo) GetPhotosForRoom(ctx context.Context, roomId int) ([]models.RoomPhoto, error) {
+	var photos []models.RoomPhoto
+	if err := ctx.Err(); err != nil {
+		return photos, err
+	}
+	defer m.lock()()
+
+	for _, p := range m.DB.tables.roomPhotos {
+		if p.RoomID == roomId {
+			photos = append(photos, p)
+		}
+	}
+
+	sort.Slice(photos, func(i, j int) bool {
+		if photos[i].SortOrder == photos[j].SortOrder {
+			return photos[i].ID < photos[j].ID
+		}
+		return photos[i].SortOrder < photos[j].SortOrder
+	})
+
+	return photos, nil
+}
+
+// Returns a room photo by id
+func (m *memoryDBRepo) GetRoomPhotoById(ctx context.Context, id int) (models.RoomPhoto, error) {
+	if err := ctx.Err(); err != nil {
+		return models.RoomPhoto{}, err
+	}
+	defer m.lock()()
+
+	p, ok := m.DB.tables.roomPhotos[id]
+	if !ok {
+		return p, sql.ErrNoRows
+	}
+
+	return p, nil
+}
+
+// Inserts a room photo at the end of the gallery and returns its id
+func (m *memoryDBRepo) InsertRoomPhoto(ctx context.Context, p models.RoomPhoto) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	defer m.lock()()
+
+	if _, ok := m.DB.tables.rooms[p.RoomID]; !ok {
+		return 0, errors.New("room doesnt exist")
+	}
+
+	p.SortOrder = 0
+	for _, x := range m.DB.tables.roomPhotos {
+		if x.RoomID == p.RoomID && x.SortOrder > p.SortOrder {
+			p.SortOrder = x.SortOrder
+		}
+	}
+	p.SortOrder++
+
+	p.ID = m.DB.tables.nextID("room_photos")
+	p.CreatedAt = time.Now()
+	p.UpdatedAt = time.Now()
+	m.DB.tables.roomPhotos[p.ID] = p
+
+	return p.ID, nil
+}
+
+// Updates the caption and position of a room photo
+func (m *memoryDBRepo) UpdateRoomPhoto(ctx context.Context, p models.RoomPhoto) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	defer m.lock()()
+
+	existing, ok := m.DB.tables.roomPhotos[p.ID]
+	if !ok {
+		return nil
+	}
+
+	existing.Caption = p.Caption
+	existing.SortOrder = p.SortOrder
+	existing.UpdatedAt = time.Now()
+	m.DB.tables.roomPhotos[p.ID] = existing
+
+	return nil
+}
+
+// Deletes a room photo, the file itself is removed by the caller
+func (m *memoryDBRepo) DeleteRoomPhoto(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	defer m.lock()()
+
+	delete(m.DB.tables.roomPhotos, id)
+
+	return nil
+}
+
+// ratePlansWhere returns the rate plans matching keep ordered by their first night
+func (m *memoryDBRepo) ratePlansWhere(keep func(p models.RatePlan) bool) []models.RatePlan {
+	var plans []models.RatePlan
+	for _, p := range m.DB.tables.ratePlans {
+		if keep(p) {
+			plans = append(plans, p)
+		}
+	}
+
+	sort.Slice(plans, func(i, j int) bool {
+		if plans[i].StartDate.Equal(plans[j].StartDate) {
+			return plans[i].ID < plans[j].ID
+		}
+		return plans[i].StartDate.Before(plans[j].StartDate)
+	})
+
+	return plans
+}
+
+// Returns all the rate plans of a room ordered by their first night
+func (m *memoryDBRepo) GetRatePlansForRoom(ctx context.Context, roomId int) ([]models.RatePlan, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	defer m.lock()()
+
+	return m.ratePlansWhere(func(p models.RatePlan) bool { return p.RoomID == roomId }), nil
+}
+
+// Returns the rate plans of a room that cover at least one night of a stay from start to end
+func (m *memoryDBRepo) GetRatePlansForRoomByDate(ctx context.Context, roomId int, start, end time.Time) ([]models.RatePlan, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	defer m.lock()()
+
+	return m.ratePlansWhere(func(p models.RatePlan) bool {
+		return p.RoomID == roomId && p.StartDate.Before(end) && !p.EndDate.Before(start)
+	}), nil
+}
+
+// Returns a rate plan by id
+func (m *memoryDBRepo) GetRatePlanById(ctx context.Context, id int) (models.RatePlan, error) {
+	if err := ctx.Err(); err != nil {
+		return models.RatePlan{}, err
+	}
+	defer m.lock()()
+
+	p, ok := m.DB.tables.ratePlans[id]
+	if !ok {
+		return p, sql.ErrNoRows
+	}
+
+	return p, nil
+}
+
+// Inserts a rate plan and returns its id
+func (m *memoryDBRepo) InsertRatePlan(ctx context.Context, p models.RatePlan) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	defer m.lock()()
+
+	if _, ok := m.DB.tables.rooms[p.RoomID]; !ok {
+		return 0, errors.New("room doesnt exist")
+	}
+
+	p.ID = m.DB.tables.nextID("rate_plans")
+	p.CreatedAt = time.Now()
+	p.UpdatedAt = time.Now()
+	m.DB.tables.ratePlans[p.ID] = p
+
+	return p.ID, nil
+}
+
+// Updates a rate plan
+func (m *memoryDBRepo) UpdateRatePlan(ctx context.Context, p models.RatePlan) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	defer m.lock()()
+
+	existing, ok := m.DB.tables.ratePlans[p.ID]
+	if !ok {
+		return nil
+	}
+
+	existing.Name = p.Name
+	existing.StartDate = p.StartDate
+	existing.EndDate = p.EndDate
+	existing.NightlyRate = p.NightlyRate
+	existing.DayMultipliers = p.DayMultipliers
+	existing.Priority = p.Priority
+	existing.CancellationPolicyID = p.CancellationPolicyID
+	existing.UpdatedAt = time.Now()
+	m.DB.tables.ratePlans[p.ID] = existing
+
+	return nil
+}
+
+// Deletes a rate plan
+func (m *memoryDBRepo) DeleteRatePlan(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	defer m.lock()()
+
+	delete(m.DB.tables.ratePlans, id)
+
+	return nil
+}
+
+// Returns the price of each night of a stay in a room, taking its rate plans into account
+func (m *memoryDBRepo) GetRatesForStay(ctx context.Context, roomId int, start, end time.Time) (pricing.Quote, error) {
+	return ratesForStay(ctx, m, roomId, start, end)
+}
+
+// stayRulesWhere returns the stay rules matching keep, the ones that always apply first, then by their first day
+func (m *memoryDBRepo) stayRulesWhere(keep func(r models.StayRule) bool) []models.StayRule {
+	var rules []models.StayRule
+	for _, r := range m.DB.tables.stayRules {
+		if keep(r) {
+			rules = append(rules, r)
+		}
+	}
+
+	sort.Slice(rules, func(i, j int) bool {
+		if rules[i].StartDate.Equal(rules[j].StartDate) {
+			return rules[i].ID < rules[j].ID
+		}
+		return rules[i].StartDate.Before(rules[j].StartDate)
+	})
+
+	return rules
+}
+
+// Returns all the stay rules of a room, the ones that always apply first
+func (m *memoryDBRepo) GetStayRulesForRoom(ctx context.Context, roomId int) ([]models.StayRule, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	defer m.lock()()
+
+	return m.stayRulesWhere(func(r models.StayRule) bool { return r.RoomID == roomId }), nil
+}
+
+// Returns the stay rules of a room that apply on any day from start to end, including the departure day
+func (m *memoryDBRepo) GetStayRulesForRoomByDate(ctx context.Context, roomId int, start, end time.Time) ([]models.StayRule, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	defer m.lock()()
+
+	return m.stayRulesWhere(func(r models.StayRule) bool {
+		return r.RoomID == roomId && (r.StartDate.IsZero() || (!r.EndDate.Before(start) && !r.StartDate.After(end)))
+	}), nil
+}
+
+// Returns a stay rule by id
+func (m *memoryDBRepo) GetStayRuleById(ctx context.Context, id int) (models.StayRule, error) {
+	if err := ctx.Err(); err != nil {
+		return models.StayRule{}, err
+	}
+	defer m.lock()()
+
+	r, ok := m.DB.tables.stayRules[id]
+	if !ok {
+		return r, sql.ErrNoRows
+	}
+
+	return r, nil
+}
+
+// Inserts a stay rule and returns its id
+func (m *memoryDBRepo) InsertStayRule(ctx context.Context, r models.StayRule) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	defer m.lock()()
+
+	if _, ok := m.DB.tables.rooms[r.RoomID]; !ok {
+		return 0, errors.New("room doesnt exist")
+	}
+
+	r.ID = m.DB.tables.nextID("stay_rules")
+	r.CreatedAt = time.Now()
+	r.UpdatedAt = time.Now()
+	m.DB.tables.stayRules[r.ID] = r
+
+	return r.ID, nil
+}
+
+// Updates a stay rule
+func (m *memoryDBRepo) UpdateStayRule(ctx context.Context, r models.StayRule) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	defer m.lock()()
+
+	existing, ok := m.DB.tables.stayRules[r.ID]
+	if !ok {
+		return nil
+	}
+
+	existing.StartDate = r.StartDate
+	existing.EndDate = r.EndDate
+	existing.MinNights = r.MinNights
+	existing.MaxNights = r.MaxNights
+	existing.ClosedToArrival = r.ClosedToArrival
+	existing.ClosedToDeparture = r.ClosedToDeparture
+	existing.ArrivalDays = r.ArrivalDays
+	existing.UpdatedAt = time.Now()
+	m.DB.tables.stayRules[r.ID] = existing
+
+	return nil
+}
+
+// Deletes a stay rule
+func (m *memoryDBRepo) DeleteStayRule(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	defer m.lock()()
+
+	delete(m.DB.tables.stayRules, id)
+
+	return nil
+}
+
+// Returns a *stayrules.Violation if a stay in a room breaks one of its stay rules
+func (m *memoryDBRepo) CheckStayRules(ctx context.Context, roomId int, start, end time.Time) error {
+	return checkStayRules(ctx, m, roomId, start, end)
+}
+
+// Returns all cancellation policies ordered by name
+func (m *memoryDBRepo) AllCancellationPolicies(ctx context.Context) ([]models.CancellationPolicy, error) {
+	var policies []models.CancellationPolicy
+	if err := ctx.Err(); err != nil {
+		return policies, err
+	}
+	defer m.lock()()
+
+	for _, p := range m.DB.tables.policies {
+		policies = append(policies, p)
+	}
+
+	sort.Slice(policies, func(i, j int) bool {
+		if policies[i].Name != policies[j].Name {
+			return policies[i].Name < policies[j].Name
+		}
+		return policies[i].ID < policies[j].ID
+	})
+
+	return policies, nil
+}
+
+// Returns a cancellation policy by id
+func (m *memoryDBRepo) GetCancellationPolicyById(ctx context.Context, id int) (models.CancellationPolicy, error) {
+	if err := ctx.Err(); err != nil {
+		return models.CancellationPolicy{}, err
+	}
+	defer m.lock()()
+
+	p, ok := m.DB.tables.policies[id]
+	if !ok {
+		return p, sql.ErrNoRows
+	}
+
+	return p, nil
+}
+
+// Inserts a cancellation policy and returns its id
+func (m *memoryDBRepo) InsertCancellationPolicy(ctx context.Context, p models.CancellationPolicy) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	defer m.lock()()
+
+	p.ID = m.DB.tables.nextID("cancellation_policies")
+	p.CreatedAt = time.Now()
+	p.UpdatedAt = time.Now()
+	m.DB.tables.policies[p.ID] = p
+
+	return p.ID, nil
+}
+
+// Updates a cancellation policy, reservations already made keep the terms they were booked with
+func (m *memoryDBRepo) UpdateCancellationPolicy(ctx context.Context, p models.CancellationPolicy) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	defer m.lock()()
+
+	existing, ok := m.DB.tables.policies[p.ID]
+	if !ok {
+		return nil
+	}
+
+	existing.Name = p.Name
+	existing.FreeDays = p.FreeDays
+	existing.FeeType = p.FeeType
+	existing.FeePercent = p.FeePercent
+	existing.UpdatedAt = time.Now()
+	m.DB.tables.policies[p.ID] = existing
+
+	return nil
+}
+
+// Deletes a cancellation policy, the rooms and rate plans using it go back to free cancellation
+func (m *memoryDBRepo) DeleteCancellationPolicy(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	defer m.lock()()
+
+	for roomId, room := range m.DB.tables.rooms {
+		if room.CancellationPolicyID == id {
+			room.CancellationPolicyID = 0
+			m.DB.tables.rooms[roomId] = room
+		}
+	}
+
+	for planId, p := range m.DB.tables.ratePlans {
+		if p.CancellationPolicyID == id {
+			p.CancellationPolicyID = 0
+			m.DB.tables.ratePlans[planId] = p
+		}
+	}
+
+	delete(m.DB.tables.policies, id)
+
+	return nil
+}
+
+// Returns the cancellation terms a stay in a room would be booked with
+func (m *memoryDBRepo) GetCancellationTermsForStay(ctx context.Context, roomId int, start, end time.Time) (models.CancellationTerms, error) {
+	return cancellationTermsForStay(ctx, m, roomId, start, end)
+}
+
+// Returns all restriction types ordered by id
+func (m *memoryDBRepo) AllRestrictions(ctx context.Context) ([]models.Restriction, error) {
+	var restrictions []models.Restriction
+	if err := ctx.Err(); err != nil {
+		return restrictions, err
+	}
+	defer m.lock()()
+
+	for _, r := range m.DB.tables.restrictions {
+		restrictions = append(restrictions, r)
+	}
+
+	sort.Slice(restrictions, func(i, j int) bool { return restrictions[i].ID < restrictions[j].ID })
+
+	return restrictions, nil
+}
+
+// Returns a restriction type by id
+func (m *memoryDBRepo) GetRestrictionById(ctx context.Context, id int) (models.Restriction, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Restriction{}, err
+	}
+	defer m.lock()()
+
+	r, ok := m.DB.tables.restrictions[id]
+	if !ok {
+		return r, sql.ErrNoRows
+	}
+
+	return r, nil
+}
+
+// Inserts a restriction type and returns its id
+func (m *memoryDBRepo) InsertRestriction(ctx context.Context, r models.Restriction) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	defer m.lock()()
+
+	r.ID = m.DB.tables.nextID("restrictions")
+	r.CreatedAt = time.Now()
+	r.UpdatedAt = time.Now()
+	m.DB.tables.restrictions[r.ID] = r
+
+	return r.ID, nil
+}
+
+// Updates a restriction type and the room restrictions of that type
+// Returns repository.ErrRoomNotAvailable if the type starts blocking availability on dates that are already taken
+func (m *memoryDBRepo) UpdateRestriction(ctx context.Context, r models.Restriction) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	defer m.lock()()
+
+	existing, ok := m.DB.tables.restrictions[r.ID]
+	if !ok {
+		return nil
+	}
+
+	// Same guarantee as the exclusion constraint in postgres
+	if r.BlocksAvailability && !existing.BlocksAvailability {
+		for _, x := range m.DB.tables.roomRestrictions {
+			if x.RestrictionID != r.ID {
+				continue
+			}
+
+			for _, y := range m.DB.tables.roomRestrictions {
+				if y.ID != x.ID && y.RoomID == x.RoomID && (y.BlocksAvailability || y.RestrictionID == r.ID) &&
+					x.StartDate.Before(y.EndDate) && x.EndDate.After(y.StartDate) {
+					return repository.ErrRoomNotAvailable
+				}
+			}
+		}
+	}
+
+	existing.RestrictionName = r.RestrictionName
+	existing.Color = r.Color
+	existing.BlocksAvailability = r.BlocksAvailability
+	existing.UpdatedAt = time.Now()
+	m.DB.tables.restrictions[r.ID] = existing
+
+	for id, x := range m.DB.tables.roomRestrictions {
+		if x.RestrictionID == r.ID {
+			x.BlocksAvailability = r.BlocksAvailability
+			m.DB.tables.roomRestrictions[id] = x
+		}
+	}
+
+	return nil
+}
+
+// Deletes a restriction type, returns repository.ErrRestrictionInUse if rooms are still restricted with it
+func (m *memoryDBRepo) DeleteRestriction(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	defer m.lock()()
+
+	for _, x := range m.DB.tables.roomRestrictions {
+		if x.RestrictionID == id {
+			return repository.ErrRestrictionInUse
+		}
+	}
+
+	delete(m.DB.tables.restrictions, id)
+
+	return nil
+}
+
+// Returns restrictions for a room by date range
+func (m *memoryDBRepo) GetRestrictionsForRoomByDate(ctx context.Context, roomId int, start, end time.Time) ([]models.RoomRestriction, error) {
+	var restrictions []models.RoomRestriction
+	if err := ctx.Err(); err != nil {
+		return restrictions, err
+	}
+	defer m.lock()()
+
+	for _, r := range m.DB.tables.roomRestrictions {
+		if r.RoomID == roomId && start.Before(r.EndDate) && !end.Before(r.StartDate) {
+			restriction := m.DB.tables.restrictions[r.RestrictionID]
+			restrictions = append(restrictions, models.RoomRestriction{
+				ID:                 r.ID,
+				ReservationID:      r.ReservationID,
+				RestrictionID:      r.RestrictionID,
+				RoomID:             r.RoomID,
+				StartDate:          r.StartDate,
+				EndDate:            r.EndDate,
+				BlocksAvailability: r.BlocksAvailability,
+				Restriction: models.Restriction{
+					ID:                 restriction.ID,
+					RestrictionName:    restriction.RestrictionName,
+					Color:              restriction.Color,
+					BlocksAvailability: restriction.BlocksAvailability,
+				},
+			})
+		}
+	}
+
+	sort.Slice(restrictions, func(i, j int) bool { return restrictions[i].ID < restrictions[j].ID })
+
+	return restrictions, nil
+}
+
+// InsertBlockRoom inserts a room restriction of the given restriction type from startDate until endDate
+func (m *memoryDBRepo) InsertBlockForRoomById(ctx context.Context, id, restrictionId int, startDate, endDate time.Time) error {
+	_, err := m.InsertRoomRestriction(ctx, models.RoomRestriction{
+		StartDate:     startDate,
+		EndDate:       endDate,
+		RoomID:        id,
+		RestrictionID: restrictionId,
+	})
+
+	return err
+}
+
+// InsertBlocksForRoom inserts every occurrence of a block in a single transaction and returns how many were inserted
+func (m *memoryDBRepo) InsertBlocksForRoom(ctx context.Context, b models.RoomBlock) (int, error) {
+	return insertBlocks(ctx, m, b)
+}
+
+// DeleteBlockRoom deletes a room restriction
+func (m *memoryDBRepo) DeleteBlockForRoomById(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	defer m.lock()()
+
+	delete(m.DB.tables.roomRestrictions, id)
+
+	return nil
+}
+
+// InsertAuditEntry appends an entry to the audit log
+func (m *memoryDBRepo) InsertAuditEntry(ctx context.Context, e models.AuditEntry) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	defer m.lock()()
+
+	e.ID = m.DB.tables.nextID("audit_log")
+	m.DB.tables.auditLog[e.ID] = e
+
+	return nil
+}
+
+// AuditEntries returns the latest entries of the audit log matching f, newest first
+func (m *memoryDBRepo) AuditEntries(ctx context.Context, f models.AuditFilter) ([]models.AuditEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	defer m.lock()()
+
+	var entries []models.AuditEntry
+	for _, e := range m.DB.tables.auditLog {
+		if (f.Entity == "" || e.Entity == f.Entity) && (f.EntityID == 0 || e.EntityID == f.EntityID) && (f.Actor == "" || e.Actor == f.Actor) {
+			entries = append(entries, e)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ID > entries[j].ID })
+	if len(entries) > auditEntriesLimit {
+		entries = entries[:auditEntriesLimit]
+	}
+
+	return entries, nil
+}
+
+// WithTx runs fn with the store locked, if fn fails or panics every table is restored to how it was before
+func (m *memoryDBRepo) WithTx(ctx context.Context, fn func(repo repository.DatabaseRepo) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if m.tx {
+		return fn(m)
+	}
+
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+
+	snapshot := m.DB.tables.clone()
+
+	committed := false
+	defer func() {
+		if !committed {
+			m.DB.tables = snapshot
+		}
+	}()
+
+	err := fn(&memoryDBRepo{App: m.App, DB: m.DB, tx: true})
+	if err != nil {
+		return err
+	}
+
+	committed = true
+	return nil
+}
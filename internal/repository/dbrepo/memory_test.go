@@ -0,0 +1,1335 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hd719/go-bookings/internal/audit"
+	"github.com/hd719/go-bookings/internal/blocks"
+	"github.com/hd719/go-bookings/internal/cancellation"
+	"github.com/hd719/go-bookings/internal/config"
+	"github.com/hd719/go-bookings/internal/models"
+	"github.com/hd719/go-bookings/internal/repository"
+	"github.com/hd719/go-bookings/internal/roles"
+	"github.com/hd719/go-bookings/internal/status"
+	"github.com/hd719/go-bookings/internal/stayrules"
+)
+
+func date(s string) time.Time {
+	t, _ := time.Parse("2006-01-02", s)
+	return t
+}
+
+func TestMemoryDBRepo_BookRoom(t *testing.T) {
+	testBookRoom(t, NewMemoryRepo(&config.AppConfig{}))
+}
+
+func TestMemoryDBRepo_WithTx(t *testing.T) {
+	testWithTx(t, NewMemoryRepo(&config.AppConfig{}))
+}
+
+// testBookRoom checks the overlap rules of BookRoom, it is shared by every DatabaseRepo implementation
+func testBookRoom(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	quote, err := repo.GetRatesForStay(ctx, 1, date("2050-01-01"), date("2050-01-04"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a price the guest was shown before the rates changed isn't trusted, the stay is priced again
+	id, err := repo.BookRoom(ctx, models.Reservation{RoomID: 1, StartDate: date("2050-01-01"), EndDate: date("2050-01-04"), TotalPrice: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := repo.GetReservationById(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if quote.Total == 0 || res.TotalPrice != quote.Total {
+		t.Errorf("expected the total price to be %d but got %d", quote.Total, res.TotalPrice)
+	}
+
+	var tests = []struct {
+		name      string
+		start     string
+		end       string
+		available bool
+	}{
+		{"same dates", "2050-01-01", "2050-01-04", false},
+		{"starts inside", "2050-01-03", "2050-01-06", false},
+		{"ends inside", "2049-12-30", "2050-01-02", false},
+		{"covers", "2049-12-30", "2050-01-06", false},
+		{"arrives on departure day", "2050-01-04", "2050-01-06", true},
+		{"departs on arrival day", "2049-12-30", "2050-01-01", true},
+	}
+
+	for _, e := range tests {
+		available, err := repo.SearchAvailabilityByDatesForRoomId(ctx, date(e.start), date(e.end), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if available != e.available {
+			t.Errorf("%s: expected available to be %t but got %t", e.name, e.available, available)
+		}
+
+		_, err = repo.BookRoom(ctx, models.Reservation{RoomID: 1, StartDate: date(e.start), EndDate: date(e.end)})
+		if !e.available && !errors.Is(err, repository.ErrRoomNotAvailable) {
+			t.Errorf("%s: expected ErrRoomNotAvailable but got %v", e.name, err)
+		}
+	}
+
+	// the other room is not affected
+	rooms, _, _ := repo.SearchAvailabilityForAllRooms(ctx, date("2050-01-01"), date("2050-01-04"))
+	if len(rooms) != 1 || rooms[0].ID != 2 {
+		t.Errorf("expected only room 2 to be available but got %v", rooms)
+	}
+}
+
+// testWithTx checks that WithTx rolls back on errors and panics
+func testWithTx(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	// a failing transaction leaves nothing behind
+	err := repo.WithTx(ctx, func(tx repository.DatabaseRepo) error {
+		if _, err := tx.InsertReservation(ctx, models.Reservation{RoomID: 1}); err != nil {
+			return err
+		}
+		return errors.New("something went wrong")
+	})
+	if err == nil {
+		t.Error("expected the error returned by fn")
+	}
+
+	reservations, _ := repo.AllReservations(ctx)
+	if len(reservations) != 0 {
+		t.Errorf("expected rollback but found %d reservations", len(reservations))
+	}
+
+	// and so does a panicking one
+	func() {
+		defer func() { _ = recover() }()
+		_ = repo.WithTx(ctx, func(tx repository.DatabaseRepo) error {
+			_, _ = tx.InsertReservation(ctx, models.Reservation{RoomID: 1})
+			panic("boom")
+		})
+	}()
+
+	reservations, _ = repo.AllReservations(ctx)
+	if len(reservations) != 0 {
+		t.Errorf("expected rollback after panic but found %d reservations", len(reservations))
+	}
+
+	// a cancelled context is honoured
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := repo.GetRoomById(cancelled, 1); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled but got %v", err)
+	}
+}
+
+func TestMemoryDBRepo_DeleteRoom(t *testing.T) {
+	testDeleteRoom(t, NewMemoryRepo(&config.AppConfig{}))
+}
+
+// testDeleteRoom checks that rooms with reservations can't be deleted, whether they are upcoming, past or in the trash
+func testDeleteRoom(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name      string
+		slug      string
+		startDate string
+		trashed   bool
+	}{
+		{"upcoming", "upcoming-cabin", "2050-01-01", false},
+		{"past", "past-cabin", "2020-01-01", false},
+		{"in the trash", "trashed-cabin", "2050-01-01", true},
+	}
+
+	for _, e := range tests {
+		id, err := repo.InsertRoom(ctx, models.Room{RoomName: "Colonel's Cabin", Slug: e.slug, Active: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resId, err := repo.BookRoom(ctx, models.Reservation{RoomID: id, StartDate: date(e.startDate), EndDate: date(e.startDate).AddDate(0, 0, 3)})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if e.trashed {
+			if err := repo.DeleteReservation(ctx, resId, "admin@admin.com", ""); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := repo.DeleteRoom(ctx, id); !errors.Is(err, repository.ErrRoomHasReservations) {
+			t.Errorf("%s: expected ErrRoomHasReservations but got %v", e.name, err)
+		}
+
+		if e.trashed {
+			if err := repo.RestoreReservation(ctx, resId); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if _, err := repo.GetReservationById(ctx, resId); err != nil {
+			t.Errorf("%s: reservation is gone after deleting its room was refused: %v", e.name, err)
+		}
+	}
+
+	id, _ := repo.InsertRoom(ctx, models.Room{RoomName: "Captain's Corner", Slug: "captains-corner", Active: true})
+	if err := repo.DeleteRoom(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := repo.GetRoomById(ctx, id); err == nil {
+		t.Error("room still exists after it was deleted")
+	}
+
+	if err := repo.DeleteRoom(ctx, id); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows deleting a room that doesn't exist but got %v", err)
+	}
+}
+
+func TestMemoryDBRepo_RoomPhotos(t *testing.T) {
+	testRoomPhotos(t, NewMemoryRepo(&config.AppConfig{}))
+}
+
+// testRoomPhotos checks the room details round trip and that photos are appended in order and go with their room
+func testRoomPhotos(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	id, err := repo.InsertRoom(ctx, models.Room{
+		RoomName:     "Colonel's Cabin",
+		Slug:         "colonels-cabin",
+		Active:       true,
+		MaxOccupancy: 4,
+		Beds:         "2 queen beds",
+		Amenities:    []string{"Wifi", "Sea view"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	room, err := repo.GetRoomById(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if room.MaxOccupancy != 4 || room.Beds != "2 queen beds" || len(room.Amenities) != 2 || room.Amenities[1] != "Sea view" {
+		t.Errorf("room details did not round trip, got %+v", room)
+	}
+
+	for _, name := range []string{"a.jpg", "b.jpg", "c.jpg"} {
+		if _, err := repo.InsertRoomPhoto(ctx, models.RoomPhoto{RoomID: id, FileName: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	photos, err := repo.GetPhotosForRoom(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(photos) != 3 || photos[0].FileName != "a.jpg" || photos[2].FileName != "c.jpg" {
+		t.Fatalf("expected the photos in upload order but got %+v", photos)
+	}
+
+	// move the last photo to the front
+	photos[2].SortOrder = 0
+	photos[2].Caption = "The view"
+	if err := repo.UpdateRoomPhoto(ctx, photos[2]); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.DeleteRoomPhoto(ctx, photos[1].ID); err != nil {
+		t.Fatal(err)
+	}
+
+	photos, _ = repo.GetPhotosForRoom(ctx, id)
+	if len(photos) != 2 || photos[0].FileName != "c.jpg" || photos[0].Caption != "The view" {
+		t.Errorf("expected c.jpg with its caption first but got %+v", photos)
+	}
+
+	if err := repo.DeleteRoom(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := repo.GetRoomPhotoById(ctx, photos[0].ID); err == nil {
+		t.Error("photo still exists after its room was deleted")
+	}
+}
+
+func TestMemoryDBRepo_RatePlans(t *testing.T) {
+	testRatePlans(t, NewMemoryRepo(&config.AppConfig{}))
+}
+
+// testRatePlans checks that rate plans are found by date and used to price a stay
+func testRatePlans(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	id, err := repo.InsertRoom(ctx, models.Room{RoomName: "Colonel's Cabin", Slug: "colonels-cabin", Active: true, NightlyRate: 10000})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	summer := models.RatePlan{
+		RoomID:         id,
+		Name:           "Summer",
+		StartDate:      date("2050-06-01"),
+		EndDate:        date("2050-08-31"),
+		NightlyRate:    20000,
+		DayMultipliers: [7]int{100, 100, 100, 100, 100, 150, 150},
+	}
+
+	summerId, err := repo.InsertRatePlan(ctx, summer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := repo.GetRatePlanById(ctx, summerId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if plan.Name != "Summer" || plan.DayMultipliers != summer.DayMultipliers || !plan.EndDate.Equal(summer.EndDate) {
+		t.Errorf("rate plan did not round trip, got %+v", plan)
+	}
+
+	// a stay that leaves on the first night of the plan is not covered by it
+	plans, _ := repo.GetRatePlansForRoomByDate(ctx, id, date("2050-05-29"), date("2050-06-01"))
+	if len(plans) != 0 {
+		t.Errorf("expected no rate plans but got %d", len(plans))
+	}
+
+	plans, _ = repo.GetRatePlansForRoomByDate(ctx, id, date("2050-08-31"), date("2050-09-02"))
+	if len(plans) != 1 {
+		t.Errorf("expected the summer plan to cover its last night but got %d plans", len(plans))
+	}
+
+	// the night before the season, then Wednesday and Thursday, then a Friday at 150%
+	quote, err := repo.GetRatesForStay(ctx, id, date("2050-05-31"), date("2050-06-04"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if quote.Total != 10000+20000+20000+30000 {
+		t.Errorf("expected a total of 80000 but got %d for %+v", quote.Total, quote.Nights)
+	}
+
+	plan.Priority = 2
+	plan.NightlyRate = 25000
+	if err := repo.UpdateRatePlan(ctx, plan); err != nil {
+		t.Fatal(err)
+	}
+
+	plan, _ = repo.GetRatePlanById(ctx, summerId)
+	if plan.Priority != 2 || plan.NightlyRate != 25000 {
+		t.Errorf("expected the rate plan to be updated but got %+v", plan)
+	}
+
+	if err := repo.DeleteRatePlan(ctx, summerId); err != nil {
+		t.Fatal(err)
+	}
+
+	plans, _ = repo.GetRatePlansForRoom(ctx, id)
+	if len(plans) != 0 {
+		t.Errorf("expected the rate plan to be deleted but got %d plans", len(plans))
+	}
+}
+
+func TestMemoryDBRepo_StayRules(t *testing.T) {
+	testStayRules(t, NewMemoryRepo(&config.AppConfig{}))
+}
+
+// testStayRules checks that stay rules exclude rooms from the search and stop them from being booked
+func testStayRules(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	// at least 3 nights all year, and arrivals on Saturdays only over the summer
+	allYear := models.StayRule{RoomID: 1, MinNights: 3}
+	summer := models.StayRule{RoomID: 1, StartDate: date("2050-07-01"), EndDate: date("2050-08-31"), ArrivalDays: []time.Weekday{time.Saturday}}
+
+	if _, err := repo.InsertStayRule(ctx, allYear); err != nil {
+		t.Fatal(err)
+	}
+
+	summerId, err := repo.InsertStayRule(ctx, summer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rule, err := repo.GetStayRuleById(ctx, summerId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rule.ArrivalDays) != 1 || rule.ArrivalDays[0] != time.Saturday || !rule.EndDate.Equal(summer.EndDate) {
+		t.Errorf("stay rule did not round trip, got %+v", rule)
+	}
+
+	rules, _ := repo.GetStayRulesForRoom(ctx, 1)
+	if len(rules) != 2 || !rules[0].StartDate.IsZero() {
+		t.Errorf("expected the all year rule first but got %+v", rules)
+	}
+
+	// a stay leaving on the first day of the summer rule is still covered by it, for closed to departure
+	rules, _ = repo.GetStayRulesForRoomByDate(ctx, 1, date("2050-06-27"), date("2050-07-01"))
+	if len(rules) != 2 {
+		t.Errorf("expected 2 stay rules but got %d", len(rules))
+	}
+
+	rules, _ = repo.GetStayRulesForRoomByDate(ctx, 1, date("2050-09-01"), date("2050-09-04"))
+	if len(rules) != 1 {
+		t.Errorf("expected only the all year rule but got %d", len(rules))
+	}
+
+	// two nights is too short for room 1, room 2 has no rules
+	rooms, exclusions, err := repo.SearchAvailabilityForAllRooms(ctx, date("2050-05-02"), date("2050-05-04"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rooms) != 1 || rooms[0].ID != 2 {
+		t.Errorf("expected only room 2 to be available but got %v", rooms)
+	}
+
+	if len(exclusions) != 1 || exclusions[0].Room.ID != 1 || exclusions[0].Reason == "" {
+		t.Errorf("expected room 1 to be excluded with a reason but got %+v", exclusions)
+	}
+
+	// a summer stay arriving on a Monday breaks the arrival days
+	_, err = repo.BookRoom(ctx, models.Reservation{RoomID: 1, StartDate: date("2050-07-04"), EndDate: date("2050-07-09")})
+
+	var v *stayrules.Violation
+	if !errors.As(err, &v) || v.Rule.ID != summerId {
+		t.Errorf("expected the summer rule to be broken but got %v", err)
+	}
+
+	if _, err := repo.BookRoom(ctx, models.Reservation{RoomID: 1, StartDate: date("2050-07-02"), EndDate: date("2050-07-09")}); err != nil {
+		t.Errorf("expected a Saturday arrival to be booked but got %v", err)
+	}
+
+	rule.ArrivalDays = nil
+	rule.ClosedToArrival = true
+	if err := repo.UpdateStayRule(ctx, rule); err != nil {
+		t.Fatal(err)
+	}
+
+	rule, _ = repo.GetStayRuleById(ctx, summerId)
+	if !rule.ClosedToArrival || len(rule.ArrivalDays) != 0 {
+		t.Errorf("expected the stay rule to be updated but got %+v", rule)
+	}
+
+	if err := repo.DeleteStayRule(ctx, summerId); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.CheckStayRules(ctx, 1, date("2050-07-11"), date("2050-07-14")); err != nil {
+		t.Errorf("expected the stay to be allowed once the summer rule is deleted but got %v", err)
+	}
+}
+
+func TestMemoryDBRepo_Restrictions(t *testing.T) {
+	testRestrictions(t, NewMemoryRepo(&config.AppConfig{}))
+}
+
+// testRestrictions checks that restriction types decide whether their blocks take a room off the market
+func testRestrictions(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	noteId, err := repo.InsertRestriction(ctx, models.Restriction{RestrictionName: "Deep Clean", Color: "#20c997"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	note, err := repo.GetRestrictionById(ctx, noteId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if note.RestrictionName != "Deep Clean" || note.Color != "#20c997" || note.BlocksAvailability {
+		t.Errorf("restriction did not round trip, got %+v", note)
+	}
+
+	// a block that doesn't block availability leaves the room bookable
+	if err := repo.InsertBlockForRoomById(ctx, 1, noteId, date("2050-02-01"), date("2050-02-02")); err != nil {
+		t.Fatal(err)
+	}
+
+	if available, _ := repo.SearchAvailabilityByDatesForRoomId(ctx, date("2050-02-01"), date("2050-02-03"), 1); !available {
+		t.Error("expected the room to be available over a non blocking restriction")
+	}
+
+	if _, err := repo.BookRoom(ctx, models.Reservation{RoomID: 1, StartDate: date("2050-02-01"), EndDate: date("2050-02-03")}); err != nil {
+		t.Fatalf("expected the room to be booked over a non blocking restriction but got %v", err)
+	}
+
+	restrictions, _ := repo.GetRestrictionsForRoomByDate(ctx, 1, date("2050-02-01"), date("2050-02-02"))
+	if len(restrictions) != 2 {
+		t.Fatalf("expected the block and the reservation but got %+v", restrictions)
+	}
+
+	for _, r := range restrictions {
+		if r.ReservationID == 0 && (r.Restriction.Color != "#20c997" || r.BlocksAvailability) {
+			t.Errorf("expected the block to come with its type but got %+v", r)
+		}
+	}
+
+	// the type can't start blocking while one of its blocks overlaps a reservation
+	note.BlocksAvailability = true
+	if err := repo.UpdateRestriction(ctx, note); !errors.Is(err, repository.ErrRoomNotAvailable) {
+		t.Errorf("expected ErrRoomNotAvailable but got %v", err)
+	}
+
+	if err := repo.DeleteRestriction(ctx, noteId); !errors.Is(err, repository.ErrRestrictionInUse) {
+		t.Errorf("expected ErrRestrictionInUse but got %v", err)
+	}
+
+	// once the block is gone the type can be changed and deleted
+	if err := repo.DeleteBlockForRoomById(ctx, restrictionIdOf(restrictions)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.UpdateRestriction(ctx, note); err != nil {
+		t.Fatal(err)
+	}
+
+	// blocks of the type now take the room off the market
+	if err := repo.InsertBlockForRoomById(ctx, 2, noteId, date("2050-02-01"), date("2050-02-02")); err != nil {
+		t.Fatal(err)
+	}
+
+	if available, _ := repo.SearchAvailabilityByDatesForRoomId(ctx, date("2050-02-01"), date("2050-02-03"), 2); available {
+		t.Error("expected the room to be blocked")
+	}
+}
+
+func TestMemoryDBRepo_RoomBlocks(t *testing.T) {
+	testRoomBlocks(t, NewMemoryRepo(&config.AppConfig{}))
+}
+
+// testRoomBlocks checks that multi-day and recurring blocks are inserted together or not at all
+func testRoomBlocks(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	if _, err := repo.BookRoom(ctx, models.Reservation{RoomID: 1, StartDate: date("2050-09-20"), EndDate: date("2050-09-22")}); err != nil {
+		t.Fatal(err)
+	}
+
+	// every Tuesday and Wednesday clashes with the reservation on the 20th, so none of them are added
+	_, err := repo.InsertBlocksForRoom(ctx, models.RoomBlock{
+		RoomID: 1, RestrictionID: 2, StartDate: date("2050-09-06"), EndDate: date("2050-09-07"), RepeatWeeks: 1, RepeatUntil: date("2050-09-30"),
+	})
+
+	var conflict *repository.BlockConflictError
+	if !errors.As(err, &conflict) || !conflict.Date.Equal(date("2050-09-20")) || !errors.Is(err, repository.ErrRoomNotAvailable) {
+		t.Errorf("expected a conflict on September 20 but got %v", err)
+	}
+
+	if available, _ := repo.SearchAvailabilityByDatesForRoomId(ctx, date("2050-09-06"), date("2050-09-07"), 1); !available {
+		t.Error("expected none of the clashing blocks to be added")
+	}
+
+	// every Monday fits around the reservation
+	n, err := repo.InsertBlocksForRoom(ctx, models.RoomBlock{
+		RoomID: 1, RestrictionID: 2, StartDate: date("2050-09-05"), EndDate: date("2050-09-05"), RepeatWeeks: 1, RepeatUntil: date("2050-09-26"),
+	})
+	if err != nil || n != 4 {
+		t.Fatalf("expected 4 blocks but got %d, %v", n, err)
+	}
+
+	if available, _ := repo.SearchAvailabilityByDatesForRoomId(ctx, date("2050-09-12"), date("2050-09-13"), 1); available {
+		t.Error("expected the room to be blocked on a Monday")
+	}
+
+	if available, _ := repo.SearchAvailabilityByDatesForRoomId(ctx, date("2050-09-13"), date("2050-09-15"), 1); !available {
+		t.Error("expected the room to be available between the Mondays")
+	}
+
+	// a range is a single room restriction covering every day of it
+	if n, err := repo.InsertBlocksForRoom(ctx, models.RoomBlock{RoomID: 1, RestrictionID: 2, StartDate: date("2050-10-03"), EndDate: date("2050-10-09")}); err != nil || n != 1 {
+		t.Fatalf("expected 1 block but got %d, %v", n, err)
+	}
+
+	restrictions, _ := repo.GetRestrictionsForRoomByDate(ctx, 1, date("2050-10-01"), date("2050-10-31"))
+	if len(restrictions) != 1 || !restrictions[0].StartDate.Equal(date("2050-10-03")) || !restrictions[0].EndDate.Equal(date("2050-10-10")) {
+		t.Errorf("expected one block from October 3 to 10 but got %+v", restrictions)
+	}
+
+	if _, err := repo.InsertBlocksForRoom(ctx, models.RoomBlock{RoomID: 1, RestrictionID: 2, StartDate: date("2050-11-02"), EndDate: date("2050-11-01")}); err != blocks.ErrEndBeforeStart {
+		t.Errorf("expected ErrEndBeforeStart but got %v", err)
+	}
+}
+
+func TestMemoryDBRepo_ConfirmationCodes(t *testing.T) {
+	testConfirmationCodes(t, NewMemoryRepo(&config.AppConfig{}))
+}
+
+// testConfirmationCodes checks that every reservation gets its own code and can only be looked up with the matching email
+func testConfirmationCodes(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	firstId, err := repo.BookRoom(ctx, models.Reservation{RoomID: 1, Email: "guest@example.com", StartDate: date("2050-06-01"), EndDate: date("2050-06-03")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secondId, err := repo.BookRoom(ctx, models.Reservation{RoomID: 1, Email: "other@example.com", StartDate: date("2050-06-03"), EndDate: date("2050-06-05")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, _ := repo.GetReservationById(ctx, firstId)
+	second, _ := repo.GetReservationById(ctx, secondId)
+	if first.ConfirmationCode == "" || first.ConfirmationCode == second.ConfirmationCode {
+		t.Fatalf("expected two different codes but got %q and %q", first.ConfirmationCode, second.ConfirmationCode)
+	}
+
+	res, err := repo.GetReservationByConfirmationCode(ctx, first.ConfirmationCode, "Guest@Example.com")
+	if err != nil || res.ID != firstId || res.Room.RoomName == "" {
+		t.Errorf("expected to find the first reservation with its room but got %+v, %v", res, err)
+	}
+
+	if _, err := repo.GetReservationByConfirmationCode(ctx, first.ConfirmationCode, "other@example.com"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for the wrong email but got %v", err)
+	}
+
+	// a code picked by the caller is kept
+	id, err := repo.BookRoom(ctx, models.Reservation{RoomID: 2, Email: "guest@example.com", StartDate: date("2050-06-01"), EndDate: date("2050-06-03"), ConfirmationCode: "ABCDEFGHJK"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res, _ := repo.GetReservationByConfirmationCode(ctx, "ABCDEFGHJK", "guest@example.com"); res.ID != id {
+		t.Errorf("expected the given code to be kept but got %+v", res)
+	}
+}
+
+func TestMemoryDBRepo_ChangeReservation(t *testing.T) {
+	testChangeReservation(t, NewMemoryRepo(&config.AppConfig{}))
+}
+
+// testChangeReservation checks that moving and cancelling a reservation keep its room restriction in step
+func testChangeReservation(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	firstId, err := repo.BookRoom(ctx, models.Reservation{RoomID: 1, Email: "first@example.com", StartDate: date("2050-08-01"), EndDate: date("2050-08-03")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secondId, err := repo.BookRoom(ctx, models.Reservation{RoomID: 1, Email: "second@example.com", StartDate: date("2050-08-05"), EndDate: date("2050-08-07")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// moving over its own dates is fine
+	res, err := repo.ChangeReservationDates(ctx, firstId, date("2050-08-02"), date("2050-08-04"), "guest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !res.StartDate.Equal(date("2050-08-02")) || res.TotalPrice == 0 || res.ChangedBy != "guest" {
+		t.Errorf("expected the reservation to be moved and repriced but got %+v", res)
+	}
+
+	if available, _ := repo.SearchAvailabilityByDatesForRoomId(ctx, date("2050-08-01"), date("2050-08-02"), 1); !available {
+		t.Error("expected the old first night to be free")
+	}
+
+	// moving onto the second reservation fails and leaves the first where it was
+	if _, err := repo.ChangeReservationDates(ctx, firstId, date("2050-08-04"), date("2050-08-06"), "guest"); !errors.Is(err, repository.ErrRoomNotAvailable) {
+		t.Errorf("expected ErrRoomNotAvailable but got %v", err)
+	}
+
+	if available, _ := repo.SearchAvailabilityByDatesForRoomId(ctx, date("2050-08-02"), date("2050-08-04"), 1); available {
+		t.Error("expected the first reservation to still hold its dates")
+	}
+
+	// cancelling frees the dates and can only happen once
+	if _, err := repo.CancelReservation(ctx, secondId, "guest"); err != nil {
+		t.Fatal(err)
+	}
+
+	if available, _ := repo.SearchAvailabilityByDatesForRoomId(ctx, date("2050-08-05"), date("2050-08-07"), 1); !available {
+		t.Error("expected the dates of the cancelled reservation to be free")
+	}
+
+	if res, _ := repo.GetReservationById(ctx, secondId); !res.Cancelled() || res.ChangedBy != "guest" {
+		t.Errorf("expected the reservation to be cancelled by the guest but got %+v", res)
+	}
+
+	if _, err := repo.CancelReservation(ctx, secondId, "guest"); !errors.Is(err, repository.ErrReservationCancelled) {
+		t.Errorf("expected ErrReservationCancelled but got %v", err)
+	}
+
+	if _, err := repo.ChangeReservationDates(ctx, secondId, date("2050-08-10"), date("2050-08-12"), "guest"); !errors.Is(err, repository.ErrReservationCancelled) {
+		t.Errorf("expected ErrReservationCancelled but got %v", err)
+	}
+}
+
+func TestMemoryDBRepo_CancellationPolicies(t *testing.T) {
+	testCancellationPolicies(t, NewMemoryRepo(&config.AppConfig{}))
+}
+
+// testCancellationPolicies checks which policy a stay gets, that reservations keep their terms and the fee charged on cancelling
+func testCancellationPolicies(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	strictId, err := repo.InsertCancellationPolicy(ctx, models.CancellationPolicy{Name: "Strict", FreeDays: 7, FeeType: cancellation.FeePercent, FeePercent: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peakId, err := repo.InsertCancellationPolicy(ctx, models.CancellationPolicy{Name: "Peak", FreeDays: 14, FeeType: cancellation.FeeFirstNight})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roomId, err := repo.InsertRoom(ctx, models.Room{RoomName: "Brigadier's Bunk", Slug: "brigadiers-bunk", Active: true, NightlyRate: 10000, CancellationPolicyID: strictId})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	planId, err := repo.InsertRatePlan(ctx, models.RatePlan{
+		RoomID:               roomId,
+		Name:                 "Peak",
+		StartDate:            date("2050-12-20"),
+		EndDate:              date("2050-12-31"),
+		NightlyRate:          20000,
+		DayMultipliers:       [7]int{100, 100, 100, 100, 100, 100, 100},
+		CancellationPolicyID: peakId,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the room's policy, half of two nights
+	terms, err := repo.GetCancellationTermsForStay(ctx, roomId, date("2050-12-01"), date("2050-12-03"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if terms != (models.CancellationTerms{Policy: "Strict", FreeDays: 7, LateFee: 10000}) {
+		t.Errorf("expected the terms of the room's policy but got %+v", terms)
+	}
+
+	// arriving on a night of the plan, the first night at the plan's rate
+	terms, err = repo.GetCancellationTermsForStay(ctx, roomId, date("2050-12-24"), date("2050-12-26"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if terms != (models.CancellationTerms{Policy: "Peak", FreeDays: 14, LateFee: 20000}) {
+		t.Errorf("expected the terms of the plan's policy but got %+v", terms)
+	}
+
+	// the reservation keeps the terms it was booked with when the policy changes
+	id, err := repo.BookRoom(ctx, models.Reservation{RoomID: roomId, Email: "guest@example.com", StartDate: date("2050-12-24"), EndDate: date("2050-12-26"), Cancellation: terms})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.UpdateCancellationPolicy(ctx, models.CancellationPolicy{ID: peakId, Name: "Peak", FreeDays: 30, FeeType: cancellation.FeePercent, FeePercent: 100}); err != nil {
+		t.Fatal(err)
+	}
+
+	if res, _ := repo.GetReservationById(ctx, id); res.Cancellation != terms {
+		t.Errorf("expected the reservation to keep its terms but got %+v", res.Cancellation)
+	}
+
+	// cancelling well before the deadline is free
+	fee, err := repo.CancelReservation(ctx, id, "guest")
+	if err != nil || fee != 0 {
+		t.Errorf("expected a free cancellation but got %d, %v", fee, err)
+	}
+
+	// cancelling after the deadline charges the late fee
+	arrival := today().AddDate(0, 0, 3)
+	lateId, err := repo.BookRoom(ctx, models.Reservation{RoomID: roomId, Email: "late@example.com", StartDate: arrival, EndDate: arrival.AddDate(0, 0, 2),
+		Cancellation: models.CancellationTerms{Policy: "Strict", FreeDays: 7, LateFee: 10000}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fee, err = repo.CancelReservation(ctx, lateId, "admin@admin.com")
+	if err != nil || fee != 10000 {
+		t.Errorf("expected a fee of 10000 but got %d, %v", fee, err)
+	}
+
+	if res, _ := repo.GetReservationById(ctx, lateId); res.CancellationFee != 10000 {
+		t.Errorf("expected the fee to be saved but got %+v", res)
+	}
+
+	// deleting a policy takes it off the rooms and rate plans that use it
+	if err := repo.DeleteCancellationPolicy(ctx, strictId); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.DeleteCancellationPolicy(ctx, peakId); err != nil {
+		t.Fatal(err)
+	}
+
+	room, _ := repo.GetRoomById(ctx, roomId)
+	plan, _ := repo.GetRatePlanById(ctx, planId)
+	if room.CancellationPolicyID != 0 || plan.CancellationPolicyID != 0 {
+		t.Errorf("expected the policies to be removed but the room has %d and the plan %d", room.CancellationPolicyID, plan.CancellationPolicyID)
+	}
+
+	if terms, _ := repo.GetCancellationTermsForStay(ctx, roomId, date("2050-12-24"), date("2050-12-26")); terms != (models.CancellationTerms{}) {
+		t.Errorf("expected free cancellation but got %+v", terms)
+	}
+}
+
+func TestMemoryDBRepo_ReservationStatus(t *testing.T) {
+	testReservationStatus(t, NewMemoryRepo(&config.AppConfig{}))
+}
+
+// testReservationStatus walks reservations through their statuses and checks the changes that aren't allowed fail
+func testReservationStatus(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	roomId, err := repo.InsertRoom(ctx, models.Room{RoomName: "Marshal's Manor", Slug: "marshals-manor", Active: true, NightlyRate: 10000})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := repo.BookRoom(ctx, models.Reservation{RoomID: roomId, Email: "guest@example.com", StartDate: date("2050-05-01"), EndDate: date("2050-05-03")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res, _ := repo.GetReservationById(ctx, id); res.Status != status.Pending {
+		t.Errorf("expected a new reservation to be pending but got %q", res.Status)
+	}
+
+	if pending, _ := repo.AllNewReservations(ctx); len(pending) != 1 || pending[0].ID != id {
+		t.Errorf("expected the reservation to be new but got %+v", pending)
+	}
+
+	var transitionErr *status.TransitionError
+	if err := repo.UpdateReservationStatus(ctx, id, status.CheckedIn, "admin@admin.com"); !errors.As(err, &transitionErr) {
+		t.Errorf("expected checking in a pending reservation to fail but got %v", err)
+	}
+
+	for _, to := range []string{status.Confirmed, status.CheckedIn, status.CheckedOut} {
+		if err := repo.UpdateReservationStatus(ctx, id, to, "admin@admin.com"); err != nil {
+			t.Fatalf("marking the reservation as %s: %v", to, err)
+		}
+	}
+
+	res, err := repo.GetReservationById(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Status != status.CheckedOut || res.ConfirmedAt.IsZero() || res.CheckedInAt.IsZero() || res.CheckedOutAt.IsZero() || !res.NoShowAt.IsZero() || res.ChangedBy != "admin@admin.com" {
+		t.Errorf("expected a checked out reservation with the times it moved on but got %+v", res)
+	}
+
+	if checkedOut, _ := repo.ReservationsByStatus(ctx, status.CheckedOut); len(checkedOut) != 1 || checkedOut[0].Status != status.CheckedOut {
+		t.Errorf("expected the checked out reservation but got %+v", checkedOut)
+	}
+
+	if pending, _ := repo.AllNewReservations(ctx); len(pending) != 0 {
+		t.Errorf("expected no new reservations but got %+v", pending)
+	}
+
+	if _, err := repo.CancelReservation(ctx, id, "admin@admin.com"); !errors.As(err, &transitionErr) {
+		t.Errorf("expected cancelling a finished stay to fail but got %v", err)
+	}
+
+	// cancelling through a status change frees the dates like CancelReservation
+	id, err = repo.BookRoom(ctx, models.Reservation{RoomID: roomId, Email: "guest@example.com", StartDate: date("2050-06-01"), EndDate: date("2050-06-03")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.UpdateReservationStatus(ctx, id, status.Cancelled, "guest"); err != nil {
+		t.Fatal(err)
+	}
+
+	if res, _ := repo.GetReservationById(ctx, id); res.Status != status.Cancelled || !res.Cancelled() {
+		t.Errorf("expected the reservation to be cancelled but got %+v", res)
+	}
+
+	if ok, _ := repo.SearchAvailabilityByDatesForRoomId(ctx, date("2050-06-01"), date("2050-06-03"), roomId); !ok {
+		t.Error("expected the dates of the cancelled reservation to be free")
+	}
+
+	if err := repo.UpdateReservationStatus(ctx, id, status.Cancelled, "guest"); !errors.Is(err, repository.ErrReservationCancelled) {
+		t.Errorf("expected ErrReservationCancelled but got %v", err)
+	}
+
+	if err := repo.UpdateReservationStatus(ctx, 9999, status.Confirmed, "admin@admin.com"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for a missing reservation but got %v", err)
+	}
+}
+
+func TestMemoryDBRepo_SoftDelete(t *testing.T) {
+	testSoftDelete(t, NewMemoryRepo(&config.AppConfig{}))
+}
+
+// testSoftDelete moves reservations to the trash and back, and checks only the ones deleted before the cutoff are purged
+func testSoftDelete(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	roomId, err := repo.InsertRoom(ctx, models.Room{RoomName: "Viceroy's Villa", Slug: "viceroys-villa", Active: true, NightlyRate: 10000})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := repo.BookRoom(ctx, models.Reservation{RoomID: roomId, Email: "guest@example.com", StartDate: date("2050-07-01"), EndDate: date("2050-07-03")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.DeleteReservation(ctx, id, "admin@admin.com", "booked twice"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.DeleteReservation(ctx, id, "admin@admin.com", ""); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected deleting it again to return sql.ErrNoRows but got %v", err)
+	}
+
+	if _, err := repo.GetReservationById(ctx, id); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected a deleted reservation to be left out but got %v", err)
+	}
+
+	// a reservation in the trash can't change status until it is restored
+	if err := repo.UpdateReservationStatus(ctx, id, status.Confirmed, "admin@admin.com"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected confirming a deleted reservation to return sql.ErrNoRows but got %v", err)
+	}
+
+	if _, err := repo.CancelReservation(ctx, id, "admin@admin.com"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected cancelling a deleted reservation to return sql.ErrNoRows but got %v", err)
+	}
+
+	if all, _ := repo.AllReservations(ctx); len(all) != 0 {
+		t.Errorf("expected no reservations but got %+v", all)
+	}
+
+	deleted, err := repo.DeletedReservations(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(deleted) != 1 || deleted[0].ID != id || deleted[0].DeletedBy != "admin@admin.com" || deleted[0].DeleteReason != "booked twice" || deleted[0].DeletedAt.IsZero() {
+		t.Errorf("expected the reservation in the trash but got %+v", deleted)
+	}
+
+	if ok, _ := repo.SearchAvailabilityByDatesForRoomId(ctx, date("2050-07-01"), date("2050-07-03"), roomId); !ok {
+		t.Error("expected the dates of the deleted reservation to be free")
+	}
+
+	// another guest books the freed dates, the reservation can't get them back while they are taken
+	otherId, err := repo.BookRoom(ctx, models.Reservation{RoomID: roomId, Email: "other@example.com", StartDate: date("2050-07-02"), EndDate: date("2050-07-04")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.RestoreReservation(ctx, id); !errors.Is(err, repository.ErrRoomNotAvailable) {
+		t.Errorf("expected ErrRoomNotAvailable but got %v", err)
+	}
+
+	if deleted, _ := repo.DeletedReservations(ctx); len(deleted) != 1 {
+		t.Errorf("expected the reservation to stay in the trash but got %+v", deleted)
+	}
+
+	if err := repo.DeleteReservation(ctx, otherId, "admin@admin.com", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.RestoreReservation(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+
+	if res, err := repo.GetReservationById(ctx, id); err != nil || res.Deleted() || res.DeleteReason != "" {
+		t.Errorf("expected the reservation to be restored but got %+v, %v", res, err)
+	}
+
+	if ok, _ := repo.SearchAvailabilityByDatesForRoomId(ctx, date("2050-07-01"), date("2050-07-03"), roomId); ok {
+		t.Error("expected the restored reservation to hold its dates again")
+	}
+
+	// only reservations deleted before the cutoff are purged
+	if purged, err := repo.PurgeDeletedReservations(ctx, time.Now().Add(-time.Hour)); err != nil || purged != 0 {
+		t.Errorf("expected nothing to be purged but got %d, %v", purged, err)
+	}
+
+	if purged, err := repo.PurgeDeletedReservations(ctx, time.Now().Add(time.Hour)); err != nil || purged != 1 {
+		t.Errorf("expected the deleted reservation to be purged but got %d, %v", purged, err)
+	}
+
+	if deleted, _ := repo.DeletedReservations(ctx); len(deleted) != 0 {
+		t.Errorf("expected the trash to be empty but got %+v", deleted)
+	}
+
+	if _, err := repo.GetReservationById(ctx, id); err != nil {
+		t.Errorf("expected the restored reservation to be kept but got %v", err)
+	}
+}
+
+// restrictionIdOf returns the id of the first room restriction that is not held by a reservation
+func restrictionIdOf(restrictions []models.RoomRestriction) int {
+	for _, r := range restrictions {
+		if r.ReservationID == 0 {
+			return r.ID
+		}
+	}
+
+	return 0
+}
+
+func TestMemoryDBRepo_AuditLog(t *testing.T) {
+	testAuditLog(t, NewMemoryRepo(&config.AppConfig{}))
+}
+
+// testAuditLog makes changes as an admin and checks they are recorded with their diffs, and that failed changes are not
+func testAuditLog(t *testing.T, repo repository.DatabaseRepo) {
+	admin := audit.Actor{UserID: 1, Name: "admin@admin.com"}
+	ctx := audit.WithRequest(audit.WithActor(context.Background(), admin), audit.Request{IP: "203.0.113.7", UserAgent: "test-agent", Method: "POST", Path: "/admin/reservations/all/1"})
+
+	roomId, err := repo.InsertRoom(ctx, models.Room{RoomName: "Duke's Den", Slug: "dukes-den", Active: true, NightlyRate: 10000})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := repo.BookRoom(ctx, models.Reservation{RoomID: roomId, FirstName: "Jane", Email: "guest@example.com", StartDate: date("2050-08-01"), EndDate: date("2050-08-03")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := repo.GetReservationById(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res.FirstName = "Janet"
+	if err := repo.UpdateReservation(ctx, res); err != nil {
+		t.Fatal(err)
+	}
+
+	// neither a booking that fails nor a transaction that is rolled back leave an entry behind
+	if _, err := repo.BookRoom(ctx, models.Reservation{RoomID: roomId, StartDate: date("2050-08-02"), EndDate: date("2050-08-04")}); !errors.Is(err, repository.ErrRoomNotAvailable) {
+		t.Fatalf("expected ErrRoomNotAvailable but got %v", err)
+	}
+
+	errRollback := errors.New("rollback")
+	err = repo.WithTx(ctx, func(tx repository.DatabaseRepo) error {
+		if err := tx.UpdateReservationStatus(ctx, id, status.Confirmed, admin.Name); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		t.Fatalf("expected the transaction to be rolled back but got %v", err)
+	}
+
+	entries, err := repo.AuditEntries(ctx, models.AuditFilter{Entity: audit.Reservation, EntityID: id})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 2 || entries[0].Action != audit.Update || entries[1].Action != audit.Create {
+		t.Fatalf("expected the update and the booking, newest first, but got %+v", entries)
+	}
+
+	update := entries[0]
+	if update.UserID != 1 || update.Actor != admin.Name || update.IP != "203.0.113.7" || update.UserAgent != "test-agent" || update.Request != "POST /admin/reservations/all/1" {
+		t.Errorf("expected the entry to be made by the admin with the request but got %+v", update)
+	}
+
+	want := []models.FieldChange{{Field: "FirstName", Before: "Jane", After: "Janet"}}
+	if len(update.Changes) != 1 || update.Changes[0] != want[0] {
+		t.Errorf("expected changes %+v but got %+v", want, update.Changes)
+	}
+
+	// changes the app makes by itself are recorded as made by the system
+	if err := repo.DeleteReservation(ctx, id, admin.Name, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := repo.PurgeDeletedReservations(context.Background(), time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	purged, err := repo.AuditEntries(ctx, models.AuditFilter{Entity: audit.Reservation, EntityID: id, Actor: "system"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(purged) != 1 || purged[0].Action != audit.Purge || purged[0].Request != "" {
+		t.Errorf("expected the purge to be recorded as made by the system but got %+v", purged)
+	}
+
+	rooms, err := repo.AuditEntries(ctx, models.AuditFilter{Entity: audit.Room, EntityID: roomId, Actor: admin.Name})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rooms) != 1 || rooms[0].Action != audit.Create {
+		t.Errorf("expected the room to be recorded as created but got %+v", rooms)
+	}
+
+	// releasing a reservation's dates is recorded under the reservation, holding them again under the new room
+	// restriction's id, like moving the reservation does
+	moved, err := repo.BookRoom(ctx, models.Reservation{RoomID: roomId, FirstName: "Jim", Email: "guest@example.com", StartDate: date("2050-09-01"), EndDate: date("2050-09-03")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.DeleteRestrictionsForReservation(ctx, moved); err != nil {
+		t.Fatal(err)
+	}
+
+	released, err := repo.AuditEntries(ctx, models.AuditFilter{Entity: audit.Reservation, EntityID: moved})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(released) != 2 || released[0].Action != audit.Update || len(released[0].Changes) != 1 || released[0].Changes[0].Field != "RoomRestrictions" {
+		t.Errorf("expected releasing the dates to be recorded under the reservation but got %+v", released)
+	}
+
+	rrId, err := repo.InsertRoomRestriction(ctx, models.RoomRestriction{RoomID: roomId, ReservationID: moved, RestrictionID: repository.ReservationRestrictionID, StartDate: date("2050-09-10"), EndDate: date("2050-09-12")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	held, err := repo.AuditEntries(ctx, models.AuditFilter{Entity: audit.RoomRestriction, EntityID: rrId})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rrId == 0 || len(held) != 1 || held[0].Action != audit.Create {
+		t.Errorf("expected the room restriction to be recorded under its id %d but got %+v", rrId, held)
+	}
+}
+
+func TestMemoryDBRepo_Users(t *testing.T) {
+	testUsers(t, NewMemoryRepo(&config.AppConfig{}))
+}
+
+// testUsers invites a user, changes them without touching the other users, sets their password and disables them
+func testUsers(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	id, err := repo.InsertUser(ctx, models.User{FirstName: "Fran", LastName: "Desk", Email: "fran@example.com", Role: roles.FrontDesk})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := repo.InsertUser(ctx, models.User{Email: "FRAN@example.com", Role: roles.ReadOnly}); !errors.Is(err, repository.ErrEmailTaken) {
+		t.Errorf("expected ErrEmailTaken but got %v", err)
+	}
+
+	// an invited user can't log in until they set a password
+	if _, _, err := repo.Authenticate(ctx, "fran@example.com", ""); err == nil {
+		t.Error("expected a user without a password not to be able to log in")
+	}
+
+	u, err := repo.GetUserById(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u.Role = roles.Manager
+	if err := repo.UpdateUser(ctx, u); err != nil {
+		t.Fatal(err)
+	}
+
+	if u, _ := repo.GetUserById(ctx, id); u.Role != roles.Manager || u.Email != "fran@example.com" {
+		t.Errorf("expected the user to be a manager but got %+v", u)
+	}
+
+	if admin, _ := repo.GetUserById(ctx, 1); admin.Role != roles.Owner || admin.Email != "admin@admin.com" {
+		t.Errorf("expected the other users to be left alone but got %+v", admin)
+	}
+
+	u.Email = "admin@admin.com"
+	if err := repo.UpdateUser(ctx, u); !errors.Is(err, repository.ErrEmailTaken) {
+		t.Errorf("expected ErrEmailTaken but got %v", err)
+	}
+
+	if err := repo.UpdateUserPassword(ctx, id, "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, _, err := repo.Authenticate(ctx, "fran@example.com", "correct horse"); err != nil || got != id {
+		t.Errorf("expected the user to log in with their new password but got %d, %v", got, err)
+	}
+
+	if err := repo.SetUserDisabled(ctx, id, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := repo.Authenticate(ctx, "fran@example.com", "correct horse"); err == nil {
+		t.Error("expected a disabled user not to be able to log in")
+	}
+
+	users, err := repo.AllUsers(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ordered by last name, Desk before admin
+	if len(users) != 2 || users[0].ID != id || !users[0].Disabled() {
+		t.Errorf("expected both users, the disabled one included, but got %+v", users)
+	}
+
+	if err := repo.SetUserDisabled(ctx, id, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := repo.Authenticate(ctx, "fran@example.com", "correct horse"); err != nil {
+		t.Errorf("expected an enabled user to log in again but got %v", err)
+	}
+}
+
+func TestMemoryDBRepo_PasswordResets(t *testing.T) {
+	testPasswordResets(t, NewMemoryRepo(&config.AppConfig{}))
+}
+
+// testPasswordResets checks that a reset token works once, only before it expires, and that using it voids the
+// other tokens of the user
+func testPasswordResets(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	admin, err := repo.GetUserByEmail(ctx, "ADMIN@admin.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expires := time.Now().Add(time.Hour)
+	for _, p := range []models.PasswordReset{
+		{UserID: admin.ID, TokenHash: "first", ExpiresAt: expires},
+		{UserID: admin.ID, TokenHash: "second", ExpiresAt: expires},
+		{UserID: admin.ID, TokenHash: "expired", ExpiresAt: time.Now().Add(-time.Minute)},
+	} {
+		if err := repo.InsertPasswordReset(ctx, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if p, err := repo.GetPasswordReset(ctx, "first"); err != nil || !p.Usable(time.Now()) || p.UserID != admin.ID {
+		t.Errorf("expected a usable reset for the admin but got %+v, %v", p, err)
+	}
+
+	if _, err := repo.UsePasswordReset(ctx, "expired"); !errors.Is(err, repository.ErrPasswordResetInvalid) {
+		t.Errorf("expected ErrPasswordResetInvalid for an expired token but got %v", err)
+	}
+
+	if _, err := repo.UsePasswordReset(ctx, "unknown"); !errors.Is(err, repository.ErrPasswordResetInvalid) {
+		t.Errorf("expected ErrPasswordResetInvalid for an unknown token but got %v", err)
+	}
+
+	if id, err := repo.UsePasswordReset(ctx, "first"); err != nil || id != admin.ID {
+		t.Errorf("expected the token to be used by the admin but got %d, %v", id, err)
+	}
+
+	for _, hash := range []string{"first", "second"} {
+		if _, err := repo.UsePasswordReset(ctx, hash); !errors.Is(err, repository.ErrPasswordResetInvalid) {
+			t.Errorf("expected ErrPasswordResetInvalid for %s once a token was used but got %v", hash, err)
+		}
+	}
+
+	if p, _ := repo.GetPasswordReset(ctx, "second"); p.Usable(time.Now()) {
+		t.Errorf("expected the other token to be void but got %+v", p)
+	}
+
+	if err := repo.UpdateUserPassword(ctx, admin.ID, "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+
+	if u, _ := repo.GetUserById(ctx, admin.ID); u.SessionVersion != admin.SessionVersion+1 {
+		t.Errorf("expected changing the password to bump the session version but got %d", u.SessionVersion)
+	}
+}
+
+func TestMemoryDBRepo_TwoFactor(t *testing.T) {
+	testTwoFactor(t, NewMemoryRepo(&config.AppConfig{}))
+}
+
+// testTwoFactor turns two-factor authentication on and off, checks a code step can't be used twice and that recovery
+// codes work once and are replaced as a set
+func testTwoFactor(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	if err := repo.SetUserTOTPSecret(ctx, 1, "SECRET"); err != nil {
+		t.Fatal(err)
+	}
+
+	if u, _ := repo.GetUserById(ctx, 1); !u.TwoFactor() || u.TOTPSecret != "SECRET" {
+		t.Errorf("expected two-factor authentication to be on but got %+v", u)
+	}
+
+	for _, e := range []struct {
+		step int64
+		ok   bool
+	}{{5, true}, {5, false}, {4, false}, {6, true}} {
+		if ok, err := repo.UseUserTOTPStep(ctx, 1, e.step); err != nil || ok != e.ok {
+			t.Errorf("using step %d: expected %v but got %v, %v", e.step, e.ok, ok, err)
+		}
+	}
+
+	if err := repo.ReplaceRecoveryCodes(ctx, 1, []string{"first", "second"}); err != nil {
+		t.Fatal(err)
+	}
+
+	codes, err := repo.UnusedRecoveryCodes(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(codes) != 2 || codes[0].CodeHash != "first" {
+		t.Fatalf("expected both codes but got %+v", codes)
+	}
+
+	if ok, err := repo.UseRecoveryCode(ctx, codes[0].ID); !ok || err != nil {
+		t.Errorf("expected the code to be used but got %v, %v", ok, err)
+	}
+
+	if ok, _ := repo.UseRecoveryCode(ctx, codes[0].ID); ok {
+		t.Error("expected a recovery code to work only once")
+	}
+
+	if codes, _ := repo.UnusedRecoveryCodes(ctx, 1); len(codes) != 1 || codes[0].CodeHash != "second" {
+		t.Errorf("expected only the unused code but got %+v", codes)
+	}
+
+	if err := repo.ReplaceRecoveryCodes(ctx, 1, []string{"third"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if codes, _ := repo.UnusedRecoveryCodes(ctx, 1); len(codes) != 1 || codes[0].CodeHash != "third" {
+		t.Errorf("expected the old codes to be replaced but got %+v", codes)
+	}
+
+	if err := repo.SetUserTOTPSecret(ctx, 1, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if u, _ := repo.GetUserById(ctx, 1); u.TwoFactor() || u.TOTPLastStep != 0 {
+		t.Errorf("expected two-factor authentication to be off but got %+v", u)
+	}
+
+	entries, err := repo.AuditEntries(ctx, models.AuditFilter{Entity: audit.User, EntityID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// newest first, the secret and the codes are never in the log
+	if len(entries) != 4 || entries[0].Changes[0] != (models.FieldChange{Field: "TwoFactor", Before: "on", After: "off"}) {
+		t.Errorf("expected the changes to be audited but got %+v", entries)
+	}
+}
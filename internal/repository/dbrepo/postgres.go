@@ -3,36 +3,48 @@ package dbrepo
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/hd719/go-bookings/internal/cancellation"
 	"github.com/hd719/go-bookings/internal/models"
+	"github.com/hd719/go-bookings/internal/pricing"
+	"github.com/hd719/go-bookings/internal/repository"
+	"github.com/hd719/go-bookings/internal/status"
+	"github.com/jackc/pgconn"
 	"golang.org/x/crypto/bcrypt"
 )
 
-func (m *postgresDBRepo) AllUsers() bool {
-	return true
-}
-
 // Inserts a reservation into the database
-func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error) {
-	// If the the insert operation is taking more than 3 seconds cancel it
+func (m *postgresDBRepo) InsertReservation(ctx context.Context, res models.Reservation) (int, error) {
+	// If the the insert operation is taking longer than the configured timeout, or the request is cancelled, cancel it
 	// Context: Package context defines the Context type, which carries deadlines, cancellation signals, and other request-scoped values across API boundaries and between processes.
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(ctx, m.App)
 	defer cancel()
 
 	var newId int
 
-	stmt := `insert into reservations (first_name, last_name, email, phone, start_date, end_date, room_id, created_at, updated_at)
-		values ($1, $2, $3, $4, $5, $6, $7, $8, $9) returning id`
+	code, err := confirmationCodeFor(res)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt := `insert into reservations (first_name, last_name, email, phone, start_date, end_date, room_id, total_price, confirmation_code,
+		cancellation_policy, cancellation_free_days, cancellation_late_fee, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14) returning id`
 
 	// OLD: Inserting into DB
 	// _, err := m.DB.ExecContext(ctx, stmt, res.FirstName, res.LastName, res.Email, res.Phone, res.StartDate, res.EndDate, res.RoomID, time.Now(), time.Now())
 
 	// New: Query that returns an id and sets the value to the memory address of var newId
-	err := m.DB.QueryRowContext(ctx, stmt, res.FirstName, res.LastName, res.Email, res.Phone, res.StartDate, res.EndDate, res.RoomID, time.Now(), time.Now()).Scan(&newId)
+	err = m.DB.QueryRowContext(ctx, stmt, res.FirstName, res.LastName, res.Email, res.Phone, res.StartDate, res.EndDate, res.RoomID, res.TotalPrice, code,
+		res.Cancellation.Policy, res.Cancellation.FreeDays, res.Cancellation.LateFee, time.Now(), time.Now()).Scan(&newId)
 	if err != nil {
 		return 0, err
 	}
@@ -40,28 +52,126 @@ func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error)
 	return newId, nil
 }
 
-// InsertRoomRestriction into the Database
-func (m *postgresDBRepo) InsertRoomRestriction(r models.RoomRestriction) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// InsertRoomRestriction into the Database and return its id
+func (m *postgresDBRepo) InsertRoomRestriction(ctx context.Context, r models.RoomRestriction) (int, error) {
+	ctx, cancel := queryContext(ctx, m.App)
 	defer cancel()
 
-	stmt := `insert into room_restrictions (start_date, end_date, room_id, reservation_id, created_at, updated_at, restriction_id) values ($1, $2, $3, $4, $5, $6, $7)`
+	var newId int
+
+	stmt := `insert into room_restrictions (start_date, end_date, room_id, reservation_id, created_at, updated_at, restriction_id, blocks_availability)
+		values ($1, $2, $3, $4, $5, $6, $7, (select blocks_availability from restrictions where id = $7)) returning id`
 
-	_, err := m.DB.ExecContext(ctx, stmt, r.StartDate, r.EndDate, r.RoomID, r.ReservationID, time.Now(), time.Now(), r.RestrictionID)
+	err := m.DB.QueryRowContext(ctx, stmt, r.StartDate, r.EndDate, r.RoomID, r.ReservationID, time.Now(), time.Now(), r.RestrictionID).Scan(&newId)
 	if err != nil {
+		return 0, err
+	}
+
+	return newId, nil
+}
+
+// BookRoom prices a reservation and inserts it and its room restriction in a single transaction
+// Returns repository.ErrRoomNotAvailable if the room is already taken for any of the requested dates
+func (m *postgresDBRepo) BookRoom(ctx context.Context, res models.Reservation) (int, error) {
+	var newId int
+
+	err := m.WithTx(ctx, func(repo repository.DatabaseRepo) error {
+		room, err := repo.GetRoomById(ctx, res.RoomID)
+		if err != nil {
+			return err
+		}
+
+		if !room.Active {
+			return repository.ErrRoomNotAvailable
+		}
+
+		if err := repo.CheckStayRules(ctx, res.RoomID, res.StartDate, res.EndDate); err != nil {
+			return err
+		}
+
+		// Check for overlapping restrictions first so we can fail early with a friendly error,
+		// the exclusion constraint on room_restrictions still guards against two bookings racing each other
+		var available bool
+		available, err = repo.SearchAvailabilityByDatesForRoomId(ctx, res.StartDate, res.EndDate, res.RoomID)
+		if err != nil {
+			return err
+		}
+
+		if !available {
+			return repository.ErrRoomNotAvailable
+		}
+
+		// The price and cancellation terms come from the rates and policies at the time of booking, not from
+		// whatever the guest was shown earlier
+		quote, err := repo.GetRatesForStay(ctx, res.RoomID, res.StartDate, res.EndDate)
+		if err != nil {
+			return err
+		}
+		res.TotalPrice = quote.Total
+
+		res.Cancellation, err = repo.GetCancellationTermsForStay(ctx, res.RoomID, res.StartDate, res.EndDate)
+		if err != nil {
+			return err
+		}
+
+		newId, err = repo.InsertReservation(ctx, res)
+		if err != nil {
+			return err
+		}
+
+		_, err = repo.InsertRoomRestriction(ctx, models.RoomRestriction{
+			StartDate:     res.StartDate,
+			EndDate:       res.EndDate,
+			RoomID:        res.RoomID,
+			ReservationID: newId,
+			RestrictionID: repository.ReservationRestrictionID,
+		})
+
 		return err
+	})
+
+	if isExclusionViolation(err) {
+		return 0, repository.ErrRoomNotAvailable
 	}
 
-	return nil
+	if err != nil {
+		return 0, err
+	}
+
+	return newId, nil
+}
+
+// WithTx runs fn inside a transaction, if the repo is already bound to a transaction fn joins it
+func (m *postgresDBRepo) WithTx(ctx context.Context, fn func(repo repository.DatabaseRepo) error) error {
+	if m.conn == nil {
+		return fn(m)
+	}
+
+	return runInTx(ctx, m.conn, func(tx *sql.Tx) error {
+		return fn(&postgresDBRepo{App: m.App, DB: tx})
+	})
+}
+
+// exclusionViolation is the postgres error code raised when an exclusion constraint is violated
+const exclusionViolation = "23P01"
+
+// isExclusionViolation reports whether err was caused by the room_restrictions_no_overlap exclusion constraint
+func isExclusionViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == exclusionViolation
+	}
+
+	return false
 }
 
 // SearchAvailabilityByDates returns true if availability exists for a specific room and false if no availability exists
-func (m *postgresDBRepo) SearchAvailabilityByDatesForRoomId(start, end time.Time, roomId int) (bool, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) SearchAvailabilityByDatesForRoomId(ctx context.Context, start, end time.Time, roomId int) (bool, error) {
+	ctx, cancel := queryContext(ctx, m.App)
 	defer cancel()
 
 	// Iterate through all the rows for a given room and see if there are any overlapping dates
-	query := `select count(id) from room_restrictions where room_id = $1 and $2 < end_date and $3 > start_date`
+	query := `select count(id) from room_restrictions where room_id = $1 and $2 < end_date and $3 > start_date and blocks_availability`
 
 	row := m.DB.QueryRowContext(ctx, query, roomId, start, end)
 	var numRows int
@@ -77,28 +187,34 @@ func (m *postgresDBRepo) SearchAvailabilityByDatesForRoomId(start, end time.Time
 	return false, nil
 }
 
-// SearchAvailabilityForAllRooms returns a slice of available rooms if any for a given date range
-func (m *postgresDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// SearchAvailabilityForAllRooms returns the rooms that can be booked for a given date range,
+// and the rooms that are free but excluded by one of their stay rules
+func (m *postgresDBRepo) SearchAvailabilityForAllRooms(ctx context.Context, start, end time.Time) ([]models.Room, []models.RoomExclusion, error) {
+	rooms, err := m.freeRooms(ctx, start, end)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return excludeByStayRules(ctx, m, rooms, start, end)
+}
+
+// freeRooms returns the active rooms without restrictions between start and end
+func (m *postgresDBRepo) freeRooms(ctx context.Context, start, end time.Time) ([]models.Room, error) {
+	ctx, cancel := queryContext(ctx, m.App)
 	defer cancel()
 
 	var rooms []models.Room
 
-	query := `select rooms.id, rooms.room_name from rooms where rooms.id not in (select rr.room_id from room_restrictions rr where $1 < rr.end_date and $2 > rr.start_date)`
+	query := `select ` + roomColumns + ` from rooms where rooms.active and rooms.id not in (select rr.room_id from room_restrictions rr where $1 < rr.end_date and $2 > rr.start_date and rr.blocks_availability) order by rooms.id`
 
 	rows, err := m.DB.QueryContext(ctx, query, start, end)
 	if err != nil {
 		return rooms, err
 	}
+	defer rows.Close()
 
 	for rows.Next() {
-		var room models.Room
-
-		err := rows.Scan(
-			&room.ID,
-			&room.RoomName,
-		)
-
+		room, err := scanRoom(rows)
 		if err != nil {
 			return rooms, err
 		}
@@ -107,34 +223,82 @@ func (m *postgresDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]
 	}
 
 	if err = rows.Err(); err != nil {
-		log.Fatal("Error scanning rows", err)
 		return rooms, err
 	}
 
 	return rooms, nil
 }
 
-// GetRoomByID gets room by ID
-func (m *postgresDBRepo) GetRoomById(id int) (models.Room, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+// roomColumns is the column list scanRoom expects
+const roomColumns = `rooms.id, rooms.room_name, rooms.slug, rooms.description, rooms.active, rooms.max_occupancy, rooms.beds, rooms.amenities, rooms.nightly_rate, rooms.weekend_rate, rooms.created_at, rooms.updated_at, rooms.cancellation_policy_id`
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
 
+// scanRoom scans a row selected with roomColumns
+func scanRoom(row rowScanner) (models.Room, error) {
 	var room models.Room
-	query := `select id, room_name, created_at, updated_at from rooms where id = $1`
+	var amenities string
+	var policyId sql.NullInt64
 
-	row := m.DB.QueryRowContext(ctx, query, id)
 	err := row.Scan(
 		&room.ID,
 		&room.RoomName,
+		&room.Slug,
+		&room.Description,
+		&room.Active,
+		&room.MaxOccupancy,
+		&room.Beds,
+		&amenities,
+		&room.NightlyRate,
+		&room.WeekendRate,
 		&room.CreatedAt,
 		&room.UpdatedAt,
+		&policyId,
 	)
 
-	if err != nil {
-		return room, err
+	room.Amenities = splitAmenities(amenities)
+	room.CancellationPolicyID = int(policyId.Int64)
+
+	return room, err
+}
+
+// Amenities are stored one per line
+func joinAmenities(amenities []string) string {
+	return strings.Join(amenities, "\n")
+}
+
+func splitAmenities(s string) []string {
+	var amenities []string
+	for _, a := range strings.Split(s, "\n") {
+		if a = strings.TrimSpace(a); a != "" {
+			amenities = append(amenities, a)
+		}
 	}
 
-	return room, nil
+	return amenities
+}
+
+// GetRoomByID gets room by ID
+func (m *postgresDBRepo) GetRoomById(ctx context.Context, id int) (models.Room, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	query := `select ` + roomColumns + ` from rooms where id = $1`
+
+	return scanRoom(m.DB.QueryRowContext(ctx, query, id))
+}
+
+// GetRoomBySlug gets room by the slug used in its url
+func (m *postgresDBRepo) GetRoomBySlug(ctx context.Context, slug string) (models.Room, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	query := `select ` + roomColumns + ` from rooms where slug = $1`
+
+	return scanRoom(m.DB.QueryRowContext(ctx, query, slug))
 }
 
 // Logic Ex. for SearchAvailabilityByDates
@@ -158,60 +322,164 @@ func (m *postgresDBRepo) GetRoomById(id int) (models.Room, error) {
 // Get me all of the room ids and room names from the rooms table where the id from the rooms table (rooms.id) is not in this query (select rr.room_id from room_restrictions rr where '2021-02-19' < rr.end_date and '2021-02-21' > rr.start_date -> returns a row of room ids that are booked within the given dates)
 // select rooms.id, rooms.room_name from rooms where rooms.id not in (select rr.room_id from room_restrictions rr where '2021-02-19' < rr.end_date and '2021-02-21' > rr.start_date)
 
+// userColumns are the columns of users in the order scanUser reads them
+const userColumns = `id, first_name, last_name, email, password, role, disabled_at, session_version, totp_secret, totp_last_step,
+	created_at, updated_at`
+
+func scanUser(row rowScanner) (models.User, error) {
+	var u models.User
+	var disabledAt sql.NullTime
+
+	err := row.Scan(&u.ID, &u.FirstName, &u.LastName, &u.Email, &u.Password, &u.Role, &disabledAt, &u.SessionVersion, &u.TOTPSecret, &u.TOTPLastStep,
+		&u.CreatedAt, &u.UpdatedAt)
+	u.DisabledAt = disabledAt.Time
+
+	return u, err
+}
+
+// AllUsers returns every user, disabled ones included, ordered by name
+func (m *postgresDBRepo) AllUsers(ctx context.Context) ([]models.User, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	var users []models.User
+
+	rows, err := m.DB.QueryContext(ctx, `select `+userColumns+` from users order by last_name, first_name, id`)
+	if err != nil {
+		return users, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return users, err
+		}
+
+		users = append(users, u)
+	}
+
+	if err = rows.Err(); err != nil {
+		return users, err
+	}
+
+	return users, nil
+}
+
 // Returns a user by Id
-func (m *postgresDBRepo) GetUserById(id int) (models.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) GetUserById(ctx context.Context, id int) (models.User, error) {
+	ctx, cancel := queryContext(ctx, m.App)
 	defer cancel()
 
-	query := `select id, first_name, last_name, email, password, access_level, created_at, updated_at from users where id = $1`
+	query := `select ` + userColumns + ` from users where id = $1`
 
-	row := m.DB.QueryRowContext(ctx, query, id)
+	return scanUser(m.DB.QueryRowContext(ctx, query, id))
+}
 
-	var u models.User
-	err := row.Scan(
-		&u.ID,
-		&u.FirstName,
-		&u.LastName,
-		&u.Email,
-		&u.Password,
-		&u.AccessLevel,
-		&u.CreatedAt,
-		&u.UpdatedAt,
-	)
+// GetUserByEmail returns the user with email, ignoring case
+func (m *postgresDBRepo) GetUserByEmail(ctx context.Context, email string) (models.User, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	query := `select ` + userColumns + ` from users where lower(email) = lower($1)`
+
+	return scanUser(m.DB.QueryRowContext(ctx, query, email))
+}
+
+// emailTaken reports whether a user other than the one with the given id has email
+func (m *postgresDBRepo) emailTaken(ctx context.Context, email string, id int) (bool, error) {
+	var n int
+	err := m.DB.QueryRowContext(ctx, `select count(id) from users where lower(email) = lower($1) and id <> $2`, email, id).Scan(&n)
+	return n > 0, err
+}
+
+// InsertUser adds a user and returns its id, the password is the hash to store, empty for a user that still has to set one
+// Returns repository.ErrEmailTaken if another user has the same email
+func (m *postgresDBRepo) InsertUser(ctx context.Context, u models.User) (int, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	taken, err := m.emailTaken(ctx, u.Email, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	if taken {
+		return 0, repository.ErrEmailTaken
+	}
 
+	var newId int
+
+	stmt := `insert into users (first_name, last_name, email, password, role, created_at, updated_at) values ($1, $2, $3, $4, $5, $6, $7) returning id`
+
+	err = m.DB.QueryRowContext(ctx, stmt, u.FirstName, u.LastName, u.Email, u.Password, u.Role, time.Now(), time.Now()).Scan(&newId)
 	if err != nil {
-		return u, err
+		return 0, err
 	}
 
-	return u, nil
+	return newId, nil
 }
 
-// Updates user
-func (m *postgresDBRepo) UpdateUser(u models.User) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// Updates the name, email and role of a user
+// Returns repository.ErrEmailTaken if another user has the same email
+func (m *postgresDBRepo) UpdateUser(ctx context.Context, u models.User) error {
+	ctx, cancel := queryContext(ctx, m.App)
 	defer cancel()
 
-	query := `update users set first_name = $1, last_name = $2, email = $3, access_level = $4, updated_at = $5`
+	taken, err := m.emailTaken(ctx, u.Email, u.ID)
+	if err != nil {
+		return err
+	}
+
+	if taken {
+		return repository.ErrEmailTaken
+	}
+
+	query := `update users set first_name = $1, last_name = $2, email = $3, role = $4, updated_at = $5 where id = $6`
 
-	_, err := m.DB.ExecContext(ctx, query,
+	_, err = m.DB.ExecContext(ctx, query,
 		u.FirstName,
 		u.LastName,
 		u.Email,
-		u.AccessLevel,
+		u.Role,
 		time.Now(),
+		u.ID,
 	)
+
+	return err
+}
+
+// UpdateUserPassword hashes password and stores it as the password of a user, the sessions of the user are logged out
+func (m *postgresDBRepo) UpdateUserPassword(ctx context.Context, id int, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
-		fmt.Println("Failed to update User")
 		return err
 	}
 
-	fmt.Println("User updated!")
-	return nil
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, `update users set password = $1, session_version = session_version + 1, updated_at = $2 where id = $3`, string(hashedPassword), time.Now(), id)
+	return err
+}
+
+// SetUserDisabled disables a user, so they can no longer log in, or enables them again
+func (m *postgresDBRepo) SetUserDisabled(ctx context.Context, id int, disabled bool) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	var disabledAt sql.NullTime
+	if disabled {
+		disabledAt = sql.NullTime{Time: time.Now(), Valid: true}
+	}
+
+	_, err := m.DB.ExecContext(ctx, `update users set disabled_at = $1, updated_at = $2 where id = $3`, disabledAt, time.Now(), id)
+	return err
 }
 
 // Authenticates a user
-func (m *postgresDBRepo) Authenticate(email, testPassword string) (int, string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) Authenticate(ctx context.Context, email, testPassword string) (int, string, error) {
+	ctx, cancel := queryContext(ctx, m.App)
 	defer cancel()
 
 	// This will be the id of the user if the credentials are correct
@@ -220,8 +488,8 @@ func (m *postgresDBRepo) Authenticate(email, testPassword string) (int, string,
 	// Will hold the password we get from the DB
 	var hashedPassword string
 
-	// check to if the email exists in the Db
-	row := m.DB.QueryRowContext(ctx, "select id, password from users where email = $1", email)
+	// check to if the email exists in the Db, disabled users can't log in
+	row := m.DB.QueryRowContext(ctx, "select id, password from users where email = $1 and disabled_at is null", email)
 	err := row.Scan(&id, &hashedPassword)
 	if err != nil {
 		return id, "", err
@@ -238,49 +506,187 @@ func (m *postgresDBRepo) Authenticate(email, testPassword string) (int, string,
 	return id, hashedPassword, nil
 }
 
-// Returns a slice of all ressys
-func (m *postgresDBRepo) AllReservations() ([]models.Reservation, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// InsertPasswordReset stores the hash of a password reset token
+func (m *postgresDBRepo) InsertPasswordReset(ctx context.Context, p models.PasswordReset) error {
+	ctx, cancel := queryContext(ctx, m.App)
 	defer cancel()
 
-	var reservations []models.Reservation
+	stmt := `insert into password_resets (user_id, token_hash, expires_at, created_at) values ($1, $2, $3, $4)`
+
+	_, err := m.DB.ExecContext(ctx, stmt, p.UserID, p.TokenHash, p.ExpiresAt, time.Now())
+	return err
+}
+
+// GetPasswordReset returns the password reset with the hash of a token, used and expired ones included
+func (m *postgresDBRepo) GetPasswordReset(ctx context.Context, tokenHash string) (models.PasswordReset, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
 
-	query := `select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date, r.room_id, r.created_at, r.updated_at, r.processed, rm.id, rm.room_name from reservations r left join rooms rm on (r.room_id = rm.id) order by r.start_date asc`
+	var p models.PasswordReset
+	var usedAt sql.NullTime
+
+	query := `select id, user_id, token_hash, expires_at, used_at, created_at from password_resets where token_hash = $1`
+
+	err := m.DB.QueryRowContext(ctx, query, tokenHash).Scan(&p.ID, &p.UserID, &p.TokenHash, &p.ExpiresAt, &usedAt, &p.CreatedAt)
+	p.UsedAt = usedAt.Time
+
+	return p, err
+}
+
+// UsePasswordReset marks the token with the hash as used and returns the id of its user, the other tokens of the user
+// stop working too. Returns repository.ErrPasswordResetInvalid if the token is unknown, expired or already used
+func (m *postgresDBRepo) UsePasswordReset(ctx context.Context, tokenHash string) (int, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	var userId int
+
+	// checking and using the token in one statement means it can't be used twice at the same time
+	stmt := `update password_resets set used_at = $1 where token_hash = $2 and used_at is null and expires_at > $1 returning user_id`
+
+	err := m.DB.QueryRowContext(ctx, stmt, time.Now(), tokenHash).Scan(&userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, repository.ErrPasswordResetInvalid
+	}
 
-	rows, err := m.DB.QueryContext(ctx, query)
 	if err != nil {
-		return reservations, err
+		return 0, err
 	}
-	defer rows.Close() // prevents memory leak
 
-	for rows.Next() {
-		var i models.Reservation
-		err := rows.Scan(&i.ID, &i.FirstName, &i.LastName, &i.Email, &i.Phone, &i.StartDate, &i.EndDate, &i.RoomID, &i.CreatedAt, &i.UpdatedAt, &i.Processed, &i.Room.ID, &i.Room.RoomName)
+	_, err = m.DB.ExecContext(ctx, `update password_resets set used_at = $1 where user_id = $2 and used_at is null`, time.Now(), userId)
+	if err != nil {
+		return 0, err
+	}
 
-		if err != nil {
-			return reservations, err
+	return userId, nil
+}
+
+// SetUserTOTPSecret turns on two-factor authentication for a user with secret, or turns it off with an empty one
+func (m *postgresDBRepo) SetUserTOTPSecret(ctx context.Context, id int, secret string) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `update users set totp_secret = $1, totp_last_step = 0, updated_at = $2 where id = $3`, secret, time.Now(), id)
+	return err
+}
+
+// UseUserTOTPStep records that a user logged in with the code of step, it returns false if a code of that step or a
+// later one was used already
+func (m *postgresDBRepo) UseUserTOTPStep(ctx context.Context, id int, step int64) (bool, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `update users set totp_last_step = $1 where id = $2 and totp_last_step < $1`, step, id)
+	if err != nil {
+		return false, err
+	}
+
+	updated, err := result.RowsAffected()
+	return updated == 1, err
+}
+
+// ReplaceRecoveryCodes stores the hashes as the recovery codes of a user, the codes they had before stop working
+func (m *postgresDBRepo) ReplaceRecoveryCodes(ctx context.Context, userId int, hashes []string) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	replace := func(db dbtx) error {
+		if _, err := db.ExecContext(ctx, `delete from recovery_codes where user_id = $1`, userId); err != nil {
+			return err
 		}
 
-		reservations = append(reservations, i)
+		for _, hash := range hashes {
+			_, err := db.ExecContext(ctx, `insert into recovery_codes (user_id, code_hash, created_at) values ($1, $2, $3)`, userId, hash, time.Now())
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	if m.conn == nil {
+		return replace(m.DB)
+	}
+
+	return runInTx(ctx, m.conn, func(tx *sql.Tx) error { return replace(tx) })
+}
+
+// UnusedRecoveryCodes returns the recovery codes of a user that can still be used
+func (m *postgresDBRepo) UnusedRecoveryCodes(ctx context.Context, userId int) ([]models.RecoveryCode, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	var codes []models.RecoveryCode
+
+	rows, err := m.DB.QueryContext(ctx, `select id, user_id, code_hash, created_at from recovery_codes where user_id = $1 and used_at is null order by id`, userId)
+	if err != nil {
+		return codes, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c models.RecoveryCode
+		if err := rows.Scan(&c.ID, &c.UserID, &c.CodeHash, &c.CreatedAt); err != nil {
+			return codes, err
+		}
+
+		codes = append(codes, c)
 	}
 
 	if err = rows.Err(); err != nil {
-		return reservations, err
+		return codes, err
 	}
 
-	return reservations, nil
+	return codes, nil
+}
+
+// UseRecoveryCode marks a recovery code as used, it returns false if it was used already
+func (m *postgresDBRepo) UseRecoveryCode(ctx context.Context, id int) (bool, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `update recovery_codes set used_at = $1 where id = $2 and used_at is null`, time.Now(), id)
+	if err != nil {
+		return false, err
+	}
+
+	updated, err := result.RowsAffected()
+	return updated == 1, err
+}
+
+// Returns a slice of all ressys
+func (m *postgresDBRepo) AllReservations(ctx context.Context) ([]models.Reservation, error) {
+	return m.queryReservations(ctx, `r.deleted_at is null`)
+}
+
+// Returns new reservations, the ones still pending
+func (m *postgresDBRepo) AllNewReservations(ctx context.Context) ([]models.Reservation, error) {
+	return m.queryReservations(ctx, `r.deleted_at is null and r.status = $1`, status.Pending)
+}
+
+// ReservationsByStatus returns the reservations with the given status
+func (m *postgresDBRepo) ReservationsByStatus(ctx context.Context, s string) ([]models.Reservation, error) {
+	return m.queryReservations(ctx, `r.deleted_at is null and r.status = $1`, s)
 }
 
-// Returns new reservations
-func (m *postgresDBRepo) AllNewReservations() ([]models.Reservation, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// DeletedReservations returns the reservations in the trash
+func (m *postgresDBRepo) DeletedReservations(ctx context.Context) ([]models.Reservation, error) {
+	return m.queryReservations(ctx, `r.deleted_at is not null`)
+}
+
+// queryReservations returns the reservations matching where ordered by start date, together with the id and name of their room
+func (m *postgresDBRepo) queryReservations(ctx context.Context, where string, args ...interface{}) ([]models.Reservation, error) {
+	ctx, cancel := queryContext(ctx, m.App)
 	defer cancel()
 
 	var reservations []models.Reservation
 
-	query := `select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date, r.room_id, r.created_at, r.updated_at, rm.id, rm.room_name from reservations r left join rooms rm on (r.room_id = rm.id) where processed = 0 order by r.start_date asc`
+	query := `select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date, r.room_id, r.created_at, r.updated_at, r.status, r.cancelled_at,
+		r.deleted_at, r.deleted_by, r.delete_reason, rm.id, rm.room_name
+		from reservations r left join rooms rm on (r.room_id = rm.id) where ` + where + ` order by r.start_date asc`
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		return reservations, err
 	}
@@ -288,11 +694,15 @@ func (m *postgresDBRepo) AllNewReservations() ([]models.Reservation, error) {
 
 	for rows.Next() {
 		var i models.Reservation
-		err := rows.Scan(&i.ID, &i.FirstName, &i.LastName, &i.Email, &i.Phone, &i.StartDate, &i.EndDate, &i.RoomID, &i.CreatedAt, &i.UpdatedAt, &i.Room.ID, &i.Room.RoomName)
+		var cancelledAt, deletedAt sql.NullTime
+		err := rows.Scan(&i.ID, &i.FirstName, &i.LastName, &i.Email, &i.Phone, &i.StartDate, &i.EndDate, &i.RoomID, &i.CreatedAt, &i.UpdatedAt, &i.Status, &cancelledAt,
+			&deletedAt, &i.DeletedBy, &i.DeleteReason, &i.Room.ID, &i.Room.RoomName)
 
 		if err != nil {
 			return reservations, err
 		}
+		i.CancelledAt = cancelledAt.Time
+		i.DeletedAt = deletedAt.Time
 
 		reservations = append(reservations, i)
 	}
@@ -305,38 +715,59 @@ func (m *postgresDBRepo) AllNewReservations() ([]models.Reservation, error) {
 }
 
 // Returns 1 reservation by id
-func (m *postgresDBRepo) GetReservationById(id int) (models.Reservation, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) GetReservationById(ctx context.Context, id int) (models.Reservation, error) {
+	return m.getReservation(ctx, `r.id = $1`, id)
+}
+
+// GetReservationByConfirmationCode returns the reservation with the given code, if it was made with the given email
+func (m *postgresDBRepo) GetReservationByConfirmationCode(ctx context.Context, code, email string) (models.Reservation, error) {
+	return m.getReservation(ctx, `r.confirmation_code = $1 and lower(r.email) = lower($2)`, code, email)
+}
+
+// getReservation returns the reservation matching where, together with the id and name of its room, reservations in the trash are left out
+func (m *postgresDBRepo) getReservation(ctx context.Context, where string, args ...interface{}) (models.Reservation, error) {
+	ctx, cancel := queryContext(ctx, m.App)
 	defer cancel()
 
 	var res models.Reservation
+	var cancelledAt, confirmedAt, checkedInAt, checkedOutAt, noShowAt sql.NullTime
 
-	query := `select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date, r.room_id, r.created_at, r.updated_at, r.processed, rm.id, rm.room_name from reservations r left join rooms rm on (r.room_id = rm.id) where r.id = $1`
+	query := `select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date, r.room_id, r.created_at, r.updated_at, r.total_price, r.confirmation_code, r.cancelled_at, r.changed_by,
+		r.cancellation_policy, r.cancellation_free_days, r.cancellation_late_fee, r.cancellation_fee,
+		r.status, r.confirmed_at, r.checked_in_at, r.checked_out_at, r.no_show_at, rm.id, rm.room_name from reservations r left join rooms rm on (r.room_id = rm.id) where r.deleted_at is null and ` + where
 
-	row := m.DB.QueryRowContext(ctx, query, id)
+	row := m.DB.QueryRowContext(ctx, query, args...)
 	err := row.Scan(
-		&res.ID, &res.FirstName, &res.LastName, &res.Email, &res.Phone, &res.StartDate, &res.EndDate, &res.RoomID, &res.CreatedAt, &res.UpdatedAt, &res.Processed, &res.Room.ID, &res.Room.RoomName,
+		&res.ID, &res.FirstName, &res.LastName, &res.Email, &res.Phone, &res.StartDate, &res.EndDate, &res.RoomID, &res.CreatedAt, &res.UpdatedAt, &res.TotalPrice, &res.ConfirmationCode, &cancelledAt, &res.ChangedBy,
+		&res.Cancellation.Policy, &res.Cancellation.FreeDays, &res.Cancellation.LateFee, &res.CancellationFee,
+		&res.Status, &confirmedAt, &checkedInAt, &checkedOutAt, &noShowAt, &res.Room.ID, &res.Room.RoomName,
 	)
 
 	if err != nil {
 		return res, err
 	}
+	res.CancelledAt = cancelledAt.Time
+	res.ConfirmedAt = confirmedAt.Time
+	res.CheckedInAt = checkedInAt.Time
+	res.CheckedOutAt = checkedOutAt.Time
+	res.NoShowAt = noShowAt.Time
 
 	return res, nil
 }
 
 // Update Reservation
-func (m *postgresDBRepo) UpdateReservation(u models.Reservation) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) UpdateReservation(ctx context.Context, u models.Reservation) error {
+	ctx, cancel := queryContext(ctx, m.App)
 	defer cancel()
 
-	query := `update reservations set first_name = $1, last_name = $2, email = $3, phone = $4, updated_at = $5 where id = $6`
+	query := `update reservations set first_name = $1, last_name = $2, email = $3, phone = $4, changed_by = $5, updated_at = $6 where id = $7`
 
 	_, err := m.DB.ExecContext(ctx, query,
 		u.FirstName,
 		u.LastName,
 		u.Email,
 		u.Phone,
+		u.ChangedBy,
 		time.Now(),
 		u.ID,
 	)
@@ -349,90 +780,1010 @@ func (m *postgresDBRepo) UpdateReservation(u models.Reservation) error {
 	return nil
 }
 
-// Deletes ressy
-func (m *postgresDBRepo) DeleteReservation(id int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// UpdateReservationDates saves the dates, price and cancellation terms of a reservation, the room restriction holding its dates is left as is
+func (m *postgresDBRepo) UpdateReservationDates(ctx context.Context, res models.Reservation) error {
+	ctx, cancel := queryContext(ctx, m.App)
 	defer cancel()
 
-	query := `delete from reservations where id = $1`
-
-	fmt.Printf("Delete Reservation")
+	query := `update reservations set start_date = $1, end_date = $2, total_price = $3, cancellation_policy = $4, cancellation_free_days = $5,
+		cancellation_late_fee = $6, changed_by = $7, updated_at = $8 where id = $9`
 
-	_, err := m.DB.ExecContext(ctx, query, id)
+	_, err := m.DB.ExecContext(ctx, query, res.StartDate, res.EndDate, res.TotalPrice, res.Cancellation.Policy, res.Cancellation.FreeDays,
+		res.Cancellation.LateFee, res.ChangedBy, time.Now(), res.ID)
+	return err
+}
 
-	if err != nil {
-		return err
+// ChangeReservationDates moves a reservation to new dates and reprices it, together with the room restriction holding its dates
+// Returns repository.ErrRoomNotAvailable if the room is taken on any of the new dates
+func (m *postgresDBRepo) ChangeReservationDates(ctx context.Context, id int, start, end time.Time, changedBy string) (models.Reservation, error) {
+	res, err := changeReservationDates(ctx, m, id, start, end, changedBy)
+	if isExclusionViolation(err) {
+		return models.Reservation{}, repository.ErrRoomNotAvailable
 	}
 
-	return nil
+	return res, err
 }
 
-// Updates processed for a reservation by id
-func (m *postgresDBRepo) UpdateProcessedForReservation(id, processed int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// CancelReservation marks a reservation as cancelled, frees its dates and returns the fee charged under its cancellation terms
+// Returns repository.ErrReservationCancelled if it was already cancelled, or a *status.TransitionError once the guest has arrived
+func (m *postgresDBRepo) CancelReservation(ctx context.Context, id int, changedBy string) (int, error) {
+	ctx, cancel := queryContext(ctx, m.App)
 	defer cancel()
 
-	query := `udpate from reservations set processed = $1 where id = $2`
-
-	_, err := m.DB.ExecContext(ctx, query, processed, id)
+	var fee int
 
-	if err != nil {
-		return err
-	}
+	update := func(db dbtx) error {
+		var from string
+		var arrival time.Time
+		var terms models.CancellationTerms
 
-	return nil
-}
+		query := `select status, start_date, cancellation_free_days, cancellation_late_fee from reservations where id = $1 and deleted_at is null`
+		if err := db.QueryRowContext(ctx, query, id).Scan(&from, &arrival, &terms.FreeDays, &terms.LateFee); err != nil {
+			return err
+		}
 
-func (m *postgresDBRepo) AllRooms() ([]models.Room, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+		if err := cancelTransition(from); err != nil {
+			return err
+		}
 
-	var rooms []models.Room
+		now := time.Now()
+		fee = cancellation.Fee(terms, arrival, now)
 
-	query := `select id, room_name, created_at, updated_at from rooms order by room_name`
+		query = `update reservations set status = $1, cancelled_at = $2, cancellation_fee = $3, changed_by = $4, updated_at = $2 where id = $5`
+		if _, err := db.ExecContext(ctx, query, status.Cancelled, now, fee, changedBy, id); err != nil {
+			return err
+		}
+
+		_, err := db.ExecContext(ctx, `delete from room_restrictions where reservation_id = $1`, id)
+		return err
+	}
+
+	var err error
+	if m.conn == nil {
+		err = update(m.DB)
+	} else {
+		err = runInTx(ctx, m.conn, func(tx *sql.Tx) error { return update(tx) })
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return fee, nil
+}
+
+// DeleteReservation moves a reservation to the trash and frees its dates, it is removed for good by PurgeDeletedReservations
+// Returns sql.ErrNoRows if there is no such reservation or it is already in the trash
+func (m *postgresDBRepo) DeleteReservation(ctx context.Context, id int, deletedBy, reason string) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	update := func(db dbtx) error {
+		query := `update reservations set deleted_at = $1, deleted_by = $2, delete_reason = $3, updated_at = $1 where id = $4 and deleted_at is null`
+		result, err := db.ExecContext(ctx, query, time.Now(), deletedBy, reason, id)
+		if err != nil {
+			return err
+		}
+
+		deleted, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if deleted == 0 {
+			return sql.ErrNoRows
+		}
+
+		_, err = db.ExecContext(ctx, `delete from room_restrictions where reservation_id = $1`, id)
+		return err
+	}
+
+	if m.conn == nil {
+		return update(m.DB)
+	}
+
+	return runInTx(ctx, m.conn, func(tx *sql.Tx) error { return update(tx) })
+}
+
+// RestoreReservation takes a reservation out of the trash and holds its dates again, unless it was cancelled
+// Returns repository.ErrRoomNotAvailable if the room has been taken on its dates in the meantime
+func (m *postgresDBRepo) RestoreReservation(ctx context.Context, id int) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	update := func(db dbtx) error {
+		var res models.Reservation
+		var deletedAt sql.NullTime
+
+		query := `select status, room_id, start_date, end_date, deleted_at from reservations where id = $1`
+		if err := db.QueryRowContext(ctx, query, id).Scan(&res.Status, &res.RoomID, &res.StartDate, &res.EndDate, &deletedAt); err != nil {
+			return err
+		}
+
+		if !deletedAt.Valid {
+			return nil
+		}
+
+		query = `update reservations set deleted_at = null, deleted_by = '', delete_reason = '', updated_at = $1 where id = $2`
+		if _, err := db.ExecContext(ctx, query, time.Now(), id); err != nil {
+			return err
+		}
+
+		// a cancelled reservation gave up its dates when it was cancelled
+		if res.Status == status.Cancelled {
+			return nil
+		}
+
+		query = `insert into room_restrictions (start_date, end_date, room_id, reservation_id, created_at, updated_at, restriction_id, blocks_availability)
+			values ($1, $2, $3, $4, $5, $5, $6, (select blocks_availability from restrictions where id = $6))`
+		_, err := db.ExecContext(ctx, query, res.StartDate, res.EndDate, res.RoomID, id, time.Now(), repository.ReservationRestrictionID)
+		return err
+	}
+
+	var err error
+	if m.conn == nil {
+		err = update(m.DB)
+	} else {
+		err = runInTx(ctx, m.conn, func(tx *sql.Tx) error { return update(tx) })
+	}
+
+	if isExclusionViolation(err) {
+		return repository.ErrRoomNotAvailable
+	}
+
+	return err
+}
+
+// PurgeDeletedReservations removes the reservations that were moved to the trash before the given time for good and returns how many
+func (m *postgresDBRepo) PurgeDeletedReservations(ctx context.Context, before time.Time) (int, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `delete from reservations where deleted_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+
+	purged, err := result.RowsAffected()
+	return int(purged), err
+}
+
+// Deletes the room restrictions that belong to a reservation
+func (m *postgresDBRepo) DeleteRestrictionsForReservation(ctx context.Context, reservationId int) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	query := `delete from room_restrictions where reservation_id = $1`
+
+	_, err := m.DB.ExecContext(ctx, query, reservationId)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UpdateReservationStatus moves a reservation on to another status and records when it did
+// Returns a *status.TransitionError if the reservation can't move there from its current status, cancelling goes through CancelReservation
+func (m *postgresDBRepo) UpdateReservationStatus(ctx context.Context, id int, to, changedBy string) error {
+	if to == status.Cancelled {
+		_, err := m.CancelReservation(ctx, id, changedBy)
+		return err
+	}
+
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	update := func(db dbtx) error {
+		var from string
+		if err := db.QueryRowContext(ctx, `select status from reservations where id = $1 and deleted_at is null`, id).Scan(&from); err != nil {
+			return err
+		}
+
+		if err := status.Transition(from, to); err != nil {
+			return err
+		}
+
+		// the column is picked from a fixed set, to has been checked to be a status the reservation can move to
+		query := `update reservations set status = $1, ` + statusColumns[to] + ` = $2, changed_by = $3, updated_at = $2 where id = $4`
+		_, err := db.ExecContext(ctx, query, to, time.Now(), changedBy, id)
+		return err
+	}
+
+	if m.conn == nil {
+		return update(m.DB)
+	}
+
+	return runInTx(ctx, m.conn, func(tx *sql.Tx) error { return update(tx) })
+}
+
+func (m *postgresDBRepo) AllRooms(ctx context.Context) ([]models.Room, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	var rooms []models.Room
+
+	query := `select ` + roomColumns + ` from rooms order by room_name`
 
 	rows, err := m.DB.QueryContext(ctx, query)
 	if err != nil {
-		fmt.Sprintf("There was an error querying for all rooms")
-		return rooms, err
+		log.Println("There was an error querying for all rooms")
+		return rooms, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		rm, err := scanRoom(rows)
+		if err != nil {
+			log.Println("There was an error scanning rooms")
+			return rooms, err
+		}
+
+		rooms = append(rooms, rm)
+	}
+
+	if err = rows.Err(); err != nil {
+		return rooms, err
+	}
+
+	return rooms, nil
+}
+
+// Inserts a room and returns its id
+func (m *postgresDBRepo) InsertRoom(ctx context.Context, room models.Room) (int, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	var newId int
+
+	stmt := `insert into rooms (room_name, slug, description, active, max_occupancy, beds, amenities, nightly_rate, weekend_rate, cancellation_policy_id, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt, room.RoomName, room.Slug, room.Description, room.Active, room.MaxOccupancy, room.Beds, joinAmenities(room.Amenities), room.NightlyRate, room.WeekendRate,
+		nullID(room.CancellationPolicyID), time.Now(), time.Now()).Scan(&newId)
+	if err != nil {
+		return 0, err
+	}
+
+	return newId, nil
+}
+
+// Updates a room
+func (m *postgresDBRepo) UpdateRoom(ctx context.Context, room models.Room) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	query := `update rooms set room_name = $1, slug = $2, description = $3, active = $4, max_occupancy = $5, beds = $6, amenities = $7,
+		nightly_rate = $8, weekend_rate = $9, cancellation_policy_id = $10, updated_at = $11
+		where id = $12`
+
+	_, err := m.DB.ExecContext(ctx, query, room.RoomName, room.Slug, room.Description, room.Active, room.MaxOccupancy, room.Beds, joinAmenities(room.Amenities),
+		room.NightlyRate, room.WeekendRate, nullID(room.CancellationPolicyID), time.Now(), room.ID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Deletes a room along with its restrictions, photos, rate plans and stay rules
+// Returns repository.ErrRoomHasReservations if any reservation is for the room, and sql.ErrNoRows if there is no room
+func (m *postgresDBRepo) DeleteRoom(ctx context.Context, id int) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	// The check is part of the delete so a reservation made in the meantime can not slip through
+	query := `delete from rooms where id = $1
+		and not exists (select 1 from reservations where room_id = $1)`
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if deleted == 0 {
+		var reservations int
+		err = m.DB.QueryRowContext(ctx, `select count(id) from reservations where room_id = $1`, id).Scan(&reservations)
+		if err != nil {
+			return err
+		}
+
+		if reservations > 0 {
+			return repository.ErrRoomHasReservations
+		}
+
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// Returns the photos of a room in gallery order
+func (m *postgresDBRepo) GetPhotosForRoom(ctx context.Context, roomId int) ([]models.RoomPhoto, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	var photos []models.RoomPhoto
+
+	query := `select id, room_id, file_name, caption, sort_order, created_at, updated_at
+		from room_photos where room_id = $1 order by sort_order, id`
+
+	rows, err := m.DB.QueryContext(ctx, query, roomId)
+	if err != nil {
+		return photos, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p models.RoomPhoto
+		err := rows.Scan(&p.ID, &p.RoomID, &p.FileName, &p.Caption, &p.SortOrder, &p.CreatedAt, &p.UpdatedAt)
+		if err != nil {
+			return photos, err
+		}
+
+		photos = append(photos, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return photos, err
+	}
+
+	return photos, nil
+}
+
+// Returns a room photo by id
+func (m *postgresDBRepo) GetRoomPhotoById(ctx context.Context, id int) (models.RoomPhoto, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	var p models.RoomPhoto
+
+	query := `select id, room_id, file_name, caption, sort_order, created_at, updated_at from room_photos where id = $1`
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&p.ID, &p.RoomID, &p.FileName, &p.Caption, &p.SortOrder, &p.CreatedAt, &p.UpdatedAt)
+	if err != nil {
+		return p, err
+	}
+
+	return p, nil
+}
+
+// Inserts a room photo at the end of the gallery and returns its id
+func (m *postgresDBRepo) InsertRoomPhoto(ctx context.Context, p models.RoomPhoto) (int, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	var newId int
+
+	stmt := `insert into room_photos (room_id, file_name, caption, sort_order, created_at, updated_at)
+		values ($1, $2, $3, (select coalesce(max(sort_order), 0) + 1 from room_photos where room_id = $1), $4, $5) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt, p.RoomID, p.FileName, p.Caption, time.Now(), time.Now()).Scan(&newId)
+	if err != nil {
+		return 0, err
+	}
+
+	return newId, nil
+}
+
+// Updates the caption and position of a room photo
+func (m *postgresDBRepo) UpdateRoomPhoto(ctx context.Context, p models.RoomPhoto) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	query := `update room_photos set caption = $1, sort_order = $2, updated_at = $3 where id = $4`
+
+	_, err := m.DB.ExecContext(ctx, query, p.Caption, p.SortOrder, time.Now(), p.ID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Deletes a room photo, the file itself is removed by the caller
+func (m *postgresDBRepo) DeleteRoomPhoto(ctx context.Context, id int) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from room_photos where id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ratePlanColumns is the column list scanRatePlan expects
+const ratePlanColumns = `id, room_id, name, start_date, end_date, nightly_rate, day_multipliers, priority, created_at, updated_at, cancellation_policy_id`
+
+// scanRatePlan scans a row selected with ratePlanColumns
+func scanRatePlan(row rowScanner) (models.RatePlan, error) {
+	var p models.RatePlan
+	var multipliers string
+	var policyId sql.NullInt64
+
+	err := row.Scan(&p.ID, &p.RoomID, &p.Name, &p.StartDate, &p.EndDate, &p.NightlyRate, &multipliers, &p.Priority, &p.CreatedAt, &p.UpdatedAt, &policyId)
+	if err != nil {
+		return p, err
+	}
+
+	p.CancellationPolicyID = int(policyId.Int64)
+
+	p.DayMultipliers, err = splitDayMultipliers(multipliers)
+
+	return p, err
+}
+
+// Day multipliers are stored as a comma separated list of percentages, starting with Sunday
+func joinDayMultipliers(m [7]int) string {
+	parts := make([]string, len(m))
+	for i, v := range m {
+		parts[i] = strconv.Itoa(v)
+	}
+
+	return strings.Join(parts, ",")
+}
+
+func splitDayMultipliers(s string) ([7]int, error) {
+	var m [7]int
+
+	parts := strings.Split(s, ",")
+	if len(parts) != len(m) {
+		return m, fmt.Errorf("expected 7 day multipliers but got %q", s)
+	}
+
+	for i, part := range parts {
+		v, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return m, err
+		}
+		m[i] = v
+	}
+
+	return m, nil
+}
+
+// queryRatePlans runs a query selecting ratePlanColumns and scans the results
+func (m *postgresDBRepo) queryRatePlans(ctx context.Context, query string, args ...interface{}) ([]models.RatePlan, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	var plans []models.RatePlan
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return plans, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		p, err := scanRatePlan(rows)
+		if err != nil {
+			return plans, err
+		}
+
+		plans = append(plans, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return plans, err
+	}
+
+	return plans, nil
+}
+
+// Returns all the rate plans of a room ordered by their first night
+func (m *postgresDBRepo) GetRatePlansForRoom(ctx context.Context, roomId int) ([]models.RatePlan, error) {
+	query := `select ` + ratePlanColumns + ` from rate_plans where room_id = $1 order by start_date, id`
+
+	return m.queryRatePlans(ctx, query, roomId)
+}
+
+// Returns the rate plans of a room that cover at least one night of a stay from start to end
+func (m *postgresDBRepo) GetRatePlansForRoomByDate(ctx context.Context, roomId int, start, end time.Time) ([]models.RatePlan, error) {
+	query := `select ` + ratePlanColumns + ` from rate_plans
+		where room_id = $1 and end_date >= $2 and start_date < $3 order by start_date, id`
+
+	return m.queryRatePlans(ctx, query, roomId, start, end)
+}
+
+// Returns a rate plan by id
+func (m *postgresDBRepo) GetRatePlanById(ctx context.Context, id int) (models.RatePlan, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	query := `select ` + ratePlanColumns + ` from rate_plans where id = $1`
+
+	return scanRatePlan(m.DB.QueryRowContext(ctx, query, id))
+}
+
+// Inserts a rate plan and returns its id
+func (m *postgresDBRepo) InsertRatePlan(ctx context.Context, p models.RatePlan) (int, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	var newId int
+
+	stmt := `insert into rate_plans (room_id, name, start_date, end_date, nightly_rate, day_multipliers, priority, cancellation_policy_id, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt, p.RoomID, p.Name, p.StartDate, p.EndDate, p.NightlyRate, joinDayMultipliers(p.DayMultipliers), p.Priority,
+		nullID(p.CancellationPolicyID), time.Now(), time.Now()).Scan(&newId)
+	if err != nil {
+		return 0, err
+	}
+
+	return newId, nil
+}
+
+// Updates a rate plan
+func (m *postgresDBRepo) UpdateRatePlan(ctx context.Context, p models.RatePlan) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	query := `update rate_plans set name = $1, start_date = $2, end_date = $3, nightly_rate = $4, day_multipliers = $5, priority = $6,
+		cancellation_policy_id = $7, updated_at = $8
+		where id = $9`
+
+	_, err := m.DB.ExecContext(ctx, query, p.Name, p.StartDate, p.EndDate, p.NightlyRate, joinDayMultipliers(p.DayMultipliers), p.Priority,
+		nullID(p.CancellationPolicyID), time.Now(), p.ID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Deletes a rate plan
+func (m *postgresDBRepo) DeleteRatePlan(ctx context.Context, id int) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from rate_plans where id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Returns the price of each night of a stay in a room, taking its rate plans into account
+func (m *postgresDBRepo) GetRatesForStay(ctx context.Context, roomId int, start, end time.Time) (pricing.Quote, error) {
+	return ratesForStay(ctx, m, roomId, start, end)
+}
+
+// stayRuleColumns is the column list scanStayRule expects
+const stayRuleColumns = `id, room_id, start_date, end_date, min_nights, max_nights, closed_to_arrival, closed_to_departure, arrival_days, created_at, updated_at`
+
+// scanStayRule scans a row selected with stayRuleColumns
+func scanStayRule(row rowScanner) (models.StayRule, error) {
+	var r models.StayRule
+	var start, end sql.NullTime
+	var arrivalDays string
+
+	err := row.Scan(&r.ID, &r.RoomID, &start, &end, &r.MinNights, &r.MaxNights, &r.ClosedToArrival, &r.ClosedToDeparture, &arrivalDays, &r.CreatedAt, &r.UpdatedAt)
+	if err != nil {
+		return r, err
+	}
+
+	r.StartDate = start.Time
+	r.EndDate = end.Time
+	r.ArrivalDays, err = splitWeekdays(arrivalDays)
+
+	return r, err
+}
+
+// nullDate stores the zero time as null
+func nullDate(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+// nullID stores an id of 0 as null, for optional references to another table
+func nullID(id int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(id), Valid: id != 0}
+}
+
+// Arrival days are stored as a comma separated list of weekday numbers, starting with 0 for Sunday
+func joinWeekdays(days []time.Weekday) string {
+	parts := make([]string, len(days))
+	for i, d := range days {
+		parts[i] = strconv.Itoa(int(d))
+	}
+
+	return strings.Join(parts, ",")
+}
+
+func splitWeekdays(s string) ([]time.Weekday, error) {
+	var days []time.Weekday
+	if s == "" {
+		return days, nil
+	}
+
+	for _, part := range strings.Split(s, ",") {
+		d, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || d < 0 || d > 6 {
+			return days, fmt.Errorf("invalid arrival days %q", s)
+		}
+		days = append(days, time.Weekday(d))
 	}
 
+	return days, nil
+}
+
+// queryStayRules runs a query selecting stayRuleColumns and scans the results
+func (m *postgresDBRepo) queryStayRules(ctx context.Context, query string, args ...interface{}) ([]models.StayRule, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	var rules []models.StayRule
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return rules, err
+	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var rm models.Room
-		err := rows.Scan(
-			&rm.ID,
-			&rm.RoomName,
-			&rm.CreatedAt,
-			&rm.UpdatedAt,
-		)
+		r, err := scanStayRule(rows)
+		if err != nil {
+			return rules, err
+		}
+
+		rules = append(rules, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return rules, err
+	}
+
+	return rules, nil
+}
+
+// Returns all the stay rules of a room, the ones that always apply first
+func (m *postgresDBRepo) GetStayRulesForRoom(ctx context.Context, roomId int) ([]models.StayRule, error) {
+	query := `select ` + stayRuleColumns + ` from stay_rules where room_id = $1 order by start_date is not null, start_date, id`
+
+	return m.queryStayRules(ctx, query, roomId)
+}
+
+// Returns the stay rules of a room that apply on any day from start to end, including the departure day
+func (m *postgresDBRepo) GetStayRulesForRoomByDate(ctx context.Context, roomId int, start, end time.Time) ([]models.StayRule, error) {
+	query := `select ` + stayRuleColumns + ` from stay_rules
+		where room_id = $1 and (start_date is null or (end_date >= $2 and start_date <= $3))
+		order by start_date is not null, start_date, id`
+
+	return m.queryStayRules(ctx, query, roomId, start, end)
+}
+
+// Returns a stay rule by id
+func (m *postgresDBRepo) GetStayRuleById(ctx context.Context, id int) (models.StayRule, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	query := `select ` + stayRuleColumns + ` from stay_rules where id = $1`
+
+	return scanStayRule(m.DB.QueryRowContext(ctx, query, id))
+}
+
+// Inserts a stay rule and returns its id
+func (m *postgresDBRepo) InsertStayRule(ctx context.Context, r models.StayRule) (int, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	var newId int
+
+	stmt := `insert into stay_rules (room_id, start_date, end_date, min_nights, max_nights, closed_to_arrival, closed_to_departure, arrival_days, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt, r.RoomID, nullDate(r.StartDate), nullDate(r.EndDate), r.MinNights, r.MaxNights,
+		r.ClosedToArrival, r.ClosedToDeparture, joinWeekdays(r.ArrivalDays), time.Now(), time.Now()).Scan(&newId)
+	if err != nil {
+		return 0, err
+	}
+
+	return newId, nil
+}
+
+// Updates a stay rule
+func (m *postgresDBRepo) UpdateStayRule(ctx context.Context, r models.StayRule) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
 
+	query := `update stay_rules set start_date = $1, end_date = $2, min_nights = $3, max_nights = $4, closed_to_arrival = $5, closed_to_departure = $6,
+		arrival_days = $7, updated_at = $8 where id = $9`
+
+	_, err := m.DB.ExecContext(ctx, query, nullDate(r.StartDate), nullDate(r.EndDate), r.MinNights, r.MaxNights,
+		r.ClosedToArrival, r.ClosedToDeparture, joinWeekdays(r.ArrivalDays), time.Now(), r.ID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Deletes a stay rule
+func (m *postgresDBRepo) DeleteStayRule(ctx context.Context, id int) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from stay_rules where id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Returns a *stayrules.Violation if a stay in a room breaks one of its stay rules
+func (m *postgresDBRepo) CheckStayRules(ctx context.Context, roomId int, start, end time.Time) error {
+	return checkStayRules(ctx, m, roomId, start, end)
+}
+
+// cancellationPolicyColumns is the column list scanCancellationPolicy expects
+const cancellationPolicyColumns = `id, name, free_days, fee_type, fee_percent, created_at, updated_at`
+
+// scanCancellationPolicy scans a row selected with cancellationPolicyColumns
+func scanCancellationPolicy(row rowScanner) (models.CancellationPolicy, error) {
+	var p models.CancellationPolicy
+	err := row.Scan(&p.ID, &p.Name, &p.FreeDays, &p.FeeType, &p.FeePercent, &p.CreatedAt, &p.UpdatedAt)
+
+	return p, err
+}
+
+// Returns all cancellation policies ordered by name
+func (m *postgresDBRepo) AllCancellationPolicies(ctx context.Context) ([]models.CancellationPolicy, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	var policies []models.CancellationPolicy
+
+	rows, err := m.DB.QueryContext(ctx, `select `+cancellationPolicyColumns+` from cancellation_policies order by name, id`)
+	if err != nil {
+		return policies, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		p, err := scanCancellationPolicy(rows)
 		if err != nil {
-			fmt.Sprintf("There was an error scanning rooms")
-			return rooms, err
+			return policies, err
 		}
 
-		rooms = append(rooms, rm)
+		policies = append(policies, p)
 	}
 
 	if err = rows.Err(); err != nil {
-		return rooms, err
+		return policies, err
 	}
 
-	return rooms, nil
+	return policies, nil
+}
+
+// Returns a cancellation policy by id
+func (m *postgresDBRepo) GetCancellationPolicyById(ctx context.Context, id int) (models.CancellationPolicy, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	query := `select ` + cancellationPolicyColumns + ` from cancellation_policies where id = $1`
+
+	return scanCancellationPolicy(m.DB.QueryRowContext(ctx, query, id))
+}
+
+// Inserts a cancellation policy and returns its id
+func (m *postgresDBRepo) InsertCancellationPolicy(ctx context.Context, p models.CancellationPolicy) (int, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	var newId int
+
+	stmt := `insert into cancellation_policies (name, free_days, fee_type, fee_percent, created_at, updated_at) values ($1, $2, $3, $4, $5, $6) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt, p.Name, p.FreeDays, p.FeeType, p.FeePercent, time.Now(), time.Now()).Scan(&newId)
+	if err != nil {
+		return 0, err
+	}
+
+	return newId, nil
+}
+
+// Updates a cancellation policy, reservations already made keep the terms they were booked with
+func (m *postgresDBRepo) UpdateCancellationPolicy(ctx context.Context, p models.CancellationPolicy) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	query := `update cancellation_policies set name = $1, free_days = $2, fee_type = $3, fee_percent = $4, updated_at = $5 where id = $6`
+
+	_, err := m.DB.ExecContext(ctx, query, p.Name, p.FreeDays, p.FeeType, p.FeePercent, time.Now(), p.ID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Deletes a cancellation policy, the rooms and rate plans using it go back to free cancellation
+func (m *postgresDBRepo) DeleteCancellationPolicy(ctx context.Context, id int) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	// The references are cleared by hand as SQLite doesn't have the foreign keys that would do it
+	update := func(db dbtx) error {
+		if _, err := db.ExecContext(ctx, `update rooms set cancellation_policy_id = null where cancellation_policy_id = $1`, id); err != nil {
+			return err
+		}
+
+		if _, err := db.ExecContext(ctx, `update rate_plans set cancellation_policy_id = null where cancellation_policy_id = $1`, id); err != nil {
+			return err
+		}
+
+		_, err := db.ExecContext(ctx, `delete from cancellation_policies where id = $1`, id)
+		return err
+	}
+
+	if m.conn == nil {
+		return update(m.DB)
+	}
+
+	return runInTx(ctx, m.conn, func(tx *sql.Tx) error { return update(tx) })
+}
+
+// Returns the cancellation terms a stay in a room would be booked with
+func (m *postgresDBRepo) GetCancellationTermsForStay(ctx context.Context, roomId int, start, end time.Time) (models.CancellationTerms, error) {
+	return cancellationTermsForStay(ctx, m, roomId, start, end)
+}
+
+// restrictionColumns is the column list scanRestriction expects
+const restrictionColumns = `id, restriction_name, color, blocks_availability, created_at, updated_at`
+
+// scanRestriction scans a row selected with restrictionColumns
+func scanRestriction(row rowScanner) (models.Restriction, error) {
+	var r models.Restriction
+	err := row.Scan(&r.ID, &r.RestrictionName, &r.Color, &r.BlocksAvailability, &r.CreatedAt, &r.UpdatedAt)
+
+	return r, err
+}
+
+// Returns all restriction types ordered by id
+func (m *postgresDBRepo) AllRestrictions(ctx context.Context) ([]models.Restriction, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	var restrictions []models.Restriction
+
+	rows, err := m.DB.QueryContext(ctx, `select `+restrictionColumns+` from restrictions order by id`)
+	if err != nil {
+		return restrictions, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		r, err := scanRestriction(rows)
+		if err != nil {
+			return restrictions, err
+		}
+
+		restrictions = append(restrictions, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return restrictions, err
+	}
+
+	return restrictions, nil
+}
+
+// Returns a restriction type by id
+func (m *postgresDBRepo) GetRestrictionById(ctx context.Context, id int) (models.Restriction, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	query := `select ` + restrictionColumns + ` from restrictions where id = $1`
+
+	return scanRestriction(m.DB.QueryRowContext(ctx, query, id))
+}
+
+// Inserts a restriction type and returns its id
+func (m *postgresDBRepo) InsertRestriction(ctx context.Context, r models.Restriction) (int, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	var newId int
+
+	stmt := `insert into restrictions (restriction_name, color, blocks_availability, created_at, updated_at) values ($1, $2, $3, $4, $5) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt, r.RestrictionName, r.Color, r.BlocksAvailability, time.Now(), time.Now()).Scan(&newId)
+	if err != nil {
+		return 0, err
+	}
+
+	return newId, nil
+}
+
+// Updates a restriction type and the room restrictions of that type
+// Returns repository.ErrRoomNotAvailable if the type starts blocking availability on dates that are already taken
+func (m *postgresDBRepo) UpdateRestriction(ctx context.Context, r models.Restriction) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	update := func(db dbtx) error {
+		query := `update restrictions set restriction_name = $1, color = $2, blocks_availability = $3, updated_at = $4 where id = $5`
+
+		_, err := db.ExecContext(ctx, query, r.RestrictionName, r.Color, r.BlocksAvailability, time.Now(), r.ID)
+		if err != nil {
+			return err
+		}
+
+		_, err = db.ExecContext(ctx, `update room_restrictions set blocks_availability = $1 where restriction_id = $2`, r.BlocksAvailability, r.ID)
+		return err
+	}
+
+	var err error
+	if m.conn == nil {
+		err = update(m.DB)
+	} else {
+		err = runInTx(ctx, m.conn, func(tx *sql.Tx) error { return update(tx) })
+	}
+
+	if isExclusionViolation(err) {
+		return repository.ErrRoomNotAvailable
+	}
+
+	return err
+}
+
+// Deletes a restriction type, returns repository.ErrRestrictionInUse if rooms are still restricted with it
+func (m *postgresDBRepo) DeleteRestriction(ctx context.Context, id int) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	// The check is part of the delete so a block added in the meantime is not removed by the cascading foreign key
+	query := `delete from restrictions where id = $1
+		and not exists (select 1 from room_restrictions where restriction_id = $1)`
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if deleted == 0 {
+		var used int
+		err = m.DB.QueryRowContext(ctx, `select count(id) from room_restrictions where restriction_id = $1`, id).Scan(&used)
+		if err != nil {
+			return err
+		}
+
+		if used > 0 {
+			return repository.ErrRestrictionInUse
+		}
+	}
+
+	return nil
 }
 
-// Returns restrictions for a room by date range
-func (m *postgresDBRepo) GetRestrictionsForRoomByDate(roomId int, start, end time.Time) ([]models.RoomRestriction, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// Returns restrictions for a room by date range, along with their restriction type
+func (m *postgresDBRepo) GetRestrictionsForRoomByDate(ctx context.Context, roomId int, start, end time.Time) ([]models.RoomRestriction, error) {
+	ctx, cancel := queryContext(ctx, m.App)
 	defer cancel()
 
 	var restrictions []models.RoomRestriction
 
 	// The "coalesce" -> if reservation_id is null use 0 otherwise use the id
-	query := `select id, coalesce(reservation_id, 0), restriction_id, room_id, start_date, end_date
-	from room_restrictions where $1 < end_date and $2 >= start_date and room_id = $3 `
+	query := `select rr.id, coalesce(rr.reservation_id, 0), rr.restriction_id, rr.room_id, rr.start_date, rr.end_date, rr.blocks_availability,
+		r.id, r.restriction_name, r.color, r.blocks_availability
+	from room_restrictions rr
+	left join restrictions r on (r.id = rr.restriction_id)
+	where $1 < rr.end_date and $2 >= rr.start_date and rr.room_id = $3`
 
 	rows, err := m.DB.QueryContext(ctx, query, start, end, roomId)
 	if err != nil {
@@ -449,6 +1800,11 @@ func (m *postgresDBRepo) GetRestrictionsForRoomByDate(roomId int, start, end tim
 			&r.RoomID,
 			&r.StartDate,
 			&r.EndDate,
+			&r.BlocksAvailability,
+			&r.Restriction.ID,
+			&r.Restriction.RestrictionName,
+			&r.Restriction.Color,
+			&r.Restriction.BlocksAvailability,
 		)
 
 		if err != nil {
@@ -465,14 +1821,15 @@ func (m *postgresDBRepo) GetRestrictionsForRoomByDate(roomId int, start, end tim
 	return restrictions, nil
 }
 
-// InsertBlockRoom inserts a room restriction
-func (m *postgresDBRepo) InsertBlockForRoomById(id int, startDate time.Time) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// InsertBlockRoom inserts a room restriction of the given restriction type from startDate until endDate
+func (m *postgresDBRepo) InsertBlockForRoomById(ctx context.Context, id, restrictionId int, startDate, endDate time.Time) error {
+	ctx, cancel := queryContext(ctx, m.App)
 	defer cancel()
 
-	query := `insert into room_restrictions (start_date, end_date, room_id, restriction_id, created_at, updated_at) values ($1, $2, $3, $4, $5, $6)`
+	query := `insert into room_restrictions (start_date, end_date, room_id, restriction_id, created_at, updated_at, blocks_availability)
+		values ($1, $2, $3, $4, $5, $6, (select blocks_availability from restrictions where id = $4))`
 
-	_, err := m.DB.ExecContext(ctx, query, startDate, startDate.AddDate(0, 0, 1), id, 2, time.Now(), time.Now())
+	_, err := m.DB.ExecContext(ctx, query, startDate, endDate, id, restrictionId, time.Now(), time.Now())
 	if err != nil {
 		log.Println(err)
 		return err
@@ -481,9 +1838,19 @@ func (m *postgresDBRepo) InsertBlockForRoomById(id int, startDate time.Time) err
 	return nil
 }
 
+// InsertBlocksForRoom inserts every occurrence of a block in a single transaction and returns how many were inserted
+func (m *postgresDBRepo) InsertBlocksForRoom(ctx context.Context, b models.RoomBlock) (int, error) {
+	n, err := insertBlocks(ctx, m, b)
+	if isExclusionViolation(err) {
+		return 0, repository.ErrRoomNotAvailable
+	}
+
+	return n, err
+}
+
 // DeleteBlockRoom inserts a room restriction
-func (m *postgresDBRepo) DeleteBlockForRoomById(id int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) DeleteBlockForRoomById(ctx context.Context, id int) error {
+	ctx, cancel := queryContext(ctx, m.App)
 	defer cancel()
 
 	query := `delete from room_restrictions where id=$1`
@@ -496,3 +1863,76 @@ func (m *postgresDBRepo) DeleteBlockForRoomById(id int) error {
 
 	return nil
 }
+
+// InsertAuditEntry appends an entry to the audit log
+func (m *postgresDBRepo) InsertAuditEntry(ctx context.Context, e models.AuditEntry) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	changes, err := json.Marshal(e.Changes)
+	if err != nil {
+		return err
+	}
+
+	stmt := `insert into audit_log (user_id, actor, action, entity, entity_id, changes, ip, user_agent, request, created_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
+
+	_, err = m.DB.ExecContext(ctx, stmt, e.UserID, e.Actor, e.Action, e.Entity, e.EntityID, string(changes), e.IP, e.UserAgent, e.Request, e.CreatedAt)
+	return err
+}
+
+// AuditEntries returns the latest entries of the audit log matching f, newest first
+func (m *postgresDBRepo) AuditEntries(ctx context.Context, f models.AuditFilter) ([]models.AuditEntry, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	var where []string
+	var args []interface{}
+	if f.Entity != "" {
+		args = append(args, f.Entity)
+		where = append(where, fmt.Sprintf("entity = $%d", len(args)))
+	}
+	if f.EntityID > 0 {
+		args = append(args, f.EntityID)
+		where = append(where, fmt.Sprintf("entity_id = $%d", len(args)))
+	}
+	if f.Actor != "" {
+		args = append(args, f.Actor)
+		where = append(where, fmt.Sprintf("actor = $%d", len(args)))
+	}
+
+	query := `select id, user_id, actor, action, entity, entity_id, changes, ip, user_agent, request, created_at from audit_log`
+	if len(where) > 0 {
+		query += ` where ` + strings.Join(where, " and ")
+	}
+	query += ` order by id desc limit ` + strconv.Itoa(auditEntriesLimit)
+
+	var entries []models.AuditEntry
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return entries, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e models.AuditEntry
+		var changes string
+		err := rows.Scan(&e.ID, &e.UserID, &e.Actor, &e.Action, &e.Entity, &e.EntityID, &changes, &e.IP, &e.UserAgent, &e.Request, &e.CreatedAt)
+		if err != nil {
+			return entries, err
+		}
+
+		if err := json.Unmarshal([]byte(changes), &e.Changes); err != nil {
+			return entries, err
+		}
+
+		entries = append(entries, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return entries, err
+	}
+
+	return entries, nil
+}
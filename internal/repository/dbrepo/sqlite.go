@@ -0,0 +1,102 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/hd719/go-bookings/internal/config"
+	"github.com/hd719/go-bookings/internal/models"
+	"github.com/hd719/go-bookings/internal/repository"
+	"github.com/mattn/go-sqlite3"
+)
+
+// sqliteDBRepo runs the postgres queries against SQLite, they are written to work on both,
+// only the methods that depend on postgres specific behaviour are overridden here
+type sqliteDBRepo struct {
+	*postgresDBRepo
+}
+
+func NewSQLiteRepo(conn *sql.DB, a *config.AppConfig) repository.DatabaseRepo {
+	return audited(&sqliteDBRepo{
+		postgresDBRepo: &postgresDBRepo{
+			App:  a,
+			DB:   conn,
+			conn: conn,
+		},
+	})
+}
+
+// BookRoom prices a reservation and inserts it and its room restriction in a single transaction
+// The overlap triggers on room_restrictions play the part of the postgres exclusion constraint
+func (m *sqliteDBRepo) BookRoom(ctx context.Context, res models.Reservation) (int, error) {
+	newId, err := m.postgresDBRepo.BookRoom(ctx, res)
+	if isOverlapTriggerViolation(err) {
+		return 0, repository.ErrRoomNotAvailable
+	}
+
+	return newId, err
+}
+
+// ChangeReservationDates moves a reservation to new dates and reprices it, together with the room restriction holding its dates
+func (m *sqliteDBRepo) ChangeReservationDates(ctx context.Context, id int, start, end time.Time, changedBy string) (models.Reservation, error) {
+	res, err := m.postgresDBRepo.ChangeReservationDates(ctx, id, start, end, changedBy)
+	if isOverlapTriggerViolation(err) {
+		return models.Reservation{}, repository.ErrRoomNotAvailable
+	}
+
+	return res, err
+}
+
+// RestoreReservation takes a reservation out of the trash and holds its dates again, unless it was cancelled
+func (m *sqliteDBRepo) RestoreReservation(ctx context.Context, id int) error {
+	err := m.postgresDBRepo.RestoreReservation(ctx, id)
+	if isOverlapTriggerViolation(err) {
+		return repository.ErrRoomNotAvailable
+	}
+
+	return err
+}
+
+// UpdateRestriction updates a restriction type and the room restrictions of that type
+func (m *sqliteDBRepo) UpdateRestriction(ctx context.Context, r models.Restriction) error {
+	err := m.postgresDBRepo.UpdateRestriction(ctx, r)
+	if isOverlapTriggerViolation(err) {
+		return repository.ErrRoomNotAvailable
+	}
+
+	return err
+}
+
+// InsertBlocksForRoom inserts every occurrence of a block in a single transaction and returns how many were inserted
+func (m *sqliteDBRepo) InsertBlocksForRoom(ctx context.Context, b models.RoomBlock) (int, error) {
+	n, err := insertBlocks(ctx, m, b)
+	if isOverlapTriggerViolation(err) {
+		return 0, repository.ErrRoomNotAvailable
+	}
+
+	return n, err
+}
+
+// WithTx runs fn inside a transaction, if the repo is already bound to a transaction fn joins it
+func (m *sqliteDBRepo) WithTx(ctx context.Context, fn func(repo repository.DatabaseRepo) error) error {
+	if m.conn == nil {
+		return fn(m)
+	}
+
+	return runInTx(ctx, m.conn, func(tx *sql.Tx) error {
+		return fn(&sqliteDBRepo{postgresDBRepo: &postgresDBRepo{App: m.App, DB: tx}})
+	})
+}
+
+// isOverlapTriggerViolation reports whether err was raised by the room_restrictions_no_overlap triggers
+func isOverlapTriggerViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code == sqlite3.ErrConstraint && strings.Contains(sqliteErr.Error(), "room_restrictions_no_overlap")
+	}
+
+	return false
+}
@@ -0,0 +1,292 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"io/fs"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hd719/go-bookings/internal/config"
+	"github.com/hd719/go-bookings/internal/driver"
+	"github.com/hd719/go-bookings/internal/migrate"
+	"github.com/hd719/go-bookings/internal/models"
+	"github.com/hd719/go-bookings/internal/repository"
+	"github.com/hd719/go-bookings/migrations"
+	"github.com/hd719/go-bookings/seed"
+)
+
+func newSQLiteTestRepo(t *testing.T) repository.DatabaseRepo {
+	return NewSQLiteRepo(newSQLiteTestDB(t), &config.AppConfig{})
+}
+
+// newSQLiteTestDB returns a migrated and seeded SQLite database, removed when the test is done
+func newSQLiteTestDB(t *testing.T) *sql.DB {
+	db, err := driver.ConnectSQLite(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.SQL.Close() })
+
+	for _, source := range []fs.FS{migrations.FS, seed.FS} {
+		m, err := migrate.New(db.SQL, db.Dialect, source)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := m.Up(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return db.SQL
+}
+
+// TestSQLiteSeed_Upgrade checks that a database seeded before the rooms and restrictions gained new columns ends up
+// with the same seeded data as a fresh one
+func TestSQLiteSeed_Upgrade(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := driver.ConnectSQLite(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.SQL.Close()
+
+	// a database migrated and seeded before any of these changes, SQLite's first schema is the one postgres had then
+	up := func(source fs.FS, before string) {
+		m, err := migrate.New(db.SQL, db.Dialect, source)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var older []migrate.Migration
+		for _, mig := range m.Migrations {
+			if mig.Version < before {
+				older = append(older, mig)
+			}
+		}
+		m.Migrations = older
+
+		if _, err := m.Up(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	up(migrations.FS, "20261018110000")
+	up(seed.FS, "20261018110000")
+	up(migrations.FS, "99999999999999")
+
+	// the migrations fill in the seeded rooms, the app doesn't ask for the seeds to be run again
+	repo := NewSQLiteRepo(db.SQL, &config.AppConfig{})
+	checkRooms(t, "upgraded", repo)
+
+	up(seed.FS, "99999999999999")
+
+	restrictions, err := repo.AllRestrictions(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	colors := map[int]string{1: "#dc3545", 2: "#ffc107", 3: "#17a2b8", 4: "#343a40", 5: "#6f42c1"}
+	if len(restrictions) != len(colors) {
+		t.Fatalf("expected %d restriction types but got %+v", len(colors), restrictions)
+	}
+
+	for _, r := range restrictions {
+		if r.Color != colors[r.ID] {
+			t.Errorf("expected restriction %d to be %s but it is %s", r.ID, colors[r.ID], r.Color)
+		}
+	}
+
+	id, err := repo.InsertRestriction(ctx, models.Restriction{RestrictionName: "Deep Clean", Color: "#28a745", BlocksAvailability: true})
+	if err != nil || id != 6 {
+		t.Errorf("expected the next restriction to get id 6 but got %d, %v", id, err)
+	}
+
+	freshRepo := NewSQLiteRepo(newSQLiteTestDB(t), &config.AppConfig{})
+
+	// a fresh database gets the same types
+	fresh, err := freshRepo.AllRestrictions(ctx)
+	if err != nil || len(fresh) != len(colors) || fresh[0].Color != colors[1] {
+		t.Errorf("expected the seeded restriction types in a fresh database but got %+v, %v", fresh, err)
+	}
+
+	checkRooms(t, "fresh", freshRepo)
+}
+
+// checkRooms checks that the seeded rooms have their slugs, descriptions and rates and that new rooms can be added
+func checkRooms(t *testing.T, name string, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	rooms := []models.Room{
+		{ID: 1, Slug: "generals-quarters", NightlyRate: 12000, WeekendRate: 15000},
+		{ID: 2, Slug: "majors-suite", NightlyRate: 15000, WeekendRate: 18000},
+	}
+
+	for _, e := range rooms {
+		room, err := repo.GetRoomById(ctx, e.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if room.Slug != e.Slug || room.Description == "" || room.NightlyRate != e.NightlyRate || room.WeekendRate != e.WeekendRate {
+			t.Errorf("%s: expected room %d to have its slug, description and rates but got %+v", name, e.ID, room)
+		}
+	}
+
+	// rooms added later don't clash with the seeded ones
+	if _, err := repo.InsertRoom(ctx, models.Room{RoomName: "Colonel's Cabin", Slug: "colonels-cabin-" + name, Active: true}); err != nil {
+		t.Errorf("%s: expected a new room to be added but got %v", name, err)
+	}
+}
+
+func TestSQLiteDBRepo_BookRoom(t *testing.T) {
+	testBookRoom(t, newSQLiteTestRepo(t))
+}
+
+func TestSQLiteDBRepo_WithTx(t *testing.T) {
+	testWithTx(t, newSQLiteTestRepo(t))
+}
+
+func TestSQLiteDBRepo_OverlapTrigger(t *testing.T) {
+	repo := newSQLiteTestRepo(t)
+	ctx := context.Background()
+
+	if err := repo.InsertBlockForRoomById(ctx, 2, 2, date("2050-01-01"), date("2050-01-02")); err != nil {
+		t.Fatal(err)
+	}
+
+	// blocks skip the availability check in BookRoom, the trigger still has to catch the overlap
+	if err := repo.InsertBlockForRoomById(ctx, 2, 2, date("2050-01-01"), date("2050-01-02")); !isOverlapTriggerViolation(err) {
+		t.Errorf("expected the overlap trigger to fire but got %v", err)
+	}
+}
+
+func TestSQLiteDBRepo_DeleteRoom(t *testing.T) {
+	testDeleteRoom(t, newSQLiteTestRepo(t))
+}
+
+func TestSQLiteDBRepo_RoomPhotos(t *testing.T) {
+	testRoomPhotos(t, newSQLiteTestRepo(t))
+}
+
+func TestSQLiteDBRepo_RatePlans(t *testing.T) {
+	testRatePlans(t, newSQLiteTestRepo(t))
+}
+
+func TestSQLiteDBRepo_StayRules(t *testing.T) {
+	testStayRules(t, newSQLiteTestRepo(t))
+}
+
+func TestSQLiteDBRepo_Restrictions(t *testing.T) {
+	testRestrictions(t, newSQLiteTestRepo(t))
+}
+
+func TestSQLiteDBRepo_RoomBlocks(t *testing.T) {
+	testRoomBlocks(t, newSQLiteTestRepo(t))
+}
+
+func TestSQLiteDBRepo_ConfirmationCodes(t *testing.T) {
+	testConfirmationCodes(t, newSQLiteTestRepo(t))
+}
+
+func TestSQLiteDBRepo_ChangeReservation(t *testing.T) {
+	testChangeReservation(t, newSQLiteTestRepo(t))
+}
+
+func TestSQLiteDBRepo_CancellationPolicies(t *testing.T) {
+	testCancellationPolicies(t, newSQLiteTestRepo(t))
+}
+
+func TestSQLiteDBRepo_ReservationStatus(t *testing.T) {
+	testReservationStatus(t, newSQLiteTestRepo(t))
+}
+
+func TestSQLiteDBRepo_SoftDelete(t *testing.T) {
+	testSoftDelete(t, newSQLiteTestRepo(t))
+}
+
+func TestSQLiteDBRepo_AuditLog(t *testing.T) {
+	repo := newSQLiteTestRepo(t)
+	testAuditLog(t, repo)
+
+	// the audit log is append-only, the triggers refuse to change or remove entries
+	db := repo.(*auditRepo).DatabaseRepo.(*sqliteDBRepo).DB
+	if _, err := db.ExecContext(context.Background(), `update audit_log set actor = 'someone else'`); err == nil {
+		t.Error("expected updating the audit log to fail")
+	}
+
+	if _, err := db.ExecContext(context.Background(), `delete from audit_log`); err == nil {
+		t.Error("expected deleting from the audit log to fail")
+	}
+}
+
+func TestSQLiteDBRepo_Users(t *testing.T) {
+	testUsers(t, newSQLiteTestRepo(t))
+}
+
+func TestSQLiteDBRepo_PasswordResets(t *testing.T) {
+	testPasswordResets(t, newSQLiteTestRepo(t))
+}
+
+func TestSQLiteDBRepo_TwoFactor(t *testing.T) {
+	testTwoFactor(t, newSQLiteTestRepo(t))
+}
+
+func TestSQLiteAttemptStore(t *testing.T) {
+	s := NewAttemptStore(newSQLiteTestDB(t), &config.AppConfig{})
+	ctx := context.Background()
+	now := time.Now()
+
+	a, err := s.Get(ctx, "account:admin@admin.com")
+	if err != nil || a.Failures != 0 {
+		t.Fatalf("expected no attempts for an unknown key but got %v, %v", a, err)
+	}
+
+	for i := 1; i <= 3; i++ {
+		a, err = s.Fail(ctx, "account:admin@admin.com", now, now.Add(-time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if a.Failures != i {
+			t.Errorf("expected %d failures but got %d", i, a.Failures)
+		}
+	}
+
+	a, err = s.Get(ctx, "account:admin@admin.com")
+	if err != nil || a.Failures != 3 || !a.Last.Equal(now) {
+		t.Errorf("expected 3 failures, the last now, but got %v, %v", a, err)
+	}
+
+	// a failure long after the last one starts the count over
+	a, err = s.Fail(ctx, "account:admin@admin.com", now.Add(2*time.Hour), now.Add(time.Hour))
+	if err != nil || a.Failures != 1 {
+		t.Errorf("expected the count to start over but got %v, %v", a, err)
+	}
+
+	if _, err = s.Fail(ctx, "ip:10.0.0.1", now, now.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Prune(ctx, now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	if a, _ = s.Get(ctx, "ip:10.0.0.1"); a.Failures != 0 {
+		t.Error("expected old attempts to be pruned")
+	}
+
+	if a, _ = s.Get(ctx, "account:admin@admin.com"); a.Failures != 1 {
+		t.Error("expected recent attempts to be kept")
+	}
+
+	if err := s.Reset(ctx, "account:admin@admin.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	if a, _ = s.Get(ctx, "account:admin@admin.com"); a.Failures != 0 {
+		t.Error("expected no attempts after a reset")
+	}
+}
@@ -1,29 +1,133 @@
 package repository
 
 import (
+	"context"
+	"errors"
 	"time"
 
 	"github.com/hd719/go-bookings/internal/models"
+	"github.com/hd719/go-bookings/internal/pricing"
 )
 
+// ErrRoomNotAvailable is returned when a room has already been reserved or blocked for the requested dates
+var ErrRoomNotAvailable = errors.New("room is no longer available for the requested dates")
+
+// ErrRoomHasReservations is returned when deleting a room that has reservations, past ones and ones in the trash
+// included, the room has to be deactivated instead so they are kept
+var ErrRoomHasReservations = errors.New("room has reservations")
+
+// ErrRestrictionInUse is returned when deleting a restriction type that rooms are still restricted with
+var ErrRestrictionInUse = errors.New("restriction is in use")
+
+// ErrReservationCancelled is returned when changing a reservation that has been cancelled
+var ErrReservationCancelled = errors.New("reservation has been cancelled")
+
+// BlockConflictError is returned when a block can't be added because the room is already reserved or blocked,
+// Date is the first day of the occurrence that clashes. It matches ErrRoomNotAvailable with errors.Is
+type BlockConflictError struct {
+	Date time.Time
+}
+
+func (e *BlockConflictError) Error() string {
+	return "room is already reserved or blocked on " + e.Date.Format("January 2, 2006")
+}
+
+func (e *BlockConflictError) Unwrap() error {
+	return ErrRoomNotAvailable
+}
+
+// ErrEmailTaken is returned when adding or changing a user to an email another user already has
+var ErrEmailTaken = errors.New("email is already used by another user")
+
+// ErrPasswordResetInvalid is returned when using a password reset token that is unknown, expired or already used
+var ErrPasswordResetInvalid = errors.New("password reset link is invalid or has expired")
+
+// ReservationRestrictionID is the restriction type of the room restrictions that hold a reservation
+const ReservationRestrictionID = 1
+
+// DatabaseRepo is implemented by every storage backend, each method takes the context of the request it is serving
+// so that queries are cancelled when the client goes away or the server shuts down
 type DatabaseRepo interface {
-	AllUsers() bool
-	InsertReservation(res models.Reservation) (int, error)
-	InsertRoomRestriction(r models.RoomRestriction) error
-	SearchAvailabilityByDatesForRoomId(start, end time.Time, roomId int) (bool, error)
-	SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error)
-	GetRoomById(id int) (models.Room, error)
-	GetUserById(id int) (models.User, error)
-	UpdateUser(u models.User) error
-	Authenticate(email, testPassword string) (int, string, error)
-	AllReservations() ([]models.Reservation, error)
-	AllNewReservations() ([]models.Reservation, error)
-	GetReservationById(id int) (models.Reservation, error)
-	UpdateReservation(u models.Reservation) error
-	DeleteReservation(id int) error
-	UpdateProcessedForReservation(id, processed int) error
-	AllRooms() ([]models.Room, error)
-	GetRestrictionsForRoomByDate(roomId int, start, end time.Time) ([]models.RoomRestriction, error)
-	InsertBlockForRoomById(id int, startDate time.Time) error
-	DeleteBlockForRoomById(id int) error
+	AllUsers(ctx context.Context) ([]models.User, error)
+	InsertReservation(ctx context.Context, res models.Reservation) (int, error)
+	InsertRoomRestriction(ctx context.Context, r models.RoomRestriction) (int, error)
+	BookRoom(ctx context.Context, res models.Reservation) (int, error)
+	SearchAvailabilityByDatesForRoomId(ctx context.Context, start, end time.Time, roomId int) (bool, error)
+	SearchAvailabilityForAllRooms(ctx context.Context, start, end time.Time) ([]models.Room, []models.RoomExclusion, error)
+	GetRoomById(ctx context.Context, id int) (models.Room, error)
+	GetRoomBySlug(ctx context.Context, slug string) (models.Room, error)
+	GetUserById(ctx context.Context, id int) (models.User, error)
+	GetUserByEmail(ctx context.Context, email string) (models.User, error)
+	InsertUser(ctx context.Context, u models.User) (int, error)
+	UpdateUser(ctx context.Context, u models.User) error
+	UpdateUserPassword(ctx context.Context, id int, password string) error
+	SetUserDisabled(ctx context.Context, id int, disabled bool) error
+	Authenticate(ctx context.Context, email, testPassword string) (int, string, error)
+	InsertPasswordReset(ctx context.Context, p models.PasswordReset) error
+	GetPasswordReset(ctx context.Context, tokenHash string) (models.PasswordReset, error)
+	UsePasswordReset(ctx context.Context, tokenHash string) (int, error)
+	SetUserTOTPSecret(ctx context.Context, id int, secret string) error
+	UseUserTOTPStep(ctx context.Context, id int, step int64) (bool, error)
+	ReplaceRecoveryCodes(ctx context.Context, userId int, hashes []string) error
+	UnusedRecoveryCodes(ctx context.Context, userId int) ([]models.RecoveryCode, error)
+	UseRecoveryCode(ctx context.Context, id int) (bool, error)
+	AllReservations(ctx context.Context) ([]models.Reservation, error)
+	AllNewReservations(ctx context.Context) ([]models.Reservation, error)
+	ReservationsByStatus(ctx context.Context, status string) ([]models.Reservation, error)
+	GetReservationById(ctx context.Context, id int) (models.Reservation, error)
+	GetReservationByConfirmationCode(ctx context.Context, code, email string) (models.Reservation, error)
+	UpdateReservation(ctx context.Context, u models.Reservation) error
+	UpdateReservationDates(ctx context.Context, res models.Reservation) error
+	ChangeReservationDates(ctx context.Context, id int, start, end time.Time, changedBy string) (models.Reservation, error)
+	CancelReservation(ctx context.Context, id int, changedBy string) (int, error)
+	DeleteReservation(ctx context.Context, id int, deletedBy, reason string) error
+	RestoreReservation(ctx context.Context, id int) error
+	DeletedReservations(ctx context.Context) ([]models.Reservation, error)
+	PurgeDeletedReservations(ctx context.Context, before time.Time) (int, error)
+	DeleteRestrictionsForReservation(ctx context.Context, reservationId int) error
+	UpdateReservationStatus(ctx context.Context, id int, to, changedBy string) error
+	AllRooms(ctx context.Context) ([]models.Room, error)
+	InsertRoom(ctx context.Context, room models.Room) (int, error)
+	UpdateRoom(ctx context.Context, room models.Room) error
+	DeleteRoom(ctx context.Context, id int) error
+	GetPhotosForRoom(ctx context.Context, roomId int) ([]models.RoomPhoto, error)
+	GetRoomPhotoById(ctx context.Context, id int) (models.RoomPhoto, error)
+	InsertRoomPhoto(ctx context.Context, p models.RoomPhoto) (int, error)
+	UpdateRoomPhoto(ctx context.Context, p models.RoomPhoto) error
+	DeleteRoomPhoto(ctx context.Context, id int) error
+	GetRatePlansForRoom(ctx context.Context, roomId int) ([]models.RatePlan, error)
+	GetRatePlansForRoomByDate(ctx context.Context, roomId int, start, end time.Time) ([]models.RatePlan, error)
+	GetRatePlanById(ctx context.Context, id int) (models.RatePlan, error)
+	InsertRatePlan(ctx context.Context, p models.RatePlan) (int, error)
+	UpdateRatePlan(ctx context.Context, p models.RatePlan) error
+	DeleteRatePlan(ctx context.Context, id int) error
+	GetRatesForStay(ctx context.Context, roomId int, start, end time.Time) (pricing.Quote, error)
+	GetStayRulesForRoom(ctx context.Context, roomId int) ([]models.StayRule, error)
+	GetStayRulesForRoomByDate(ctx context.Context, roomId int, start, end time.Time) ([]models.StayRule, error)
+	GetStayRuleById(ctx context.Context, id int) (models.StayRule, error)
+	InsertStayRule(ctx context.Context, r models.StayRule) (int, error)
+	UpdateStayRule(ctx context.Context, r models.StayRule) error
+	DeleteStayRule(ctx context.Context, id int) error
+	CheckStayRules(ctx context.Context, roomId int, start, end time.Time) error
+	AllCancellationPolicies(ctx context.Context) ([]models.CancellationPolicy, error)
+	GetCancellationPolicyById(ctx context.Context, id int) (models.CancellationPolicy, error)
+	InsertCancellationPolicy(ctx context.Context, p models.CancellationPolicy) (int, error)
+	UpdateCancellationPolicy(ctx context.Context, p models.CancellationPolicy) error
+	DeleteCancellationPolicy(ctx context.Context, id int) error
+	GetCancellationTermsForStay(ctx context.Context, roomId int, start, end time.Time) (models.CancellationTerms, error)
+	AllRestrictions(ctx context.Context) ([]models.Restriction, error)
+	GetRestrictionById(ctx context.Context, id int) (models.Restriction, error)
+	InsertRestriction(ctx context.Context, r models.Restriction) (int, error)
+	UpdateRestriction(ctx context.Context, r models.Restriction) error
+	DeleteRestriction(ctx context.Context, id int) error
+	GetRestrictionsForRoomByDate(ctx context.Context, roomId int, start, end time.Time) ([]models.RoomRestriction, error)
+	InsertBlockForRoomById(ctx context.Context, id, restrictionId int, startDate, endDate time.Time) error
+	InsertBlocksForRoom(ctx context.Context, b models.RoomBlock) (int, error)
+	DeleteBlockForRoomById(ctx context.Context, id int) error
+	InsertAuditEntry(ctx context.Context, e models.AuditEntry) error
+	AuditEntries(ctx context.Context, f models.AuditFilter) ([]models.AuditEntry, error)
+
+	// WithTx runs fn in a single database transaction, the repo handed to fn must be used for every operation that
+	// should be part of it. The transaction is rolled back if fn returns an error or panics and committed otherwise
+	WithTx(ctx context.Context, fn func(repo DatabaseRepo) error) error
 }
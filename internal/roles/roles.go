@@ -0,0 +1,65 @@
+// Package roles is what each kind of staff account is allowed to do in the admin
+package roles
+
+// The roles a user can have, from the most to the least trusted
+const (
+	Owner     = "owner"
+	Manager   = "manager"
+	FrontDesk = "front_desk"
+	ReadOnly  = "read_only"
+)
+
+// All lists every role in the order they are offered to pick from
+var All = []string{Owner, Manager, FrontDesk, ReadOnly}
+
+// Permission is something a role may be allowed to do, each admin route requires one
+type Permission string
+
+// The permissions the admin routes require
+const (
+	View             Permission = "view"              // see reservations, the calendar, rooms and restriction types
+	EditReservations Permission = "edit_reservations" // change, cancel, delete and restore reservations and block days
+	ManageRooms      Permission = "manage_rooms"      // change rooms, rates, stay rules, restriction types and cancellation policies
+	ViewAudit        Permission = "view_audit"        // browse the audit log
+	ManageUsers      Permission = "manage_users"      // invite, edit and disable staff accounts
+)
+
+var permissions = map[string][]Permission{
+	Owner:     {View, EditReservations, ManageRooms, ViewAudit, ManageUsers},
+	Manager:   {View, EditReservations, ManageRooms, ViewAudit},
+	FrontDesk: {View, EditReservations},
+	ReadOnly:  {View},
+}
+
+var labels = map[string]string{
+	Owner:     "Owner",
+	Manager:   "Manager",
+	FrontDesk: "Front desk",
+	ReadOnly:  "Read-only",
+}
+
+// Valid reports whether role is one of the roles
+func Valid(role string) bool {
+	_, ok := labels[role]
+	return ok
+}
+
+// Label returns the name of a role as shown to people, e.g. "Front desk"
+func Label(role string) string {
+	if l, ok := labels[role]; ok {
+		return l
+	}
+
+	return role
+}
+
+// Can reports whether users with role have permission p, unknown roles have none
+func Can(role string, p Permission) bool {
+	for _, granted := range permissions[role] {
+		if granted == p {
+			return true
+		}
+	}
+
+	return false
+}
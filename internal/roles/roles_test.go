@@ -0,0 +1,37 @@
+package roles
+
+import "testing"
+
+func TestCan(t *testing.T) {
+	var tests = []struct {
+		role    string
+		p       Permission
+		allowed bool
+	}{
+		{Owner, ManageUsers, true},
+		{Manager, ManageUsers, false},
+		{Manager, ManageRooms, true},
+		{Manager, ViewAudit, true},
+		{FrontDesk, EditReservations, true},
+		{FrontDesk, ManageRooms, false},
+		{FrontDesk, ViewAudit, false},
+		{ReadOnly, View, true},
+		{ReadOnly, EditReservations, false},
+		{"", View, false},
+		{"unknown", View, false},
+	}
+
+	for _, e := range tests {
+		if got := Can(e.role, e.p); got != e.allowed {
+			t.Errorf("expected %q to have %s %v but got %v", e.role, e.p, e.allowed, got)
+		}
+	}
+}
+
+func TestEveryRoleCanView(t *testing.T) {
+	for _, role := range All {
+		if !Valid(role) || !Can(role, View) {
+			t.Errorf("expected %s to be a valid role that can view the admin", role)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+// Package signing signs the links sent to guests, so they can be trusted without the guest logging in
+package signing
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+)
+
+// Sign returns the signature of message with secret, safe to use in a URL
+func Sign(secret []byte, message string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(message))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature was made by Sign with the same secret and message
+func Verify(secret []byte, message, signature string) bool {
+	got, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(message))
+
+	return hmac.Equal(got, mac.Sum(nil))
+}
@@ -0,0 +1,29 @@
+package signing
+
+import "testing"
+
+func TestVerify(t *testing.T) {
+	secret := []byte("secret")
+	signature := Sign(secret, "reservation:1:K7MD2QXW9P")
+
+	var tests = []struct {
+		name      string
+		secret    string
+		message   string
+		signature string
+		valid     bool
+	}{
+		{"signed", "secret", "reservation:1:K7MD2QXW9P", signature, true},
+		{"other message", "secret", "reservation:2:K7MD2QXW9P", signature, false},
+		{"other secret", "other", "reservation:1:K7MD2QXW9P", signature, false},
+		{"tampered", "secret", "reservation:1:K7MD2QXW9P", signature[1:], false},
+		{"not base64", "secret", "reservation:1:K7MD2QXW9P", "%%%", false},
+		{"empty", "secret", "reservation:1:K7MD2QXW9P", "", false},
+	}
+
+	for _, e := range tests {
+		if got := Verify([]byte(e.secret), e.message, e.signature); got != e.valid {
+			t.Errorf("%s: Verify returned %v, expected %v", e.name, got, e.valid)
+		}
+	}
+}
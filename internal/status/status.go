@@ -0,0 +1,79 @@
+// Package status is the lifecycle of a reservation, from booked to checked out, and the changes allowed between its statuses
+package status
+
+import "fmt"
+
+// The statuses a reservation goes through, new reservations are pending
+const (
+	Pending    = "pending"
+	Confirmed  = "confirmed"
+	CheckedIn  = "checked_in"
+	CheckedOut = "checked_out"
+	Cancelled  = "cancelled"
+	NoShow     = "no_show"
+)
+
+// All lists every status in the order a reservation goes through them
+var All = []string{Pending, Confirmed, CheckedIn, CheckedOut, Cancelled, NoShow}
+
+// transitions maps each status to the statuses a reservation can move on to from it, in the order they are offered to admins
+var transitions = map[string][]string{
+	Pending:   {Confirmed, Cancelled},
+	Confirmed: {CheckedIn, NoShow, Cancelled},
+	CheckedIn: {CheckedOut},
+}
+
+var labels = map[string]string{
+	Pending:    "Pending",
+	Confirmed:  "Confirmed",
+	CheckedIn:  "Checked in",
+	CheckedOut: "Checked out",
+	Cancelled:  "Cancelled",
+	NoShow:     "No-show",
+}
+
+// TransitionError is returned when a reservation can't move from its status to another one
+type TransitionError struct {
+	From string
+	To   string
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("A %s reservation can't be marked as %s", Label(e.From), Label(e.To))
+}
+
+// Valid reports whether s is one of the statuses
+func Valid(s string) bool {
+	_, ok := labels[s]
+	return ok
+}
+
+// Label returns the name of a status as shown to people, e.g. "Checked in"
+func Label(s string) string {
+	if l, ok := labels[s]; ok {
+		return l
+	}
+
+	return s
+}
+
+// Next returns the statuses a reservation can move on to from s, none once it has ended
+func Next(s string) []string {
+	return transitions[s]
+}
+
+// Open reports whether a reservation with status s is still to come, so it can be changed or cancelled
+func Open(s string) bool {
+	return s == Pending || s == Confirmed
+}
+
+// Transition returns a *TransitionError unless a reservation can move from one status to the other
+func Transition(from, to string) error {
+	for _, next := range transitions[from] {
+		if next == to {
+			return nil
+		}
+	}
+
+	return &TransitionError{From: from, To: to}
+}
@@ -0,0 +1,63 @@
+package status
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTransition(t *testing.T) {
+	var tests = []struct {
+		from    string
+		to      string
+		allowed bool
+	}{
+		{Pending, Confirmed, true},
+		{Pending, Cancelled, true},
+		{Pending, CheckedIn, false},
+		{Confirmed, CheckedIn, true},
+		{Confirmed, NoShow, true},
+		{Confirmed, Cancelled, true},
+		{Confirmed, Pending, false},
+		{CheckedIn, CheckedOut, true},
+		{CheckedIn, Cancelled, false},
+		{CheckedOut, CheckedIn, false},
+		{Cancelled, Confirmed, false},
+		{NoShow, CheckedIn, false},
+		{Pending, "unknown", false},
+	}
+
+	for _, e := range tests {
+		err := Transition(e.from, e.to)
+		if e.allowed && err != nil {
+			t.Errorf("expected %s to %s to be allowed but got %v", e.from, e.to, err)
+		}
+
+		var te *TransitionError
+		if !e.allowed && !errors.As(err, &te) {
+			t.Errorf("expected %s to %s to fail with a *TransitionError but got %v", e.from, e.to, err)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	for _, s := range All {
+		for _, next := range Next(s) {
+			if err := Transition(s, next); err != nil {
+				t.Errorf("%s is offered after %s but the transition fails: %v", next, s, err)
+			}
+		}
+	}
+
+	for _, s := range []string{CheckedOut, Cancelled, NoShow} {
+		if len(Next(s)) != 0 {
+			t.Errorf("expected %s to be final but got %v", s, Next(s))
+		}
+	}
+}
+
+func TestTransitionError(t *testing.T) {
+	err := Transition(CheckedOut, Confirmed)
+	if err == nil || err.Error() != "A Checked out reservation can't be marked as Confirmed" {
+		t.Errorf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,88 @@
+// Package stayrules checks a stay against the booking rules of a room, e.g. a minimum number of nights
+package stayrules
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hd719/go-bookings/internal/models"
+)
+
+// Violation is returned when a stay breaks a stay rule, Reason can be shown to the guest as is
+type Violation struct {
+	Rule   models.StayRule
+	Reason string
+}
+
+func (v *Violation) Error() string {
+	return v.Reason
+}
+
+// Covers reports whether rule applies on date, rules without dates apply every day
+func Covers(rule models.StayRule, date time.Time) bool {
+	if rule.StartDate.IsZero() {
+		return true
+	}
+
+	return !date.Before(rule.StartDate) && !date.After(rule.EndDate)
+}
+
+// Check returns a *Violation for the first rule the stay from start to end breaks, or nil if the stay is allowed
+// The length of stay and arrival day rules apply to stays arriving while the rule is in effect,
+// closed to departure applies to stays leaving while it is in effect
+func Check(rules []models.StayRule, start, end time.Time) error {
+	nights := int(end.Sub(start).Hours() / 24)
+
+	for _, rule := range rules {
+		if Covers(rule, end) && rule.ClosedToDeparture {
+			return &Violation{rule, fmt.Sprintf("Departures aren't possible on %s", end.Format("Monday, January 2"))}
+		}
+
+		if !Covers(rule, start) {
+			continue
+		}
+
+		if rule.ClosedToArrival {
+			return &Violation{rule, fmt.Sprintf("Arrivals aren't possible on %s", start.Format("Monday, January 2"))}
+		}
+
+		if len(rule.ArrivalDays) > 0 && !allowsArrival(rule, start.Weekday()) {
+			return &Violation{rule, fmt.Sprintf("Arrivals are only possible on %s", joinDays(rule.ArrivalDays))}
+		}
+
+		if rule.MinNights > 0 && nights < rule.MinNights {
+			return &Violation{rule, fmt.Sprintf("Stays arriving on %s have to be at least %d nights", start.Format("January 2"), rule.MinNights)}
+		}
+
+		if rule.MaxNights > 0 && nights > rule.MaxNights {
+			return &Violation{rule, fmt.Sprintf("Stays arriving on %s can be at most %d nights", start.Format("January 2"), rule.MaxNights)}
+		}
+	}
+
+	return nil
+}
+
+func allowsArrival(rule models.StayRule, day time.Weekday) bool {
+	for _, d := range rule.ArrivalDays {
+		if d == day {
+			return true
+		}
+	}
+
+	return false
+}
+
+// joinDays lists days for a guest, e.g. "Friday or Saturday"
+func joinDays(days []time.Weekday) string {
+	names := make([]string, len(days))
+	for i, d := range days {
+		names[i] = d.String()
+	}
+
+	if len(names) == 1 {
+		return names[0]
+	}
+
+	return strings.Join(names[:len(names)-1], ", ") + " or " + names[len(names)-1]
+}
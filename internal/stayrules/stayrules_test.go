@@ -0,0 +1,65 @@
+package stayrules
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hd719/go-bookings/internal/models"
+)
+
+func date(s string) time.Time {
+	d, _ := time.Parse("2006-01-02", s)
+	return d
+}
+
+func TestCheck(t *testing.T) {
+	rules := []models.StayRule{
+		// always at least 2 nights
+		{ID: 1, MinNights: 2},
+		// a week at most over the summer, arriving on Saturdays only
+		{ID: 2, StartDate: date("2050-07-01"), EndDate: date("2050-08-31"), MaxNights: 7, ArrivalDays: []time.Weekday{time.Saturday}},
+		// closed for arrivals and departures over new year's eve
+		{ID: 3, StartDate: date("2050-12-31"), EndDate: date("2050-12-31"), ClosedToArrival: true},
+		{ID: 4, StartDate: date("2051-01-01"), EndDate: date("2051-01-01"), ClosedToDeparture: true},
+	}
+
+	var tests = []struct {
+		name  string
+		start string
+		end   string
+		rule  int // id of the rule that is broken, 0 if the stay is allowed
+	}{
+		{"long enough", "2050-05-02", "2050-05-04", 0},
+		{"too short", "2050-05-02", "2050-05-03", 1},
+		{"summer saturday", "2050-07-02", "2050-07-09", 0},
+		{"summer too long", "2050-07-02", "2050-07-10", 2},
+		{"summer wrong day", "2050-07-04", "2050-07-08", 2},
+		{"leaves in summer", "2050-06-28", "2050-07-04", 0},
+		{"arrives on new year's eve", "2050-12-31", "2051-01-03", 3},
+		{"leaves on new year's day", "2050-12-29", "2051-01-01", 4},
+		{"stays over new year", "2050-12-29", "2051-01-02", 0},
+	}
+
+	for _, e := range tests {
+		err := Check(rules, date(e.start), date(e.end))
+
+		var v *Violation
+		if e.rule == 0 && err != nil {
+			t.Errorf("%s: expected the stay to be allowed but got %v", e.name, err)
+		}
+
+		if e.rule != 0 && (!errors.As(err, &v) || v.Rule.ID != e.rule) {
+			t.Errorf("%s: expected rule %d to be broken but got %v", e.name, e.rule, err)
+		}
+	}
+}
+
+func TestCheck_Reason(t *testing.T) {
+	rules := []models.StayRule{{ArrivalDays: []time.Weekday{time.Friday, time.Saturday}}}
+
+	err := Check(rules, date("2050-01-03"), date("2050-01-05"))
+	if err == nil || err.Error() != "Arrivals are only possible on Friday or Saturday" {
+		t.Errorf("unexpected reason %v", err)
+	}
+}
@@ -0,0 +1,30 @@
+// Package tokens makes the random secrets that are emailed to users, e.g. to reset their password.
+// Only the hash of a token is stored, so a copy of the database can't be used to redeem them
+package tokens
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// size of a token in bytes, 256 random bits can't be guessed
+const size = 32
+
+// New returns a random token, safe to use in a URL, and the hash to store for it
+func New() (token, hash string, err error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, Hash(token), nil
+}
+
+// Hash returns the hash stored for token. Tokens are random, a fast unsalted hash is enough to keep them secret
+func Hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,29 @@
+package tokens
+
+import "testing"
+
+func TestNew(t *testing.T) {
+	token, hash, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(token) != 43 {
+		t.Errorf("expected a token of 43 characters but got %q", token)
+	}
+
+	if hash != Hash(token) || len(hash) != 64 {
+		t.Errorf("expected the hash of the token but got %q", hash)
+	}
+
+	other, _, _ := New()
+	if other == token {
+		t.Error("expected every token to be different")
+	}
+}
+
+func TestHash(t *testing.T) {
+	if Hash("a") == Hash("b") {
+		t.Error("expected different tokens to have different hashes")
+	}
+}
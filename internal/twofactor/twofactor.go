@@ -0,0 +1,136 @@
+// Package twofactor implements the time-based one-time passwords (TOTP, RFC 6238) staff log in with as a second step,
+// and the recovery codes they can use instead when they lose their phone
+package twofactor
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/hd719/go-bookings/internal/confirmation"
+	"github.com/skip2/go-qrcode"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Period is how long a code is valid, the default of every authenticator app
+const Period = 30 * time.Second
+
+// Digits of a code
+const Digits = 6
+
+// skew is how many periods before and after the current one are accepted, for clocks that are a little off
+const skew = 1
+
+// RecoveryCodes is how many recovery codes a user gets
+const RecoveryCodes = 10
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewSecret returns a random secret, base32 encoded the way authenticator apps expect it
+func NewSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return encoding.EncodeToString(b), nil
+}
+
+// URL returns the otpauth:// URL that authenticator apps read from the QR code
+func URL(issuer, account, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+
+	return fmt.Sprintf("otpauth://totp/%s:%s?%s", url.PathEscape(issuer), url.PathEscape(account), v.Encode())
+}
+
+// QRCode returns a PNG of the QR code for the URL of a secret
+func QRCode(otpURL string) ([]byte, error) {
+	return qrcode.Encode(otpURL, qrcode.Medium, 256)
+}
+
+// Step returns the number of the period t is in
+func Step(t time.Time) int64 {
+	return t.Unix() / int64(Period/time.Second)
+}
+
+// Code returns the code for secret in step
+func Code(secret string, step int64) (string, error) {
+	key, err := encoding.DecodeString(secret)
+	if err != nil {
+		return "", err
+	}
+
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	// dynamic truncation, RFC 4226 section 5.3
+	offset := sum[len(sum)-1] & 0x0f
+	n := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < Digits; i++ {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", Digits, n%mod), nil
+}
+
+// Verify reports whether code is valid for secret at now, and the step it belongs to. A code should be accepted only
+// once, callers keep the last step that was used and refuse codes of that step or an earlier one
+func Verify(secret, code string, now time.Time) (int64, bool) {
+	if secret == "" || len(code) != Digits {
+		return 0, false
+	}
+
+	current := Step(now)
+	for step := current - skew; step <= current+skew; step++ {
+		want, err := Code(secret, step)
+		if err != nil {
+			return 0, false
+		}
+
+		if subtle.ConstantTimeCompare([]byte(want), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+// NewRecoveryCodes returns a fresh set of recovery codes, formatted to be written down, and their hashes to store
+func NewRecoveryCodes() (codes []string, hashes []string, err error) {
+	for i := 0; i < RecoveryCodes; i++ {
+		code, err := confirmation.NewCode()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		hash, err := bcrypt.GenerateFromPassword([]byte(code), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		half := len(code) / 2
+		codes = append(codes, code[:half]+"-"+code[half:])
+		hashes = append(hashes, string(hash))
+	}
+
+	return codes, hashes, nil
+}
+
+// MatchRecoveryCode reports whether code, typed the way a user might, is the recovery code hash was made from
+func MatchRecoveryCode(hash, code string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(confirmation.Normalize(code))) == nil
+}
@@ -0,0 +1,96 @@
+package twofactor
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 key of the test vectors in RFC 6238 appendix B
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestCode(t *testing.T) {
+	// the last six digits of the eight digit codes in the RFC
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{20000000000, "353130"},
+	}
+
+	for _, e := range tests {
+		got, err := Code(rfcSecret, Step(time.Unix(e.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got != e.code {
+			t.Errorf("at %d expected %s but got %s", e.unix, e.code, got)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	secret, err := NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	code, _ := Code(secret, Step(now.Add(-Period)))
+
+	// a code of the previous period is still accepted for clocks that are behind
+	if step, ok := Verify(secret, code, now); !ok || step != Step(now)-1 {
+		t.Errorf("expected the code of the previous period to be valid but got %d, %v", step, ok)
+	}
+
+	if _, ok := Verify(secret, code, now.Add(3*Period)); ok {
+		t.Error("expected an old code not to be valid")
+	}
+
+	if _, ok := Verify(secret, "12345", now); ok {
+		t.Error("expected a short code not to be valid")
+	}
+
+	// the codes of an empty key can be worked out by anyone
+	empty, _ := Code("", Step(now))
+	if _, ok := Verify("", empty, now); ok {
+		t.Error("expected no code to be valid without a secret")
+	}
+}
+
+func TestURL(t *testing.T) {
+	got := URL("Fort Smythe", "admin@admin.com", "ABC")
+	if !strings.HasPrefix(got, "otpauth://totp/Fort%20Smythe:admin@admin.com?") || !strings.Contains(got, "secret=ABC") {
+		t.Errorf("unexpected URL %s", got)
+	}
+
+	if png, err := QRCode(got); err != nil || len(png) == 0 {
+		t.Errorf("expected a QR code but got %v", err)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes, err := NewRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(codes) != RecoveryCodes || len(hashes) != RecoveryCodes {
+		t.Fatalf("expected %d codes but got %d", RecoveryCodes, len(codes))
+	}
+
+	// typed in lower case and without the dash
+	typed := strings.ToLower(strings.Replace(codes[0], "-", "", 1))
+	if !MatchRecoveryCode(hashes[0], typed) {
+		t.Errorf("expected %s to match its hash", typed)
+	}
+
+	if MatchRecoveryCode(hashes[1], codes[0]) {
+		t.Error("expected a code not to match the hash of another one")
+	}
+}
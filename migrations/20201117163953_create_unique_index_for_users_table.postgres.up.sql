@@ -0,0 +1 @@
+create unique index users_email_idx on users (email);
@@ -0,0 +1,4 @@
+alter table room_restrictions drop constraint room_restrictions_reservations_id_fk;
+
+drop index reservations_email_idx;
+drop index reservations_last_name_idx;
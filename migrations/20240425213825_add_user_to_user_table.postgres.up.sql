@@ -0,0 +1 @@
+INSERT INTO "public"."users" ("first_name", "last_name", "email", "password", "access_level", "created_at", "updated_at") VALUES ('admin', 'admin', 'admin@admin.com', '$2a$14$ajq8Q7fbtFRQvXpdCq7Jcuy.Rx1h/L4J60Otx.gyNLbAYctGMJ9tK', 3, '2024-04-04 00:00:00', '2024-04-04 00:00:00');
\ No newline at end of file
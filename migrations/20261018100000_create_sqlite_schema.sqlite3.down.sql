@@ -0,0 +1,7 @@
+drop trigger room_restrictions_no_overlap_update;
+drop trigger room_restrictions_no_overlap_insert;
+drop table room_restrictions;
+drop table reservations;
+drop table restrictions;
+drop table rooms;
+drop table users;
@@ -0,0 +1,84 @@
+-- SQLite databases start from the full schema built up by the earlier postgres migrations, see schema.sql
+
+create table users (
+    id integer primary key autoincrement,
+    first_name varchar(255) not null default '',
+    last_name varchar(255) not null default '',
+    email varchar(255) not null,
+    password varchar(60) not null,
+    access_level integer not null default 1,
+    created_at timestamp not null,
+    updated_at timestamp not null
+);
+
+create unique index users_email_idx on users (email);
+
+create table rooms (
+    id integer primary key autoincrement,
+    room_name varchar(255) not null default '',
+    created_at timestamp not null,
+    updated_at timestamp not null
+);
+
+create table restrictions (
+    id integer primary key autoincrement,
+    restriction_name varchar(255) not null default '',
+    created_at timestamp not null,
+    updated_at timestamp not null
+);
+
+create table reservations (
+    id integer primary key autoincrement,
+    first_name varchar(255) not null default '',
+    last_name varchar(255) not null default '',
+    email varchar(255) not null,
+    phone varchar(255) not null default '',
+    start_date date not null,
+    end_date date not null,
+    room_id integer not null references rooms (id) on update cascade on delete cascade,
+    created_at timestamp not null,
+    updated_at timestamp not null,
+    processed integer not null default 0
+);
+
+create index reservations_email_idx on reservations (email);
+create index reservations_last_name_idx on reservations (last_name);
+
+create table room_restrictions (
+    id integer primary key autoincrement,
+    start_date date not null,
+    end_date date not null,
+    room_id integer not null references rooms (id) on update cascade on delete cascade,
+    reservation_id integer references reservations (id) on update cascade on delete cascade,
+    restriction_id integer not null references restrictions (id) on update cascade on delete cascade,
+    created_at timestamp not null,
+    updated_at timestamp not null
+);
+
+create index room_restrictions_reservation_id_idx on room_restrictions (reservation_id);
+create index room_restrictions_room_id_idx on room_restrictions (room_id);
+create index room_restrictions_start_date_end_date_idx on room_restrictions (start_date, end_date);
+
+-- SQLite has no exclusion constraints, these triggers stand in for room_restrictions_no_overlap
+create trigger room_restrictions_no_overlap_insert
+before insert on room_restrictions
+when exists (
+    select 1 from room_restrictions
+    where room_id = new.room_id and new.start_date < end_date and new.end_date > start_date
+)
+begin
+    select raise(abort, 'room_restrictions_no_overlap');
+end;
+
+create trigger room_restrictions_no_overlap_update
+before update of start_date, end_date, room_id on room_restrictions
+when exists (
+    select 1 from room_restrictions
+    where id <> new.id and room_id = new.room_id and new.start_date < end_date and new.end_date > start_date
+)
+begin
+    select raise(abort, 'room_restrictions_no_overlap');
+end;
+
+insert into users (first_name, last_name, email, password, access_level, created_at, updated_at) values
+('admin', 'admin', 'admin@admin.com', '$2a$14$ajq8Q7fbtFRQvXpdCq7Jcuy.Rx1h/L4J60Otx.gyNLbAYctGMJ9tK', 3, '2024-04-04 00:00:00', '2024-04-04 00:00:00');
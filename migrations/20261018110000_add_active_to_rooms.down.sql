@@ -0,0 +1 @@
+alter table rooms drop column active;
@@ -0,0 +1 @@
+alter table rooms add column active boolean not null default true;
@@ -0,0 +1,3 @@
+drop index rooms_slug_idx;
+alter table rooms drop column description;
+alter table rooms drop column slug;
@@ -0,0 +1,12 @@
+alter table rooms add column slug varchar(255) not null default '';
+alter table rooms add column description text not null default '';
+
+-- keep the old /generals-quarters and /majors-suite links working
+update rooms set slug = 'generals-quarters' where room_name = 'General''s Quarters';
+update rooms set slug = 'majors-suite' where room_name = 'Major''s Suite';
+update rooms set slug = 'room-' || id where slug = '';
+
+update rooms set description = 'Your home away from home, set on the majestic waters of the Atlantic Ocean, this will be a vacation to remember.'
+where slug in ('generals-quarters', 'majors-suite');
+
+create unique index rooms_slug_idx on rooms (slug);
@@ -0,0 +1,5 @@
+drop table room_photos;
+
+alter table rooms drop column amenities;
+alter table rooms drop column beds;
+alter table rooms drop column max_occupancy;
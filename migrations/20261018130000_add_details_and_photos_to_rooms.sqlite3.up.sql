@@ -0,0 +1,15 @@
+alter table rooms add column max_occupancy integer not null default 2;
+alter table rooms add column beds varchar(255) not null default '';
+alter table rooms add column amenities text not null default '';
+
+create table room_photos (
+    id integer primary key autoincrement,
+    room_id integer not null references rooms (id) on delete cascade on update cascade,
+    file_name varchar(255) not null,
+    caption varchar(255) not null default '',
+    sort_order integer not null default 0,
+    created_at timestamp not null,
+    updated_at timestamp not null
+);
+
+create index room_photos_room_id_sort_order_idx on room_photos (room_id, sort_order);
@@ -0,0 +1,3 @@
+alter table reservations drop column total_price;
+alter table rooms drop column weekend_rate;
+alter table rooms drop column nightly_rate;
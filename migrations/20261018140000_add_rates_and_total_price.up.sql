@@ -0,0 +1,7 @@
+alter table rooms add column nightly_rate integer not null default 0;
+alter table rooms add column weekend_rate integer not null default 0;
+alter table reservations add column total_price integer not null default 0;
+
+-- the rates of the rooms the seed added, which predates them
+update rooms set nightly_rate = 12000, weekend_rate = 15000 where id = 1 and nightly_rate = 0;
+update rooms set nightly_rate = 15000, weekend_rate = 18000 where id = 2 and nightly_rate = 0;
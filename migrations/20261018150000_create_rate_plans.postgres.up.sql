@@ -0,0 +1,15 @@
+create table rate_plans (
+    id serial primary key,
+    room_id integer not null,
+    name varchar(255) not null,
+    start_date date not null,
+    end_date date not null,
+    nightly_rate integer not null,
+    day_multipliers varchar(255) not null default '100,100,100,100,100,100,100',
+    priority integer not null default 0,
+    created_at timestamp not null,
+    updated_at timestamp not null,
+    constraint rate_plans_rooms_id_fk foreign key (room_id) references rooms (id) on delete cascade on update cascade
+);
+
+create index rate_plans_room_id_start_date_end_date_idx on rate_plans (room_id, start_date, end_date);
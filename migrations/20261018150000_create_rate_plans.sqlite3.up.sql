@@ -0,0 +1,14 @@
+create table rate_plans (
+    id integer primary key autoincrement,
+    room_id integer not null references rooms (id) on delete cascade on update cascade,
+    name varchar(255) not null,
+    start_date date not null,
+    end_date date not null,
+    nightly_rate integer not null,
+    day_multipliers varchar(255) not null default '100,100,100,100,100,100,100',
+    priority integer not null default 0,
+    created_at timestamp not null,
+    updated_at timestamp not null
+);
+
+create index rate_plans_room_id_start_date_end_date_idx on rate_plans (room_id, start_date, end_date);
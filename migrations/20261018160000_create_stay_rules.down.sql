@@ -0,0 +1 @@
+drop table stay_rules;
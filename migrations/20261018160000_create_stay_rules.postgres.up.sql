@@ -0,0 +1,16 @@
+create table stay_rules (
+    id serial primary key,
+    room_id integer not null,
+    start_date date,
+    end_date date,
+    min_nights integer not null default 0,
+    max_nights integer not null default 0,
+    closed_to_arrival boolean not null default false,
+    closed_to_departure boolean not null default false,
+    arrival_days varchar(255) not null default '',
+    created_at timestamp not null,
+    updated_at timestamp not null,
+    constraint stay_rules_rooms_id_fk foreign key (room_id) references rooms (id) on delete cascade on update cascade
+);
+
+create index stay_rules_room_id_idx on stay_rules (room_id);
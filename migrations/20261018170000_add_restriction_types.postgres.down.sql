@@ -0,0 +1,13 @@
+-- restrictions that did not block availability may overlap others, they have to go before the old constraint is back
+delete from room_restrictions where not blocks_availability;
+
+alter table room_restrictions drop constraint room_restrictions_no_overlap;
+
+alter table room_restrictions
+    add constraint room_restrictions_no_overlap
+    exclude using gist (room_id with =, daterange(start_date, end_date) with &&);
+
+alter table room_restrictions drop column blocks_availability;
+
+alter table restrictions drop column blocks_availability;
+alter table restrictions drop column color;
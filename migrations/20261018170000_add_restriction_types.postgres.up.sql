@@ -0,0 +1,14 @@
+alter table restrictions add column color varchar(7) not null default '#6c757d';
+alter table restrictions add column blocks_availability boolean not null default true;
+
+update restrictions set color = '#dc3545' where id = 1;
+update restrictions set color = '#ffc107' where id = 2;
+
+-- copied from the restriction type, an exclusion constraint can only look at the columns of its own table
+alter table room_restrictions add column blocks_availability boolean not null default true;
+
+alter table room_restrictions drop constraint room_restrictions_no_overlap;
+
+alter table room_restrictions
+    add constraint room_restrictions_no_overlap
+    exclude using gist (room_id with =, daterange(start_date, end_date) with &&) where (blocks_availability);
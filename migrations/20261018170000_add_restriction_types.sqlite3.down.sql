@@ -0,0 +1,30 @@
+-- restrictions that did not block availability may overlap others, they have to go before the old triggers are back
+delete from room_restrictions where not blocks_availability;
+
+drop trigger room_restrictions_no_overlap_insert;
+drop trigger room_restrictions_no_overlap_update;
+
+create trigger room_restrictions_no_overlap_insert
+before insert on room_restrictions
+when exists (
+    select 1 from room_restrictions
+    where room_id = new.room_id and new.start_date < end_date and new.end_date > start_date
+)
+begin
+    select raise(abort, 'room_restrictions_no_overlap');
+end;
+
+create trigger room_restrictions_no_overlap_update
+before update of start_date, end_date, room_id on room_restrictions
+when exists (
+    select 1 from room_restrictions
+    where id <> new.id and room_id = new.room_id and new.start_date < end_date and new.end_date > start_date
+)
+begin
+    select raise(abort, 'room_restrictions_no_overlap');
+end;
+
+alter table room_restrictions drop column blocks_availability;
+
+alter table restrictions drop column blocks_availability;
+alter table restrictions drop column color;
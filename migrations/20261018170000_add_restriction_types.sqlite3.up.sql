@@ -0,0 +1,31 @@
+alter table restrictions add column color varchar(7) not null default '#6c757d';
+alter table restrictions add column blocks_availability boolean not null default true;
+
+update restrictions set color = '#dc3545' where id = 1;
+update restrictions set color = '#ffc107' where id = 2;
+
+-- copied from the restriction type, like in postgres where the exclusion constraint needs it
+alter table room_restrictions add column blocks_availability boolean not null default true;
+
+drop trigger room_restrictions_no_overlap_insert;
+drop trigger room_restrictions_no_overlap_update;
+
+create trigger room_restrictions_no_overlap_insert
+before insert on room_restrictions
+when new.blocks_availability and exists (
+    select 1 from room_restrictions
+    where room_id = new.room_id and blocks_availability and new.start_date < end_date and new.end_date > start_date
+)
+begin
+    select raise(abort, 'room_restrictions_no_overlap');
+end;
+
+create trigger room_restrictions_no_overlap_update
+before update of start_date, end_date, room_id, blocks_availability on room_restrictions
+when new.blocks_availability and exists (
+    select 1 from room_restrictions
+    where id <> new.id and room_id = new.room_id and blocks_availability and new.start_date < end_date and new.end_date > start_date
+)
+begin
+    select raise(abort, 'room_restrictions_no_overlap');
+end;
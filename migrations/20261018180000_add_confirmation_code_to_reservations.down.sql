@@ -0,0 +1,2 @@
+drop index reservations_confirmation_code_idx;
+alter table reservations drop column confirmation_code;
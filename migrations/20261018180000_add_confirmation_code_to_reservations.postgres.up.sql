@@ -0,0 +1,6 @@
+alter table reservations add column confirmation_code varchar(16) not null default '';
+
+-- reservations made before codes existed get a random one, guests only ever see the codes of new reservations
+update reservations set confirmation_code = upper(substr(md5(random()::text || id::text), 1, 10));
+
+create unique index reservations_confirmation_code_idx on reservations (confirmation_code);
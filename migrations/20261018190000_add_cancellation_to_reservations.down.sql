@@ -0,0 +1,2 @@
+alter table reservations drop column changed_by;
+alter table reservations drop column cancelled_at;
@@ -0,0 +1,5 @@
+-- null while the reservation stands, cancelled reservations are kept but no longer hold their dates
+alter table reservations add column cancelled_at timestamp;
+
+-- who made the last change, "guest" or the email of the admin
+alter table reservations add column changed_by varchar(255) not null default '';
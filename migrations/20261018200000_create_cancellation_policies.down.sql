@@ -0,0 +1,7 @@
+alter table reservations drop column cancellation_fee;
+alter table reservations drop column cancellation_late_fee;
+alter table reservations drop column cancellation_free_days;
+alter table reservations drop column cancellation_policy;
+alter table rate_plans drop column cancellation_policy_id;
+alter table rooms drop column cancellation_policy_id;
+drop table cancellation_policies;
@@ -0,0 +1,19 @@
+create table cancellation_policies (
+    id integer primary key autoincrement,
+    name varchar(255) not null,
+    free_days integer not null default 0,
+    fee_type varchar(16) not null default 'percent',
+    fee_percent integer not null default 0,
+    created_at timestamp not null,
+    updated_at timestamp not null
+);
+
+-- no foreign keys, SQLite can't drop a column that has one, deleting a policy clears these itself
+alter table rooms add column cancellation_policy_id integer;
+alter table rate_plans add column cancellation_policy_id integer;
+
+-- the terms of the policy as agreed when booking, and the fee charged if the reservation was cancelled
+alter table reservations add column cancellation_policy varchar(255) not null default '';
+alter table reservations add column cancellation_free_days integer not null default 0;
+alter table reservations add column cancellation_late_fee integer not null default 0;
+alter table reservations add column cancellation_fee integer not null default 0;
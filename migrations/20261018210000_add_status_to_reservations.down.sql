@@ -0,0 +1,9 @@
+alter table reservations add column processed integer not null default 0;
+update reservations set processed = 1 where status <> 'pending';
+
+drop index reservations_status_idx;
+alter table reservations drop column no_show_at;
+alter table reservations drop column checked_out_at;
+alter table reservations drop column checked_in_at;
+alter table reservations drop column confirmed_at;
+alter table reservations drop column status;
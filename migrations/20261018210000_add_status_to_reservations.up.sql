@@ -0,0 +1,13 @@
+-- processed becomes a status, with the time the reservation reached each one
+alter table reservations add column status varchar(16) not null default 'pending';
+alter table reservations add column confirmed_at timestamp;
+alter table reservations add column checked_in_at timestamp;
+alter table reservations add column checked_out_at timestamp;
+alter table reservations add column no_show_at timestamp;
+
+update reservations set status = 'confirmed', confirmed_at = updated_at where processed = 1;
+update reservations set status = 'cancelled' where cancelled_at is not null;
+
+create index reservations_status_idx on reservations (status);
+
+alter table reservations drop column processed;
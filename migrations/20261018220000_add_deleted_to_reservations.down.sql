@@ -0,0 +1,6 @@
+delete from reservations where deleted_at is not null;
+
+drop index reservations_deleted_at_idx;
+alter table reservations drop column delete_reason;
+alter table reservations drop column deleted_by;
+alter table reservations drop column deleted_at;
@@ -0,0 +1,6 @@
+-- deleted reservations are kept until they are purged, so they can be restored
+alter table reservations add column deleted_at timestamp;
+alter table reservations add column deleted_by varchar(255) not null default '';
+alter table reservations add column delete_reason varchar(255) not null default '';
+
+create index reservations_deleted_at_idx on reservations (deleted_at);
@@ -0,0 +1,26 @@
+create table audit_log (
+    id serial primary key,
+    user_id integer not null default 0,
+    actor varchar(255) not null,
+    action varchar(32) not null,
+    entity varchar(32) not null,
+    entity_id integer not null default 0,
+    changes text not null default '[]',
+    ip varchar(64) not null default '',
+    user_agent text not null default '',
+    request varchar(255) not null default '',
+    created_at timestamp not null
+);
+
+create index audit_log_entity_idx on audit_log (entity, entity_id);
+create index audit_log_actor_idx on audit_log (actor);
+
+-- the log is append-only, entries can't be changed or removed once written
+create function audit_log_append_only() returns trigger language plpgsql as $$
+begin
+    raise exception 'audit_log is append-only';
+end;
+$$;
+
+create trigger audit_log_append_only before update or delete on audit_log
+    for each row execute function audit_log_append_only();
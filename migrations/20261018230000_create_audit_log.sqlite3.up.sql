@@ -0,0 +1,27 @@
+create table audit_log (
+    id integer primary key autoincrement,
+    user_id integer not null default 0,
+    actor varchar(255) not null,
+    action varchar(32) not null,
+    entity varchar(32) not null,
+    entity_id integer not null default 0,
+    changes text not null default '[]',
+    ip varchar(64) not null default '',
+    user_agent text not null default '',
+    request varchar(255) not null default '',
+    created_at timestamp not null
+);
+
+create index audit_log_entity_idx on audit_log (entity, entity_id);
+create index audit_log_actor_idx on audit_log (actor);
+
+-- the log is append-only, entries can't be changed or removed once written
+create trigger audit_log_no_update before update on audit_log
+begin
+    select raise(abort, 'audit_log is append-only');
+end;
+
+create trigger audit_log_no_delete before delete on audit_log
+begin
+    select raise(abort, 'audit_log is append-only');
+end;
@@ -0,0 +1,6 @@
+alter table users add column access_level integer not null default 1;
+
+update users set access_level = 3 where role = 'owner';
+
+alter table users drop column disabled_at;
+alter table users drop column role;
@@ -0,0 +1,7 @@
+-- roles replace the access level nothing read, the users that had the highest level become owners
+alter table users add column role varchar(32) not null default 'read_only';
+alter table users add column disabled_at timestamp;
+
+update users set role = 'owner' where access_level >= 3;
+
+alter table users drop column access_level;
@@ -0,0 +1,2 @@
+alter table users drop column session_version;
+drop table password_resets;
@@ -0,0 +1,14 @@
+-- only the hash of a token is stored, the token itself is only ever in the email
+create table password_resets (
+    id serial primary key,
+    user_id integer not null
+        constraint password_resets_users_id_fk references users (id) on update cascade on delete cascade,
+    token_hash varchar(64) not null
+        constraint password_resets_token_hash_key unique,
+    expires_at timestamp not null,
+    used_at timestamp,
+    created_at timestamp not null
+);
+
+-- bumped whenever the password changes, sessions logged in with an older version are logged out
+alter table users add column session_version integer not null default 0;
@@ -0,0 +1,3 @@
+drop table recovery_codes;
+alter table users drop column totp_last_step;
+alter table users drop column totp_secret;
@@ -0,0 +1,16 @@
+-- the secret is empty unless the user turned on two-factor authentication, the last step stops a code from being
+-- used twice
+alter table users add column totp_secret varchar(64) not null default '';
+alter table users add column totp_last_step bigint not null default 0;
+
+-- only the bcrypt hash of a recovery code is stored, each works once
+create table recovery_codes (
+    id serial primary key,
+    user_id integer not null
+        constraint recovery_codes_users_id_fk references users (id) on update cascade on delete cascade,
+    code_hash varchar(60) not null,
+    used_at timestamp,
+    created_at timestamp not null
+);
+
+create index recovery_codes_user_id_idx on recovery_codes (user_id);
@@ -0,0 +1,9 @@
+-- failed logins counted per account ("account:<email>") and per IP address ("ip:<address>"), see the lockout package
+-- "if not exists" because this migration was first released as 20261018236000, databases that ran it keep their table
+create table if not exists login_attempts (
+    lockout_key varchar(255) primary key,
+    failures integer not null,
+    last_failure timestamp not null
+);
+
+create index if not exists login_attempts_last_failure_idx on login_attempts (last_failure);
@@ -0,0 +1 @@
+-- nothing to undo, moving the sequence back would hand out the ids of rooms that exist
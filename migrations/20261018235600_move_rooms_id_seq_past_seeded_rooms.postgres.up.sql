@@ -0,0 +1,2 @@
+-- the rooms seed sets its ids by hand, databases seeded before anything moved the sequence past them still hand out 1
+select setval('rooms_id_seq', coalesce((select max(id) from rooms), 1), (select max(id) from rooms) is not null);
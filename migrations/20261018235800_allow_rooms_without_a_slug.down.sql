@@ -0,0 +1,2 @@
+drop index rooms_slug_idx;
+create unique index rooms_slug_idx on rooms (slug);
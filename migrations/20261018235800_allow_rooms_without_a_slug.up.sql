@@ -0,0 +1,4 @@
+-- rooms seeded before they had a slug are inserted without one and given theirs afterwards, the unique index only
+-- has to hold for the slugs that are set
+drop index rooms_slug_idx;
+create unique index rooms_slug_idx on rooms (slug) where slug <> '';
@@ -0,0 +1,7 @@
+// Package migrations embeds the schema migrations so the binary can apply them itself, see internal/migrate
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
@@ -16,10 +16,245 @@ SET xmloption = content;
 SET client_min_messages = warning;
 SET row_security = off;
 
+--
+-- Name: btree_gist; Type: EXTENSION; Schema: -; Owner: -
+--
+
+CREATE EXTENSION IF NOT EXISTS btree_gist WITH SCHEMA public;
+
+
+--
+-- Name: audit_log_append_only(); Type: FUNCTION; Schema: public; Owner: system
+--
+
+CREATE FUNCTION public.audit_log_append_only() RETURNS trigger
+    LANGUAGE plpgsql
+    AS $$
+begin
+    raise exception 'audit_log is append-only';
+end;
+$$;
+
+
+ALTER FUNCTION public.audit_log_append_only() OWNER TO system;
+
 SET default_tablespace = '';
 
 SET default_table_access_method = heap;
 
+--
+-- Name: audit_log; Type: TABLE; Schema: public; Owner: system
+--
+
+CREATE TABLE public.audit_log (
+    id integer NOT NULL,
+    user_id integer DEFAULT 0 NOT NULL,
+    actor character varying(255) NOT NULL,
+    action character varying(32) NOT NULL,
+    entity character varying(32) NOT NULL,
+    entity_id integer DEFAULT 0 NOT NULL,
+    changes text DEFAULT '[]'::text NOT NULL,
+    ip character varying(64) DEFAULT ''::character varying NOT NULL,
+    user_agent text DEFAULT ''::text NOT NULL,
+    request character varying(255) DEFAULT ''::character varying NOT NULL,
+    created_at timestamp without time zone NOT NULL
+);
+
+
+ALTER TABLE public.audit_log OWNER TO system;
+
+--
+-- Name: audit_log_id_seq; Type: SEQUENCE; Schema: public; Owner: system
+--
+
+CREATE SEQUENCE public.audit_log_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER SEQUENCE public.audit_log_id_seq OWNER TO system;
+
+--
+-- Name: audit_log_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: system
+--
+
+ALTER SEQUENCE public.audit_log_id_seq OWNED BY public.audit_log.id;
+
+
+--
+-- Name: cancellation_policies; Type: TABLE; Schema: public; Owner: system
+--
+
+CREATE TABLE public.cancellation_policies (
+    id integer NOT NULL,
+    name character varying(255) NOT NULL,
+    free_days integer DEFAULT 0 NOT NULL,
+    fee_type character varying(16) DEFAULT 'percent'::character varying NOT NULL,
+    fee_percent integer DEFAULT 0 NOT NULL,
+    created_at timestamp without time zone NOT NULL,
+    updated_at timestamp without time zone NOT NULL
+);
+
+
+ALTER TABLE public.cancellation_policies OWNER TO system;
+
+--
+-- Name: cancellation_policies_id_seq; Type: SEQUENCE; Schema: public; Owner: system
+--
+
+CREATE SEQUENCE public.cancellation_policies_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER SEQUENCE public.cancellation_policies_id_seq OWNER TO system;
+
+--
+-- Name: cancellation_policies_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: system
+--
+
+ALTER SEQUENCE public.cancellation_policies_id_seq OWNED BY public.cancellation_policies.id;
+
+
+--
+-- Name: login_attempts; Type: TABLE; Schema: public; Owner: system
+--
+
+CREATE TABLE public.login_attempts (
+    lockout_key character varying(255) NOT NULL,
+    failures integer NOT NULL,
+    last_failure timestamp without time zone NOT NULL
+);
+
+
+ALTER TABLE public.login_attempts OWNER TO system;
+
+--
+-- Name: password_resets; Type: TABLE; Schema: public; Owner: system
+--
+
+CREATE TABLE public.password_resets (
+    id integer NOT NULL,
+    user_id integer NOT NULL,
+    token_hash character varying(64) NOT NULL,
+    expires_at timestamp without time zone NOT NULL,
+    used_at timestamp without time zone,
+    created_at timestamp without time zone NOT NULL
+);
+
+
+ALTER TABLE public.password_resets OWNER TO system;
+
+--
+-- Name: password_resets_id_seq; Type: SEQUENCE; Schema: public; Owner: system
+--
+
+CREATE SEQUENCE public.password_resets_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER SEQUENCE public.password_resets_id_seq OWNER TO system;
+
+--
+-- Name: password_resets_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: system
+--
+
+ALTER SEQUENCE public.password_resets_id_seq OWNED BY public.password_resets.id;
+
+
+--
+-- Name: rate_plans; Type: TABLE; Schema: public; Owner: system
+--
+
+CREATE TABLE public.rate_plans (
+    id integer NOT NULL,
+    room_id integer NOT NULL,
+    name character varying(255) NOT NULL,
+    start_date date NOT NULL,
+    end_date date NOT NULL,
+    nightly_rate integer NOT NULL,
+    day_multipliers character varying(255) DEFAULT '100,100,100,100,100,100,100'::character varying NOT NULL,
+    priority integer DEFAULT 0 NOT NULL,
+    created_at timestamp without time zone NOT NULL,
+    updated_at timestamp without time zone NOT NULL,
+    cancellation_policy_id integer
+);
+
+
+ALTER TABLE public.rate_plans OWNER TO system;
+
+--
+-- Name: rate_plans_id_seq; Type: SEQUENCE; Schema: public; Owner: system
+--
+
+CREATE SEQUENCE public.rate_plans_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER SEQUENCE public.rate_plans_id_seq OWNER TO system;
+
+--
+-- Name: rate_plans_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: system
+--
+
+ALTER SEQUENCE public.rate_plans_id_seq OWNED BY public.rate_plans.id;
+
+
+--
+-- Name: recovery_codes; Type: TABLE; Schema: public; Owner: system
+--
+
+CREATE TABLE public.recovery_codes (
+    id integer NOT NULL,
+    user_id integer NOT NULL,
+    code_hash character varying(60) NOT NULL,
+    used_at timestamp without time zone,
+    created_at timestamp without time zone NOT NULL
+);
+
+
+ALTER TABLE public.recovery_codes OWNER TO system;
+
+--
+-- Name: recovery_codes_id_seq; Type: SEQUENCE; Schema: public; Owner: system
+--
+
+CREATE SEQUENCE public.recovery_codes_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER SEQUENCE public.recovery_codes_id_seq OWNER TO system;
+
+--
+-- Name: recovery_codes_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: system
+--
+
+ALTER SEQUENCE public.recovery_codes_id_seq OWNED BY public.recovery_codes.id;
+
+
 --
 -- Name: reservations; Type: TABLE; Schema: public; Owner: system
 --
@@ -35,7 +270,22 @@ CREATE TABLE public.reservations (
     room_id integer NOT NULL,
     created_at timestamp without time zone NOT NULL,
     updated_at timestamp without time zone NOT NULL,
-    processed integer DEFAULT 0 NOT NULL
+    total_price integer DEFAULT 0 NOT NULL,
+    confirmation_code character varying(16) DEFAULT ''::character varying NOT NULL,
+    cancelled_at timestamp without time zone,
+    changed_by character varying(255) DEFAULT ''::character varying NOT NULL,
+    cancellation_policy character varying(255) DEFAULT ''::character varying NOT NULL,
+    cancellation_free_days integer DEFAULT 0 NOT NULL,
+    cancellation_late_fee integer DEFAULT 0 NOT NULL,
+    cancellation_fee integer DEFAULT 0 NOT NULL,
+    status character varying(16) DEFAULT 'pending'::character varying NOT NULL,
+    confirmed_at timestamp without time zone,
+    checked_in_at timestamp without time zone,
+    checked_out_at timestamp without time zone,
+    no_show_at timestamp without time zone,
+    deleted_at timestamp without time zone,
+    deleted_by character varying(255) DEFAULT ''::character varying NOT NULL,
+    delete_reason character varying(255) DEFAULT ''::character varying NOT NULL
 );
 
 
@@ -71,7 +321,9 @@ CREATE TABLE public.restrictions (
     id integer NOT NULL,
     restriction_name character varying(255) DEFAULT ''::character varying NOT NULL,
     created_at timestamp without time zone NOT NULL,
-    updated_at timestamp without time zone NOT NULL
+    updated_at timestamp without time zone NOT NULL,
+    color character varying(7) DEFAULT '#6c757d'::character varying NOT NULL,
+    blocks_availability boolean DEFAULT true NOT NULL
 );
 
 
@@ -99,6 +351,45 @@ ALTER SEQUENCE public.restrictions_id_seq OWNER TO system;
 ALTER SEQUENCE public.restrictions_id_seq OWNED BY public.restrictions.id;
 
 
+--
+-- Name: room_photos; Type: TABLE; Schema: public; Owner: system
+--
+
+CREATE TABLE public.room_photos (
+    id integer NOT NULL,
+    room_id integer NOT NULL,
+    file_name character varying(255) NOT NULL,
+    caption character varying(255) DEFAULT ''::character varying NOT NULL,
+    sort_order integer DEFAULT 0 NOT NULL,
+    created_at timestamp without time zone NOT NULL,
+    updated_at timestamp without time zone NOT NULL
+);
+
+
+ALTER TABLE public.room_photos OWNER TO system;
+
+--
+-- Name: room_photos_id_seq; Type: SEQUENCE; Schema: public; Owner: system
+--
+
+CREATE SEQUENCE public.room_photos_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER SEQUENCE public.room_photos_id_seq OWNER TO system;
+
+--
+-- Name: room_photos_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: system
+--
+
+ALTER SEQUENCE public.room_photos_id_seq OWNED BY public.room_photos.id;
+
+
 --
 -- Name: room_restrictions; Type: TABLE; Schema: public; Owner: system
 --
@@ -111,7 +402,8 @@ CREATE TABLE public.room_restrictions (
     reservation_id integer,
     restriction_id integer NOT NULL,
     created_at timestamp without time zone NOT NULL,
-    updated_at timestamp without time zone NOT NULL
+    updated_at timestamp without time zone NOT NULL,
+    blocks_availability boolean DEFAULT true NOT NULL
 );
 
 
@@ -147,7 +439,16 @@ CREATE TABLE public.rooms (
     id integer NOT NULL,
     room_name character varying(255) DEFAULT ''::character varying NOT NULL,
     created_at timestamp without time zone NOT NULL,
-    updated_at timestamp without time zone NOT NULL
+    updated_at timestamp without time zone NOT NULL,
+    active boolean DEFAULT true NOT NULL,
+    slug character varying(255) DEFAULT ''::character varying NOT NULL,
+    description text DEFAULT ''::text NOT NULL,
+    max_occupancy integer DEFAULT 2 NOT NULL,
+    beds character varying(255) DEFAULT ''::character varying NOT NULL,
+    amenities text DEFAULT ''::text NOT NULL,
+    nightly_rate integer DEFAULT 0 NOT NULL,
+    weekend_rate integer DEFAULT 0 NOT NULL,
+    cancellation_policy_id integer
 );
 
 
@@ -186,6 +487,49 @@ CREATE TABLE public.schema_migration (
 
 ALTER TABLE public.schema_migration OWNER TO system;
 
+--
+-- Name: stay_rules; Type: TABLE; Schema: public; Owner: system
+--
+
+CREATE TABLE public.stay_rules (
+    id integer NOT NULL,
+    room_id integer NOT NULL,
+    start_date date,
+    end_date date,
+    min_nights integer DEFAULT 0 NOT NULL,
+    max_nights integer DEFAULT 0 NOT NULL,
+    closed_to_arrival boolean DEFAULT false NOT NULL,
+    closed_to_departure boolean DEFAULT false NOT NULL,
+    arrival_days character varying(255) DEFAULT ''::character varying NOT NULL,
+    created_at timestamp without time zone NOT NULL,
+    updated_at timestamp without time zone NOT NULL
+);
+
+
+ALTER TABLE public.stay_rules OWNER TO system;
+
+--
+-- Name: stay_rules_id_seq; Type: SEQUENCE; Schema: public; Owner: system
+--
+
+CREATE SEQUENCE public.stay_rules_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER SEQUENCE public.stay_rules_id_seq OWNER TO system;
+
+--
+-- Name: stay_rules_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: system
+--
+
+ALTER SEQUENCE public.stay_rules_id_seq OWNED BY public.stay_rules.id;
+
+
 --
 -- Name: users; Type: TABLE; Schema: public; Owner: system
 --
@@ -196,9 +540,13 @@ CREATE TABLE public.users (
     last_name character varying(255) DEFAULT ''::character varying NOT NULL,
     email character varying(255) NOT NULL,
     password character varying(60) NOT NULL,
-    access_level integer DEFAULT 1 NOT NULL,
     created_at timestamp without time zone NOT NULL,
-    updated_at timestamp without time zone NOT NULL
+    updated_at timestamp without time zone NOT NULL,
+    role character varying(32) DEFAULT 'read_only'::character varying NOT NULL,
+    disabled_at timestamp without time zone,
+    session_version integer DEFAULT 0 NOT NULL,
+    totp_secret character varying(64) DEFAULT ''::character varying NOT NULL,
+    totp_last_step bigint DEFAULT 0 NOT NULL
 );
 
 
@@ -226,6 +574,41 @@ ALTER SEQUENCE public.users_id_seq OWNER TO system;
 ALTER SEQUENCE public.users_id_seq OWNED BY public.users.id;
 
 
+--
+-- Name: audit_log id; Type: DEFAULT; Schema: public; Owner: system
+--
+
+ALTER TABLE ONLY public.audit_log ALTER COLUMN id SET DEFAULT nextval('public.audit_log_id_seq'::regclass);
+
+
+--
+-- Name: cancellation_policies id; Type: DEFAULT; Schema: public; Owner: system
+--
+
+ALTER TABLE ONLY public.cancellation_policies ALTER COLUMN id SET DEFAULT nextval('public.cancellation_policies_id_seq'::regclass);
+
+
+--
+-- Name: password_resets id; Type: DEFAULT; Schema: public; Owner: system
+--
+
+ALTER TABLE ONLY public.password_resets ALTER COLUMN id SET DEFAULT nextval('public.password_resets_id_seq'::regclass);
+
+
+--
+-- Name: rate_plans id; Type: DEFAULT; Schema: public; Owner: system
+--
+
+ALTER TABLE ONLY public.rate_plans ALTER COLUMN id SET DEFAULT nextval('public.rate_plans_id_seq'::regclass);
+
+
+--
+-- Name: recovery_codes id; Type: DEFAULT; Schema: public; Owner: system
+--
+
+ALTER TABLE ONLY public.recovery_codes ALTER COLUMN id SET DEFAULT nextval('public.recovery_codes_id_seq'::regclass);
+
+
 --
 -- Name: reservations id; Type: DEFAULT; Schema: public; Owner: system
 --
@@ -240,6 +623,13 @@ ALTER TABLE ONLY public.reservations ALTER COLUMN id SET DEFAULT nextval('public
 ALTER TABLE ONLY public.restrictions ALTER COLUMN id SET DEFAULT nextval('public.restrictions_id_seq'::regclass);
 
 
+--
+-- Name: room_photos id; Type: DEFAULT; Schema: public; Owner: system
+--
+
+ALTER TABLE ONLY public.room_photos ALTER COLUMN id SET DEFAULT nextval('public.room_photos_id_seq'::regclass);
+
+
 --
 -- Name: room_restrictions id; Type: DEFAULT; Schema: public; Owner: system
 --
@@ -254,6 +644,13 @@ ALTER TABLE ONLY public.room_restrictions ALTER COLUMN id SET DEFAULT nextval('p
 ALTER TABLE ONLY public.rooms ALTER COLUMN id SET DEFAULT nextval('public.rooms_id_seq'::regclass);
 
 
+--
+-- Name: stay_rules id; Type: DEFAULT; Schema: public; Owner: system
+--
+
+ALTER TABLE ONLY public.stay_rules ALTER COLUMN id SET DEFAULT nextval('public.stay_rules_id_seq'::regclass);
+
+
 --
 -- Name: users id; Type: DEFAULT; Schema: public; Owner: system
 --
@@ -261,6 +658,62 @@ ALTER TABLE ONLY public.rooms ALTER COLUMN id SET DEFAULT nextval('public.rooms_
 ALTER TABLE ONLY public.users ALTER COLUMN id SET DEFAULT nextval('public.users_id_seq'::regclass);
 
 
+--
+-- Name: audit_log audit_log_pkey; Type: CONSTRAINT; Schema: public; Owner: system
+--
+
+ALTER TABLE ONLY public.audit_log
+    ADD CONSTRAINT audit_log_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: cancellation_policies cancellation_policies_pkey; Type: CONSTRAINT; Schema: public; Owner: system
+--
+
+ALTER TABLE ONLY public.cancellation_policies
+    ADD CONSTRAINT cancellation_policies_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: login_attempts login_attempts_pkey; Type: CONSTRAINT; Schema: public; Owner: system
+--
+
+ALTER TABLE ONLY public.login_attempts
+    ADD CONSTRAINT login_attempts_pkey PRIMARY KEY (lockout_key);
+
+
+--
+-- Name: password_resets password_resets_pkey; Type: CONSTRAINT; Schema: public; Owner: system
+--
+
+ALTER TABLE ONLY public.password_resets
+    ADD CONSTRAINT password_resets_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: password_resets password_resets_token_hash_key; Type: CONSTRAINT; Schema: public; Owner: system
+--
+
+ALTER TABLE ONLY public.password_resets
+    ADD CONSTRAINT password_resets_token_hash_key UNIQUE (token_hash);
+
+
+--
+-- Name: rate_plans rate_plans_pkey; Type: CONSTRAINT; Schema: public; Owner: system
+--
+
+ALTER TABLE ONLY public.rate_plans
+    ADD CONSTRAINT rate_plans_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: recovery_codes recovery_codes_pkey; Type: CONSTRAINT; Schema: public; Owner: system
+--
+
+ALTER TABLE ONLY public.recovery_codes
+    ADD CONSTRAINT recovery_codes_pkey PRIMARY KEY (id);
+
+
 --
 -- Name: reservations reservations_pkey; Type: CONSTRAINT; Schema: public; Owner: system
 --
@@ -277,6 +730,14 @@ ALTER TABLE ONLY public.restrictions
     ADD CONSTRAINT restrictions_pkey PRIMARY KEY (id);
 
 
+--
+-- Name: room_photos room_photos_pkey; Type: CONSTRAINT; Schema: public; Owner: system
+--
+
+ALTER TABLE ONLY public.room_photos
+    ADD CONSTRAINT room_photos_pkey PRIMARY KEY (id);
+
+
 --
 -- Name: room_restrictions room_restrictions_pkey; Type: CONSTRAINT; Schema: public; Owner: system
 --
@@ -285,6 +746,14 @@ ALTER TABLE ONLY public.room_restrictions
     ADD CONSTRAINT room_restrictions_pkey PRIMARY KEY (id);
 
 
+--
+-- Name: room_restrictions room_restrictions_no_overlap; Type: CONSTRAINT; Schema: public; Owner: system
+--
+
+ALTER TABLE ONLY public.room_restrictions
+    ADD CONSTRAINT room_restrictions_no_overlap EXCLUDE USING gist (room_id WITH =, daterange(start_date, end_date) WITH &&) WHERE (blocks_availability);
+
+
 --
 -- Name: rooms rooms_pkey; Type: CONSTRAINT; Schema: public; Owner: system
 --
@@ -301,6 +770,14 @@ ALTER TABLE ONLY public.schema_migration
     ADD CONSTRAINT schema_migration_pkey PRIMARY KEY (version);
 
 
+--
+-- Name: stay_rules stay_rules_pkey; Type: CONSTRAINT; Schema: public; Owner: system
+--
+
+ALTER TABLE ONLY public.stay_rules
+    ADD CONSTRAINT stay_rules_pkey PRIMARY KEY (id);
+
+
 --
 -- Name: users users_pkey; Type: CONSTRAINT; Schema: public; Owner: system
 --
@@ -309,6 +786,55 @@ ALTER TABLE ONLY public.users
     ADD CONSTRAINT users_pkey PRIMARY KEY (id);
 
 
+--
+-- Name: audit_log_actor_idx; Type: INDEX; Schema: public; Owner: system
+--
+
+CREATE INDEX audit_log_actor_idx ON public.audit_log USING btree (actor);
+
+
+--
+-- Name: audit_log_entity_idx; Type: INDEX; Schema: public; Owner: system
+--
+
+CREATE INDEX audit_log_entity_idx ON public.audit_log USING btree (entity, entity_id);
+
+
+--
+-- Name: login_attempts_last_failure_idx; Type: INDEX; Schema: public; Owner: system
+--
+
+CREATE INDEX login_attempts_last_failure_idx ON public.login_attempts USING btree (last_failure);
+
+
+--
+-- Name: rate_plans_room_id_start_date_end_date_idx; Type: INDEX; Schema: public; Owner: system
+--
+
+CREATE INDEX rate_plans_room_id_start_date_end_date_idx ON public.rate_plans USING btree (room_id, start_date, end_date);
+
+
+--
+-- Name: recovery_codes_user_id_idx; Type: INDEX; Schema: public; Owner: system
+--
+
+CREATE INDEX recovery_codes_user_id_idx ON public.recovery_codes USING btree (user_id);
+
+
+--
+-- Name: reservations_confirmation_code_idx; Type: INDEX; Schema: public; Owner: system
+--
+
+CREATE UNIQUE INDEX reservations_confirmation_code_idx ON public.reservations USING btree (confirmation_code);
+
+
+--
+-- Name: reservations_deleted_at_idx; Type: INDEX; Schema: public; Owner: system
+--
+
+CREATE INDEX reservations_deleted_at_idx ON public.reservations USING btree (deleted_at);
+
+
 --
 -- Name: reservations_email_idx; Type: INDEX; Schema: public; Owner: system
 --
@@ -323,6 +849,20 @@ CREATE INDEX reservations_email_idx ON public.reservations USING btree (email);
 CREATE INDEX reservations_last_name_idx ON public.reservations USING btree (last_name);
 
 
+--
+-- Name: reservations_status_idx; Type: INDEX; Schema: public; Owner: system
+--
+
+CREATE INDEX reservations_status_idx ON public.reservations USING btree (status);
+
+
+--
+-- Name: room_photos_room_id_sort_order_idx; Type: INDEX; Schema: public; Owner: system
+--
+
+CREATE INDEX room_photos_room_id_sort_order_idx ON public.room_photos USING btree (room_id, sort_order);
+
+
 --
 -- Name: room_restrictions_reservation_id_idx; Type: INDEX; Schema: public; Owner: system
 --
@@ -344,6 +884,13 @@ CREATE INDEX room_restrictions_room_id_idx ON public.room_restrictions USING btr
 CREATE INDEX room_restrictions_start_date_end_date_idx ON public.room_restrictions USING btree (start_date, end_date);
 
 
+--
+-- Name: rooms_slug_idx; Type: INDEX; Schema: public; Owner: system
+--
+
+CREATE UNIQUE INDEX rooms_slug_idx ON public.rooms USING btree (slug) WHERE ((slug)::text <> ''::text);
+
+
 --
 -- Name: schema_migration_version_idx; Type: INDEX; Schema: public; Owner: system
 --
@@ -351,6 +898,13 @@ CREATE INDEX room_restrictions_start_date_end_date_idx ON public.room_restrictio
 CREATE UNIQUE INDEX schema_migration_version_idx ON public.schema_migration USING btree (version);
 
 
+--
+-- Name: stay_rules_room_id_idx; Type: INDEX; Schema: public; Owner: system
+--
+
+CREATE INDEX stay_rules_room_id_idx ON public.stay_rules USING btree (room_id);
+
+
 --
 -- Name: users_email_idx; Type: INDEX; Schema: public; Owner: system
 --
@@ -358,6 +912,45 @@ CREATE UNIQUE INDEX schema_migration_version_idx ON public.schema_migration USIN
 CREATE UNIQUE INDEX users_email_idx ON public.users USING btree (email);
 
 
+--
+-- Name: audit_log audit_log_append_only; Type: TRIGGER; Schema: public; Owner: system
+--
+
+CREATE TRIGGER audit_log_append_only BEFORE DELETE OR UPDATE ON public.audit_log FOR EACH ROW EXECUTE FUNCTION public.audit_log_append_only();
+
+
+--
+-- Name: password_resets password_resets_users_id_fk; Type: FK CONSTRAINT; Schema: public; Owner: system
+--
+
+ALTER TABLE ONLY public.password_resets
+    ADD CONSTRAINT password_resets_users_id_fk FOREIGN KEY (user_id) REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+
+--
+-- Name: rate_plans rate_plans_cancellation_policies_id_fk; Type: FK CONSTRAINT; Schema: public; Owner: system
+--
+
+ALTER TABLE ONLY public.rate_plans
+    ADD CONSTRAINT rate_plans_cancellation_policies_id_fk FOREIGN KEY (cancellation_policy_id) REFERENCES public.cancellation_policies(id) ON DELETE SET NULL;
+
+
+--
+-- Name: rate_plans rate_plans_rooms_id_fk; Type: FK CONSTRAINT; Schema: public; Owner: system
+--
+
+ALTER TABLE ONLY public.rate_plans
+    ADD CONSTRAINT rate_plans_rooms_id_fk FOREIGN KEY (room_id) REFERENCES public.rooms(id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+
+--
+-- Name: recovery_codes recovery_codes_users_id_fk; Type: FK CONSTRAINT; Schema: public; Owner: system
+--
+
+ALTER TABLE ONLY public.recovery_codes
+    ADD CONSTRAINT recovery_codes_users_id_fk FOREIGN KEY (user_id) REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+
 --
 -- Name: reservations reservations_rooms_id_fk; Type: FK CONSTRAINT; Schema: public; Owner: system
 --
@@ -366,6 +959,14 @@ ALTER TABLE ONLY public.reservations
     ADD CONSTRAINT reservations_rooms_id_fk FOREIGN KEY (room_id) REFERENCES public.rooms(id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
+--
+-- Name: room_photos room_photos_rooms_id_fk; Type: FK CONSTRAINT; Schema: public; Owner: system
+--
+
+ALTER TABLE ONLY public.room_photos
+    ADD CONSTRAINT room_photos_rooms_id_fk FOREIGN KEY (room_id) REFERENCES public.rooms(id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+
 --
 -- Name: room_restrictions room_restrictions_reservations_id_fk; Type: FK CONSTRAINT; Schema: public; Owner: system
 --
@@ -390,6 +991,22 @@ ALTER TABLE ONLY public.room_restrictions
     ADD CONSTRAINT room_restrictions_rooms_id_fk FOREIGN KEY (room_id) REFERENCES public.rooms(id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
+--
+-- Name: rooms rooms_cancellation_policies_id_fk; Type: FK CONSTRAINT; Schema: public; Owner: system
+--
+
+ALTER TABLE ONLY public.rooms
+    ADD CONSTRAINT rooms_cancellation_policies_id_fk FOREIGN KEY (cancellation_policy_id) REFERENCES public.cancellation_policies(id) ON DELETE SET NULL;
+
+
+--
+-- Name: stay_rules stay_rules_rooms_id_fk; Type: FK CONSTRAINT; Schema: public; Owner: system
+--
+
+ALTER TABLE ONLY public.stay_rules
+    ADD CONSTRAINT stay_rules_rooms_id_fk FOREIGN KEY (room_id) REFERENCES public.rooms(id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+
 --
 -- PostgreSQL database dump complete
 --
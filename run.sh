@@ -1,4 +1,4 @@
 #!/bin/bash
 
 go build -o go-bookings cmd/web/*.go
-./go-bookings -dbuser=system -dbname=go-bookings -production=false -cache=false -dbport=5431
\ No newline at end of file
+./go-bookings -dbuser=system -dbname=go-bookings -production=false -cache=false -dbport=5431 -migrate
\ No newline at end of file
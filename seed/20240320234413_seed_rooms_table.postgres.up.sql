@@ -1,3 +1,3 @@
 INSERT INTO "public"."rooms" ("id", "room_name", "created_at", "updated_at") VALUES
 (1, 'General''s Quarters', '2024-03-19 00:00:00', '2024-03-19 00:00:00'),
-(2, 'Major''s Suite', '2024-03-19 00:00:00', '2024-03-19 00:00:00');
\ No newline at end of file
+(2, 'Major''s Suite', '2024-03-19 00:00:00', '2024-03-19 00:00:00');
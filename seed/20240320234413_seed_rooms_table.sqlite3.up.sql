@@ -0,0 +1,3 @@
+insert into rooms (id, room_name, created_at, updated_at) values
+(1, 'General''s Quarters', '2024-03-19 00:00:00', '2024-03-19 00:00:00'),
+(2, 'Major''s Suite', '2024-03-19 00:00:00', '2024-03-19 00:00:00');
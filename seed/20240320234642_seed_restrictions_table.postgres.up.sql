@@ -1,3 +1,6 @@
 INSERT INTO "public"."restrictions" ("id", "restriction_name", "created_at", "updated_at") VALUES
 (1, 'Reservation', '2024-03-10 00:00:00', '2024-03-10 00:00:00'),
-(2, 'Owner Block', '2024-03-20 00:00:00', '2024-03-20 00:00:00');
\ No newline at end of file
+(2, 'Owner Block', '2024-03-20 00:00:00', '2024-03-20 00:00:00');
+
+-- the ids above are set by hand, move the sequence past them
+select setval('restrictions_id_seq', (select max(id) from restrictions));
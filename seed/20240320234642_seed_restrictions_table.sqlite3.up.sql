@@ -0,0 +1,3 @@
+insert into restrictions (id, restriction_name, created_at, updated_at) values
+(1, 'Reservation', '2024-03-10 00:00:00', '2024-03-10 00:00:00'),
+(2, 'Owner Block', '2024-03-20 00:00:00', '2024-03-20 00:00:00');
@@ -0,0 +1,10 @@
+-- the types added with restriction colours, a separate seed so databases seeded before them get them too
+insert into restrictions (id, restriction_name, color, blocks_availability, created_at, updated_at) values
+(3, 'Maintenance', '#17a2b8', true, '2026-10-18 00:00:00', '2026-10-18 00:00:00'),
+(4, 'Out of Order', '#343a40', true, '2026-10-18 00:00:00', '2026-10-18 00:00:00'),
+(5, 'Hold', '#6f42c1', true, '2026-10-18 00:00:00', '2026-10-18 00:00:00')
+on conflict (id) do nothing;
+
+-- seeded after the migration that coloured them, unless they were given a colour since
+update restrictions set color = '#dc3545' where id = 1 and color = '#6c757d';
+update restrictions set color = '#ffc107' where id = 2 and color = '#6c757d';
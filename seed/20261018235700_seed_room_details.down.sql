@@ -0,0 +1 @@
+-- nothing to undo, the seeded rooms are removed with the rooms seed
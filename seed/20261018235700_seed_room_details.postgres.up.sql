@@ -0,0 +1,10 @@
+-- the rooms seed predates their slugs, descriptions and rates, 20261018120000 and 20261018140000 backfill them in
+-- databases seeded before those migrations, this fills them in when seeding a database that is already migrated
+update rooms set slug = 'generals-quarters' where id = 1 and slug = '';
+update rooms set slug = 'majors-suite' where id = 2 and slug = '';
+update rooms set description = 'Your home away from home, set on the majestic waters of the Atlantic Ocean, this will be a vacation to remember.' where id in (1, 2) and description = '';
+update rooms set nightly_rate = 12000, weekend_rate = 15000 where id = 1 and nightly_rate = 0;
+update rooms set nightly_rate = 15000, weekend_rate = 18000 where id = 2 and nightly_rate = 0;
+
+-- the rooms seed sets its ids by hand, move the sequence past them
+select setval('rooms_id_seq', (select max(id) from rooms));
@@ -0,0 +1,7 @@
+// Package seed embeds the seed data, it is applied like the migrations with go-bookings migrate seed
+package seed
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS